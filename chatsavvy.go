@@ -2,21 +2,46 @@ package chatsavvy
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/memory"
 	"github.com/davesavic/chatsavvy/migrations"
 	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/sqlstore"
+	sqlmigrations "github.com/davesavic/chatsavvy/sqlstore/migrations"
+	"github.com/davesavic/chatsavvy/store"
+	"github.com/davesavic/chatsavvy/stream"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
+// ErrSubscribeUnsupported is returned by Subscribe when the storage backend has no change streams.
+var ErrSubscribeUnsupported = errors.New("subscribe is not supported by the storage backend")
+
 type ChatSavvy struct {
-	client *mongo.Client
+	client  *mongo.Client
+	db      *mongo.Database
+	memory  *memory.DB
+	sqlDB   *sqlstore.DB
+	options options
+
+	Conversation store.ConversationStore
+	Message      store.MessageStore
+	Presence     store.PresenceStore
 
-	Conversation *repository.Conversation
-	Message      *repository.Message
+	// Hooks runs registered hooks around the repositories' writes, see the hook package.
+	Hooks *hook.Registry
 }
 
-func New(client *mongo.Client) (*ChatSavvy, error) {
+// New connects the repositories to the chatsavvy database, or the database given by
+// WithDatabase. The options apply to every repository.
+func New(client *mongo.Client, opts ...Option) (*ChatSavvy, error) {
+	o := newOptions(opts)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -25,22 +50,125 @@ func New(client *mongo.Client) (*ChatSavvy, error) {
 		return nil, err
 	}
 
-	db := client.Database("chatsavvy")
+	return newChatSavvy(client, o, hook.NewRegistry()), nil
+}
+
+func newChatSavvy(client *mongo.Client, o options, hooks *hook.Registry) *ChatSavvy {
+	db := client.Database(o.database)
+	repoOpts := append(o.repository(), repository.WithHooks(hooks))
+	conversation := repository.NewConversation(db, repoOpts...)
+
+	return &ChatSavvy{
+		client:  client,
+		db:      db,
+		options: o,
+
+		Conversation: conversation,
+		Message:      repository.NewMessage(db, conversation, repoOpts...),
+		Presence:     repository.NewPresence(db, conversation, repoOpts...),
+		Hooks:        hooks,
+	}
+}
+
+// NewInMemory returns a ChatSavvy that keeps everything in memory, e.g. for tests that should
+// not need a MongoDB. The database and collection options do not apply and Subscribe is not
+// supported.
+func NewInMemory(opts ...Option) *ChatSavvy {
+	return newInMemory(memory.NewDB(), newOptions(opts), hook.NewRegistry())
+}
+
+func newInMemory(db *memory.DB, o options, hooks *hook.Registry) *ChatSavvy {
+	memoryOpts := append(o.memory(), memory.WithHooks(hooks))
+	conversation := memory.NewConversation(db, memoryOpts...)
+
+	return &ChatSavvy{
+		memory:  db,
+		options: o,
+
+		Conversation: conversation,
+		Message:      memory.NewMessage(db, conversation, memoryOpts...),
+		Presence:     memory.NewPresence(db, conversation, memoryOpts...),
+		Hooks:        hooks,
+	}
+}
+
+// NewSQL returns a ChatSavvy that stores everything in a SQLite or PostgreSQL database, for
+// deployments without MongoDB. The caller opens db with a registered driver, runs MigrateSQL
+// and closes db. The database and collection options do not apply and Subscribe is not
+// supported.
+func NewSQL(db *sql.DB, dialect sqlstore.Dialect, opts ...Option) *ChatSavvy {
+	return newSQL(sqlstore.NewDB(db, dialect), newOptions(opts), hook.NewRegistry())
+}
+
+func newSQL(db *sqlstore.DB, o options, hooks *hook.Registry) *ChatSavvy {
+	sqlOpts := append(o.sql(), sqlstore.WithHooks(hooks))
+	conversation := sqlstore.NewConversation(db, sqlOpts...)
 
 	return &ChatSavvy{
-		client: client,
+		sqlDB:   db,
+		options: o,
+
+		Conversation: conversation,
+		Message:      sqlstore.NewMessage(db, conversation, sqlOpts...),
+		Presence:     sqlstore.NewPresence(db, conversation, sqlOpts...),
+		Hooks:        hooks,
+	}
+}
+
+// ForTenant returns a ChatSavvy whose repositories and subscriptions only read and write the
+// documents of the tenant. It shares the connection and the hooks with cs.
+// ChatSavvy returned by New works with the default tenant, whose id is empty.
+func (cs *ChatSavvy) ForTenant(tenantID string) *ChatSavvy {
+	o := cs.options
+	o.tenant = tenantID
+	if cs.memory != nil {
+		return newInMemory(cs.memory, o, cs.Hooks)
+	}
+	if cs.sqlDB != nil {
+		return newSQL(cs.sqlDB, o, cs.Hooks)
+	}
+	return newChatSavvy(cs.client, o, cs.Hooks)
+}
+
+// Subscribe streams conversation and message events as they happen, optionally filtered
+// by conversation, participant and event kind. It requires MongoDB to run as a replica set.
+// Pass the resume token of the last processed event to continue after a restart.
+// It returns ErrSubscribeUnsupported for a ChatSavvy returned by NewInMemory or NewSQL, and
+// for a standalone MongoDB server.
+func (cs *ChatSavvy) Subscribe(ctx context.Context, d data.Subscribe) (*stream.Subscription, error) {
+	conversation, ok := cs.Conversation.(*repository.Conversation)
+	if !ok || cs.db == nil {
+		return nil, ErrSubscribeUnsupported
+	}
 
-		Conversation: repository.NewConversation(db),
-		Message:      repository.NewMessage(db, repository.NewConversation(db)),
-	}, nil
+	subscription, err := stream.Subscribe(ctx, cs.db, conversation, d)
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(changeStreamUnavailable) {
+		return nil, fmt.Errorf("%w: %w", ErrSubscribeUnsupported, err)
+	}
+	return subscription, err
 }
 
+// changeStreamUnavailable is the code of the error of a MongoDB server that is not part of
+// a replica set or a sharded cluster when opening a change stream.
+const changeStreamUnavailable = 40573
+
 func (cs *ChatSavvy) Close() error {
+	if cs.client == nil {
+		return nil
+	}
 	return cs.client.Disconnect(context.Background())
 }
 
 // Migrate runs the migrations in the specified direction (up or down).
 // Beware that running migrations in the down direction will delete all data.
-func Migrate(client *mongo.Client, direction string) error {
-	return migrations.Run(client, direction)
+// Pass the same database and collection options as to New.
+func Migrate(client *mongo.Client, direction string, opts ...Option) error {
+	return migrations.Run(client, direction, newOptions(opts).migrations()...)
+}
+
+// MigrateSQL runs the migrations of the SQL schema in the specified direction (up or down).
+// Beware that running migrations in the down direction will delete all data.
+func MigrateSQL(db *sql.DB, dialect sqlstore.Dialect, direction string, opts ...Option) error {
+	return sqlmigrations.Run(db, dialect, direction, newOptions(opts).sqlMigrations()...)
 }
@@ -35,7 +35,12 @@ func main() {
 		panic(err)
 	}
 
-	err = migrations.Run(client, direction)
+	var opts []migrations.Option
+	if database := os.Getenv("MONGODB_DATABASE"); database != "" {
+		opts = append(opts, migrations.WithDatabase(database))
+	}
+
+	err = migrations.Run(client, direction, opts...)
 	if err != nil {
 		panic(err)
 	}
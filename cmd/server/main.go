@@ -0,0 +1,133 @@
+// Command server serves the JSON API of httpapi.
+//
+// It is configured through the environment:
+//
+//	CHATSAVVY_ADDR      the listen address, :8080 by default
+//	CHATSAVVY_STORE     mongodb (the default), sqlite or memory
+//	CHATSAVVY_API_KEY   when set, requests must carry it as a bearer token
+//	CHATSAVVY_ORIGINS   comma separated origins of other sites allowed to open WebSocket connections
+//	MONGODB_URI         the MongoDB connection string, migrated with cmd/migrate
+//	MONGODB_DATABASE    the MongoDB database, chatsavvy by default
+//	SQLITE_PATH         the SQLite database file, chatsavvy.db by default, migrated on start
+//
+// Callers are identified by the X-Participant-ID, X-Participant-Metadata and X-Tenant-ID
+// headers, so the server must run behind a gateway that authenticates them.
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/davesavic/chatsavvy"
+	"github.com/davesavic/chatsavvy/httpapi"
+	"github.com/davesavic/chatsavvy/sqlstore"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cs, err := open(os.Getenv("CHATSAVVY_STORE"))
+	if err != nil {
+		panic(err)
+	}
+	defer cs.Close()
+
+	auth := httpapi.HeaderAuthenticator()
+	if key := os.Getenv("CHATSAVVY_API_KEY"); key != "" {
+		auth = requireAPIKey(key, auth)
+	}
+
+	var opts []httpapi.Option
+	if origins := os.Getenv("CHATSAVVY_ORIGINS"); origins != "" {
+		opts = append(opts, httpapi.WithAllowedOrigins(strings.Split(origins, ",")...))
+	}
+
+	server := &http.Server{
+		Addr:              env("CHATSAVVY_ADDR", ":8080"),
+		Handler:           httpapi.New(cs, auth, opts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down the server", "error", err)
+		}
+	}()
+
+	slog.Info("Server listening", "addr", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
+	}
+
+	slog.Info("Server stopped")
+}
+
+// open connects to the store.
+func open(store string) (*chatsavvy.ChatSavvy, error) {
+	switch store {
+	case "", "mongodb":
+		client, err := mongo.Connect(options.Client().ApplyURI(os.Getenv("MONGODB_URI")))
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []chatsavvy.Option
+		if database := os.Getenv("MONGODB_DATABASE"); database != "" {
+			opts = append(opts, chatsavvy.WithDatabase(database))
+		}
+
+		return chatsavvy.New(client, opts...)
+	case "sqlite":
+		db, err := sql.Open("sqlite3", env("SQLITE_PATH", "chatsavvy.db"))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := chatsavvy.MigrateSQL(db, sqlstore.SQLite, "up"); err != nil {
+			return nil, err
+		}
+
+		return chatsavvy.NewSQL(db, sqlstore.SQLite), nil
+	case "memory":
+		slog.Warn("Using the in-memory store, the data is lost on exit")
+		return chatsavvy.NewInMemory(), nil
+	default:
+		return nil, errors.New("invalid store. Please provide either 'mongodb', 'sqlite' or 'memory'")
+	}
+}
+
+// requireAPIKey only lets requests carrying the key as a bearer token through to auth.
+func requireAPIKey(key string, auth httpapi.Authenticator) httpapi.Authenticator {
+	want := []byte("Bearer " + key)
+	return httpapi.AuthenticatorFunc(func(r *http.Request) (*httpapi.Identity, error) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			return nil, httpapi.ErrUnauthenticated
+		}
+		return auth.Authenticate(r)
+	})
+}
+
+func env(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,24 +1,72 @@
 package data
 
-import "github.com/go-playground/validator/v10"
+import (
+	"fmt"
+	"strconv"
 
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// CreateConversation creates a direct conversation unless Kind is group.
+// Only groups have a title.
 type CreateConversation struct {
-	Participants []AddParticipant `validate:"required,min=2,max=10"`
-	Metadata     map[string]any   `validate:"omitempty"`
+	Kind              model.ConversationKind  `validate:"omitempty,oneof=direct group"`
+	Title             string                  `validate:"excluded_unless=Kind group,max=200"`
+	Participants      []AddParticipant        `validate:"required,min=2"`
+	Metadata          map[string]any          `validate:"omitempty"`
+	HistoryVisibility model.HistoryVisibility `validate:"omitempty,oneof=shared joined"`
 }
 
+// Validate validates the conversation against the default limits.
 func (c CreateConversation) Validate() error {
-	return validator.New().Struct(c)
+	return c.ValidateWith(model.DefaultLimits())
+}
+
+// ValidateWith validates the conversation against the limits.
+func (c CreateConversation) ValidateWith(limits model.Limits) error {
+	if err := validate(c); err != nil {
+		return err
+	}
+
+	if limits.MaxParticipants > 0 && len(c.Participants) > limits.MaxParticipants {
+		return invalid("Participants", "max", strconv.Itoa(limits.MaxParticipants),
+			fmt.Sprintf("conversation must have at most %d participants", limits.MaxParticipants))
+	}
+
+	return nil
 }
 
+// PaginateConversations pages through the participant's conversations, pinned ones first.
+// The participant is identified by ParticipantID and Metadata, like the participant pinning
+// or archiving a conversation, see SetPinned and SetArchived.
+// Conversations the participant archived are skipped unless IncludeArchived is set.
 type PaginateConversations struct {
-	ParticipantID string `validate:"required,min=1,max=100"`
-	Page          uint   `validate:"required,min=1"`
-	PerPage       uint   `validate:"required,min=1,max=100"`
+	ParticipantID   string         `validate:"required,min=1,max=100"`
+	Metadata        map[string]any `validate:"omitempty"`
+	Page            uint           `validate:"required,min=1"`
+	PerPage         uint           `validate:"required,min=1,max=100"`
+	IncludeArchived bool           `validate:"omitempty"`
 }
 
 func (p PaginateConversations) Validate() error {
-	return validator.New().Struct(p)
+	return validate(p)
+}
+
+// LoadConversations is the cursor based variant of PaginateConversations, listing the same
+// conversations without putting the pinned ones first.
+// Cursor is the NextCursor of the previous page, empty for the first page.
+// Count additionally returns the total number of conversations, at the cost of a count query.
+type LoadConversations struct {
+	ParticipantID   string         `validate:"required,min=1,max=100"`
+	Metadata        map[string]any `validate:"omitempty"`
+	Cursor          string         `validate:"omitempty,max=200"`
+	PerPage         uint           `validate:"required,min=1,max=100"`
+	Count           bool           `validate:"omitempty"`
+	IncludeArchived bool           `validate:"omitempty"`
+}
+
+func (p LoadConversations) Validate() error {
+	return validate(p)
 }
 
 type FindParticipant struct {
@@ -31,7 +79,7 @@ type FindByParticipants struct {
 }
 
 func (d FindByParticipants) Validate() error {
-	return validator.New().Struct(d)
+	return validate(d)
 }
 
 type MetadataMatchMode string
@@ -50,5 +98,70 @@ type FindByMetadata struct {
 }
 
 func (d FindByMetadata) Validate() error {
-	return validator.New().Struct(d)
+	return validate(d)
+}
+
+// LoadByMetadata is the cursor based variant of FindByMetadata, see LoadConversations.
+type LoadByMetadata struct {
+	Metadata       map[string]any    `validate:"required,min=1"`
+	MatchMode      MetadataMatchMode `validate:"required,oneof=key_value exact"`
+	IncludeDeleted bool              `validate:"omitempty"`
+	Cursor         string            `validate:"omitempty,max=200"`
+	PerPage        uint              `validate:"required,min=1,max=100"`
+	Count          bool              `validate:"omitempty"`
+}
+
+func (d LoadByMetadata) Validate() error {
+	return validate(d)
+}
+
+type UpdateConversationMetadata struct {
+	Metadata map[string]any `validate:"omitempty"`
+}
+
+func (d UpdateConversationMetadata) Validate() error {
+	return validate(d)
+}
+
+type SetTitle struct {
+	Title string `validate:"max=200"`
+}
+
+func (d SetTitle) Validate() error {
+	return validate(d)
+}
+
+type SetHistoryVisibility struct {
+	HistoryVisibility model.HistoryVisibility `validate:"required,oneof=shared joined"`
+}
+
+func (d SetHistoryVisibility) Validate() error {
+	return validate(d)
+}
+
+// UpdateConversation changes the fields that are set at once, see UpdateConversationMetadata,
+// SetTitle and SetHistoryVisibility.
+type UpdateConversation struct {
+	Title             *string                  `validate:"omitempty,max=200"`
+	Metadata          *map[string]any          `validate:"omitempty"`
+	HistoryVisibility *model.HistoryVisibility `validate:"omitempty,oneof=shared joined"`
+}
+
+func (d UpdateConversation) Validate() error {
+	return validate(d)
+}
+
+// LoadInbox loads the participant's conversations with their unread counts, see LoadConversations.
+// UnreadOnly skips the conversations without unread messages. Conversations the participant
+// archived are skipped unless IncludeArchived is set.
+type LoadInbox struct {
+	Participant     ReadParticipant `validate:"required"`
+	UnreadOnly      bool            `validate:"omitempty"`
+	IncludeArchived bool            `validate:"omitempty"`
+	Cursor          string          `validate:"omitempty,max=200"`
+	PerPage         uint            `validate:"required,min=1,max=100"`
+}
+
+func (d LoadInbox) Validate() error {
+	return validate(d)
 }
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateConversation_Validate(t *testing.T) {
+	participants := []AddParticipant{{ParticipantID: "123"}, {ParticipantID: "456"}}
+
+	testCases := []struct {
+		name    string
+		conv    CreateConversation
+		wantErr bool
+	}{
+		{
+			name:    "direct by default",
+			conv:    CreateConversation{Participants: participants},
+			wantErr: false,
+		},
+		{
+			name:    "group with a title",
+			conv:    CreateConversation{Kind: model.ConversationKindGroup, Title: "Team", Participants: participants},
+			wantErr: false,
+		},
+		{
+			name:    "group without a title",
+			conv:    CreateConversation{Kind: model.ConversationKindGroup, Participants: participants},
+			wantErr: false,
+		},
+		{
+			name:    "direct with a title",
+			conv:    CreateConversation{Kind: model.ConversationKindDirect, Title: "Team", Participants: participants},
+			wantErr: true,
+		},
+		{
+			name:    "default kind with a title",
+			conv:    CreateConversation{Title: "Team", Participants: participants},
+			wantErr: true,
+		},
+		{
+			name:    "unknown kind",
+			conv:    CreateConversation{Kind: "channel", Participants: participants},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.conv.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
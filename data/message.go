@@ -1,9 +1,11 @@
 package data
 
 import (
-	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
 
-	"github.com/go-playground/validator/v10"
+	"github.com/davesavic/chatsavvy/model"
 )
 
 type MessageSender struct {
@@ -19,40 +21,146 @@ type CreateAttachment struct {
 type CreateMessage struct {
 	Kind        string             `validate:"required,min=1,max=100" bson:"kind"`
 	Sender      MessageSender      `validate:"required" bson:"sender"`
-	Content     string             `validate:"omitempty,max=5000" bson:"content"`
-	Attachments []CreateAttachment `validate:"omitempty,max=10,dive" bson:"attachments"`
+	Content     string             `validate:"omitempty" bson:"content"`
+	Attachments []CreateAttachment `validate:"omitempty,dive" bson:"attachments"`
+	ParentID    *string            `validate:"omitempty,min=1,max=100" bson:"parent_id"`
+	ReplyToID   *string            `validate:"omitempty,min=1,max=100" bson:"reply_to_id"`
 }
 
+// Validate validates the message against the default limits.
 func (c CreateMessage) Validate() error {
-	if err := validator.New().Struct(c); err != nil {
+	return c.ValidateWith(model.DefaultLimits())
+}
+
+// ValidateWith validates the message against the limits.
+func (c CreateMessage) ValidateWith(limits model.Limits) error {
+	if err := validate(c); err != nil {
+		return err
+	}
+
+	if c.Content == "" && len(c.Attachments) == 0 {
+		return invalid("Content", "required", "", "message must have content or at least one attachment")
+	}
+
+	return validateMessageLimits(c.Content, c.Attachments, limits)
+}
+
+type EditMessage struct {
+	MessageID   string             `validate:"required,min=1,max=100" bson:"message_id"`
+	Editor      MessageSender      `validate:"required" bson:"editor"`
+	Content     string             `validate:"omitempty" bson:"content"`
+	Attachments []CreateAttachment `validate:"omitempty,dive" bson:"attachments"`
+}
+
+// Validate validates the edit against the default limits.
+func (c EditMessage) Validate() error {
+	return c.ValidateWith(model.DefaultLimits())
+}
+
+// ValidateWith validates the edit against the limits.
+func (c EditMessage) ValidateWith(limits model.Limits) error {
+	if err := validate(c); err != nil {
 		return err
 	}
 
 	if c.Content == "" && len(c.Attachments) == 0 {
-		return errors.New("message must have content or at least one attachment")
+		return invalid("Content", "required", "", "message must have content or at least one attachment")
+	}
+
+	return validateMessageLimits(c.Content, c.Attachments, limits)
+}
+
+func validateMessageLimits(content string, attachments []CreateAttachment, limits model.Limits) error {
+	if limits.MaxContentLength > 0 && utf8.RuneCountInString(content) > limits.MaxContentLength {
+		return invalid("Content", "max", strconv.Itoa(limits.MaxContentLength),
+			fmt.Sprintf("message content must be at most %d characters", limits.MaxContentLength))
+	}
+
+	if limits.MaxAttachments > 0 && len(attachments) > limits.MaxAttachments {
+		return invalid("Attachments", "max", strconv.Itoa(limits.MaxAttachments),
+			fmt.Sprintf("message must have at most %d attachments", limits.MaxAttachments))
 	}
 
 	return nil
 }
 
+type DeleteMessageForEveryone struct {
+	MessageID string        `validate:"required,min=1,max=100" bson:"message_id"`
+	Sender    MessageSender `validate:"required" bson:"sender"`
+}
+
+func (c DeleteMessageForEveryone) Validate() error {
+	return validate(c)
+}
+
+type DeleteMessageForMe struct {
+	MessageID   string          `validate:"required,min=1,max=100" bson:"message_id"`
+	Participant ReadParticipant `validate:"required" bson:"participant"`
+}
+
+func (c DeleteMessageForMe) Validate() error {
+	return validate(c)
+}
+
 type PaginateMessages struct {
-	ConversationID string `validate:"required,min=1,max=100" bson:"conversation_id"`
-	Page           uint   `validate:"required,min=1" bson:"page"`
-	PerPage        uint   `validate:"required,min=1,max=100" bson:"per_page"`
+	ConversationID       string           `validate:"required,min=1,max=100" bson:"conversation_id"`
+	Viewer               *ReadParticipant `validate:"omitempty" bson:"viewer"`
+	IncludeThreadReplies bool             `validate:"omitempty" bson:"include_thread_replies"`
+	Page                 uint             `validate:"required,min=1" bson:"page"`
+	PerPage              uint             `validate:"required,min=1,max=100" bson:"per_page"`
 }
 
 func (c PaginateMessages) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type LoadMessages struct {
-	ConversationID string  `validate:"required,min=1,max=100" bson:"conversation_id"`
-	LastMessageID  *string `validate:"omitempty,min=1,max=100" bson:"last_message_id"`
-	PerPage        uint    `validate:"required,min=1,max=100" bson:"per_page"`
+	ConversationID       string           `validate:"required,min=1,max=100" bson:"conversation_id"`
+	Viewer               *ReadParticipant `validate:"omitempty" bson:"viewer"`
+	IncludeThreadReplies bool             `validate:"omitempty" bson:"include_thread_replies"`
+	LastMessageID        *string          `validate:"omitempty,min=1,max=100" bson:"last_message_id"`
+	PerPage              uint             `validate:"required,min=1,max=100" bson:"per_page"`
 }
 
 func (c LoadMessages) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
+}
+
+type LoadNewerMessages struct {
+	ConversationID       string           `validate:"required,min=1,max=100" bson:"conversation_id"`
+	Viewer               *ReadParticipant `validate:"omitempty" bson:"viewer"`
+	IncludeThreadReplies bool             `validate:"omitempty" bson:"include_thread_replies"`
+	FirstMessageID       string           `validate:"required,min=1,max=100" bson:"first_message_id"`
+	PerPage              uint             `validate:"required,min=1,max=100" bson:"per_page"`
+}
+
+func (c LoadNewerMessages) Validate() error {
+	return validate(c)
+}
+
+type LoadMessagesAround struct {
+	ConversationID       string           `validate:"required,min=1,max=100" bson:"conversation_id"`
+	Viewer               *ReadParticipant `validate:"omitempty" bson:"viewer"`
+	IncludeThreadReplies bool             `validate:"omitempty" bson:"include_thread_replies"`
+	MessageID            string           `validate:"required,min=1,max=100" bson:"message_id"`
+	Before               uint             `validate:"max=100" bson:"before"`
+	After                uint             `validate:"max=100" bson:"after"`
+}
+
+func (c LoadMessagesAround) Validate() error {
+	return validate(c)
+}
+
+type LoadThread struct {
+	ConversationID string           `validate:"required,min=1,max=100" bson:"conversation_id"`
+	ParentID       string           `validate:"required,min=1,max=100" bson:"parent_id"`
+	Viewer         *ReadParticipant `validate:"omitempty" bson:"viewer"`
+	LastMessageID  *string          `validate:"omitempty,min=1,max=100" bson:"last_message_id"`
+	PerPage        uint             `validate:"required,min=1,max=100" bson:"per_page"`
+}
+
+func (c LoadThread) Validate() error {
+	return validate(c)
 }
 
 type ReactionParticipant struct {
@@ -67,7 +175,7 @@ type ToggleReaction struct {
 }
 
 func (c ToggleReaction) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type ReadParticipant struct {
@@ -82,7 +190,7 @@ type MarkRead struct {
 }
 
 func (c MarkRead) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type MarkAllRead struct {
@@ -91,7 +199,7 @@ type MarkAllRead struct {
 }
 
 func (c MarkAllRead) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type ReadersOf struct {
@@ -100,7 +208,7 @@ type ReadersOf struct {
 }
 
 func (c ReadersOf) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type UnreadCount struct {
@@ -109,5 +217,33 @@ type UnreadCount struct {
 }
 
 func (c UnreadCount) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
+}
+
+type SearchMessages struct {
+	Participant ReadParticipant `validate:"required" bson:"participant"`
+	Query       string          `validate:"required,min=1,max=200" bson:"query"`
+	Page        uint            `validate:"required,min=1" bson:"page"`
+	PerPage     uint            `validate:"required,min=1,max=100" bson:"per_page"`
+}
+
+func (c SearchMessages) Validate() error {
+	return validate(c)
+}
+
+type PinMessage struct {
+	MessageID string `validate:"required,min=1,max=100" bson:"message_id"`
+}
+
+func (c PinMessage) Validate() error {
+	return validate(c)
+}
+
+type LoadPinnedMessages struct {
+	ConversationID string           `validate:"required,min=1,max=100" bson:"conversation_id"`
+	Viewer         *ReadParticipant `validate:"omitempty" bson:"viewer"`
+}
+
+func (c LoadPinnedMessages) Validate() error {
+	return validate(c)
 }
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/davesavic/chatsavvy/model"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -95,3 +97,60 @@ func TestCreateMessage_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateMessage_ValidateWith(t *testing.T) {
+	limits := model.Limits{MaxContentLength: 5, MaxAttachments: 1}
+	sender := MessageSender{ParticipantID: "123"}
+
+	testCases := []struct {
+		name    string
+		msg     CreateMessage
+		limits  model.Limits
+		wantErr bool
+	}{
+		{
+			name:    "content within the limit counts characters",
+			msg:     CreateMessage{Kind: "general", Sender: sender, Content: "héllo"},
+			limits:  limits,
+			wantErr: false,
+		},
+		{
+			name:    "content over the limit",
+			msg:     CreateMessage{Kind: "general", Sender: sender, Content: "hello!"},
+			limits:  limits,
+			wantErr: true,
+		},
+		{
+			name: "attachments over the limit",
+			msg: CreateMessage{Kind: "general", Sender: sender, Attachments: []CreateAttachment{
+				{Kind: "image"},
+				{Kind: "image"},
+			}},
+			limits:  limits,
+			wantErr: true,
+		},
+		{
+			name:    "zero limits are not enforced",
+			msg:     CreateMessage{Kind: "general", Sender: sender, Content: strings.Repeat("a", 6000)},
+			limits:  model.Limits{},
+			wantErr: false,
+		},
+		{
+			name:    "default limits",
+			msg:     CreateMessage{Kind: "general", Sender: sender, Content: strings.Repeat("a", 5001)},
+			limits:  model.DefaultLimits(),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.msg.ValidateWith(tc.limits)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -1,14 +1,30 @@
 package data
 
-import "github.com/go-playground/validator/v10"
+import (
+	"time"
+
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// Actor is the participant performing an operation. Operations that take an actor check
+// its role against the permission matrix. A nil actor is a trusted call and is not checked.
+type Actor struct {
+	ParticipantID string         `validate:"required,min=1,max=100" bson:"participant_id"`
+	Metadata      map[string]any `validate:"omitempty" bson:"metadata"`
+}
+
+func (c Actor) Validate() error {
+	return validate(c)
+}
 
 type AddParticipant struct {
 	ParticipantID string         `validate:"required,min=1,max=100" bson:"participant_id"`
 	Metadata      map[string]any `validate:"omitempty" bson:"metadata"`
+	Role          model.Role     `validate:"omitempty,oneof=owner admin member" bson:"role"`
 }
 
 func (c AddParticipant) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type DeleteParticipant struct {
@@ -17,7 +33,7 @@ type DeleteParticipant struct {
 }
 
 func (c DeleteParticipant) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
 }
 
 type ParticipantExists struct {
@@ -26,5 +42,47 @@ type ParticipantExists struct {
 }
 
 func (c ParticipantExists) Validate() error {
-	return validator.New().Struct(c)
+	return validate(c)
+}
+
+type SetParticipantRole struct {
+	ParticipantID string         `validate:"required,min=1,max=100" bson:"participant_id"`
+	Metadata      map[string]any `validate:"omitempty" bson:"metadata"`
+	Role          model.Role     `validate:"required,oneof=owner admin member" bson:"role"`
+}
+
+func (c SetParticipantRole) Validate() error {
+	return validate(c)
+}
+
+// SetPinned pins or unpins the conversation for the participant.
+type SetPinned struct {
+	Participant ReadParticipant `validate:"required" bson:"participant"`
+	Pinned      bool            `validate:"omitempty" bson:"pinned"`
+}
+
+func (c SetPinned) Validate() error {
+	return validate(c)
+}
+
+// SetArchived archives or unarchives the conversation for the participant.
+type SetArchived struct {
+	Participant ReadParticipant `validate:"required" bson:"participant"`
+	Archived    bool            `validate:"omitempty" bson:"archived"`
+}
+
+func (c SetArchived) Validate() error {
+	return validate(c)
+}
+
+// SetMuted mutes or unmutes the conversation for the participant.
+// Until ends the mute at the given time, a nil Until mutes until unmuted.
+type SetMuted struct {
+	Participant ReadParticipant `validate:"required" bson:"participant"`
+	Muted       bool            `validate:"omitempty" bson:"muted"`
+	Until       *time.Time      `validate:"omitempty" bson:"until"`
+}
+
+func (c SetMuted) Validate() error {
+	return validate(c)
 }
@@ -0,0 +1,34 @@
+package data
+
+type SetTyping struct {
+	ConversationID string          `validate:"required,min=1,max=100" bson:"conversation_id"`
+	Participant    ReadParticipant `validate:"required" bson:"participant"`
+}
+
+func (c SetTyping) Validate() error {
+	return validate(c)
+}
+
+type LoadTyping struct {
+	ConversationID string `validate:"required,min=1,max=100" bson:"conversation_id"`
+}
+
+func (c LoadTyping) Validate() error {
+	return validate(c)
+}
+
+type Heartbeat struct {
+	Participant ReadParticipant `validate:"required" bson:"participant"`
+}
+
+func (c Heartbeat) Validate() error {
+	return validate(c)
+}
+
+type LoadPresence struct {
+	Participants []ReadParticipant `validate:"required,min=1,max=100,dive" bson:"participants"`
+}
+
+func (c LoadPresence) Validate() error {
+	return validate(c)
+}
@@ -0,0 +1,17 @@
+package data
+
+import (
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Subscribe struct {
+	ConversationIDs []string          `validate:"omitempty,max=100,dive,min=1,max=100"`
+	Participant     *ReadParticipant  `validate:"omitempty"`
+	Kinds           []model.EventKind `validate:"omitempty,dive,oneof=message.created reaction.toggled participant.added participant.removed read.advanced"`
+	ResumeToken     bson.Raw          `validate:"omitempty"`
+}
+
+func (c Subscribe) Validate() error {
+	return validate(c)
+}
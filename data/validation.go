@@ -0,0 +1,78 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ErrValidation is matched by the errors of the Validate and ValidateWith methods, for
+// errors.Is. Use errors.As with *ValidationError for the invalid fields.
+var ErrValidation = errors.New("validation failed")
+
+// FieldError describes an invalid field.
+type FieldError struct {
+	// Field is the path of the field in the data, e.g. Sender.ParticipantID or Participants[0].ParticipantID.
+	Field string
+	// Rule is the rule the field breaks, e.g. required, max or oneof.
+	Rule string
+	// Param is the parameter of the rule if any, e.g. 100 for max=100.
+	Param string
+	// Message describes the problem.
+	Message string
+}
+
+// ValidationError is returned when the data is invalid.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Message
+	}
+
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(messages, "; "))
+}
+
+// Is reports whether the target is ErrValidation.
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// invalid returns a validation error for the field.
+func invalid(field, rule, param, message string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Rule: rule, Param: param, Message: message}}}
+}
+
+// validate checks the validate tags of the struct.
+// It returns a *ValidationError listing the invalid fields, or nil.
+func validate(s any) error {
+	err := validator.New().Struct(s)
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	fields := make([]FieldError, len(errs))
+	for i, fe := range errs {
+		// The namespace starts with the name of the struct, e.g. CreateMessage.Sender.ParticipantID.
+		field := fe.Namespace()
+		if _, path, ok := strings.Cut(field, "."); ok {
+			field = path
+		}
+
+		message := fmt.Sprintf("%s failed on the %s rule", field, fe.Tag())
+		if fe.Param() != "" {
+			message = fmt.Sprintf("%s failed on the %s=%s rule", field, fe.Tag(), fe.Param())
+		}
+
+		fields[i] = FieldError{Field: field, Rule: fe.Tag(), Param: fe.Param(), Message: message}
+	}
+
+	return &ValidationError{Fields: fields}
+}
@@ -0,0 +1,53 @@
+package data
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationError(t *testing.T) {
+	t.Run("lists the invalid fields", func(t *testing.T) {
+		err := CreateMessage{Sender: MessageSender{ParticipantID: ""}, Content: "Hello"}.Validate()
+		require.ErrorIs(t, err, ErrValidation)
+
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr))
+		assert.Equal(t, []FieldError{
+			{Field: "Kind", Rule: "required", Message: "Kind failed on the required rule"},
+			{Field: "Sender.ParticipantID", Rule: "required", Message: "Sender.ParticipantID failed on the required rule"},
+		}, verr.Fields)
+	})
+
+	t.Run("includes the rule parameter", func(t *testing.T) {
+		err := PaginateConversations{ParticipantID: "123", Page: 1, PerPage: 101}.Validate()
+
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr))
+		assert.Equal(t, []FieldError{
+			{Field: "PerPage", Rule: "max", Param: "100", Message: "PerPage failed on the max=100 rule"},
+		}, verr.Fields)
+		assert.EqualError(t, err, "validation failed: PerPage failed on the max=100 rule")
+	})
+
+	t.Run("reports the limits", func(t *testing.T) {
+		err := CreateMessage{
+			Kind:    "general",
+			Sender:  MessageSender{ParticipantID: "123"},
+			Content: "Hello",
+		}.ValidateWith(model.Limits{MaxContentLength: 2})
+
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr))
+		assert.Equal(t, []FieldError{
+			{Field: "Content", Rule: "max", Param: "2", Message: "message content must be at most 2 characters"},
+		}, verr.Fields)
+	})
+
+	t.Run("nil when valid", func(t *testing.T) {
+		assert.NoError(t, PaginateConversations{ParticipantID: "123", Page: 1, PerPage: 10}.Validate())
+	})
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/davesavic/chatsavvy"
 	csdata "github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/model"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
@@ -16,36 +20,103 @@ func main() {
 	}
 	defer client.Disconnect(context.Background())
 
-	cs, err := chatsavvy.New(client)
+	cs, err := chatsavvy.New(client,
+		chatsavvy.WithDatabase("chatsavvy"),
+		chatsavvy.WithLimits(model.Limits{MaxParticipants: 50, MaxContentLength: 2000, MaxAttachments: 5}),
+	)
 	if err != nil {
 		panic(err)
 	}
 
+	hook.Before(cs.Hooks, func(ctx context.Context, e hook.CreateMessage) error {
+		if e.Data.Content == "" && len(e.Data.Attachments) == 0 {
+			return errors.New("empty message")
+		}
+		return nil
+	})
+	hook.After(cs.Hooks, func(ctx context.Context, e hook.MessageCreated) {
+		// notify the other participants
+	})
+
 	cs.Conversation.Create(context.Background(), csdata.CreateConversation{
 		Participants: []csdata.AddParticipant{
-			{ParticipantID: "1234567890"},
+			{ParticipantID: "1234567890", Role: model.RoleOwner},
 			{ParticipantID: "0987654321"},
 			{ParticipantID: "1357924680", Metadata: map[string]any{"business_id": "1234567890"}},
 		},
 		Metadata: map[string]any{},
 	})
 
+	cs.Conversation.UpdateMetadata(context.Background(), "1234567890", &csdata.Actor{ParticipantID: "1234567890"}, csdata.UpdateConversationMetadata{
+		Metadata: map[string]any{"title": "Team"},
+	})
+
+	cs.Conversation.Create(context.Background(), csdata.CreateConversation{
+		Kind:  model.ConversationKindGroup,
+		Title: "Team",
+		Participants: []csdata.AddParticipant{
+			{ParticipantID: "1234567890", Role: model.RoleOwner},
+			{ParticipantID: "0987654321"},
+		},
+		HistoryVisibility: model.HistoryVisibilityJoined,
+	})
+
+	cs.Conversation.SetTitle(context.Background(), "1234567890", &csdata.Actor{ParticipantID: "1234567890"}, csdata.SetTitle{
+		Title: "Team chat",
+	})
+
+	cs.Conversation.SetHistoryVisibility(context.Background(), "1234567890", &csdata.Actor{ParticipantID: "1234567890"}, csdata.SetHistoryVisibility{
+		HistoryVisibility: model.HistoryVisibilityJoined,
+	})
+
+	cs.Conversation.SetRole(context.Background(), "1234567890", &csdata.Actor{ParticipantID: "1234567890"}, csdata.SetParticipantRole{
+		ParticipantID: "0987654321",
+		Role:          model.RoleAdmin,
+	})
+
 	cs.Conversation.Paginate(context.Background(), csdata.PaginateConversations{
 		ParticipantID: "1234567890",
 		Page:          1,
 		PerPage:       10,
 	})
 
+	me := csdata.ReadParticipant{ParticipantID: "1234567890"}
+	cs.Conversation.SetPinned(context.Background(), "1234567890", csdata.SetPinned{Participant: me, Pinned: true})
+	cs.Conversation.SetArchived(context.Background(), "1234567890", csdata.SetArchived{Participant: me, Archived: true})
+
+	until := time.Now().Add(8 * time.Hour)
+	cs.Conversation.SetMuted(context.Background(), "1234567890", csdata.SetMuted{Participant: me, Muted: true, Until: &until})
+
+	page, _ := cs.Conversation.LoadConversations(context.Background(), csdata.LoadConversations{
+		ParticipantID: "1234567890",
+		PerPage:       10,
+	})
+	if page != nil && page.HasMore {
+		cs.Conversation.LoadConversations(context.Background(), csdata.LoadConversations{
+			ParticipantID: "1234567890",
+			Cursor:        page.NextCursor,
+			PerPage:       10,
+		})
+	}
+
+	cs.Conversation.Inbox(context.Background(), csdata.LoadInbox{
+		Participant: csdata.ReadParticipant{
+			ParticipantID: "1234567890",
+		},
+		UnreadOnly: true,
+		PerPage:    10,
+	})
+
 	cs.Conversation.Find(context.Background(), "1234567890")
 
-	cs.Conversation.AddParticipant(context.Background(), "1234567890", csdata.AddParticipant{
+	cs.Conversation.AddParticipant(context.Background(), "1234567890", nil, csdata.AddParticipant{
 		ParticipantID: "0987654321",
 		Metadata: map[string]any{
 			"business_id": "1234567891",
 		},
 	})
 
-	cs.Conversation.DeleteParticipant(context.Background(), "1234567890", csdata.DeleteParticipant{})
+	cs.Conversation.DeleteParticipant(context.Background(), "1234567890", nil, csdata.DeleteParticipant{})
 
 	cs.Conversation.ParticipantExists(context.Background(), "1234567890", csdata.ParticipantExists{
 		ParticipantID: "1234567890",
@@ -85,6 +156,28 @@ func main() {
 		},
 	})
 
+	cs.Message.Edit(context.Background(), csdata.EditMessage{
+		MessageID: "0987654321",
+		Editor: csdata.MessageSender{
+			ParticipantID: "1234567890",
+		},
+		Content: "Hello, World! (edited)",
+	})
+
+	cs.Message.DeleteForEveryone(context.Background(), csdata.DeleteMessageForEveryone{
+		MessageID: "0987654321",
+		Sender: csdata.MessageSender{
+			ParticipantID: "1234567890",
+		},
+	})
+
+	cs.Message.DeleteForMe(context.Background(), csdata.DeleteMessageForMe{
+		MessageID: "0987654321",
+		Participant: csdata.ReadParticipant{
+			ParticipantID: "0987654321",
+		},
+	})
+
 	cs.Message.Paginate(context.Background(), csdata.PaginateMessages{
 		ConversationID: "1234567890",
 		Page:           1,
@@ -97,6 +190,46 @@ func main() {
 		PerPage:        10,
 	})
 
+	cs.Message.LoadNewerMessages(context.Background(), csdata.LoadNewerMessages{
+		ConversationID: "1234567890",
+		FirstMessageID: "0987654321",
+		PerPage:        10,
+	})
+
+	cs.Message.LoadMessagesAround(context.Background(), csdata.LoadMessagesAround{
+		ConversationID: "1234567890",
+		MessageID:      "0987654321",
+		Before:         10,
+		After:          10,
+	})
+
+	parentID := "0987654321"
+	cs.Message.Create(context.Background(), "1234567890", csdata.CreateMessage{
+		Kind: "general",
+		Sender: csdata.MessageSender{
+			ParticipantID: "0987654321",
+		},
+		Content:  "Replying in a thread",
+		ParentID: &parentID,
+	})
+
+	replyToID := "0987654321"
+	cs.Message.Create(context.Background(), "1234567890", csdata.CreateMessage{
+		Kind: "general",
+		Sender: csdata.MessageSender{
+			ParticipantID: "0987654321",
+		},
+		Content:   "Quoting an earlier message",
+		ReplyToID: &replyToID,
+	})
+
+	cs.Message.LoadThread(context.Background(), csdata.LoadThread{
+		ConversationID: "1234567890",
+		ParentID:       "0987654321",
+		LastMessageID:  nil,
+		PerPage:        10,
+	})
+
 	cs.Message.ToggleReaction(context.Background(), csdata.ToggleReaction{
 		MessageID: "0987654321",
 		Emoji:     ":thumbsup:",
@@ -144,5 +277,65 @@ func main() {
 		},
 	})
 
+	cs.Message.Pin(context.Background(), &csdata.Actor{ParticipantID: "1234567890"}, csdata.PinMessage{MessageID: "1234567890"})
+
+	cs.Message.LoadPinned(context.Background(), csdata.LoadPinnedMessages{ConversationID: "1234567890"})
+
+	cs.Message.Search(context.Background(), csdata.SearchMessages{
+		Participant: csdata.ReadParticipant{ParticipantID: "1234567890"},
+		Query:       "invoice",
+		Page:        1,
+		PerPage:     10,
+	})
+
+	cs.Presence.SetTyping(context.Background(), csdata.SetTyping{
+		ConversationID: "1234567890",
+		Participant:    csdata.ReadParticipant{ParticipantID: "1234567890"},
+	})
+
+	cs.Presence.Typing(context.Background(), csdata.LoadTyping{ConversationID: "1234567890"})
+
+	cs.Presence.Heartbeat(context.Background(), csdata.Heartbeat{
+		Participant: csdata.ReadParticipant{ParticipantID: "1234567890"},
+	})
+
+	cs.Presence.LastSeen(context.Background(), csdata.LoadPresence{
+		Participants: []csdata.ReadParticipant{
+			{ParticipantID: "1234567890"},
+			{ParticipantID: "0987654321"},
+		},
+	})
+
+	acme := cs.ForTenant("acme")
+	acme.Conversation.Create(context.Background(), csdata.CreateConversation{
+		Participants: []csdata.AddParticipant{
+			{ParticipantID: "1234567890"},
+			{ParticipantID: "0987654321"},
+		},
+	})
+
+	sub, err := cs.Subscribe(context.Background(), csdata.Subscribe{
+		Participant: &csdata.ReadParticipant{
+			ParticipantID: "1234567890",
+		},
+		Kinds: []model.EventKind{model.EventKindMessageCreated, model.EventKindReadAdvanced},
+	})
+	if err == nil {
+		defer sub.Close(context.Background())
+		for sub.Next(context.Background()) {
+			_ = sub.Event()
+			_ = sub.ResumeToken() // persist to resume after a restart
+		}
+	}
+
+	// The in-memory backend has the same semantics, e.g. for tests without a MongoDB.
+	inMemory := chatsavvy.NewInMemory(chatsavvy.WithLimits(model.DefaultLimits()))
+	inMemory.Conversation.Create(context.Background(), csdata.CreateConversation{
+		Participants: []csdata.AddParticipant{
+			{ParticipantID: "1234567890"},
+			{ParticipantID: "0987654321"},
+		},
+	})
+
 	// cs.Conversation.Delete(context.Background(), "1234567890")
 }
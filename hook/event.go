@@ -0,0 +1,85 @@
+package hook
+
+import (
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// CreateConversation runs before Conversation.Create.
+type CreateConversation struct {
+	Data data.CreateConversation
+}
+
+// ConversationCreated runs after Conversation.Create inserted a new conversation.
+// It does not run when an existing conversation is returned instead.
+type ConversationCreated struct {
+	Conversation *model.Conversation
+}
+
+// AddParticipant runs before Conversation.AddParticipant.
+type AddParticipant struct {
+	ConversationID string
+	Data           data.AddParticipant
+}
+
+// ParticipantAdded runs after Conversation.AddParticipant added the participant.
+type ParticipantAdded struct {
+	Conversation *model.Conversation
+	Participant  data.AddParticipant
+}
+
+// DeleteParticipant runs before Conversation.DeleteParticipant.
+type DeleteParticipant struct {
+	ConversationID string
+	Data           data.DeleteParticipant
+}
+
+// ParticipantDeleted runs after Conversation.DeleteParticipant.
+type ParticipantDeleted struct {
+	Conversation *model.Conversation
+	Participant  data.DeleteParticipant
+}
+
+// CreateMessage runs before Message.Create.
+type CreateMessage struct {
+	ConversationID string
+	Data           data.CreateMessage
+}
+
+// MessageCreated runs after Message.Create.
+type MessageCreated struct {
+	Message *model.Message
+}
+
+// EditMessage runs before Message.Edit.
+type EditMessage struct {
+	Data data.EditMessage
+}
+
+// MessageEdited runs after Message.Edit.
+type MessageEdited struct {
+	Message *model.Message
+}
+
+// ToggleReaction runs before Message.ToggleReaction.
+type ToggleReaction struct {
+	Data data.ToggleReaction
+}
+
+// ReactionToggled runs after Message.ToggleReaction.
+type ReactionToggled struct {
+	Message *model.Message
+	Data    data.ToggleReaction
+}
+
+// MarkRead runs before Message.MarkRead, and so before Message.MarkAllRead.
+type MarkRead struct {
+	Data data.MarkRead
+}
+
+// ReadMarked runs after Message.MarkRead advanced the participant's read cursor.
+// It does not run when the call was a no-op because the cursor was already further ahead.
+type ReadMarked struct {
+	Conversation *model.Conversation
+	Data         data.MarkRead
+}
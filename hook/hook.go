@@ -0,0 +1,82 @@
+package hook
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// Registry holds the hooks run around repository writes.
+// Before hooks run ahead of a write and veto it by returning an error.
+// After hooks run once the write has succeeded.
+// A nil Registry runs no hooks.
+type Registry struct {
+	mu     sync.RWMutex
+	before map[reflect.Type][]func(context.Context, any) error
+	after  map[reflect.Type][]func(context.Context, any)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		before: make(map[reflect.Type][]func(context.Context, any) error),
+		after:  make(map[reflect.Type][]func(context.Context, any)),
+	}
+}
+
+// Before registers a hook that runs before the operation described by the payload type E,
+// e.g. CreateMessage. Returning an error aborts the operation with that error.
+func Before[E any](r *Registry, fn func(ctx context.Context, e E) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := reflect.TypeFor[E]()
+	r.before[key] = append(r.before[key], func(ctx context.Context, e any) error {
+		return fn(ctx, e.(E))
+	})
+}
+
+// After registers a hook that runs after the operation described by the payload type E
+// has succeeded, e.g. MessageCreated.
+func After[E any](r *Registry, fn func(ctx context.Context, e E)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := reflect.TypeFor[E]()
+	r.after[key] = append(r.after[key], func(ctx context.Context, e any) {
+		fn(ctx, e.(E))
+	})
+}
+
+// RunBefore runs the before hooks registered for the payload's type in registration order.
+// It stops at and returns the first error.
+func (r *Registry) RunBefore(ctx context.Context, e any) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	hooks := r.before[reflect.TypeOf(e)]
+	r.mu.RUnlock()
+
+	for _, fn := range hooks {
+		if err := fn(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunAfter runs the after hooks registered for the payload's type in registration order.
+func (r *Registry) RunAfter(ctx context.Context, e any) {
+	if r == nil {
+		return
+	}
+
+	r.mu.RLock()
+	hooks := r.after[reflect.TypeOf(e)]
+	r.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(ctx, e)
+	}
+}
@@ -0,0 +1,61 @@
+package hook_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("runs hooks registered for the payload type in order", func(t *testing.T) {
+		r := hook.NewRegistry()
+
+		var calls []string
+		hook.After(r, func(ctx context.Context, e hook.MessageCreated) {
+			calls = append(calls, "first:"+e.Message.Content)
+		})
+		hook.After(r, func(ctx context.Context, e hook.MessageCreated) {
+			calls = append(calls, "second:"+e.Message.Content)
+		})
+		hook.After(r, func(ctx context.Context, e hook.ReactionToggled) {
+			calls = append(calls, "reaction")
+		})
+
+		r.RunAfter(t.Context(), hook.MessageCreated{Message: &model.Message{Content: "hi"}})
+
+		assert.Equal(t, []string{"first:hi", "second:hi"}, calls)
+	})
+
+	t.Run("stops at the first before hook error", func(t *testing.T) {
+		r := hook.NewRegistry()
+		veto := errors.New("veto")
+
+		var calls int
+		hook.Before(r, func(ctx context.Context, e hook.CreateMessage) error {
+			calls++
+			return veto
+		})
+		hook.Before(r, func(ctx context.Context, e hook.CreateMessage) error {
+			calls++
+			return nil
+		})
+
+		err := r.RunBefore(t.Context(), hook.CreateMessage{Data: data.CreateMessage{Content: "hi"}})
+		assert.ErrorIs(t, err, veto)
+		assert.Equal(t, 1, calls)
+
+		assert.NoError(t, r.RunBefore(t.Context(), hook.MarkRead{}))
+	})
+
+	t.Run("a nil registry runs nothing", func(t *testing.T) {
+		var r *hook.Registry
+
+		assert.NoError(t, r.RunBefore(t.Context(), hook.CreateMessage{}))
+		assert.NotPanics(t, func() { r.RunAfter(t.Context(), hook.MessageCreated{}) })
+	})
+}
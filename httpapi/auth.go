@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrUnauthenticated is returned by an Authenticator when the request does not identify a
+// participant. The handler responds with 401.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Identity is the participant the caller acts as. TenantID selects the tenant whose
+// conversations the caller works with, empty for the default tenant.
+type Identity struct {
+	TenantID      string
+	ParticipantID string
+	Metadata      map[string]any
+}
+
+// Authenticator resolves the identity of the caller of a request, e.g. from a session cookie
+// or a bearer token. It returns ErrUnauthenticated when the request does not identify anyone.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// The headers read by HeaderAuthenticator.
+const (
+	HeaderTenantID            = "X-Tenant-ID"
+	HeaderParticipantID       = "X-Participant-ID"
+	HeaderParticipantMetadata = "X-Participant-Metadata"
+)
+
+// HeaderAuthenticator takes the identity from the X-Participant-ID header, the
+// X-Participant-Metadata header holding the metadata as a JSON object, and the X-Tenant-ID header.
+// It trusts the headers, so it must only be used behind a gateway that authenticates the
+// caller and sets them.
+func HeaderAuthenticator() Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Identity, error) {
+		participantID := r.Header.Get(HeaderParticipantID)
+		if participantID == "" {
+			return nil, ErrUnauthenticated
+		}
+
+		var metadata map[string]any
+		if raw := r.Header.Get(HeaderParticipantMetadata); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+				return nil, fmt.Errorf("failed to parse the participant metadata: %w", ErrUnauthenticated)
+			}
+		}
+
+		return &Identity{
+			TenantID:      r.Header.Get(HeaderTenantID),
+			ParticipantID: participantID,
+			Metadata:      metadata,
+		}, nil
+	})
+}
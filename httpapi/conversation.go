@@ -0,0 +1,305 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+)
+
+type participantRequest struct {
+	ParticipantID string         `json:"participant_id"`
+	Metadata      map[string]any `json:"metadata"`
+	Role          model.Role     `json:"role"`
+}
+
+type createConversationRequest struct {
+	Kind              model.ConversationKind  `json:"kind"`
+	Title             string                  `json:"title"`
+	Participants      []participantRequest    `json:"participants"`
+	Metadata          map[string]any          `json:"metadata"`
+	HistoryVisibility model.HistoryVisibility `json:"history_visibility"`
+}
+
+// updateConversationRequest changes the fields that are present.
+type updateConversationRequest struct {
+	Title             *string                  `json:"title"`
+	Metadata          *map[string]any          `json:"metadata"`
+	HistoryVisibility *model.HistoryVisibility `json:"history_visibility"`
+}
+
+type setRoleRequest struct {
+	Role model.Role `json:"role"`
+}
+
+// markReadRequest marks the conversation read up to the message, or entirely without one.
+type markReadRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+type unreadCountResponse struct {
+	UnreadCount uint `json:"unread_count"`
+}
+
+// inbox lists the caller's conversations with their unread counts, most recently updated first.
+func (h *Handler) inbox(w http.ResponseWriter, r *http.Request, c caller) error {
+	perPage, err := perPage(r)
+	if err != nil {
+		return err
+	}
+	unreadOnly, err := flag(r, "unread_only")
+	if err != nil {
+		return err
+	}
+	includeArchived, err := flag(r, "include_archived")
+	if err != nil {
+		return err
+	}
+
+	inbox, err := c.chat.Conversation.Inbox(r.Context(), data.LoadInbox{
+		Participant:     c.participant(),
+		UnreadOnly:      unreadOnly,
+		IncludeArchived: includeArchived,
+		Cursor:          r.URL.Query().Get("cursor"),
+		PerPage:         perPage,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, inbox)
+	return nil
+}
+
+// createConversation creates a conversation with the caller as a participant, the owner of
+// a group unless another role is given. Creating a direct conversation returns the existing
+// one with the same participants if there is one.
+func (h *Handler) createConversation(w http.ResponseWriter, r *http.Request, c caller) error {
+	var req createConversationRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	creator := -1
+	participants := make([]data.AddParticipant, len(req.Participants))
+	for i, p := range req.Participants {
+		participants[i] = data.AddParticipant{ParticipantID: p.ParticipantID, Metadata: p.Metadata, Role: p.Role}
+		if p.ParticipantID == c.ParticipantID && model.MetadataEqual(p.Metadata, c.Metadata) {
+			creator = i
+		}
+	}
+	if creator == -1 {
+		participants = append([]data.AddParticipant{{ParticipantID: c.ParticipantID, Metadata: c.Metadata}}, participants...)
+		creator = 0
+	}
+	if req.Kind == model.ConversationKindGroup && participants[creator].Role == "" {
+		participants[creator].Role = model.RoleOwner
+	}
+
+	conversation, err := c.chat.Conversation.Create(r.Context(), data.CreateConversation{
+		Kind:              req.Kind,
+		Title:             req.Title,
+		Participants:      participants,
+		Metadata:          req.Metadata,
+		HistoryVisibility: req.HistoryVisibility,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, conversation)
+	return nil
+}
+
+func (h *Handler) getConversation(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := c.conversation(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+	return nil
+}
+
+// updateConversation changes the title, metadata and history visibility of the conversation,
+// as permitted by the caller's role.
+func (h *Handler) updateConversation(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	var req updateConversationRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	conversation, err := c.conversation(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if req.Title != nil || req.Metadata != nil || req.HistoryVisibility != nil {
+		conversation, err = c.chat.Conversation.Update(ctx, id, c.actor(), data.UpdateConversation{
+			Title:             req.Title,
+			Metadata:          req.Metadata,
+			HistoryVisibility: req.HistoryVisibility,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+	return nil
+}
+
+func (h *Handler) addParticipant(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	var req participantRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	conversation, err := c.chat.Conversation.AddParticipant(r.Context(), id, c.actor(), data.AddParticipant{
+		ParticipantID: req.ParticipantID,
+		Metadata:      req.Metadata,
+		Role:          req.Role,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+	return nil
+}
+
+// removeParticipant removes the participant identified by the path and the metadata query
+// parameter. Participants may always remove themselves.
+func (h *Handler) removeParticipant(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := metadata(r)
+	if err != nil {
+		return err
+	}
+
+	conversation, err := c.chat.Conversation.DeleteParticipant(r.Context(), id, c.actor(), data.DeleteParticipant{
+		ParticipantID: r.PathValue("participant_id"),
+		Metadata:      metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+	return nil
+}
+
+func (h *Handler) setRole(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := metadata(r)
+	if err != nil {
+		return err
+	}
+
+	var req setRoleRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	conversation, err := c.chat.Conversation.SetRole(r.Context(), id, c.actor(), data.SetParticipantRole{
+		ParticipantID: r.PathValue("participant_id"),
+		Metadata:      metadata,
+		Role:          req.Role,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+	return nil
+}
+
+// markRead advances the caller's read cursor.
+func (h *Handler) markRead(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	var req markReadRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	conversation, err := c.read(r.Context(), id, req.MessageID)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, conversation)
+	return nil
+}
+
+// read advances the caller's read cursor to the message, or to the latest message without
+// one. It never moves it back.
+func (c caller) read(ctx context.Context, conversationID, messageID string) (*model.Conversation, error) {
+	if _, err := c.conversation(ctx, conversationID); err != nil {
+		return nil, err
+	}
+
+	if messageID == "" {
+		return c.chat.Message.MarkAllRead(ctx, data.MarkAllRead{
+			ConversationID: conversationID,
+			Participant:    c.participant(),
+		})
+	}
+
+	if _, err := existingID(messageID, store.ErrMessageNotFound); err != nil {
+		return nil, err
+	}
+
+	return c.chat.Message.MarkRead(ctx, data.MarkRead{
+		ConversationID: conversationID,
+		Participant:    c.participant(),
+		MessageID:      messageID,
+	})
+}
+
+func (h *Handler) unreadCount(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	count, err := c.chat.Message.UnreadCount(r.Context(), data.UnreadCount{
+		ConversationID: id,
+		Participant:    c.participant(),
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, unreadCountResponse{UnreadCount: count})
+	return nil
+}
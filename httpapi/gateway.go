@@ -0,0 +1,280 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"golang.org/x/net/websocket"
+)
+
+// writeTimeout bounds the time a frame may take to reach a WebSocket client.
+const writeTimeout = 10 * time.Second
+
+// The commands accepted over the WebSocket connection.
+const (
+	commandSend     = "send"
+	commandReact    = "react"
+	commandMarkRead = "mark_read"
+)
+
+// The types of the frames sent over the WebSocket connection.
+const (
+	frameEvent = "event"
+	frameAck   = "ack"
+	frameError = "error"
+)
+
+// command is a frame sent by the client. The id is echoed by the ack of the command.
+type command struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type sendCommand struct {
+	ConversationID string `json:"conversation_id"`
+	createMessageRequest
+}
+
+type reactCommand struct {
+	MessageID string `json:"message_id"`
+	toggleReactionRequest
+}
+
+type markReadCommand struct {
+	ConversationID string `json:"conversation_id"`
+	markReadRequest
+}
+
+// frame is a frame sent to the client: an event, the ack of a command holding either its
+// result or its error, or the error closing the connection.
+type frame struct {
+	Type   string       `json:"type"`
+	ID     string       `json:"id,omitempty"`
+	Event  *model.Event `json:"event,omitempty"`
+	Result any          `json:"result,omitempty"`
+	Error  *errorBody   `json:"error,omitempty"`
+}
+
+// connect upgrades the request to a WebSocket connection delivering the events of the
+// caller's conversations and accepting the caller's commands. The caller is subscribed to
+// the events once the handshake accepted the connection, see checkOrigin.
+//
+// Events are read from a change stream shared by the connections of the tenant when the
+// storage backend has change streams, and from the writes made through this Handler's
+// ChatSavvy otherwise, see hub.
+func (h *Handler) connect(w http.ResponseWriter, r *http.Request, c caller) error {
+	server := websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			events, err := h.hub.subscribe(c, h.sendQueue)
+			if err != nil {
+				// The connection is upgraded already, so the error can only be sent as a frame.
+				defer ws.Close()
+				if err := ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err == nil {
+					_ = websocket.JSON.Send(ws, frame{Type: frameError, Error: h.frameError(r, err)})
+				}
+				return
+			}
+			defer events.Close(context.Background())
+
+			h.serve(r, ws, c, events)
+		},
+	}
+	server.ServeHTTP(w, r)
+	return nil
+}
+
+// checkOrigin accepts the connections opened by pages served from the host of the request or
+// from one of the allowed origins, see WithAllowedOrigins. Clients other than browsers send
+// no Origin and are accepted.
+func (h *Handler) checkOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	config.Origin = origin
+	if origin == nil {
+		return nil
+	}
+
+	if strings.EqualFold(origin.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range h.allowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(allowed), "/"), origin.Scheme+"://"+origin.Host) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// serve runs the connection until either side closes it. Events and acks are queued for a
+// single writer. Commands are read one at a time and their ack waits for room in the queue,
+// so a client that does not read stops being read. Events never wait: a client that lets
+// the queue fill up is disconnected with ErrSlowConsumer and is expected to reconnect and
+// catch up through the JSON API.
+func (h *Handler) serve(r *http.Request, ws *websocket.Conn, c caller, events subscription) {
+	ws.MaxPayloadBytes = maxBodySize
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+
+	queue := make(chan frame, h.sendQueue)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		h.write(ctx, r, ws, queue)
+	}()
+
+	go func() {
+		defer wg.Done()
+		for events.Next(ctx) {
+			event := events.Event()
+			select {
+			case queue <- frame{Type: frameEvent, Event: &event}:
+			default:
+				cancel(ErrSlowConsumer)
+				return
+			}
+		}
+		cancel(events.Err())
+	}()
+
+	// read returns once the connection ended, which ends the other goroutines too.
+	h.read(ctx, cancel, r, ws, c, queue)
+	wg.Wait()
+}
+
+// write sends the queued frames until the connection ends, then sends the reason it ended
+// unless the client left, and closes the connection.
+func (h *Handler) write(ctx context.Context, r *http.Request, ws *websocket.Conn, queue <-chan frame) {
+	defer ws.Close()
+
+	send := func(f frame) error {
+		if err := ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			return err
+		}
+		return websocket.JSON.Send(ws, f)
+	}
+
+	for {
+		select {
+		case f := <-queue:
+			if err := send(f); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			cause := context.Cause(ctx)
+			if errors.Is(cause, context.Canceled) || errors.Is(cause, errClientLeft) {
+				return
+			}
+			_ = send(frame{Type: frameError, Error: h.frameError(r, cause)})
+			return
+		}
+	}
+}
+
+// errClientLeft ends the connection when the client closed it.
+var errClientLeft = errors.New("client left")
+
+// read runs the commands of the client until the connection ends.
+func (h *Handler) read(ctx context.Context, cancel context.CancelCauseFunc, r *http.Request, ws *websocket.Conn, c caller, queue chan<- frame) {
+	for {
+		var raw []byte
+		if err := websocket.Message.Receive(ws, &raw); err != nil {
+			if errors.Is(err, websocket.ErrFrameTooLarge) {
+				// The rest of the frame is still on the wire, so the connection cannot continue.
+				cancel(invalid("frame", "max", "", "frame is too large"))
+				return
+			}
+			cancel(errClientLeft)
+			return
+		}
+
+		ack := h.run(ctx, r, c, raw)
+		select {
+		case queue <- ack:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs the command and returns its ack.
+func (h *Handler) run(ctx context.Context, r *http.Request, c caller, raw []byte) frame {
+	var cmd command
+	if err := decodeJSON(bytes.NewReader(raw), "frame", &cmd); err != nil {
+		return frame{Type: frameAck, Error: h.frameError(r, err)}
+	}
+
+	result, err := c.run(ctx, cmd)
+	if err != nil {
+		return frame{Type: frameAck, ID: cmd.ID, Error: h.frameError(r, err)}
+	}
+
+	return frame{Type: frameAck, ID: cmd.ID, Result: result}
+}
+
+func (c caller) run(ctx context.Context, cmd command) (any, error) {
+	switch cmd.Type {
+	case commandSend:
+		var d sendCommand
+		if err := decodeJSON(bytes.NewReader(cmd.Data), "data", &d); err != nil {
+			return nil, err
+		}
+		id, err := existingID(d.ConversationID, store.ErrConversationNotFound)
+		if err != nil {
+			return nil, err
+		}
+		return c.send(ctx, id, d.createMessageRequest)
+
+	case commandReact:
+		var d reactCommand
+		if err := decodeJSON(bytes.NewReader(cmd.Data), "data", &d); err != nil {
+			return nil, err
+		}
+		id, err := existingID(d.MessageID, store.ErrMessageNotFound)
+		if err != nil {
+			return nil, err
+		}
+		return c.react(ctx, id, d.Emoji)
+
+	case commandMarkRead:
+		var d markReadCommand
+		if err := decodeJSON(bytes.NewReader(cmd.Data), "data", &d); err != nil {
+			return nil, err
+		}
+		id, err := existingID(d.ConversationID, store.ErrConversationNotFound)
+		if err != nil {
+			return nil, err
+		}
+		return c.read(ctx, id, d.MessageID)
+
+	default:
+		return nil, invalid("type", "oneof", "send react mark_read", "type must be one of send, react or mark_read")
+	}
+}
+
+// frameError describes the error in a frame, with the codes of the JSON API.
+func (h *Handler) frameError(r *http.Request, err error) *errorBody {
+	if errors.Is(err, ErrSlowConsumer) {
+		return &errorBody{Code: "slow_consumer", Message: err.Error()}
+	}
+
+	_, body := h.errorBody(r, err)
+	return &body
+}
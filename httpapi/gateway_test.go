@@ -0,0 +1,217 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/httpapi"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type frame struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Event  *model.Event    `json:"event"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code string `json:"code"`
+	} `json:"error"`
+}
+
+func (c *client) dial(participantID string) (*websocket.Conn, error) {
+	c.t.Helper()
+	return c.dialFrom(c.server.URL, participantID)
+}
+
+// dialFrom connects as the participant from a page of the origin.
+func (c *client) dialFrom(origin, participantID string) (*websocket.Conn, error) {
+	c.t.Helper()
+
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(c.server.URL, "http")+"/ws", origin)
+	require.NoError(c.t, err)
+	config.Header.Set(httpapi.HeaderParticipantID, participantID)
+
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c.t.Cleanup(func() { ws.Close() })
+
+	return ws, nil
+}
+
+func (c *client) connect(participantID string) *websocket.Conn {
+	c.t.Helper()
+
+	ws, err := c.dial(participantID)
+	require.NoError(c.t, err)
+	return ws
+}
+
+func command(t *testing.T, ws *websocket.Conn, id, kind string, data any) {
+	t.Helper()
+	require.NoError(t, websocket.JSON.Send(ws, map[string]any{"id": id, "type": kind, "data": data}))
+}
+
+func receive(t *testing.T, ws *websocket.Conn) frame {
+	t.Helper()
+
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var f frame
+	require.NoError(t, websocket.JSON.Receive(ws, &f))
+	return f
+}
+
+// receiveUntil skips the frames until one matches.
+func receiveUntil(t *testing.T, ws *websocket.Conn, match func(frame) bool) frame {
+	t.Helper()
+
+	for {
+		if f := receive(t, ws); match(f) {
+			return f
+		}
+	}
+}
+
+func ack(id string) func(frame) bool {
+	return func(f frame) bool { return f.Type == "ack" && f.ID == id }
+}
+
+func event(kind model.EventKind) func(frame) bool {
+	return func(f frame) bool { return f.Type == "event" && f.Event.Kind == kind }
+}
+
+func TestGateway(t *testing.T) {
+	t.Run("delivers the events of the participant's conversations", func(t *testing.T) {
+		c := newClient(t)
+		bob := c.connect("bob")
+		eve := c.connect("eve")
+		conversation := c.createConversation("alice", "bob")
+
+		var message model.Message
+		status := c.do("alice", http.MethodPost, "/conversations/"+conversation.ID.Hex()+"/messages", map[string]any{"kind": "text", "content": "Hello"}, &message)
+		require.Equal(t, http.StatusCreated, status)
+
+		f := receive(t, bob)
+		require.Equal(t, "event", f.Type)
+		assert.Equal(t, model.EventKindMessageCreated, f.Event.Kind)
+		assert.Equal(t, conversation.ID.Hex(), f.Event.ConversationID)
+		assert.Equal(t, message.ID, f.Event.Message.ID)
+
+		// The ack is the first frame eve receives, so the message was not delivered to her.
+		command(t, eve, "1", "mark_read", map[string]any{"conversation_id": conversation.ID.Hex()})
+		f = receive(t, eve)
+		assert.Equal(t, "ack", f.Type)
+		require.NotNil(t, f.Error)
+		assert.Equal(t, "forbidden", f.Error.Code)
+	})
+
+	t.Run("runs commands and acknowledges them", func(t *testing.T) {
+		c := newClient(t)
+		conversation := c.createConversation("alice", "bob")
+		alice := c.connect("alice")
+		bob := c.connect("bob")
+
+		command(t, bob, "1", "send", map[string]any{"conversation_id": conversation.ID.Hex(), "kind": "text", "content": "Hello"})
+		f := receiveUntil(t, bob, ack("1"))
+		require.Nil(t, f.Error)
+		var message model.Message
+		require.NoError(t, json.Unmarshal(f.Result, &message))
+		assert.Equal(t, "bob", message.Sender.ParticipantID)
+
+		f = receiveUntil(t, alice, event(model.EventKindMessageCreated))
+		assert.Equal(t, message.ID, f.Event.Message.ID)
+
+		command(t, alice, "2", "react", map[string]any{"message_id": message.ID.Hex(), "emoji": "👍"})
+		f = receiveUntil(t, alice, ack("2"))
+		require.Nil(t, f.Error)
+
+		f = receiveUntil(t, bob, event(model.EventKindReactionToggled))
+		require.Len(t, f.Event.Message.Reactions, 1)
+		assert.Equal(t, "👍", f.Event.Message.Reactions[0].Emoji)
+
+		command(t, alice, "3", "mark_read", map[string]any{"conversation_id": conversation.ID.Hex(), "message_id": message.ID.Hex()})
+		f = receiveUntil(t, alice, ack("3"))
+		require.Nil(t, f.Error)
+
+		f = receiveUntil(t, bob, event(model.EventKindReadAdvanced))
+		assert.Equal(t, "alice", f.Event.Participant.ParticipantID)
+	})
+
+	t.Run("delivers participant changes", func(t *testing.T) {
+		c := newClient(t)
+
+		var conversation model.Conversation
+		status := c.do("alice", http.MethodPost, "/conversations", map[string]any{
+			"kind":         "group",
+			"participants": []map[string]any{{"participant_id": "bob"}},
+		}, &conversation)
+		require.Equal(t, http.StatusCreated, status)
+
+		bob := c.connect("bob")
+		carol := c.connect("carol")
+
+		status = c.do("alice", http.MethodPost, "/conversations/"+conversation.ID.Hex()+"/participants", map[string]any{"participant_id": "carol"}, nil)
+		require.Equal(t, http.StatusOK, status)
+
+		f := receive(t, bob)
+		assert.Equal(t, model.EventKindParticipantAdded, f.Event.Kind)
+		assert.Equal(t, "carol", f.Event.Participant.ParticipantID)
+
+		f = receive(t, carol)
+		assert.Equal(t, model.EventKindParticipantAdded, f.Event.Kind)
+	})
+
+	t.Run("acknowledges invalid commands with an error", func(t *testing.T) {
+		c := newClient(t)
+		bob := c.connect("bob")
+
+		command(t, bob, "1", "shout", nil)
+		f := receive(t, bob)
+		assert.Equal(t, "1", f.ID)
+		require.NotNil(t, f.Error)
+		assert.Equal(t, "validation_failed", f.Error.Code)
+
+		command(t, bob, "2", "send", map[string]any{"conversation_id": "abc", "kind": "text", "content": "Hi"})
+		f = receive(t, bob)
+		require.NotNil(t, f.Error)
+		assert.Equal(t, "not_found", f.Error.Code)
+
+		require.NoError(t, websocket.Message.Send(bob, "{"))
+		f = receive(t, bob)
+		assert.Equal(t, "ack", f.Type)
+		require.NotNil(t, f.Error)
+		assert.Equal(t, "validation_failed", f.Error.Code)
+	})
+
+	t.Run("rejects unauthenticated connections", func(t *testing.T) {
+		c := newClient(t)
+
+		_, err := c.dial("")
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects connections from other origins", func(t *testing.T) {
+		c := newClient(t)
+
+		_, err := c.dialFrom("https://evil.example.com", "bob")
+		assert.Error(t, err)
+	})
+
+	t.Run("accepts connections from the allowed origins", func(t *testing.T) {
+		c := newClient(t, httpapi.WithAllowedOrigins("https://app.example.com"))
+
+		_, err := c.dialFrom("https://app.example.com", "bob")
+		assert.NoError(t, err)
+
+		_, err = c.dialFrom("https://evil.example.com", "bob")
+		assert.Error(t, err)
+	})
+}
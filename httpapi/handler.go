@@ -0,0 +1,196 @@
+// Package httpapi exposes conversations, participants, messages, reactions and read receipts
+// of a ChatSavvy as a JSON API, see openapi.json for the endpoints. The Handler can be mounted
+// in an existing server, cmd/server runs it standalone.
+//
+// Every request acts as the participant resolved by the Authenticator. Ids are the hex
+// encoded object ids and times are RFC 3339.
+//
+// Clients that want live updates connect to /ws once. The WebSocket connection delivers the
+// events of all of the caller's conversations and accepts the send, react and mark_read
+// commands, each answered by an ack.
+package httpapi
+
+import (
+	"context"
+	_ "embed"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/davesavic/chatsavvy"
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// OpenAPI is the OpenAPI 3.1 document describing the endpoints, served at /openapi.json.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Option configures the Handler.
+type Option func(*config)
+
+type config struct {
+	logger         *slog.Logger
+	sendQueue      int
+	allowedOrigins []string
+}
+
+// WithLogger replaces slog.Default as the logger of the unexpected errors.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithSendQueue sets the number of frames queued for a WebSocket client, 64 by default.
+// A client that lets the queue fill up with events is disconnected.
+func WithSendQueue(size int) Option {
+	return func(c *config) {
+		c.sendQueue = size
+	}
+}
+
+// WithAllowedOrigins sets the origins, e.g. https://app.example.com, of the web pages allowed
+// to open a WebSocket connection besides the pages served from the host of the API.
+// Connections from other pages are rejected, so that they cannot act as a participant whose
+// cookies the browser sends along.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(c *config) {
+		c.allowedOrigins = append(c.allowedOrigins, origins...)
+	}
+}
+
+// Handler serves the JSON API.
+type Handler struct {
+	chat *chatsavvy.ChatSavvy
+	auth Authenticator
+	mux  *http.ServeMux
+	hub  *hub
+	config
+
+	// tenants holds the ChatSavvy of each tenant by tenant id, built on its first request.
+	tenants sync.Map
+}
+
+// New returns a Handler serving the conversations of cs to the callers identified by auth.
+// Callers with a tenant id are served the conversations of that tenant, see ChatSavvy.ForTenant.
+func New(cs *chatsavvy.ChatSavvy, auth Authenticator, opts ...Option) *Handler {
+	c := config{
+		logger:    slog.Default(),
+		sendQueue: 64,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	h := &Handler{
+		chat:   cs,
+		auth:   auth,
+		mux:    http.NewServeMux(),
+		hub:    newHub(cs.Hooks, c.logger),
+		config: c,
+	}
+
+	h.mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(OpenAPI)
+	})
+
+	h.handle("GET /conversations", h.inbox)
+	h.handle("POST /conversations", h.createConversation)
+	h.handle("GET /conversations/{id}", h.getConversation)
+	h.handle("PATCH /conversations/{id}", h.updateConversation)
+	h.handle("POST /conversations/{id}/participants", h.addParticipant)
+	h.handle("DELETE /conversations/{id}/participants/{participant_id}", h.removeParticipant)
+	h.handle("PUT /conversations/{id}/participants/{participant_id}/role", h.setRole)
+	h.handle("POST /conversations/{id}/read", h.markRead)
+	h.handle("GET /conversations/{id}/unread", h.unreadCount)
+
+	h.handle("GET /conversations/{id}/messages", h.listMessages)
+	h.handle("POST /conversations/{id}/messages", h.createMessage)
+	h.handle("GET /conversations/{id}/messages/{message_id}/replies", h.listReplies)
+	h.handle("GET /conversations/{id}/messages/{message_id}/readers", h.readers)
+	h.handle("PATCH /messages/{id}", h.editMessage)
+	h.handle("DELETE /messages/{id}", h.deleteMessage)
+	h.handle("POST /messages/{id}/hide", h.hideMessage)
+	h.handle("POST /messages/{id}/reactions", h.toggleReaction)
+
+	h.handle("GET /ws", h.connect)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// endpoint handles a request of an authenticated caller. The returned error is written as
+// the response, see Handler.error.
+type endpoint func(w http.ResponseWriter, r *http.Request, c caller) error
+
+// handle registers the endpoint behind the authentication.
+func (h *Handler) handle(pattern string, e endpoint) {
+	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		identity, err := h.auth.Authenticate(r)
+		if err == nil && identity == nil {
+			err = ErrUnauthenticated
+		}
+		if err != nil {
+			h.error(w, r, err)
+			return
+		}
+
+		if err := e(w, r, caller{Identity: *identity, chat: h.forTenant(identity.TenantID)}); err != nil {
+			h.error(w, r, err)
+		}
+	})
+}
+
+// forTenant returns the ChatSavvy serving the tenant, the Handler's own one without a tenant.
+// Tenants share their ChatSavvy across requests, since building one sets up every repository.
+func (h *Handler) forTenant(tenantID string) *chatsavvy.ChatSavvy {
+	if tenantID == "" {
+		return h.chat
+	}
+
+	if cs, ok := h.tenants.Load(tenantID); ok {
+		return cs.(*chatsavvy.ChatSavvy)
+	}
+	cs, _ := h.tenants.LoadOrStore(tenantID, h.chat.ForTenant(tenantID))
+	return cs.(*chatsavvy.ChatSavvy)
+}
+
+// caller is the authenticated caller of a request along with the ChatSavvy of its tenant.
+type caller struct {
+	Identity
+	chat *chatsavvy.ChatSavvy
+}
+
+func (c caller) participant() data.ReadParticipant {
+	return data.ReadParticipant{ParticipantID: c.ParticipantID, Metadata: c.Metadata}
+}
+
+func (c caller) actor() *data.Actor {
+	return &data.Actor{ParticipantID: c.ParticipantID, Metadata: c.Metadata}
+}
+
+func (c caller) sender() data.MessageSender {
+	return data.MessageSender{ParticipantID: c.ParticipantID, Metadata: c.Metadata}
+}
+
+// conversation fetches the conversation for reading. The caller must be one of its
+// non-deleted participants.
+func (c caller) conversation(ctx context.Context, id string) (*model.Conversation, error) {
+	conversation, err := c.chat.Conversation.Find(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := chat.RequireParticipant(conversation, c.ParticipantID, c.Metadata); err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
@@ -0,0 +1,20 @@
+package httpapi
+
+import (
+	"testing"
+
+	"github.com/davesavic/chatsavvy"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler_forTenant(t *testing.T) {
+	cs := chatsavvy.NewInMemory()
+	h := New(cs, HeaderAuthenticator())
+
+	assert.Same(t, cs, h.forTenant(""))
+
+	acme := h.forTenant("acme")
+	assert.NotSame(t, cs, acme)
+	assert.Same(t, acme, h.forTenant("acme"))
+	assert.NotSame(t, acme, h.forTenant("globex"))
+}
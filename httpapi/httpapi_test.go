@@ -0,0 +1,283 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davesavic/chatsavvy"
+	"github.com/davesavic/chatsavvy/httpapi"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type client struct {
+	t      *testing.T
+	server *httptest.Server
+}
+
+func newClient(t *testing.T, opts ...httpapi.Option) *client {
+	t.Helper()
+
+	server := httptest.NewServer(httpapi.New(chatsavvy.NewInMemory(), httpapi.HeaderAuthenticator(), opts...))
+	t.Cleanup(server.Close)
+
+	return &client{t: t, server: server}
+}
+
+// do sends the request as the participant and decodes the response body into out.
+func (c *client) do(participantID, method, path string, body, out any) int {
+	c.t.Helper()
+
+	var reader *bytes.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		require.NoError(c.t, err)
+		reader = bytes.NewReader(raw)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	require.NoError(c.t, err)
+	if participantID != "" {
+		req.Header.Set(httpapi.HeaderParticipantID, participantID)
+	}
+
+	res, err := c.server.Client().Do(req)
+	require.NoError(c.t, err)
+	defer res.Body.Close()
+
+	if out != nil && res.StatusCode != http.StatusNoContent {
+		require.NoError(c.t, json.NewDecoder(res.Body).Decode(out))
+	}
+
+	return res.StatusCode
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Fields  []struct {
+			Field string `json:"field"`
+			Rule  string `json:"rule"`
+		} `json:"fields"`
+	} `json:"error"`
+}
+
+func (c *client) createConversation(participantID string, others ...string) model.Conversation {
+	c.t.Helper()
+
+	participants := make([]map[string]any, len(others))
+	for i, id := range others {
+		participants[i] = map[string]any{"participant_id": id}
+	}
+
+	var conversation model.Conversation
+	status := c.do(participantID, http.MethodPost, "/conversations", map[string]any{"participants": participants}, &conversation)
+	require.Equal(c.t, http.StatusCreated, status)
+
+	return conversation
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("sends, reacts to and reads messages", func(t *testing.T) {
+		c := newClient(t)
+		conversation := c.createConversation("alice", "bob")
+		assert.Len(t, conversation.Participants, 2)
+		path := "/conversations/" + conversation.ID.Hex()
+
+		var message model.Message
+		status := c.do("alice", http.MethodPost, path+"/messages", map[string]any{"kind": "text", "content": "Hello"}, &message)
+		require.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, "alice", message.Sender.ParticipantID)
+
+		var unread struct {
+			UnreadCount uint `json:"unread_count"`
+		}
+		require.Equal(t, http.StatusOK, c.do("bob", http.MethodGet, path+"/unread", nil, &unread))
+		assert.Equal(t, uint(1), unread.UnreadCount)
+
+		var messages struct {
+			Messages []model.Message `json:"messages"`
+		}
+		require.Equal(t, http.StatusOK, c.do("bob", http.MethodGet, path+"/messages", nil, &messages))
+		require.Len(t, messages.Messages, 1)
+		assert.Equal(t, message.ID, messages.Messages[0].ID)
+
+		var reacted model.Message
+		status = c.do("bob", http.MethodPost, "/messages/"+message.ID.Hex()+"/reactions", map[string]any{"emoji": "👍"}, &reacted)
+		require.Equal(t, http.StatusOK, status)
+		require.Len(t, reacted.Reactions, 1)
+		assert.Equal(t, "bob", reacted.Reactions[0].Participants[0].ParticipantID)
+
+		require.Equal(t, http.StatusOK, c.do("bob", http.MethodPost, path+"/read", map[string]any{"message_id": message.ID.Hex()}, nil))
+		require.Equal(t, http.StatusOK, c.do("bob", http.MethodGet, path+"/unread", nil, &unread))
+		assert.Equal(t, uint(0), unread.UnreadCount)
+
+		var readers struct {
+			Participants []model.Participant `json:"participants"`
+		}
+		require.Equal(t, http.StatusOK, c.do("alice", http.MethodGet, path+"/messages/"+message.ID.Hex()+"/readers", nil, &readers))
+		require.Len(t, readers.Participants, 1)
+		assert.Equal(t, "bob", readers.Participants[0].ParticipantID)
+
+		var inbox model.Inbox
+		require.Equal(t, http.StatusOK, c.do("alice", http.MethodGet, "/conversations", nil, &inbox))
+		require.Len(t, inbox.Entries, 1)
+		assert.Equal(t, conversation.ID, inbox.Entries[0].Conversation.ID)
+	})
+
+	t.Run("makes the creator the owner of a group", func(t *testing.T) {
+		c := newClient(t)
+
+		var conversation model.Conversation
+		status := c.do("alice", http.MethodPost, "/conversations", map[string]any{
+			"kind":         "group",
+			"title":        "Team",
+			"participants": []map[string]any{{"participant_id": "bob"}},
+		}, &conversation)
+		require.Equal(t, http.StatusCreated, status)
+
+		require.Len(t, conversation.Participants, 2)
+		assert.Equal(t, "alice", conversation.Participants[0].ParticipantID)
+		assert.Equal(t, model.RoleOwner, conversation.Participants[0].Role)
+
+		var updated model.Conversation
+		status = c.do("alice", http.MethodPatch, "/conversations/"+conversation.ID.Hex(), map[string]any{"title": "Renamed"}, &updated)
+		require.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "Renamed", updated.Title)
+
+		var res errorResponse
+		status = c.do("bob", http.MethodPatch, "/conversations/"+conversation.ID.Hex(), map[string]any{"title": "Mine"}, &res)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, "forbidden", res.Error.Code)
+	})
+
+	t.Run("edits and deletes only the caller's messages", func(t *testing.T) {
+		c := newClient(t)
+		conversation := c.createConversation("alice", "bob")
+
+		var message model.Message
+		c.do("alice", http.MethodPost, "/conversations/"+conversation.ID.Hex()+"/messages", map[string]any{"kind": "text", "content": "Hello"}, &message)
+		path := "/messages/" + message.ID.Hex()
+
+		var res errorResponse
+		assert.Equal(t, http.StatusForbidden, c.do("bob", http.MethodPatch, path, map[string]any{"content": "Hi"}, &res))
+
+		var edited model.Message
+		require.Equal(t, http.StatusOK, c.do("alice", http.MethodPatch, path, map[string]any{"content": "Hi"}, &edited))
+		assert.Equal(t, "Hi", edited.Content)
+
+		require.Equal(t, http.StatusOK, c.do("alice", http.MethodDelete, path, nil, &edited))
+		assert.NotNil(t, edited.DeletedAt)
+
+		assert.Equal(t, http.StatusConflict, c.do("alice", http.MethodPatch, path, map[string]any{"content": "Again"}, &res))
+		assert.Equal(t, "conflict", res.Error.Code)
+
+		assert.Equal(t, http.StatusNoContent, c.do("bob", http.MethodPost, path+"/hide", nil, nil))
+	})
+
+	t.Run("rejects unauthenticated requests", func(t *testing.T) {
+		c := newClient(t)
+
+		var res errorResponse
+		assert.Equal(t, http.StatusUnauthorized, c.do("", http.MethodGet, "/conversations", nil, &res))
+		assert.Equal(t, "unauthenticated", res.Error.Code)
+	})
+
+	t.Run("hides conversations from non-participants", func(t *testing.T) {
+		c := newClient(t)
+		conversation := c.createConversation("alice", "bob")
+		path := "/conversations/" + conversation.ID.Hex()
+
+		var res errorResponse
+		assert.Equal(t, http.StatusForbidden, c.do("eve", http.MethodGet, path, nil, &res))
+		assert.Equal(t, http.StatusForbidden, c.do("eve", http.MethodGet, path+"/messages", nil, &res))
+		assert.Equal(t, http.StatusForbidden, c.do("eve", http.MethodPost, path+"/messages", map[string]any{"kind": "text", "content": "Hi"}, &res))
+	})
+
+	t.Run("responds not found for unknown and malformed ids", func(t *testing.T) {
+		c := newClient(t)
+
+		var res errorResponse
+		assert.Equal(t, http.StatusNotFound, c.do("alice", http.MethodGet, "/conversations/000000000000000000000000", nil, &res))
+		assert.Equal(t, "not_found", res.Error.Code)
+		assert.Equal(t, http.StatusNotFound, c.do("alice", http.MethodGet, "/conversations/abc", nil, &res))
+		assert.Equal(t, http.StatusNotFound, c.do("alice", http.MethodPost, "/messages/abc/reactions", map[string]any{"emoji": "👍"}, &res))
+	})
+
+	t.Run("lists the invalid fields", func(t *testing.T) {
+		c := newClient(t)
+		conversation := c.createConversation("alice", "bob")
+		path := "/conversations/" + conversation.ID.Hex() + "/messages"
+
+		var res errorResponse
+		require.Equal(t, http.StatusBadRequest, c.do("alice", http.MethodPost, path, map[string]any{"content": "Hi"}, &res))
+		assert.Equal(t, "validation_failed", res.Error.Code)
+		require.Len(t, res.Error.Fields, 1)
+		assert.Equal(t, "kind", res.Error.Fields[0].Field)
+		assert.Equal(t, "required", res.Error.Fields[0].Rule)
+
+		require.Equal(t, http.StatusBadRequest, c.do("alice", http.MethodPost, path, map[string]any{
+			"kind":        "text",
+			"attachments": []map[string]any{{"kind": ""}},
+		}, &res))
+		require.Len(t, res.Error.Fields, 1)
+		assert.Equal(t, "attachments[0].kind", res.Error.Fields[0].Field)
+
+		require.Equal(t, http.StatusBadRequest, c.do("alice", http.MethodPost, path, map[string]any{"kind": "system", "content": "Hi"}, &res))
+		assert.Equal(t, "kind", res.Error.Fields[0].Field)
+
+		require.Equal(t, http.StatusBadRequest, c.do("alice", http.MethodPost, path, map[string]any{"unknown": true}, &res))
+		assert.Equal(t, "body", res.Error.Fields[0].Field)
+
+		require.Equal(t, http.StatusBadRequest, c.do("alice", http.MethodGet, path+"?per_page=-1", nil, &res))
+		assert.Equal(t, "per_page", res.Error.Fields[0].Field)
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	var document struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(httpapi.OpenAPI, &document))
+
+	c := newClient(t)
+
+	res, err := c.server.Client().Get(c.server.URL + "/openapi.json")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	methods := map[string]bool{"get": true, "post": true, "put": true, "patch": true, "delete": true}
+	operations := 0
+	for path, item := range document.Paths {
+		for method := range item {
+			if !methods[method] {
+				continue
+			}
+			operations++
+
+			// Every documented operation is routed, the router responds 404 and 405 in plain text.
+			path := strings.NewReplacer("{id}", "000000000000000000000000", "{message_id}", "000000000000000000000000", "{participant_id}", "bob").Replace(path)
+			req, err := http.NewRequest(strings.ToUpper(method), c.server.URL+path, strings.NewReader("{}"))
+			require.NoError(t, err)
+			req.Header.Set(httpapi.HeaderParticipantID, "alice")
+
+			res, err := c.server.Client().Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+
+			unrouted := (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusMethodNotAllowed) &&
+				res.Header.Get("Content-Type") != "application/json"
+			assert.False(t, unrouted, "%s %s", method, path)
+		}
+	}
+	assert.Equal(t, 18, operations)
+}
@@ -0,0 +1,401 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davesavic/chatsavvy"
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/cache"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrSlowConsumer is the reason a WebSocket connection is closed when its client does not
+// keep up with the events.
+var ErrSlowConsumer = errors.New("connection fell behind the events")
+
+// membershipCacheSize is the number of conversations the hub keeps to check who may see
+// their events.
+const membershipCacheSize = 4096
+
+// subscription is a stream of the events visible to a participant, see stream.Subscription.
+type subscription interface {
+	Next(ctx context.Context) bool
+	Event() model.Event
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// hub fans the events out to the subscriptions of the same tenant.
+//
+// The events of a tenant are read from a single change stream, see ChatSavvy.Subscribe,
+// opened with the first subscription of the tenant and closed with the last one. When the
+// storage backend has no change streams, the events are derived from the after hooks of the
+// repositories instead, so the hub only sees the writes made through the same ChatSavvy.
+type hub struct {
+	logger *slog.Logger
+
+	// hooked is set once the storage backend turned out to have no change streams.
+	hooked atomic.Bool
+
+	// feedsMu guards feeds and is taken before mu when both are needed.
+	feedsMu sync.Mutex
+	feeds   map[string]*feed
+
+	mu            sync.Mutex
+	subscriptions map[*hubSubscription]struct{}
+
+	// memberships caches the conversations of the events by tenant and id, shared by the
+	// subscriptions to check who may see them. Participant events refresh them.
+	memberships *cache.LRU[string, *model.Conversation]
+	lookups     singleflight.Group
+}
+
+// feed is the change stream of a tenant, shared by the subscriptions of the tenant.
+type feed struct {
+	events      subscription
+	cancel      context.CancelFunc
+	subscribers int
+}
+
+// published is an event along with the conversation it happened in, if known.
+type published struct {
+	event        model.Event
+	conversation *model.Conversation
+}
+
+func newHub(hooks *hook.Registry, logger *slog.Logger) *hub {
+	h := &hub{
+		logger:        logger,
+		feeds:         make(map[string]*feed),
+		subscriptions: make(map[*hubSubscription]struct{}),
+		memberships:   cache.NewLRU[string, *model.Conversation](membershipCacheSize),
+	}
+
+	hook.After(hooks, func(ctx context.Context, e hook.MessageCreated) {
+		message := *e.Message
+		h.publishHooked(message.TenantID, nil, model.Event{
+			Kind:           model.EventKindMessageCreated,
+			ConversationID: message.ConversationID.Hex(),
+			Message:        &message,
+		})
+	})
+
+	hook.After(hooks, func(ctx context.Context, e hook.ReactionToggled) {
+		message := *e.Message
+		h.publishHooked(message.TenantID, nil, model.Event{
+			Kind:           model.EventKindReactionToggled,
+			ConversationID: message.ConversationID.Hex(),
+			Message:        &message,
+		})
+	})
+
+	hook.After(hooks, func(ctx context.Context, e hook.ReadMarked) {
+		h.publishParticipant(model.EventKindReadAdvanced, e.Conversation, e.Data.Participant.ParticipantID, e.Data.Participant.Metadata)
+	})
+
+	hook.After(hooks, func(ctx context.Context, e hook.ParticipantAdded) {
+		h.publishParticipant(model.EventKindParticipantAdded, e.Conversation, e.Participant.ParticipantID, e.Participant.Metadata)
+	})
+
+	hook.After(hooks, func(ctx context.Context, e hook.ParticipantDeleted) {
+		h.publishParticipant(model.EventKindParticipantRemoved, e.Conversation, e.Participant.ParticipantID, e.Participant.Metadata)
+	})
+
+	return h
+}
+
+func (h *hub) publishParticipant(kind model.EventKind, conversation *model.Conversation, participantID string, metadata map[string]any) {
+	participant := conversation.Participant(participantID, metadata)
+	if participant == nil {
+		return
+	}
+
+	copied := *participant
+	h.publishHooked(conversation.TenantID, conversation, model.Event{
+		Kind:           kind,
+		ConversationID: conversation.ID.Hex(),
+		Participant:    &copied,
+	})
+}
+
+// publishHooked publishes the event derived from a hook, unless the events are read from
+// change streams, which see the same write.
+func (h *hub) publishHooked(tenantID string, conversation *model.Conversation, event model.Event) {
+	if !h.hooked.Load() {
+		return
+	}
+	h.publish(tenantID, conversation, event)
+}
+
+// publish hands the event to the subscriptions of the tenant without blocking the write that
+// caused it. A subscription whose buffer is full is dropped and ends with ErrSlowConsumer.
+func (h *hub) publish(tenantID string, conversation *model.Conversation, event model.Event) {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	// Participant events change who may see the conversation.
+	key := membershipKey(tenantID, event.ConversationID)
+	if conversation != nil {
+		h.memberships.Add(key, conversation)
+	} else if event.Participant != nil {
+		h.memberships.Remove(key)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscriptions {
+		if s.tenantID != tenantID {
+			continue
+		}
+
+		select {
+		case s.published <- published{event: event, conversation: conversation}:
+		default:
+			h.end(s, ErrSlowConsumer)
+		}
+	}
+}
+
+// end drops the subscription, which ends with the error once it read the events it was handed.
+// The caller holds h.mu.
+func (h *hub) end(s *hubSubscription, err error) {
+	delete(h.subscriptions, s)
+	s.closeErr = err
+	close(s.published)
+}
+
+// subscribe streams the events visible to the caller, buffering up to size of them.
+func (h *hub) subscribe(c caller, size int) (*hubSubscription, error) {
+	s := &hubSubscription{
+		hub:       h,
+		tenantID:  c.TenantID,
+		caller:    c,
+		published: make(chan published, size),
+	}
+
+	h.feedsMu.Lock()
+	defer h.feedsMu.Unlock()
+
+	if !h.hooked.Load() {
+		f, err := h.follow(c)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			f.subscribers++
+			s.feed = f
+		}
+	}
+
+	h.mu.Lock()
+	h.subscriptions[s] = struct{}{}
+	h.mu.Unlock()
+
+	return s, nil
+}
+
+// follow returns the feed of the caller's tenant, opening it if needed. It returns nil once
+// the storage backend turned out to have no change streams, from then on the events are
+// derived from the hooks. The caller holds h.feedsMu.
+func (h *hub) follow(c caller) (*feed, error) {
+	if f, ok := h.feeds[c.TenantID]; ok {
+		return f, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.chat.Subscribe(ctx, data.Subscribe{})
+	if errors.Is(err, chatsavvy.ErrSubscribeUnsupported) {
+		cancel()
+		h.logger.Info("Change streams are unavailable, delivering the events of this server's writes only", "error", err)
+		h.hooked.Store(true)
+		return nil, nil
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	f := &feed{events: events, cancel: cancel}
+	h.feeds[c.TenantID] = f
+	go h.run(ctx, c.TenantID, f)
+
+	return f, nil
+}
+
+// run publishes the events of the feed until it is closed or fails. A failed feed ends the
+// subscriptions of its tenant with its error.
+func (h *hub) run(ctx context.Context, tenantID string, f *feed) {
+	defer f.events.Close(context.Background())
+
+	for f.events.Next(ctx) {
+		h.publish(tenantID, nil, f.events.Event())
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	err := f.events.Err()
+	if err == nil {
+		err = errors.New("the change stream ended")
+	}
+
+	h.feedsMu.Lock()
+	if h.feeds[tenantID] == f {
+		delete(h.feeds, tenantID)
+	}
+	h.feedsMu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subscriptions {
+		if s.feed == f {
+			h.end(s, err)
+		}
+	}
+}
+
+// unfollow releases the subscription's share of its feed, closing the feed after the last one.
+func (h *hub) unfollow(f *feed) {
+	h.feedsMu.Lock()
+	defer h.feedsMu.Unlock()
+
+	f.subscribers--
+	if f.subscribers > 0 {
+		return
+	}
+
+	f.cancel()
+	for tenantID, current := range h.feeds {
+		if current == f {
+			delete(h.feeds, tenantID)
+		}
+	}
+}
+
+// membership returns the conversation of the caller's tenant, from the cache if possible.
+// Concurrent lookups of the same conversation share a single Find.
+func (h *hub) membership(ctx context.Context, c caller, conversationID string) (*model.Conversation, error) {
+	key := membershipKey(c.TenantID, conversationID)
+	if conversation, ok := h.memberships.Get(key); ok {
+		return conversation, nil
+	}
+
+	// The lookup is shared, so it must not fail because the subscription that started it ended.
+	ctx = context.WithoutCancel(ctx)
+	found, err, _ := h.lookups.Do(key, func() (any, error) {
+		conversation, err := c.chat.Conversation.Find(ctx, conversationID)
+		if err != nil {
+			return nil, err
+		}
+		h.memberships.Add(key, conversation)
+		return conversation, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found.(*model.Conversation), nil
+}
+
+func membershipKey(tenantID, conversationID string) string {
+	return tenantID + "/" + conversationID
+}
+
+type hubSubscription struct {
+	hub       *hub
+	tenantID  string
+	caller    caller
+	feed      *feed
+	published chan published
+
+	// closeErr is the reason the hub ended the subscription, set before published is closed.
+	closeErr error
+
+	event model.Event
+	err   error
+}
+
+// Next blocks until the next visible event is available and reports whether there is one.
+// It returns false once the context is done or the subscription fails, see Err.
+func (s *hubSubscription) Next(ctx context.Context) bool {
+	for {
+		select {
+		case p, ok := <-s.published:
+			if !ok {
+				// Only the hub closes the channel while the subscription is read.
+				s.err = s.closeErr
+				return false
+			}
+
+			visible, err := s.visible(ctx, p)
+			if err != nil {
+				s.err = err
+				return false
+			}
+			if visible {
+				s.event = p.event
+				return true
+			}
+		case <-ctx.Done():
+			s.err = ctx.Err()
+			return false
+		}
+	}
+}
+
+func (s *hubSubscription) Event() model.Event {
+	return s.event
+}
+
+func (s *hubSubscription) Err() error {
+	return s.err
+}
+
+func (s *hubSubscription) Close(ctx context.Context) error {
+	s.hub.mu.Lock()
+	if _, ok := s.hub.subscriptions[s]; ok {
+		s.hub.end(s, nil)
+	}
+	f := s.feed
+	s.feed = nil
+	s.hub.mu.Unlock()
+
+	if f != nil {
+		s.hub.unfollow(f)
+	}
+	return nil
+}
+
+// visible reports whether the participant is a non-deleted member of the event's conversation.
+// Participants always see the events about themselves, including their own removal.
+func (s *hubSubscription) visible(ctx context.Context, p published) (bool, error) {
+	event := p.event
+	if event.Participant != nil && event.Participant.ParticipantID == s.caller.ParticipantID &&
+		model.MetadataEqual(event.Participant.Metadata, s.caller.Metadata) {
+		return true, nil
+	}
+
+	conversation := p.conversation
+	if conversation == nil {
+		found, err := s.hub.membership(ctx, s.caller, event.ConversationID)
+		if errors.Is(err, store.ErrConversationNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		conversation = found
+	}
+
+	return conversation.ActiveParticipant(s.caller.ParticipantID, s.caller.Metadata) != nil, nil
+}
@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/davesavic/chatsavvy"
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub(t *testing.T) {
+	ctx := context.Background()
+	cs := chatsavvy.NewInMemory()
+
+	conversation, err := cs.Conversation.Create(ctx, data.CreateConversation{
+		Participants: []data.AddParticipant{{ParticipantID: "alice"}, {ParticipantID: "bob"}},
+	})
+	require.NoError(t, err)
+
+	added := func(participantID string) model.Event {
+		return model.Event{
+			Kind:           model.EventKindParticipantAdded,
+			ConversationID: conversation.ID.Hex(),
+			Participant:    conversation.Participant(participantID, nil),
+		}
+	}
+
+	t.Run("ends a subscription that falls behind", func(t *testing.T) {
+		h := newHub(hook.NewRegistry(), slog.Default())
+		s, err := h.subscribe(caller{Identity: Identity{ParticipantID: "alice"}, chat: cs}, 1)
+		require.NoError(t, err)
+		defer s.Close(ctx)
+
+		h.publish("", conversation, added("alice"))
+		h.publish("", conversation, added("bob"))
+
+		require.True(t, s.Next(ctx))
+		assert.Equal(t, "alice", s.Event().Participant.ParticipantID)
+
+		assert.False(t, s.Next(ctx))
+		assert.ErrorIs(t, s.Err(), ErrSlowConsumer)
+	})
+
+	t.Run("only delivers the events of the tenant", func(t *testing.T) {
+		h := newHub(hook.NewRegistry(), slog.Default())
+		s, err := h.subscribe(caller{Identity: Identity{TenantID: "acme", ParticipantID: "alice"}, chat: cs.ForTenant("acme")}, 1)
+		require.NoError(t, err)
+		defer s.Close(ctx)
+
+		h.publish("", conversation, added("alice"))
+		assert.Empty(t, s.published)
+	})
+
+	t.Run("skips the conversations of others", func(t *testing.T) {
+		h := newHub(hook.NewRegistry(), slog.Default())
+		s, err := h.subscribe(caller{Identity: Identity{ParticipantID: "eve"}, chat: cs}, 2)
+		require.NoError(t, err)
+
+		h.publish("", conversation, added("alice"))
+		require.NoError(t, s.Close(ctx))
+
+		assert.False(t, s.Next(ctx))
+	})
+
+	t.Run("derives the events from the hooks without change streams", func(t *testing.T) {
+		h := newHub(cs.Hooks, slog.Default())
+		s, err := h.subscribe(caller{Identity: Identity{ParticipantID: "bob"}, chat: cs}, 1)
+		require.NoError(t, err)
+		defer s.Close(ctx)
+		assert.True(t, h.hooked.Load())
+		assert.Nil(t, s.feed)
+
+		message, err := cs.Message.Create(ctx, conversation.ID.Hex(), data.CreateMessage{
+			Kind:    "text",
+			Sender:  data.MessageSender{ParticipantID: "alice"},
+			Content: "Hello",
+		})
+		require.NoError(t, err)
+
+		require.True(t, s.Next(ctx))
+		assert.Equal(t, message.ID, s.Event().Message.ID)
+	})
+
+	t.Run("shares the conversations between subscriptions", func(t *testing.T) {
+		h := newHub(hook.NewRegistry(), slog.Default())
+		alice, err := h.subscribe(caller{Identity: Identity{ParticipantID: "alice"}, chat: cs}, 1)
+		require.NoError(t, err)
+		defer alice.Close(ctx)
+		bob, err := h.subscribe(caller{Identity: Identity{ParticipantID: "bob"}, chat: cs}, 1)
+		require.NoError(t, err)
+		defer bob.Close(ctx)
+
+		created := model.Event{Kind: model.EventKindMessageCreated, ConversationID: conversation.ID.Hex()}
+		h.publish("", nil, created)
+		require.True(t, alice.Next(ctx))
+		require.True(t, bob.Next(ctx))
+		assert.Equal(t, 1, h.memberships.Len())
+
+		// Participant events without the conversation drop it, so that it is fetched again.
+		h.publish("", nil, added("alice"))
+		assert.Equal(t, 0, h.memberships.Len())
+	})
+}
@@ -0,0 +1,292 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+)
+
+type attachmentRequest struct {
+	Kind     string         `json:"kind"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type createMessageRequest struct {
+	Kind        string              `json:"kind"`
+	Content     string              `json:"content"`
+	Attachments []attachmentRequest `json:"attachments"`
+	ParentID    *string             `json:"parent_id"`
+	ReplyToID   *string             `json:"reply_to_id"`
+}
+
+type editMessageRequest struct {
+	Content     string              `json:"content"`
+	Attachments []attachmentRequest `json:"attachments"`
+}
+
+type toggleReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type messagesResponse struct {
+	Messages []model.Message `json:"messages"`
+}
+
+type participantsResponse struct {
+	Participants []model.Participant `json:"participants"`
+}
+
+func attachments(req []attachmentRequest) []data.CreateAttachment {
+	if req == nil {
+		return nil
+	}
+
+	attachments := make([]data.CreateAttachment, len(req))
+	for i, a := range req {
+		attachments[i] = data.CreateAttachment{Kind: a.Kind, Metadata: a.Metadata}
+	}
+	return attachments
+}
+
+// listMessages loads the messages of the conversation visible to the caller, newest first.
+// The before query parameter continues from the oldest message of the previous page.
+func (h *Handler) listMessages(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+	before, err := optionalID(r, "before")
+	if err != nil {
+		return err
+	}
+	perPage, err := perPage(r)
+	if err != nil {
+		return err
+	}
+	includeThreadReplies, err := flag(r, "include_thread_replies")
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.conversation(r.Context(), id); err != nil {
+		return err
+	}
+
+	viewer := c.participant()
+	messages, err := c.chat.Message.LoadMessages(r.Context(), data.LoadMessages{
+		ConversationID:       id,
+		Viewer:               &viewer,
+		IncludeThreadReplies: includeThreadReplies,
+		LastMessageID:        before,
+		PerPage:              perPage,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, messagesResponse{Messages: messages})
+	return nil
+}
+
+// createMessage sends a message as the caller.
+func (h *Handler) createMessage(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+
+	var req createMessageRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	message, err := c.send(r.Context(), id, req)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusCreated, message)
+	return nil
+}
+
+// send sends the message as the caller. System messages are posted by the application, so
+// they cannot be sent by participants.
+func (c caller) send(ctx context.Context, conversationID string, req createMessageRequest) (*model.Message, error) {
+	if req.Kind == model.MessageKindSystem {
+		return nil, invalid("kind", "ne", model.MessageKindSystem, "system messages cannot be sent by participants")
+	}
+
+	return c.chat.Message.Create(ctx, conversationID, data.CreateMessage{
+		Kind:        req.Kind,
+		Sender:      c.sender(),
+		Content:     req.Content,
+		Attachments: attachments(req.Attachments),
+		ParentID:    req.ParentID,
+		ReplyToID:   req.ReplyToID,
+	})
+}
+
+// listReplies loads the replies of the thread started by the message, newest first.
+func (h *Handler) listReplies(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+	parentID, err := pathID(r, "message_id", store.ErrMessageNotFound)
+	if err != nil {
+		return err
+	}
+	before, err := optionalID(r, "before")
+	if err != nil {
+		return err
+	}
+	perPage, err := perPage(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.conversation(r.Context(), id); err != nil {
+		return err
+	}
+
+	viewer := c.participant()
+	messages, err := c.chat.Message.LoadThread(r.Context(), data.LoadThread{
+		ConversationID: id,
+		ParentID:       parentID,
+		Viewer:         &viewer,
+		LastMessageID:  before,
+		PerPage:        perPage,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, messagesResponse{Messages: messages})
+	return nil
+}
+
+// readers lists the participants that have read the message.
+func (h *Handler) readers(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrConversationNotFound)
+	if err != nil {
+		return err
+	}
+	messageID, err := pathID(r, "message_id", store.ErrMessageNotFound)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.conversation(r.Context(), id); err != nil {
+		return err
+	}
+
+	participants, err := c.chat.Message.ReadersOf(r.Context(), data.ReadersOf{
+		ConversationID: id,
+		MessageID:      messageID,
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, participantsResponse{Participants: participants})
+	return nil
+}
+
+// editMessage replaces the content and attachments of the caller's message.
+func (h *Handler) editMessage(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrMessageNotFound)
+	if err != nil {
+		return err
+	}
+
+	var req editMessageRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	message, err := c.chat.Message.Edit(r.Context(), data.EditMessage{
+		MessageID:   id,
+		Editor:      c.sender(),
+		Content:     req.Content,
+		Attachments: attachments(req.Attachments),
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, message)
+	return nil
+}
+
+// deleteMessage deletes the caller's message for everyone, leaving a tombstone.
+func (h *Handler) deleteMessage(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrMessageNotFound)
+	if err != nil {
+		return err
+	}
+
+	message, err := c.chat.Message.DeleteForEveryone(r.Context(), data.DeleteMessageForEveryone{
+		MessageID: id,
+		Sender:    c.sender(),
+	})
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, message)
+	return nil
+}
+
+// hideMessage deletes the message for the caller only.
+func (h *Handler) hideMessage(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrMessageNotFound)
+	if err != nil {
+		return err
+	}
+
+	err = c.chat.Message.DeleteForMe(r.Context(), data.DeleteMessageForMe{
+		MessageID:   id,
+		Participant: c.participant(),
+	})
+	if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// toggleReaction adds the caller's reaction to the message, or removes it if it is already there.
+func (h *Handler) toggleReaction(w http.ResponseWriter, r *http.Request, c caller) error {
+	id, err := pathID(r, "id", store.ErrMessageNotFound)
+	if err != nil {
+		return err
+	}
+
+	var req toggleReactionRequest
+	if err := decode(w, r, &req); err != nil {
+		return err
+	}
+
+	message, err := c.react(r.Context(), id, req.Emoji)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, message)
+	return nil
+}
+
+func (c caller) react(ctx context.Context, messageID, emoji string) (*model.Message, error) {
+	return c.chat.Message.ToggleReaction(ctx, data.ToggleReaction{
+		MessageID: messageID,
+		Emoji:     emoji,
+		Participant: data.ReactionParticipant{
+			ParticipantID: c.ParticipantID,
+			Metadata:      c.Metadata,
+		},
+	})
+}
@@ -0,0 +1,204 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// maxBodySize is the maximum size of a request body.
+const maxBodySize = 1 << 20
+
+// errorResponse is the body of an error response. Fields lists the invalid fields of a
+// validation error.
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// statusOf maps the error to the status code and error code of the response.
+func statusOf(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return http.StatusUnauthorized, "unauthenticated"
+	case errors.Is(err, store.ErrValidation):
+		return http.StatusBadRequest, "validation_failed"
+	case errors.Is(err, store.ErrNotParticipant), errors.Is(err, store.ErrPermissionDenied):
+		return http.StatusForbidden, "forbidden"
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, store.ErrConflict):
+		return http.StatusConflict, "conflict"
+	default:
+		return http.StatusInternalServerError, "internal"
+	}
+}
+
+// error writes the error response.
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := h.errorBody(r, err)
+	writeJSON(w, status, errorResponse{Error: body})
+}
+
+// errorBody describes the error to the caller. Unexpected errors are logged and their message
+// is not disclosed.
+func (h *Handler) errorBody(r *http.Request, err error) (int, errorBody) {
+	status, code := statusOf(err)
+
+	body := errorBody{Code: code, Message: err.Error()}
+	if status == http.StatusInternalServerError {
+		h.logger.Error("Failed to handle request", "method", r.Method, "path", r.URL.Path, "error", err)
+		body.Message = http.StatusText(status)
+	}
+
+	var verr *store.ValidationError
+	if errors.As(err, &verr) {
+		for _, field := range verr.Fields {
+			body.Fields = append(body.Fields, fieldError{
+				Field:   snakeCase(field.Field),
+				Rule:    field.Rule,
+				Param:   field.Param,
+				Message: field.Message,
+			})
+		}
+	}
+
+	return status, body
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// decode reads the JSON body of the request into v.
+func decode(w http.ResponseWriter, r *http.Request, v any) error {
+	return decodeJSON(http.MaxBytesReader(w, r.Body, maxBodySize), "body", v)
+}
+
+// decodeJSON reads the JSON object into v, reporting the errors as invalid field.
+func decodeJSON(r io.Reader, field string, v any) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return invalid(field, "json", "", fmt.Sprintf("invalid JSON %s: %s", field, err))
+	}
+
+	return nil
+}
+
+// invalid returns a validation error for the request field.
+func invalid(field, rule, param, message string) error {
+	return &store.ValidationError{Fields: []store.FieldError{{Field: field, Rule: rule, Param: param, Message: message}}}
+}
+
+// pathID returns the id in the path, see existingID.
+func pathID(r *http.Request, name string, notFound error) (string, error) {
+	return existingID(r.PathValue(name), notFound)
+}
+
+// existingID checks the id of a conversation or message. A malformed id cannot exist, so
+// notFound is returned for it.
+func existingID(id string, notFound error) (string, error) {
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return "", notFound
+	}
+	return id, nil
+}
+
+// optionalID returns the id in the query parameter, nil if it is absent.
+func optionalID(r *http.Request, name string) (*string, error) {
+	id := r.URL.Query().Get(name)
+	if id == "" {
+		return nil, nil
+	}
+	if _, err := bson.ObjectIDFromHex(id); err != nil {
+		return nil, invalid(name, "hex", "", fmt.Sprintf("%s must be a hex encoded id", name))
+	}
+	return &id, nil
+}
+
+// perPage returns the page size in the per_page query parameter, defaultPerPage if it is absent.
+func perPage(r *http.Request) (uint, error) {
+	const defaultPerPage = 20
+
+	raw := r.URL.Query().Get("per_page")
+	if raw == "" {
+		return defaultPerPage, nil
+	}
+
+	n, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return 0, invalid("per_page", "number", "", "per_page must be a positive number")
+	}
+	return uint(n), nil
+}
+
+// flag returns whether the boolean query parameter is set to true.
+func flag(r *http.Request, name string) (bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, invalid(name, "boolean", "", fmt.Sprintf("%s must be true or false", name))
+	}
+	return b, nil
+}
+
+// metadata returns the participant metadata in the metadata query parameter, a JSON object.
+func metadata(r *http.Request) (map[string]any, error) {
+	raw := r.URL.Query().Get("metadata")
+	if raw == "" {
+		return nil, nil
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return nil, invalid("metadata", "json", "", "metadata must be a JSON object")
+	}
+	return m, nil
+}
+
+// snakeCase converts the path of a data field to the naming of the JSON API, e.g.
+// Sender.ParticipantID to sender.participant_id.
+func snakeCase(field string) string {
+	var b strings.Builder
+	runes := []rune(field)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// An upper case letter starts a word unless it continues an acronym.
+			if i > 0 && runes[i-1] != '.' && runes[i-1] != '[' &&
+				(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
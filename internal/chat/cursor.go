@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// conversationCursor is the position of the last conversation of a page in the
+// updated_at descending, _id descending order.
+type conversationCursor struct {
+	UpdatedAt int64  `json:"u"`
+	ID        string `json:"i"`
+}
+
+// EncodeCursor returns the opaque cursor pointing after the conversation.
+func EncodeCursor(updatedAt time.Time, id bson.ObjectID) string {
+	encoded, _ := json.Marshal(conversationCursor{UpdatedAt: updatedAt.UnixMilli(), ID: id.Hex()})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// DecodeCursor returns the update time, to the millisecond, and the id of the conversation
+// the cursor points after. It returns ErrInvalidCursor if the cursor cannot be decoded.
+func DecodeCursor(cursor string) (time.Time, bson.ObjectID, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, bson.ObjectID{}, store.ErrInvalidCursor
+	}
+
+	var c conversationCursor
+	if err := json.Unmarshal(decoded, &c); err != nil {
+		return time.Time{}, bson.ObjectID{}, store.ErrInvalidCursor
+	}
+
+	id, err := bson.ObjectIDFromHex(c.ID)
+	if err != nil {
+		return time.Time{}, bson.ObjectID{}, store.ErrInvalidCursor
+	}
+
+	return time.UnixMilli(c.UpdatedAt), id, nil
+}
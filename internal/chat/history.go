@@ -0,0 +1,32 @@
+package chat
+
+import (
+	"time"
+
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// InHistory reports whether a message created at the given time is within the participant's
+// history window.
+// Deleted participants only see the messages sent up to their deletion. In conversations with
+// joined history visibility, participants only see the messages sent during their membership
+// periods.
+func InHistory(conv *model.Conversation, p *model.Participant, createdAt time.Time) bool {
+	if conv.HistoryVisibility == model.HistoryVisibilityJoined && len(p.Memberships) > 0 {
+		for _, membership := range p.Memberships {
+			if createdAt.Before(membership.JoinedAt) {
+				continue
+			}
+			if membership.LeftAt == nil || !createdAt.After(*membership.LeftAt) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if p.DeletedAt != nil {
+		return !createdAt.After(*p.DeletedAt)
+	}
+
+	return true
+}
@@ -0,0 +1,31 @@
+package chat
+
+import "github.com/davesavic/chatsavvy/model"
+
+// QuoteExcerptLength is the maximum number of characters of content kept in a quote.
+const QuoteExcerptLength = 100
+
+// QuoteOf returns the quote snapshot of the message.
+// A deleted message keeps only its id, sender and deletion time.
+func QuoteOf(message model.Message) model.Quote {
+	quote := model.Quote{
+		MessageID: message.ID,
+		Sender:    message.Sender,
+		DeletedAt: message.DeletedAt,
+	}
+	if message.DeletedAt != nil {
+		return quote
+	}
+
+	excerpt := []rune(message.Content)
+	if len(excerpt) > QuoteExcerptLength {
+		excerpt = excerpt[:QuoteExcerptLength]
+	}
+	quote.Excerpt = string(excerpt)
+
+	if len(message.Attachments) > 0 {
+		quote.AttachmentKind = message.Attachments[0].Kind
+	}
+
+	return quote
+}
@@ -0,0 +1,92 @@
+// Package chat holds the rules shared by the storage backends, so that they match participants,
+// check permissions and encode cursors the same way.
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+)
+
+// Authorize checks that the actor is a non-deleted participant of the conversation and that
+// its role grants the permission. A nil actor is a trusted call and is always authorized.
+// It returns the actor's participant entry, nil for a trusted call, or an error.
+func Authorize(permissions model.Permissions, conversation *model.Conversation, actor *data.Actor, permission model.Permission) (*model.Participant, error) {
+	if actor == nil {
+		return nil, nil
+	}
+
+	participant, err := RequireParticipant(conversation, actor.ParticipantID, actor.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", store.ErrPermissionDenied, err)
+	}
+
+	if !permissions.Allows(participant.Role, permission) {
+		return nil, fmt.Errorf("role %q may not %s: %w", RoleOf(*participant), permission, store.ErrPermissionDenied)
+	}
+
+	return participant, nil
+}
+
+// RequireParticipant returns the non-deleted participant matching the id and metadata.
+// It returns ErrParticipantDeleted if the only match has been removed from the conversation
+// and ErrNotParticipant if there is no match at all.
+func RequireParticipant(conversation *model.Conversation, participantID string, metadata map[string]any) (*model.Participant, error) {
+	if participant := conversation.ActiveParticipant(participantID, metadata); participant != nil {
+		return participant, nil
+	}
+
+	for _, p := range conversation.Participants {
+		if p.ParticipantID == participantID && model.MetadataEqual(p.Metadata, metadata) {
+			return nil, store.ErrParticipantDeleted
+		}
+	}
+
+	return nil, store.ErrNotParticipant
+}
+
+// ValidateActor validates the actor of an operation. A nil actor is valid.
+func ValidateActor(actor *data.Actor) error {
+	if actor == nil {
+		return nil
+	}
+	if err := actor.Validate(); err != nil {
+		return fmt.Errorf("failed to validate actor: %w", err)
+	}
+	return nil
+}
+
+// RoleOf returns the participant's role. Participants stored without a role are members.
+func RoleOf(p model.Participant) model.Role {
+	if p.Role == "" {
+		return model.RoleMember
+	}
+	return p.Role
+}
+
+// WithDefaultRole returns the participant with the member role if it has none.
+func WithDefaultRole(p data.AddParticipant) data.AddParticipant {
+	if p.Role == "" {
+		p.Role = model.RoleMember
+	}
+	return p
+}
+
+// ParticipantKey identifies a participant by its id and metadata so that it can be matched
+// with a plain equality. JSON encoding sorts map keys, so equal metadata always produces
+// the same key.
+func ParticipantKey(participantID string, metadata map[string]any) (string, error) {
+	if len(metadata) == 0 {
+		return participantID, nil
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode participant metadata: %w", err)
+	}
+
+	return participantID + ":" + string(encoded), nil
+}
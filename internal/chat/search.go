@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// The weights of the searched fields, as in the MongoDB text index.
+const (
+	contentWeight        = 10
+	attachmentNameWeight = 5
+)
+
+// SearchTerms splits the query into the stems to match and the stems to exclude.
+// Matching approximates MongoDB's text search: words match case-insensitively after a light
+// stemming of plural and verb endings, any word of the query is enough to match and words
+// prefixed with a minus exclude the message. Phrases and stop words are not supported.
+func SearchTerms(query string) (include, exclude []string) {
+	for _, field := range strings.Fields(query) {
+		negated := strings.HasPrefix(field, "-")
+		for _, word := range words(field) {
+			if negated {
+				exclude = append(exclude, word)
+			} else {
+				include = append(include, word)
+			}
+		}
+	}
+	return include, exclude
+}
+
+// SearchScore returns the relevance of the message for the terms, zero if it does not match.
+// Like MongoDB, every occurrence of a term counts, weighted by the field and by how much of
+// the field the term makes up. The content and the attachment names are searched.
+func SearchScore(message model.Message, include, exclude []string) float64 {
+	index := SearchIndex(message)
+	for _, term := range exclude {
+		if _, ok := index[term]; ok {
+			return 0
+		}
+	}
+
+	var score float64
+	for _, term := range include {
+		score += index[term]
+	}
+	return score
+}
+
+// SearchIndex returns the relevance of each word of the message, see SearchScore, for the
+// stores that index the words ahead of the searches.
+func SearchIndex(message model.Message) map[string]float64 {
+	fields := []struct {
+		words  []string
+		weight float64
+	}{{words(message.Content), contentWeight}}
+	for _, attachment := range message.Attachments {
+		if name, ok := attachment.Metadata["name"].(string); ok {
+			fields = append(fields, struct {
+				words  []string
+				weight float64
+			}{words(name), attachmentNameWeight})
+		}
+	}
+
+	index := make(map[string]float64)
+	for _, field := range fields {
+		occurrences := make(map[string]int)
+		for _, word := range field.words {
+			occurrences[word]++
+		}
+		for word, n := range occurrences {
+			index[word] += field.weight * float64(n) * (0.5 + 0.5/float64(len(field.words)))
+		}
+	}
+	return index
+}
+
+// words splits the text into lower case, stemmed words.
+func words(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, field := range fields {
+		fields[i] = stem(field)
+	}
+	return fields
+}
+
+// stem strips the common English inflection endings off the word.
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "ed", "es", "s"} {
+		if stemmed, ok := strings.CutSuffix(word, suffix); ok && len(stemmed) >= 3 {
+			return stemmed
+		}
+	}
+	return word
+}
@@ -0,0 +1,902 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Conversation struct {
+	db *DB
+	config
+}
+
+func NewConversation(db *DB, opts ...Option) *Conversation {
+	return &Conversation{db: db, config: newConfig(opts)}
+}
+
+// Tenant returns the tenant the repository is scoped to. The default tenant is empty.
+func (c Conversation) Tenant() string {
+	return c.tenant
+}
+
+func (c Conversation) ParticipantExists(ctx context.Context, conversationID string, d data.ParticipantExists) (bool, error) {
+	if err := d.Validate(); err != nil {
+		return false, fmt.Errorf("failed to validate participant exists data: %w", err)
+	}
+
+	var exists bool
+	err := c.db.view(func() error {
+		conversation, err := c.find(conversationID)
+		if err != nil || conversation == nil {
+			return err
+		}
+
+		exists = slices.ContainsFunc(conversation.Participants, func(p model.Participant) bool {
+			return p.ParticipantID == d.ParticipantID && metadataContains(p.Metadata, d.Metadata)
+		})
+		return nil
+	})
+
+	return exists, err
+}
+
+// AddParticipant adds a participant to the conversation, see repository.Conversation.AddParticipant.
+// It returns the updated conversation or an error.
+func (c Conversation) AddParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.AddParticipant) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate create participant data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+	d = chat.WithDefaultRole(d)
+
+	if err := c.hooks.RunBefore(ctx, hook.AddParticipant{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var conversation *model.Conversation
+	var added bool
+	err := c.db.update(func() error {
+		current, err := c.get(conversationID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.authorize(current, actor, model.PermissionAddParticipant); err != nil {
+			return err
+		}
+		if d.Role != model.RoleMember {
+			if _, err := c.authorize(current, actor, model.PermissionManageRoles); err != nil {
+				return err
+			}
+		}
+
+		revive, active := -1, 0
+		for i, p := range current.Participants {
+			if p.DeletedAt == nil {
+				active++
+			}
+			if p.ParticipantID != d.ParticipantID || !model.MetadataEqual(p.Metadata, d.Metadata) {
+				continue
+			}
+			if p.DeletedAt == nil {
+				conversation = current
+				return nil
+			}
+			revive = i
+		}
+
+		if c.limits.MaxParticipants > 0 && active >= c.limits.MaxParticipants {
+			return store.ErrTooManyParticipants
+		}
+
+		now := c.now()
+		if revive >= 0 {
+			participant := &current.Participants[revive]
+			participant.DeletedAt = nil
+			participant.Role = d.Role
+			participant.Memberships = append(participant.Memberships, model.Membership{JoinedAt: now})
+		} else {
+			if current.Kind != model.ConversationKindGroup {
+				return store.ErrNotGroup
+			}
+
+			current.Participants = append(current.Participants, newParticipant(d, now))
+		}
+		current.UpdatedAt = now
+
+		conversation, err = c.save(current)
+		added = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if added {
+		c.hooks.RunAfter(ctx, hook.ParticipantAdded{Conversation: conversation, Participant: d})
+	}
+
+	return conversation, nil
+}
+
+// DeleteParticipant deletes a participant from the conversation and ends its current
+// membership period, see repository.Conversation.DeleteParticipant.
+// It returns the updated conversation or an error.
+func (c Conversation) DeleteParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.DeleteParticipant) (*model.Conversation, error) {
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	if err := c.hooks.RunBefore(ctx, hook.DeleteParticipant{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var conversation *model.Conversation
+	var deleted bool
+	err := c.db.update(func() error {
+		current, err := c.get(conversationID)
+		if err != nil {
+			return err
+		}
+
+		if actor != nil && (actor.ParticipantID != d.ParticipantID || !model.MetadataEqual(actor.Metadata, d.Metadata)) {
+			if _, err := c.authorize(current, actor, model.PermissionRemoveParticipant); err != nil {
+				return err
+			}
+
+			target := current.ActiveParticipant(d.ParticipantID, d.Metadata)
+			if target != nil && target.Role == model.RoleOwner {
+				if _, err := c.authorize(current, actor, model.PermissionManageRoles); err != nil {
+					return err
+				}
+			}
+		}
+
+		now := c.now()
+		for i := range current.Participants {
+			participant := &current.Participants[i]
+			if participant.DeletedAt != nil || participant.ParticipantID != d.ParticipantID || !metadataEqual(participant.Metadata, d.Metadata) {
+				continue
+			}
+
+			participant.DeletedAt = &now
+			for j := range participant.Memberships {
+				if participant.Memberships[j].LeftAt == nil {
+					participant.Memberships[j].LeftAt = &now
+				}
+			}
+			deleted = true
+		}
+		current.UpdatedAt = now
+
+		conversation, err = c.save(current)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted {
+		c.hooks.RunAfter(ctx, hook.ParticipantDeleted{Conversation: conversation, Participant: d})
+	}
+
+	return conversation, nil
+}
+
+// newParticipant returns the participant with its first membership period starting now.
+func newParticipant(d data.AddParticipant, now time.Time) model.Participant {
+	return model.Participant{
+		ParticipantID: d.ParticipantID,
+		Metadata:      d.Metadata,
+		Role:          d.Role,
+		Memberships:   []model.Membership{{JoinedAt: now}},
+	}
+}
+
+// withParticipants returns the direct conversation with exactly the participants, deleted
+// participants included, or nil. The caller holds the lock.
+func (c Conversation) withParticipants(participants []data.AddParticipant) (*model.Conversation, error) {
+	conversations, err := c.all(func(conversation model.Conversation) bool {
+		if conversation.Kind == model.ConversationKindGroup || len(conversation.Participants) != len(participants) {
+			return false
+		}
+		for _, p := range participants {
+			if !slices.ContainsFunc(conversation.Participants, func(cp model.Participant) bool {
+				return cp.ParticipantID == p.ParticipantID && metadataEqual(cp.Metadata, p.Metadata)
+			}) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil || len(conversations) == 0 {
+		return nil, err
+	}
+
+	return &conversations[0], nil
+}
+
+// Create creates a conversation with the participants, see repository.Conversation.Create.
+// It returns the created conversation or an error.
+func (c Conversation) Create(ctx context.Context, d data.CreateConversation) (*model.Conversation, error) {
+	if err := d.ValidateWith(c.limits); err != nil {
+		return nil, fmt.Errorf("failed to validate create conversation data: %w", err)
+	}
+
+	if err := c.hooks.RunBefore(ctx, hook.CreateConversation{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	kind := d.Kind
+	if kind == "" {
+		kind = model.ConversationKindDirect
+	}
+
+	historyVisibility := d.HistoryVisibility
+	if historyVisibility == "" {
+		historyVisibility = model.HistoryVisibilityShared
+	}
+
+	var conversation *model.Conversation
+	var created bool
+	err := c.db.update(func() error {
+		if kind == model.ConversationKindDirect {
+			existing, err := c.withParticipants(d.Participants)
+			if err != nil {
+				return fmt.Errorf("failed to check if conversation exists: %w", err)
+			}
+			if existing != nil {
+				conversation = existing
+				return nil
+			}
+		}
+
+		now := c.now()
+		participants := make([]model.Participant, 0, len(d.Participants))
+		for _, p := range d.Participants {
+			participants = append(participants, newParticipant(chat.WithDefaultRole(p), now))
+		}
+
+		var err error
+		conversation, err = c.save(&model.Conversation{
+			ID:                bson.NewObjectID(),
+			TenantID:          c.tenant,
+			Kind:              kind,
+			Title:             d.Title,
+			Participants:      participants,
+			Metadata:          d.Metadata,
+			HistoryVisibility: historyVisibility,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		})
+		created = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		c.hooks.RunAfter(ctx, hook.ConversationCreated{Conversation: conversation})
+	}
+
+	return conversation, nil
+}
+
+// UpdateMetadata replaces the metadata of the conversation.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) UpdateMetadata(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversationMetadata) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate update conversation metadata data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(conversationID, func(conversation *model.Conversation) error {
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		conversation.Metadata = d.Metadata
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetTitle changes the title of a group. Direct conversations have no title.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetTitle(ctx context.Context, conversationID string, actor *data.Actor, d data.SetTitle) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set title data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(conversationID, func(conversation *model.Conversation) error {
+		if conversation.Kind != model.ConversationKindGroup {
+			return store.ErrNotGroup
+		}
+
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		conversation.Title = d.Title
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetHistoryVisibility changes whether participants see the messages sent before they joined.
+// It only affects reads, so switching back to shared restores the full history.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetHistoryVisibility(ctx context.Context, conversationID string, actor *data.Actor, d data.SetHistoryVisibility) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set history visibility data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(conversationID, func(conversation *model.Conversation) error {
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		conversation.HistoryVisibility = d.HistoryVisibility
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// Update changes the title, metadata and history visibility that are set, at once, see
+// SetTitle, UpdateMetadata and SetHistoryVisibility. Nothing is changed if any of them fails.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) Update(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversation) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate update conversation data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(conversationID, func(conversation *model.Conversation) error {
+		if d.Title != nil && conversation.Kind != model.ConversationKindGroup {
+			return store.ErrNotGroup
+		}
+
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		if d.Title != nil {
+			conversation.Title = *d.Title
+		}
+		if d.Metadata != nil {
+			conversation.Metadata = *d.Metadata
+		}
+		if d.HistoryVisibility != nil {
+			conversation.HistoryVisibility = *d.HistoryVisibility
+		}
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetRole changes the role of a non-deleted participant.
+// The actor needs the manage roles permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetRole(ctx context.Context, conversationID string, actor *data.Actor, d data.SetParticipantRole) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set participant role data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(conversationID, func(conversation *model.Conversation) error {
+		if _, err := c.authorize(conversation, actor, model.PermissionManageRoles); err != nil {
+			return err
+		}
+
+		participant := conversation.ActiveParticipant(d.ParticipantID, d.Metadata)
+		if participant == nil {
+			return store.ErrNotParticipant
+		}
+
+		participant.Role = d.Role
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetPinned pins or unpins the conversation for the participant only.
+// It returns the updated conversation or an error.
+func (c Conversation) SetPinned(ctx context.Context, conversationID string, d data.SetPinned) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set pinned data: %w", err)
+	}
+
+	return c.updateParticipantState(conversationID, d.Participant, func(participant *model.Participant) {
+		participant.PinnedAt = nil
+		if d.Pinned {
+			now := c.now()
+			participant.PinnedAt = &now
+		}
+	})
+}
+
+// SetArchived archives or unarchives the conversation for the participant only.
+// An archived conversation is unarchived when a new message arrives.
+// It returns the updated conversation or an error.
+func (c Conversation) SetArchived(ctx context.Context, conversationID string, d data.SetArchived) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set archived data: %w", err)
+	}
+
+	return c.updateParticipantState(conversationID, d.Participant, func(participant *model.Participant) {
+		participant.ArchivedAt = nil
+		if d.Archived {
+			now := c.now()
+			participant.ArchivedAt = &now
+		}
+	})
+}
+
+// SetMuted mutes or unmutes the conversation for the participant only.
+// The mute ends on its own at Until, if set.
+// It returns the updated conversation or an error.
+func (c Conversation) SetMuted(ctx context.Context, conversationID string, d data.SetMuted) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set muted data: %w", err)
+	}
+
+	return c.updateParticipantState(conversationID, d.Participant, func(participant *model.Participant) {
+		participant.MutedAt, participant.MutedUntil = nil, nil
+		if d.Muted {
+			now := c.now()
+			participant.MutedAt, participant.MutedUntil = &now, d.Until
+		}
+	})
+}
+
+// updateParticipantState applies the update to the participant's own entry. The conversation's
+// updated_at is left untouched since the change is only visible to the participant.
+// It returns the updated conversation or an error.
+func (c Conversation) updateParticipantState(conversationID string, participant data.ReadParticipant, update func(*model.Participant)) (*model.Conversation, error) {
+	return c.modify(conversationID, func(conversation *model.Conversation) error {
+		p := conversation.ActiveParticipant(participant.ParticipantID, participant.Metadata)
+		if p == nil {
+			return store.ErrNotParticipant
+		}
+
+		update(p)
+		return nil
+	})
+}
+
+// Unarchive brings the conversation back for every participant that archived it.
+// It returns an error.
+func (c Conversation) Unarchive(ctx context.Context, conversationID string) error {
+	return c.db.update(func() error {
+		return c.unarchive(conversationID)
+	})
+}
+
+// unarchive is Unarchive for a caller holding the lock.
+func (c Conversation) unarchive(conversationID string) error {
+	conversation, err := c.find(conversationID)
+	if err != nil || conversation == nil {
+		return err
+	}
+
+	for i := range conversation.Participants {
+		conversation.Participants[i].ArchivedAt = nil
+	}
+
+	_, err = c.save(conversation)
+	return err
+}
+
+// Find fetches the conversation by id.
+// It returns the conversation, or ErrConversationNotFound if there is none, or an error.
+func (c Conversation) Find(ctx context.Context, id string) (*model.Conversation, error) {
+	var conversation *model.Conversation
+	err := c.db.view(func() error {
+		var err error
+		conversation, err = c.get(id)
+		return err
+	})
+
+	return conversation, err
+}
+
+// Paginate fetches conversations for the participant, the ones the participant pinned first,
+// most recently pinned first, then the others by last activity.
+// Conversations the participant archived are excluded unless IncludeArchived is set.
+// It returns the conversations and the total number of conversations or an error.
+func (c Conversation) Paginate(ctx context.Context, d data.PaginateConversations) ([]model.Conversation, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate paginate conversations data: %w", err)
+	}
+
+	pinnedAt := func(conversation model.Conversation) *time.Time {
+		return conversation.ActiveParticipant(d.ParticipantID, d.Metadata).PinnedAt
+	}
+
+	var conversations []model.Conversation
+	err := c.db.view(func() error {
+		var err error
+		conversations, err = c.all(memberMatch(d.ParticipantID, d.Metadata, d.IncludeArchived))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	slices.SortStableFunc(conversations, func(a, b model.Conversation) int {
+		if order := compareTimes(pinnedAt(b), pinnedAt(a)); order != 0 {
+			return order
+		}
+		return byRecentActivity(a, b)
+	})
+
+	return paginate(conversations, d.Page, d.PerPage), uint(len(conversations)), nil
+}
+
+// FindByParticipants finds the direct conversation between exactly the specified participants.
+// It returns ErrConversationNotFound if there is none.
+func (c Conversation) FindByParticipants(ctx context.Context, d data.FindByParticipants) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate find by participants data: %w", err)
+	}
+
+	participants := make([]data.AddParticipant, len(d.Participants))
+	for i, p := range d.Participants {
+		participants[i] = data.AddParticipant{
+			ParticipantID: p.ParticipantID,
+			Metadata:      p.Metadata,
+		}
+	}
+
+	var conversation *model.Conversation
+	err := c.db.view(func() error {
+		var err error
+		conversation, err = c.withParticipants(participants)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find conversation: %w", err)
+	}
+	if conversation == nil {
+		return nil, store.ErrConversationNotFound
+	}
+
+	return conversation, nil
+}
+
+// FindByMetadata finds conversations that have participants matching the specified metadata,
+// see repository.Conversation.FindByMetadata.
+// It returns paginated conversations, total count, or an error.
+func (c Conversation) FindByMetadata(ctx context.Context, d data.FindByMetadata) ([]model.Conversation, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate find by metadata data: %w", err)
+	}
+
+	var conversations []model.Conversation
+	err := c.db.view(func() error {
+		var err error
+		conversations, err = c.all(metadataMatch(d.Metadata, d.MatchMode, d.IncludeDeleted))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	slices.SortStableFunc(conversations, byRecentActivity)
+
+	return paginate(conversations, d.Page, d.PerPage), uint(len(conversations)), nil
+}
+
+// LoadConversations fetches the conversations the participant is a non-deleted member of,
+// most recently updated first, continuing from the cursor of the previous page.
+// Conversations the participant archived are excluded unless IncludeArchived is set.
+// It returns the page or an error.
+func (c Conversation) LoadConversations(ctx context.Context, d data.LoadConversations) (*model.ConversationPage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load conversations data: %w", err)
+	}
+
+	return c.loadPage(memberMatch(d.ParticipantID, d.Metadata, d.IncludeArchived), d.Cursor, d.PerPage, d.Count)
+}
+
+// LoadByMetadata is the cursor based variant of FindByMetadata, see LoadConversations.
+// It returns the page or an error.
+func (c Conversation) LoadByMetadata(ctx context.Context, d data.LoadByMetadata) (*model.ConversationPage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load by metadata data: %w", err)
+	}
+
+	return c.loadPage(metadataMatch(d.Metadata, d.MatchMode, d.IncludeDeleted), d.Cursor, d.PerPage, d.Count)
+}
+
+// loadPage fetches the page of conversations matching after the cursor,
+// ordered by updated_at then _id, both descending.
+func (c Conversation) loadPage(match func(model.Conversation) bool, cursor string, perPage uint, count bool) (*model.ConversationPage, error) {
+	var conversations []model.Conversation
+	err := c.db.view(func() error {
+		var err error
+		conversations, err = c.all(match)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.ConversationPage{Conversations: []model.Conversation{}}
+	if count {
+		total := uint(len(conversations))
+		page.Total = &total
+	}
+
+	if cursor != "" {
+		after, err := afterCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		conversations = slices.DeleteFunc(conversations, func(conversation model.Conversation) bool {
+			return !after(conversation)
+		})
+	}
+
+	slices.SortStableFunc(conversations, byRecentActivity)
+
+	page.Conversations = append(page.Conversations, conversations...)
+	if uint(len(page.Conversations)) > perPage {
+		page.Conversations = page.Conversations[:perPage]
+		last := page.Conversations[perPage-1]
+		page.HasMore = true
+		page.NextCursor = chat.EncodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+// UpdateLastMessage sets the message as the conversation's last message and advances the
+// conversation's updated_at to the message's creation time. Neither goes backwards.
+// It returns an error.
+func (c Conversation) UpdateLastMessage(ctx context.Context, conversationID string, message model.Message) error {
+	return c.db.update(func() error {
+		return c.updateLastMessage(conversationID, message)
+	})
+}
+
+// updateLastMessage is UpdateLastMessage for a caller holding the lock.
+func (c Conversation) updateLastMessage(conversationID string, message model.Message) error {
+	conversation, err := c.find(conversationID)
+	if err != nil {
+		return err
+	}
+	if conversation == nil {
+		return store.ErrConversationNotFound
+	}
+
+	if conversation.LastMessage == nil || compareIDs(conversation.LastMessage.ID, message.ID) < 0 {
+		conversation.LastMessage = &message
+	}
+	if message.CreatedAt.After(conversation.UpdatedAt) {
+		conversation.UpdatedAt = message.CreatedAt
+	}
+
+	_, err = c.save(conversation)
+	return err
+}
+
+// RefreshLastMessage replaces the conversation's last message with the given version of it,
+// e.g. after the message has been edited. Revisions are not copied into the conversation.
+// It is a no-op when the message is no longer the conversation's last message.
+func (c Conversation) RefreshLastMessage(ctx context.Context, conversationID string, message model.Message) error {
+	return c.db.update(func() error {
+		return c.refreshLastMessage(conversationID, message)
+	})
+}
+
+// refreshLastMessage is RefreshLastMessage for a caller holding the lock.
+func (c Conversation) refreshLastMessage(conversationID string, message model.Message) error {
+	conversation, err := c.find(conversationID)
+	if err != nil || conversation == nil || conversation.LastMessage == nil || conversation.LastMessage.ID != message.ID {
+		return err
+	}
+
+	message.Revisions = nil
+	conversation.LastMessage = &message
+
+	_, err = c.save(conversation)
+	return err
+}
+
+// authorize checks the actor against the repository's permission matrix, see chat.Authorize.
+func (c Conversation) authorize(conversation *model.Conversation, actor *data.Actor, permission model.Permission) (*model.Participant, error) {
+	return chat.Authorize(c.permissions, conversation, actor, permission)
+}
+
+// find fetches the conversation of the tenant by id, or nil. The caller holds the lock.
+func (c Conversation) find(id string) (*model.Conversation, error) {
+	obID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse conversation id: %w", err)
+	}
+
+	conversation, err := c.db.conversations.get(obID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+	if conversation == nil || conversation.TenantID != c.tenant {
+		return nil, nil
+	}
+
+	return conversation, nil
+}
+
+// get is find for operations on an existing conversation. It returns ErrConversationNotFound
+// if there is none.
+func (c Conversation) get(id string) (*model.Conversation, error) {
+	conversation, err := c.find(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+	if conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", store.ErrConversationNotFound)
+	}
+
+	return conversation, nil
+}
+
+// modify applies the update to the conversation and saves it, atomically.
+// It returns the updated conversation or an error.
+func (c Conversation) modify(conversationID string, update func(*model.Conversation) error) (*model.Conversation, error) {
+	var conversation *model.Conversation
+	err := c.db.update(func() error {
+		current, err := c.get(conversationID)
+		if err != nil {
+			return err
+		}
+
+		if err := update(current); err != nil {
+			return err
+		}
+
+		conversation, err = c.save(current)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// save stores the conversation and returns the stored version of it. The caller holds the lock.
+func (c Conversation) save(conversation *model.Conversation) (*model.Conversation, error) {
+	saved, err := c.db.conversations.put(conversation.ID.Hex(), *conversation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	return saved, nil
+}
+
+// all returns the conversations of the tenant that match, oldest first. The caller holds the lock.
+func (c Conversation) all(match func(model.Conversation) bool) ([]model.Conversation, error) {
+	conversations, err := c.db.conversations.all()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+
+	return slices.DeleteFunc(conversations, func(conversation model.Conversation) bool {
+		return conversation.TenantID != c.tenant || !match(conversation)
+	}), nil
+}
+
+// participantMatch matches the conversations the participant is part of.
+func memberMatch(participantID string, metadata map[string]any, includeArchived bool) func(model.Conversation) bool {
+	return func(conversation model.Conversation) bool {
+		p := conversation.ActiveParticipant(participantID, metadata)
+		return p != nil && (includeArchived || p.ArchivedAt == nil)
+	}
+}
+
+// metadataMatch matches the conversations with a participant matching the metadata.
+func metadataMatch(metadata map[string]any, matchMode data.MetadataMatchMode, includeDeleted bool) func(model.Conversation) bool {
+	return func(conversation model.Conversation) bool {
+		return slices.ContainsFunc(conversation.Participants, func(p model.Participant) bool {
+			if !includeDeleted && p.DeletedAt != nil {
+				return false
+			}
+			if matchMode == data.MetadataMatchModeExact {
+				return metadataEqual(p.Metadata, metadata)
+			}
+			return metadataContains(p.Metadata, metadata)
+		})
+	}
+}
+
+// afterCursor decodes the cursor into a match for the conversations after it.
+func afterCursor(cursor string) (func(model.Conversation) bool, error) {
+	updatedAt, id, err := chat.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(conversation model.Conversation) bool {
+		if conversation.UpdatedAt.Equal(updatedAt) {
+			return compareIDs(conversation.ID, id) < 0
+		}
+		return conversation.UpdatedAt.Before(updatedAt)
+	}, nil
+}
+
+// byRecentActivity orders conversations by updated_at then _id, both descending.
+func byRecentActivity(a, b model.Conversation) int {
+	if order := b.UpdatedAt.Compare(a.UpdatedAt); order != 0 {
+		return order
+	}
+	return compareIDs(b.ID, a.ID)
+}
+
+// compareTimes orders optional times, nil first.
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	default:
+		return a.Compare(*b)
+	}
+}
+
+// paginate returns the page of the items.
+func paginate[T any](items []T, page, perPage uint) []T {
+	start := min(uint(len(items)), (page-1)*perPage)
+	end := min(uint(len(items)), start+perPage)
+	return items[start:end]
+}
+
+// metadataContains reports whether the stored metadata holds every key and value of the
+// metadata, like a MongoDB query on the metadata's fields.
+func metadataContains(stored, metadata map[string]any) bool {
+	for key, value := range metadata {
+		storedValue, ok := stored[key]
+		if !ok || !reflect.DeepEqual(storedValue, normalize(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataEqual reports whether the stored metadata holds exactly the keys and values of
+// the metadata. A nil map equals an empty map.
+func metadataEqual(stored, metadata map[string]any) bool {
+	return len(stored) == len(metadata) && metadataContains(stored, metadata)
+}
@@ -0,0 +1,116 @@
+// Package memory implements the storage interfaces of the store package in memory.
+// It behaves like the MongoDB repositories and needs no database, which suits tests,
+// examples and small single process deployments. Nothing is persisted.
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// DB holds the documents of the in-memory backend.
+// Repositories built on the same DB see the same documents. It is safe for concurrent use.
+type DB struct {
+	mu            sync.RWMutex
+	conversations collection[model.Conversation]
+	messages      collection[model.Message]
+	typing        collection[typingDocument]
+	presence      collection[presenceDocument]
+}
+
+func NewDB() *DB {
+	return &DB{
+		conversations: collection[model.Conversation]{},
+		messages:      collection[model.Message]{},
+		typing:        collection[typingDocument]{},
+		presence:      collection[presenceDocument]{},
+	}
+}
+
+// view runs fn while holding the read lock.
+func (db *DB) view(fn func() error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return fn()
+}
+
+// update runs fn while holding the write lock, so that fn reads and writes atomically.
+func (db *DB) update(fn func() error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return fn()
+}
+
+// collection holds documents encoded as BSON by key, so that the documents handed out never
+// share memory with the stored ones and are decoded exactly as they are from MongoDB.
+type collection[T any] map[string]bson.Raw
+
+// get returns the document stored under the key, or nil.
+func (c collection[T]) get(key string) (*T, error) {
+	raw, ok := c[key]
+	if !ok {
+		return nil, nil
+	}
+
+	var document T
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return &document, nil
+}
+
+// put stores the document under the key and returns the stored version of it.
+func (c collection[T]) put(key string, document T) (*T, error) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode document: %w", err)
+	}
+	c[key] = raw
+	return c.get(key)
+}
+
+// all returns the documents ordered by key. Documents keyed by their id are thus in
+// insertion order.
+func (c collection[T]) all() ([]T, error) {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	documents := make([]T, 0, len(keys))
+	for _, key := range keys {
+		var document T
+		if err := bson.Unmarshal(c[key], &document); err != nil {
+			return nil, fmt.Errorf("failed to decode document: %w", err)
+		}
+		documents = append(documents, document)
+	}
+	return documents, nil
+}
+
+// compareIDs orders ids like MongoDB does, which is by creation time.
+func compareIDs(a, b bson.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// normalize returns the value as it reads back from the database, e.g. with integers
+// stored as int32 and nested maps as documents, so that it can be compared with stored values.
+func normalize(value any) any {
+	raw, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return value
+	}
+	var document struct {
+		V any `bson:"v"`
+	}
+	if err := bson.Unmarshal(raw, &document); err != nil {
+		return value
+	}
+	return document.V
+}
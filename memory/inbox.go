@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// Inbox fetches the page of conversations the participant is a non-deleted member of, each
+// with its unread count and the participant's own read cursor, along with the unread badge
+// counts over the whole inbox, see repository.Conversation.Inbox.
+// It returns the inbox page or an error.
+func (c Conversation) Inbox(ctx context.Context, d data.LoadInbox) (*model.Inbox, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load inbox data: %w", err)
+	}
+
+	var after func(model.Conversation) bool
+	if d.Cursor != "" {
+		var err error
+		if after, err = afterCursor(d.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	inbox := &model.Inbox{Entries: []model.InboxEntry{}}
+	err := c.db.view(func() error {
+		conversations, err := c.all(func(conversation model.Conversation) bool {
+			return conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) != nil
+		})
+		if err != nil {
+			return err
+		}
+
+		messages, err := c.messages()
+		if err != nil {
+			return err
+		}
+
+		now := c.now()
+		for _, conversation := range conversations {
+			me := *conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata)
+			unreadCount := unread(&conversation, &me, d.Participant, messages[conversation.ID.Hex()])
+
+			if !me.Muted(now) {
+				inbox.UnreadMessages += unreadCount
+				if unreadCount > 0 {
+					inbox.UnreadConversations++
+				}
+			}
+
+			if !d.IncludeArchived && me.ArchivedAt != nil {
+				continue
+			}
+			if d.UnreadOnly && unreadCount == 0 {
+				continue
+			}
+			if after != nil && !after(conversation) {
+				continue
+			}
+
+			inbox.Entries = append(inbox.Entries, model.InboxEntry{
+				Conversation: conversation,
+				Participant:  me,
+				UnreadCount:  unreadCount,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(inbox.Entries, func(a, b model.InboxEntry) int {
+		return byRecentActivity(a.Conversation, b.Conversation)
+	})
+
+	if uint(len(inbox.Entries)) > d.PerPage {
+		inbox.Entries = inbox.Entries[:d.PerPage]
+		last := inbox.Entries[d.PerPage-1].Conversation
+		inbox.HasMore = true
+		inbox.NextCursor = chat.EncodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	return inbox, nil
+}
+
+// messages returns the messages of the tenant by conversation id, oldest first.
+// The caller holds the lock.
+func (c Conversation) messages() (map[string][]model.Message, error) {
+	messages, err := c.db.messages.all()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	byConversation := map[string][]model.Message{}
+	for _, message := range messages {
+		if message.TenantID != c.tenant {
+			continue
+		}
+		id := message.ConversationID.Hex()
+		byConversation[id] = append(byConversation[id], message)
+	}
+
+	return byConversation, nil
+}
@@ -0,0 +1,21 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/memory"
+	"github.com/davesavic/chatsavvy/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T, now func() time.Time) storetest.Stores {
+		db := memory.NewDB()
+		cr := memory.NewConversation(db, memory.WithClock(now))
+		return storetest.Stores{
+			Conversations: cr,
+			Messages:      memory.NewMessage(db, cr, memory.WithClock(now)),
+			Presence:      memory.NewPresence(db, cr, memory.WithClock(now)),
+		}
+	})
+}
@@ -0,0 +1,1006 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Message struct {
+	db           *DB
+	conversation *Conversation
+	config
+}
+
+func NewMessage(db *DB, conversation *Conversation, opts ...Option) *Message {
+	return &Message{
+		db:           db,
+		conversation: conversation,
+		config:       newConfig(opts),
+	}
+}
+
+// Create creates a new message in the conversation, see repository.Message.Create.
+// The message, the thread parent and the conversation's last message are written atomically.
+// It returns the created message or an error.
+func (m Message) Create(ctx context.Context, conversationID string, d data.CreateMessage) (*model.Message, error) {
+	if err := d.ValidateWith(m.limits); err != nil {
+		return nil, err
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.CreateMessage{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var message *model.Message
+	err := m.db.update(func() error {
+		conversation, err := m.conversation.get(conversationID)
+		if err != nil {
+			return err
+		}
+
+		if d.Kind != model.MessageKindSystem {
+			if _, err := chat.RequireParticipant(conversation, d.Sender.ParticipantID, d.Sender.Metadata); err != nil {
+				return err
+			}
+
+			actor := &data.Actor{ParticipantID: d.Sender.ParticipantID, Metadata: d.Sender.Metadata}
+			if _, err := m.conversation.authorize(conversation, actor, model.PermissionPostMessage); err != nil {
+				return err
+			}
+		}
+
+		var parent *model.Message
+		if d.ParentID != nil {
+			parent, err = m.threadParent(conversation.ID.Hex(), *d.ParentID)
+			if err != nil {
+				return err
+			}
+		}
+
+		var quote *model.Quote
+		if d.ReplyToID != nil {
+			quote, err = m.quote(conversation.ID.Hex(), *d.ReplyToID)
+			if err != nil {
+				return err
+			}
+		}
+
+		now := m.now()
+		created := model.Message{
+			ID:             bson.NewObjectID(),
+			TenantID:       m.tenant,
+			ConversationID: conversation.ID,
+			Sender:         model.MessageSender{ParticipantID: d.Sender.ParticipantID, Metadata: d.Sender.Metadata},
+			Kind:           d.Kind,
+			Content:        d.Content,
+			Attachments:    attachmentsOf(d.Attachments),
+			ReplyTo:        quote,
+			CreatedAt:      now,
+		}
+		if parent != nil {
+			created.ParentID = &parent.ID
+		}
+
+		message, err = m.save(&created)
+		if err != nil {
+			return err
+		}
+
+		if parent != nil {
+			parent.ReplyCount++
+			if parent.LastReplyAt == nil || message.CreatedAt.After(*parent.LastReplyAt) {
+				parent.LastReplyAt = &message.CreatedAt
+			}
+			if _, err := m.save(parent); err != nil {
+				return fmt.Errorf("failed to update the thread parent: %w", err)
+			}
+		}
+
+		if err := m.conversation.updateLastMessage(conversationID, *message); err != nil {
+			return fmt.Errorf("failed to touch the conversation: %w", err)
+		}
+
+		return m.conversation.unarchive(conversationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.MessageCreated{Message: message})
+
+	return message, nil
+}
+
+// Edit replaces the content and attachments of a message, see repository.Message.Edit.
+// It returns the edited message or an error.
+func (m Message) Edit(ctx context.Context, d data.EditMessage) (*model.Message, error) {
+	if err := d.ValidateWith(m.limits); err != nil {
+		return nil, fmt.Errorf("failed to validate edit message data: %w", err)
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.EditMessage{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var edited *model.Message
+	err := m.db.update(func() error {
+		message, err := m.find(d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		conversation, err := m.conversation.find(message.ConversationID.Hex())
+		if err != nil {
+			return fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		if conversation == nil {
+			return store.ErrConversationNotFound
+		}
+
+		if _, err := chat.RequireParticipant(conversation, d.Editor.ParticipantID, d.Editor.Metadata); err != nil {
+			return err
+		}
+
+		if message.Sender.ParticipantID != d.Editor.ParticipantID || !model.MetadataEqual(message.Sender.Metadata, d.Editor.Metadata) {
+			return store.ErrNotSender
+		}
+
+		if message.DeletedAt != nil {
+			return store.ErrMessageDeleted
+		}
+
+		now := m.now()
+		message.Revisions = append(message.Revisions, model.Revision{
+			Content:     message.Content,
+			Attachments: message.Attachments,
+			EditedBy: model.MessageSender{
+				ParticipantID: d.Editor.ParticipantID,
+				Metadata:      d.Editor.Metadata,
+			},
+			EditedAt: now,
+		})
+		message.Content = d.Content
+		message.Attachments = attachmentsOf(d.Attachments)
+		message.EditedAt = &now
+
+		edited, err = m.save(message)
+		if err != nil {
+			return err
+		}
+
+		if err := m.conversation.refreshLastMessage(edited.ConversationID.Hex(), *edited); err != nil {
+			return fmt.Errorf("failed to sync the conversation: %w", err)
+		}
+
+		return m.syncQuotes(*edited)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.MessageEdited{Message: edited})
+
+	return edited, nil
+}
+
+// DeleteForEveryone tombstones a message, see repository.Message.DeleteForEveryone.
+// It returns the deleted message or an error.
+func (m Message) DeleteForEveryone(ctx context.Context, d data.DeleteMessageForEveryone) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate delete message data: %w", err)
+	}
+
+	var deleted *model.Message
+	err := m.db.update(func() error {
+		message, err := m.find(d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if message.Sender.ParticipantID != d.Sender.ParticipantID || !model.MetadataEqual(message.Sender.Metadata, d.Sender.Metadata) {
+			return store.ErrNotSender
+		}
+
+		if message.DeletedAt != nil {
+			deleted = message
+			return nil
+		}
+
+		now := m.now()
+		message.Content = ""
+		message.Attachments = []model.Attachment{}
+		message.Reactions = []model.Reaction{}
+		message.Revisions = nil
+		message.PinnedAt, message.PinnedBy = nil, nil
+		message.DeletedAt = &now
+
+		deleted, err = m.save(message)
+		if err != nil {
+			return err
+		}
+
+		if err := m.conversation.refreshLastMessage(deleted.ConversationID.Hex(), *deleted); err != nil {
+			return fmt.Errorf("failed to sync the conversation: %w", err)
+		}
+
+		return m.syncQuotes(*deleted)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteForMe hides a message from the participant only, see repository.Message.DeleteForMe.
+func (m Message) DeleteForMe(ctx context.Context, d data.DeleteMessageForMe) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate delete message data: %w", err)
+	}
+
+	return m.db.update(func() error {
+		message, err := m.find(d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		conversation, err := m.conversation.find(message.ConversationID.Hex())
+		if err != nil {
+			return fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		if conversation == nil {
+			return store.ErrConversationNotFound
+		}
+
+		if conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) == nil {
+			return store.ErrNotParticipant
+		}
+
+		for _, h := range message.HiddenFor {
+			if h.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(h.Metadata, d.Participant.Metadata) {
+				return nil
+			}
+		}
+
+		message.HiddenFor = append(message.HiddenFor, model.HiddenParticipant{
+			ParticipantID: d.Participant.ParticipantID,
+			Metadata:      d.Participant.Metadata,
+		})
+
+		_, err = m.save(message)
+		return err
+	})
+}
+
+// Paginate fetches messages in the conversation, newest first.
+// Thread replies are only included if IncludeThreadReplies is set.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded.
+// It returns the messages and the total number of messages in the conversation or an error.
+func (m Message) Paginate(ctx context.Context, d data.PaginateMessages) ([]model.Message, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		messages, err = m.messages(conversation, visible(conversation, d.Viewer, d.IncludeThreadReplies))
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	slices.SortStableFunc(messages, func(a, b model.Message) int {
+		if order := b.CreatedAt.Compare(a.CreatedAt); order != 0 {
+			return order
+		}
+		return compareIDs(b.ID, a.ID)
+	})
+
+	return paginate(messages, d.Page, d.PerPage), uint(len(messages)), nil
+}
+
+// LoadMessages fetches the messages in the conversation older than the last message id
+// provided, or the latest messages if it is nil, newest first.
+// Thread replies and the viewer are handled as in Paginate.
+// It returns the messages or an error.
+func (m Message) LoadMessages(ctx context.Context, d data.LoadMessages) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		messages, err = m.messages(conversation, visible(conversation, d.Viewer, d.IncludeThreadReplies))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.LastMessageID != nil {
+		lastObID, err := bson.ObjectIDFromHex(*d.LastMessageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the last message id: %w", err)
+		}
+		messages = olderThan(messages, lastObID)
+	}
+
+	slices.Reverse(messages)
+	return messages[:min(len(messages), int(d.PerPage))], nil
+}
+
+// LoadNewerMessages fetches the messages in the conversation newer than the first message id
+// provided. Thread replies and the viewer are handled as in Paginate.
+// It returns the window of messages, newest first, or an error.
+func (m Message) LoadNewerMessages(ctx context.Context, d data.LoadNewerMessages) (*model.MessageWindow, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		messages, err = m.messages(conversation, visible(conversation, d.Viewer, d.IncludeThreadReplies))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	firstObID, err := bson.ObjectIDFromHex(d.FirstMessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the first message id: %w", err)
+	}
+
+	split, found := slices.BinarySearchFunc(messages, firstObID, func(message model.Message, id bson.ObjectID) int {
+		return compareIDs(message.ID, id)
+	})
+	if found {
+		split++
+	}
+
+	newer := messages[split:]
+	hasNewer := uint(len(newer)) > d.PerPage
+	newer = slices.Clone(newer[:min(uint(len(newer)), d.PerPage)])
+	slices.Reverse(newer)
+
+	return &model.MessageWindow{Messages: newer, HasOlder: split > 0, HasNewer: hasNewer}, nil
+}
+
+// LoadMessagesAround fetches the message with the given id along with up to Before older
+// and After newer messages. Thread replies and the viewer are handled as in Paginate.
+// It returns the window of messages, newest first, or an error.
+func (m Message) LoadMessagesAround(ctx context.Context, d data.LoadMessagesAround) (*model.MessageWindow, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		messages, err = m.messages(conversation, visible(conversation, d.Viewer, d.IncludeThreadReplies))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	anchor := slices.IndexFunc(messages, func(message model.Message) bool {
+		return message.ID == messageObID
+	})
+	if anchor == -1 {
+		return nil, fmt.Errorf("%w in conversation", store.ErrMessageNotFound)
+	}
+
+	start := max(0, anchor-int(d.Before))
+	end := min(len(messages), anchor+int(d.After)+1)
+	window := slices.Clone(messages[start:end])
+	slices.Reverse(window)
+
+	return &model.MessageWindow{Messages: window, HasOlder: start > 0, HasNewer: end < len(messages)}, nil
+}
+
+// LoadThread fetches the replies in a message thread older than the last message id
+// provided, or the latest replies if it is nil, newest first.
+// The viewer is handled as in Paginate.
+// It returns the replies or an error.
+func (m Message) LoadThread(ctx context.Context, d data.LoadThread) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		parent, err := m.threadParent(d.ConversationID, d.ParentID)
+		if err != nil {
+			return err
+		}
+
+		isVisible := visible(conversation, d.Viewer, true)
+		messages, err = m.messages(conversation, func(message model.Message) bool {
+			return message.ParentID != nil && *message.ParentID == parent.ID && isVisible(message)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.LastMessageID != nil {
+		lastObID, err := bson.ObjectIDFromHex(*d.LastMessageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the last message id: %w", err)
+		}
+		messages = olderThan(messages, lastObID)
+	}
+
+	slices.Reverse(messages)
+	return messages[:min(len(messages), int(d.PerPage))], nil
+}
+
+// threadParent fetches the root message of the thread the given message belongs to.
+// The message must belong to the conversation. The caller holds the lock.
+func (m Message) threadParent(conversationID string, messageID string) (*model.Message, error) {
+	parent, err := m.find(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the parent message: %w", err)
+	}
+
+	if parent.ConversationID.Hex() != conversationID {
+		return nil, fmt.Errorf("parent message does not belong to conversation: %w", store.ErrMessageNotFound)
+	}
+
+	if parent.ParentID != nil {
+		return m.threadParent(conversationID, parent.ParentID.Hex())
+	}
+
+	return parent, nil
+}
+
+// quote builds a snapshot of the message being replied to.
+// The message must belong to the conversation and must not be deleted. The caller holds the lock.
+func (m Message) quote(conversationID string, messageID string) (*model.Quote, error) {
+	quoted, err := m.find(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the reply to message: %w", err)
+	}
+
+	if quoted.ConversationID.Hex() != conversationID {
+		return nil, fmt.Errorf("reply to message does not belong to conversation: %w", store.ErrMessageNotFound)
+	}
+
+	if quoted.DeletedAt != nil {
+		return nil, fmt.Errorf("reply to %w", store.ErrMessageDeleted)
+	}
+
+	quote := chat.QuoteOf(*quoted)
+	return &quote, nil
+}
+
+// syncQuotes refreshes the snapshots of the message embedded in replies,
+// including a reply that is cached as a conversation's last message. The caller holds the lock.
+func (m Message) syncQuotes(message model.Message) error {
+	quote := chat.QuoteOf(message)
+
+	replies, err := m.db.messages.all()
+	if err != nil {
+		return fmt.Errorf("failed to sync quotes: %w", err)
+	}
+	for _, reply := range replies {
+		if reply.TenantID != m.tenant || reply.ReplyTo == nil || reply.ReplyTo.MessageID != message.ID {
+			continue
+		}
+		reply.ReplyTo = &quote
+		if _, err := m.save(&reply); err != nil {
+			return fmt.Errorf("failed to sync quotes: %w", err)
+		}
+	}
+
+	conversations, err := m.conversation.all(func(conversation model.Conversation) bool {
+		last := conversation.LastMessage
+		return last != nil && last.ReplyTo != nil && last.ReplyTo.MessageID == message.ID
+	})
+	if err != nil {
+		return fmt.Errorf("failed to sync quotes: %w", err)
+	}
+	for _, conversation := range conversations {
+		conversation.LastMessage.ReplyTo = &quote
+		if _, err := m.conversation.save(&conversation); err != nil {
+			return fmt.Errorf("failed to sync quotes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Pin pins a message in its conversation. The actor needs the pin message permission.
+// Pinning a pinned message keeps the original pin.
+// It returns the pinned message or an error.
+func (m Message) Pin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error) {
+	return m.setPinned(actor, d, true)
+}
+
+// Unpin unpins a message. The actor needs the pin message permission.
+// It returns the unpinned message or an error.
+func (m Message) Unpin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error) {
+	return m.setPinned(actor, d, false)
+}
+
+func (m Message) setPinned(actor *data.Actor, d data.PinMessage, pinned bool) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate pin message data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	var updated *model.Message
+	err := m.db.update(func() error {
+		message, err := m.find(d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		conversation, err := m.conversation.get(message.ConversationID.Hex())
+		if err != nil {
+			return err
+		}
+
+		if _, err := m.conversation.authorize(conversation, actor, model.PermissionPinMessage); err != nil {
+			return err
+		}
+
+		if message.DeletedAt != nil {
+			return store.ErrMessageDeleted
+		}
+
+		if pinned == (message.PinnedAt != nil) {
+			updated = message
+			return nil
+		}
+
+		message.PinnedAt, message.PinnedBy = nil, nil
+		if pinned {
+			now := m.now()
+			message.PinnedAt = &now
+			if actor != nil {
+				message.PinnedBy = &model.MessageSender{ParticipantID: actor.ParticipantID, Metadata: actor.Metadata}
+			}
+		}
+
+		updated, err = m.save(message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// LoadPinned fetches the pinned messages of the conversation, most recently pinned first.
+// The viewer is handled as in Paginate.
+// It returns the messages or an error.
+func (m Message) LoadPinned(ctx context.Context, d data.LoadPinnedMessages) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		isVisible := visible(conversation, d.Viewer, true)
+		messages, err = m.messages(conversation, func(message model.Message) bool {
+			return message.PinnedAt != nil && isVisible(message)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(messages, func(a, b model.Message) int {
+		return b.PinnedAt.Compare(*a.PinnedAt)
+	})
+
+	return messages, nil
+}
+
+// ToggleReaction toggles a reaction on the message for the participant.
+// The participant must be a non-deleted participant of the message's conversation.
+// It returns the updated message or an error.
+func (m Message) ToggleReaction(ctx context.Context, d data.ToggleReaction) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.ToggleReaction{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var updated *model.Message
+	err := m.db.update(func() error {
+		message, err := m.find(d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		conversation, err := m.conversation.find(message.ConversationID.Hex())
+		if err != nil {
+			return fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		if conversation == nil {
+			return store.ErrConversationNotFound
+		}
+
+		if _, err := chat.RequireParticipant(conversation, d.Participant.ParticipantID, d.Participant.Metadata); err != nil {
+			return err
+		}
+
+		if message.DeletedAt != nil {
+			return store.ErrMessageDeleted
+		}
+
+		reactor := model.ReactionParticipant{ParticipantID: d.Participant.ParticipantID, Metadata: d.Participant.Metadata}
+		reactionIndex := slices.IndexFunc(message.Reactions, func(r model.Reaction) bool {
+			return r.Emoji == d.Emoji
+		})
+
+		if reactionIndex == -1 {
+			message.Reactions = append(message.Reactions, model.Reaction{
+				Emoji:        d.Emoji,
+				Participants: []model.ReactionParticipant{reactor},
+			})
+		} else {
+			reaction := &message.Reactions[reactionIndex]
+			participantIndex := slices.IndexFunc(reaction.Participants, func(p model.ReactionParticipant) bool {
+				return p.ParticipantID == reactor.ParticipantID && model.MetadataEqual(p.Metadata, reactor.Metadata)
+			})
+
+			if participantIndex == -1 {
+				reaction.Participants = append(reaction.Participants, reactor)
+			} else {
+				reaction.Participants = slices.Delete(reaction.Participants, participantIndex, participantIndex+1)
+			}
+		}
+
+		message.Reactions = slices.DeleteFunc(message.Reactions, func(r model.Reaction) bool {
+			return len(r.Participants) == 0
+		})
+
+		updated, err = m.save(message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.ReactionToggled{Message: updated, Data: d})
+
+	return updated, nil
+}
+
+// MarkRead advances the participant's read cursor to the given message.
+// A backward (older-or-equal) call is a no-op. Soft-deleted participants are rejected.
+func (m Message) MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate mark read data: %w", err)
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.MarkRead{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var updated *model.Conversation
+	var advanced bool
+	err := m.db.update(func() error {
+		message, err := m.find(d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if message.ConversationID.Hex() != d.ConversationID {
+			return fmt.Errorf("message does not belong to conversation: %w", store.ErrMessageNotFound)
+		}
+
+		conversation, err := m.conversation.find(d.ConversationID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		if conversation == nil {
+			return store.ErrConversationNotFound
+		}
+
+		participant := conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata)
+		if participant == nil {
+			return store.ErrNotParticipant
+		}
+
+		updated = conversation
+		if participant.LastReadMessageID != nil && compareIDs(*participant.LastReadMessageID, message.ID) >= 0 {
+			return nil
+		}
+
+		participant.LastReadMessageID = &message.ID
+		participant.LastReadAt = &message.CreatedAt
+
+		updated, err = m.conversation.save(conversation)
+		advanced = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if advanced {
+		m.hooks.RunAfter(ctx, hook.ReadMarked{Conversation: updated, Data: d})
+	}
+
+	return updated, nil
+}
+
+// MarkAllRead advances the participant's read cursor to the latest message of the conversation.
+func (m Message) MarkAllRead(ctx context.Context, d data.MarkAllRead) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate mark all read data: %w", err)
+	}
+
+	var conversation *model.Conversation
+	var latest []model.Message
+	err := m.db.view(func() error {
+		var err error
+		conversation, err = m.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		latest, err = m.messages(conversation, func(model.Message) bool { return true })
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(latest) == 0 {
+		return conversation, nil
+	}
+
+	return m.MarkRead(ctx, data.MarkRead{
+		ConversationID: d.ConversationID,
+		Participant:    d.Participant,
+		MessageID:      latest[len(latest)-1].ID.Hex(),
+	})
+}
+
+// ReadersOf returns the participants that have read the given message
+// (i.e. whose last read message id is greater than or equal to the message id).
+// Soft-deleted participants are excluded.
+func (m Message) ReadersOf(ctx context.Context, d data.ReadersOf) ([]model.Participant, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate readers of data: %w", err)
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message id: %w", err)
+	}
+
+	var conversation *model.Conversation
+	err = m.db.view(func() error {
+		var err error
+		conversation, err = m.conversation.find(d.ConversationID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if conversation == nil {
+		return nil, store.ErrConversationNotFound
+	}
+
+	readers := make([]model.Participant, 0)
+	for _, p := range conversation.Participants {
+		if p.DeletedAt == nil && p.LastReadMessageID != nil && compareIDs(*p.LastReadMessageID, messageObID) >= 0 {
+			readers = append(readers, p)
+		}
+	}
+
+	return readers, nil
+}
+
+// UnreadCount returns the number of unread messages in the conversation for the participant,
+// see repository.Message.UnreadCount.
+func (m Message) UnreadCount(ctx context.Context, d data.UnreadCount) (uint, error) {
+	if err := d.Validate(); err != nil {
+		return 0, fmt.Errorf("failed to validate unread count data: %w", err)
+	}
+
+	var count uint
+	err := m.db.view(func() error {
+		conversation, err := m.conversation.find(d.ConversationID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		if conversation == nil {
+			return store.ErrConversationNotFound
+		}
+
+		index := slices.IndexFunc(conversation.Participants, func(p model.Participant) bool {
+			return p.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(p.Metadata, d.Participant.Metadata)
+		})
+		if index == -1 || conversation.Participants[index].DeletedAt != nil {
+			return store.ErrNotParticipant
+		}
+
+		messages, err := m.messages(conversation, func(model.Message) bool { return true })
+		if err != nil {
+			return err
+		}
+
+		count = unread(conversation, &conversation.Participants[index], d.Participant, messages)
+		return nil
+	})
+
+	return count, err
+}
+
+// unread counts the messages the participant has not read: the messages after the read
+// cursor that are not deleted, not hidden from the participant, not the participant's own
+// and within the participant's history window.
+func unread(conversation *model.Conversation, participant *model.Participant, reader data.ReadParticipant, messages []model.Message) uint {
+	var count uint
+	for _, message := range messages {
+		if message.DeletedAt != nil {
+			continue
+		}
+		if participant.LastReadMessageID != nil && compareIDs(message.ID, *participant.LastReadMessageID) <= 0 {
+			continue
+		}
+		if hiddenFor(message, reader) || sentBy(message, reader) {
+			continue
+		}
+		if !chat.InHistory(conversation, participant, message.CreatedAt) {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// visible returns a match for the messages in the conversation that are visible to the viewer.
+// A nil viewer sees every message. A viewer that is not a participant of the conversation
+// only has the messages they deleted for themselves excluded.
+func visible(conversation *model.Conversation, viewer *data.ReadParticipant, includeThreadReplies bool) func(model.Message) bool {
+	var participant *model.Participant
+	if viewer != nil {
+		participant = conversation.Participant(viewer.ParticipantID, viewer.Metadata)
+	}
+
+	return func(message model.Message) bool {
+		if !includeThreadReplies && message.ParentID != nil {
+			return false
+		}
+		if viewer == nil {
+			return true
+		}
+		if hiddenFor(message, *viewer) {
+			return false
+		}
+		return participant == nil || chat.InHistory(conversation, participant, message.CreatedAt)
+	}
+}
+
+// hiddenFor reports whether the participant deleted the message for themselves.
+func hiddenFor(message model.Message, participant data.ReadParticipant) bool {
+	return slices.ContainsFunc(message.HiddenFor, func(h model.HiddenParticipant) bool {
+		return h.ParticipantID == participant.ParticipantID && metadataEqual(h.Metadata, participant.Metadata)
+	})
+}
+
+// sentBy reports whether the participant sent the message.
+func sentBy(message model.Message, participant data.ReadParticipant) bool {
+	return message.Sender.ParticipantID == participant.ParticipantID && metadataEqual(message.Sender.Metadata, participant.Metadata)
+}
+
+// olderThan returns the messages, oldest first, before the message with the id.
+func olderThan(messages []model.Message, id bson.ObjectID) []model.Message {
+	end, _ := slices.BinarySearchFunc(messages, id, func(message model.Message, id bson.ObjectID) int {
+		return compareIDs(message.ID, id)
+	})
+	return messages[:end]
+}
+
+func attachmentsOf(attachments []data.CreateAttachment) []model.Attachment {
+	if attachments == nil {
+		return nil
+	}
+
+	converted := make([]model.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		converted = append(converted, model.Attachment{Kind: a.Kind, Metadata: a.Metadata})
+	}
+	return converted
+}
+
+// find fetches the message of the tenant by id. The caller holds the lock.
+func (m Message) find(id string) (*model.Message, error) {
+	obID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	message, err := m.db.messages.get(obID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
+	}
+	if message == nil || message.TenantID != m.tenant {
+		return nil, fmt.Errorf("failed to fetch the message: %w", store.ErrMessageNotFound)
+	}
+
+	return message, nil
+}
+
+// save stores the message and returns the stored version of it. The caller holds the lock.
+func (m Message) save(message *model.Message) (*model.Message, error) {
+	saved, err := m.db.messages.put(message.ID.Hex(), *message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	return saved, nil
+}
+
+// messages returns the messages of the conversation that match, oldest first.
+// The caller holds the lock.
+func (m Message) messages(conversation *model.Conversation, match func(model.Message) bool) ([]model.Message, error) {
+	messages, err := m.db.messages.all()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	return slices.DeleteFunc(messages, func(message model.Message) bool {
+		return message.TenantID != m.tenant || message.ConversationID != conversation.ID || !match(message)
+	}), nil
+}
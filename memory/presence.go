@@ -0,0 +1,224 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+)
+
+const (
+	// typingTimeout is how long a participant is shown as typing after the last SetTyping.
+	typingTimeout = 6 * time.Second
+	// onlineTimeout is how long a participant is shown as online after the last Heartbeat.
+	onlineTimeout = time.Minute
+	// lastSeenRetention is how long the last seen time is kept after the last Heartbeat.
+	lastSeenRetention = 30 * 24 * time.Hour
+)
+
+type typingDocument struct {
+	model.Typing `bson:",inline"`
+	TenantID     string `bson:"tenant_id"`
+}
+
+type presenceDocument struct {
+	model.Presence `bson:",inline"`
+	TenantID       string    `bson:"tenant_id"`
+	ExpiresAt      time.Time `bson:"expires_at"`
+}
+
+type Presence struct {
+	db           *DB
+	conversation *Conversation
+	config
+}
+
+func NewPresence(db *DB, conversation *Conversation, opts ...Option) *Presence {
+	return &Presence{
+		db:           db,
+		conversation: conversation,
+		config:       newConfig(opts),
+	}
+}
+
+// SetTyping marks the participant as typing in the conversation for a few seconds.
+// Clients call it repeatedly while the participant keeps typing.
+func (p Presence) SetTyping(ctx context.Context, d data.SetTyping) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate set typing data: %w", err)
+	}
+
+	key, err := p.typingKey(d)
+	if err != nil {
+		return err
+	}
+
+	return p.db.update(func() error {
+		conversation, err := p.conversation.get(d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		if conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) == nil {
+			return store.ErrNotParticipant
+		}
+
+		_, err = p.db.typing.put(key, typingDocument{
+			Typing: model.Typing{
+				ConversationID: d.ConversationID,
+				ParticipantID:  d.Participant.ParticipantID,
+				Metadata:       d.Participant.Metadata,
+				ExpiresAt:      p.now().Add(typingTimeout),
+			},
+			TenantID: p.tenant,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set typing: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// StopTyping clears the typing indicator of the participant, e.g. once the message is sent.
+func (p Presence) StopTyping(ctx context.Context, d data.SetTyping) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate set typing data: %w", err)
+	}
+
+	key, err := p.typingKey(d)
+	if err != nil {
+		return err
+	}
+
+	return p.db.update(func() error {
+		delete(p.db.typing, key)
+		return nil
+	})
+}
+
+// Typing returns the participants currently typing in the conversation.
+func (p Presence) Typing(ctx context.Context, d data.LoadTyping) ([]model.Typing, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load typing data: %w", err)
+	}
+
+	// Expired indicators are dropped here rather than by a TTL monitor as in MongoDB.
+	now := p.now()
+	typing := []model.Typing{}
+	err := p.db.update(func() error {
+		for _, key := range slices.Sorted(maps.Keys(p.db.typing)) {
+			document, err := p.db.typing.get(key)
+			if err != nil {
+				return fmt.Errorf("failed to find typing participants: %w", err)
+			}
+			if !document.ExpiresAt.After(now) {
+				delete(p.db.typing, key)
+				continue
+			}
+			if document.TenantID == p.tenant && document.ConversationID == d.ConversationID {
+				typing = append(typing, document.Typing)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return typing, nil
+}
+
+// Heartbeat records that the participant is online now.
+// Clients call it periodically while connected.
+func (p Presence) Heartbeat(ctx context.Context, d data.Heartbeat) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate heartbeat data: %w", err)
+	}
+
+	key, err := p.presenceKey(d.Participant)
+	if err != nil {
+		return err
+	}
+
+	now := p.now()
+	return p.db.update(func() error {
+		_, err := p.db.presence.put(key, presenceDocument{
+			Presence: model.Presence{
+				ParticipantID: d.Participant.ParticipantID,
+				Metadata:      d.Participant.Metadata,
+				LastSeenAt:    now,
+			},
+			TenantID:  p.tenant,
+			ExpiresAt: now.Add(lastSeenRetention),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record heartbeat: %w", err)
+		}
+		return nil
+	})
+}
+
+// LastSeen returns the last seen time of the participants and whether they are online.
+// Participants that were never seen, or not within the retention period, are omitted.
+func (p Presence) LastSeen(ctx context.Context, d data.LoadPresence) ([]model.Presence, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load presence data: %w", err)
+	}
+
+	keys := make([]string, 0, len(d.Participants))
+	for _, participant := range d.Participants {
+		key, err := p.presenceKey(participant)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	now := p.now()
+	presence := []model.Presence{}
+	err := p.db.view(func() error {
+		for _, key := range keys {
+			document, err := p.db.presence.get(key)
+			if err != nil {
+				return fmt.Errorf("failed to find presence: %w", err)
+			}
+			if document == nil || !document.ExpiresAt.After(now) {
+				continue
+			}
+
+			document.Online = now.Sub(document.LastSeenAt) < onlineTimeout
+			presence = append(presence, document.Presence)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return presence, nil
+}
+
+// typingKey identifies the typing indicator of the participant in the conversation.
+func (p Presence) typingKey(d data.SetTyping) (string, error) {
+	key, err := chat.ParticipantKey(d.Participant.ParticipantID, d.Participant.Metadata)
+	if err != nil {
+		return "", err
+	}
+	return p.tenant + "\x00" + d.ConversationID + "\x00" + key, nil
+}
+
+// presenceKey identifies the last seen time of the participant.
+func (p Presence) presenceKey(participant data.ReadParticipant) (string, error) {
+	key, err := chat.ParticipantKey(participant.ParticipantID, participant.Metadata)
+	if err != nil {
+		return "", err
+	}
+	return p.tenant + "\x00" + key, nil
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Search runs a full-text query over the content and attachment file names of the messages
+// in the conversations the participant is a non-deleted member of, with the same scope as
+// repository.Message.Search.
+// Matching approximates MongoDB's text search, see chat.SearchTerms.
+// It returns the matches ordered by relevance along with their conversation, and the total
+// number of matches or an error.
+func (m Message) Search(ctx context.Context, d data.SearchMessages) ([]model.SearchResult, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate search messages data: %w", err)
+	}
+
+	include, exclude := chat.SearchTerms(d.Query)
+	reader := d.Participant
+
+	var results []model.SearchResult
+	err := m.db.view(func() error {
+		conversations, err := m.conversation.all(func(conversation model.Conversation) bool {
+			return conversation.ActiveParticipant(reader.ParticipantID, reader.Metadata) != nil
+		})
+		if err != nil {
+			return err
+		}
+
+		memberOf := make(map[bson.ObjectID]model.Conversation, len(conversations))
+		for _, conversation := range conversations {
+			memberOf[conversation.ID] = conversation
+		}
+
+		messages, err := m.db.messages.all()
+		if err != nil {
+			return fmt.Errorf("failed to search messages: %w", err)
+		}
+
+		for _, message := range messages {
+			conversation, ok := memberOf[message.ConversationID]
+			if !ok || message.TenantID != m.tenant || message.DeletedAt != nil || hiddenFor(message, reader) {
+				continue
+			}
+
+			participant := conversation.ActiveParticipant(reader.ParticipantID, reader.Metadata)
+			if !chat.InHistory(&conversation, participant, message.CreatedAt) {
+				continue
+			}
+
+			if score := chat.SearchScore(message, include, exclude); score > 0 {
+				results = append(results, model.SearchResult{Message: message, Conversation: conversation, Score: score})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	slices.SortStableFunc(results, func(a, b model.SearchResult) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return compareIDs(b.Message.ID, a.Message.ID)
+	})
+
+	return slices.Clone(paginate(results, d.Page, d.PerPage)), uint(len(results)), nil
+}
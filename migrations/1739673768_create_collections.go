@@ -9,6 +9,7 @@ import (
 )
 
 func Up1739673768(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	conversationsValidator := bson.M{
 		"$jsonSchema": bson.M{
 			"bsonType": "object",
@@ -77,11 +78,11 @@ func Up1739673768(ctx context.Context, db *mongo.Database) error {
 		},
 	}
 
-	if err := db.CreateCollection(ctx, "conversations", options.CreateCollection().SetValidator(conversationsValidator)); err != nil {
+	if err := db.CreateCollection(ctx, collections.Conversations, options.CreateCollection().SetValidator(conversationsValidator)); err != nil {
 		return err
 	}
 
-	db.Collection("conversations").Indexes().CreateOne(ctx, mongo.IndexModel{
+	db.Collection(collections.Conversations).Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "participants.participant_id", Value: 1},
 			{Key: "updated_at", Value: -1},
@@ -153,11 +154,11 @@ func Up1739673768(ctx context.Context, db *mongo.Database) error {
 		},
 	}
 
-	if err := db.CreateCollection(ctx, "messages", options.CreateCollection().SetValidator(messagesValidator)); err != nil {
+	if err := db.CreateCollection(ctx, collections.Messages, options.CreateCollection().SetValidator(messagesValidator)); err != nil {
 		return err
 	}
 
-	db.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+	db.Collection(collections.Messages).Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "conversation_id", Value: 1},
 			{Key: "created_at", Value: -1},
@@ -168,11 +169,12 @@ func Up1739673768(ctx context.Context, db *mongo.Database) error {
 }
 
 func Down1739673768(ctx context.Context, db *mongo.Database) error {
-	if err := db.Collection("messages").Drop(ctx); err != nil {
+	collections := collectionsFrom(ctx)
+	if err := db.Collection(collections.Messages).Drop(ctx); err != nil {
 		return err
 	}
 
-	if err := db.Collection("conversations").Drop(ctx); err != nil {
+	if err := db.Collection(collections.Conversations).Drop(ctx); err != nil {
 		return err
 	}
 
@@ -8,6 +8,7 @@ import (
 )
 
 func Up1770967803(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	// Add attachments to messages collection validator
 	messagesValidator := bson.M{
 		"$jsonSchema": bson.M{
@@ -98,7 +99,7 @@ func Up1770967803(ctx context.Context, db *mongo.Database) error {
 	}
 
 	err := db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "messages"},
+		{Key: "collMod", Value: collections.Messages},
 		{Key: "validator", Value: messagesValidator},
 	}).Err()
 	if err != nil {
@@ -198,12 +199,13 @@ func Up1770967803(ctx context.Context, db *mongo.Database) error {
 	}
 
 	return db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "conversations"},
+		{Key: "collMod", Value: collections.Conversations},
 		{Key: "validator", Value: conversationsValidator},
 	}).Err()
 }
 
 func Down1770967803(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	// Revert messages validator (remove attachments)
 	messagesValidator := bson.M{
 		"$jsonSchema": bson.M{
@@ -271,7 +273,7 @@ func Down1770967803(ctx context.Context, db *mongo.Database) error {
 	}
 
 	err := db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "messages"},
+		{Key: "collMod", Value: collections.Messages},
 		{Key: "validator", Value: messagesValidator},
 	}).Err()
 	if err != nil {
@@ -348,7 +350,7 @@ func Down1770967803(ctx context.Context, db *mongo.Database) error {
 	}
 
 	return db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "conversations"},
+		{Key: "collMod", Value: collections.Conversations},
 		{Key: "validator", Value: conversationsValidator},
 	}).Err()
 }
@@ -8,6 +8,7 @@ import (
 )
 
 func Up1774000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	// Remove enum constraint on message kind, replace with bsonType string
 	messagesValidator := bson.M{
 		"$jsonSchema": bson.M{
@@ -98,12 +99,13 @@ func Up1774000000(ctx context.Context, db *mongo.Database) error {
 	}
 
 	return db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "messages"},
+		{Key: "collMod", Value: collections.Messages},
 		{Key: "validator", Value: messagesValidator},
 	}).Err()
 }
 
 func Down1774000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	// Restore enum constraint on message kind
 	messagesValidator := bson.M{
 		"$jsonSchema": bson.M{
@@ -194,7 +196,7 @@ func Down1774000000(ctx context.Context, db *mongo.Database) error {
 	}
 
 	return db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "messages"},
+		{Key: "collMod", Value: collections.Messages},
 		{Key: "validator", Value: messagesValidator},
 	}).Err()
 }
@@ -8,6 +8,7 @@ import (
 )
 
 func Up1777000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	conversationsValidator := bson.M{
 		"$jsonSchema": bson.M{
 			"bsonType": "object",
@@ -112,12 +113,13 @@ func Up1777000000(ctx context.Context, db *mongo.Database) error {
 	}
 
 	return db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "conversations"},
+		{Key: "collMod", Value: collections.Conversations},
 		{Key: "validator", Value: conversationsValidator},
 	}).Err()
 }
 
 func Down1777000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
 	conversationsValidator := bson.M{
 		"$jsonSchema": bson.M{
 			"bsonType": "object",
@@ -210,7 +212,7 @@ func Down1777000000(ctx context.Context, db *mongo.Database) error {
 	}
 
 	return db.RunCommand(ctx, bson.D{
-		{Key: "collMod", Value: "conversations"},
+		{Key: "collMod", Value: collections.Conversations},
 		{Key: "validator", Value: conversationsValidator},
 	}).Err()
 }
@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Quoted message snapshots are refreshed by reply_to.message_id whenever the quoted message
+// is edited or deleted.
+func Up1778000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Messages).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "reply_to.message_id", Value: 1}},
+		Options: options.Index().SetName("reply_to_message_id").SetSparse(true),
+	})
+	return err
+}
+
+func Down1778000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	return db.Collection(collections.Messages).Indexes().DropOne(ctx, "reply_to_message_id")
+}
@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Typing indicators and presence are ephemeral. MongoDB removes the documents once their
+// expires_at has passed, so neither collection grows with inactive participants.
+func Up1779000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	if err := db.CreateCollection(ctx, collections.Typing); err != nil {
+		return err
+	}
+
+	_, err := db.Collection(collections.Typing).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "participant_key", Value: 1}},
+			Options: options.Index().SetName("conversation_participant").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.CreateCollection(ctx, collections.Presence); err != nil {
+		return err
+	}
+
+	_, err = db.Collection(collections.Presence).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "participant_key", Value: 1}},
+			Options: options.Index().SetName("participant").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func Down1779000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	if err := db.Collection(collections.Presence).Drop(ctx); err != nil {
+		return err
+	}
+
+	return db.Collection(collections.Typing).Drop(ctx)
+}
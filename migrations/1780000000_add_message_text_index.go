@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Message search matches the content and the file names of the attachments.
+// A collection can only have one text index, so every searchable field belongs to it.
+func Up1780000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Messages).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "content", Value: "text"},
+			{Key: "attachments.metadata.name", Value: "text"},
+		},
+		Options: options.Index().
+			SetName("message_text").
+			SetWeights(bson.D{{Key: "content", Value: 10}, {Key: "attachments.metadata.name", Value: 5}}),
+	})
+	return err
+}
+
+func Down1780000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	return db.Collection(collections.Messages).Indexes().DropOne(ctx, "message_text")
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Participants added before roles existed become members.
+func Up1781000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).UpdateMany(ctx,
+		bson.M{"participants": bson.M{"$elemMatch": bson.M{"role": bson.M{"$exists": false}}}},
+		bson.M{"$set": bson.M{"participants.$[participant].role": "member"}},
+		options.UpdateMany().SetArrayFilters([]any{bson.M{"participant.role": bson.M{"$exists": false}}}),
+	)
+	return err
+}
+
+func Down1781000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).UpdateMany(ctx,
+		bson.M{"participants.role": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"participants.$[].role": ""}},
+	)
+	return err
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Cursor pagination sorts the participant's conversations by updated_at with _id as tie-breaker.
+func Up1782000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "participants.participant_id", Value: 1},
+			{Key: "updated_at", Value: -1},
+			{Key: "_id", Value: -1},
+		},
+		Options: options.Index().SetName("participant_updated_at_id"),
+	})
+	return err
+}
+
+func Down1782000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	return db.Collection(collections.Conversations).Indexes().DropOne(ctx, "participant_updated_at_id")
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// The inbox counts the unread messages of a conversation after the read cursor, and message
+// windows walk the timeline by _id in both directions.
+func Up1783000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Messages).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "conversation_id", Value: 1},
+			{Key: "_id", Value: 1},
+		},
+		Options: options.Index().SetName("conversation_id_id"),
+	})
+	return err
+}
+
+func Down1783000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	return db.Collection(collections.Messages).Indexes().DropOne(ctx, "conversation_id_id")
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Participants added before membership periods existed get a single period starting when the
+// conversation was created and ending when they were deleted, if they were.
+func Up1784000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).UpdateMany(ctx,
+		bson.M{"participants": bson.M{"$elemMatch": bson.M{"memberships": bson.M{"$exists": false}}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"participants": bson.M{"$map": bson.M{
+				"input": "$participants",
+				"as":    "p",
+				"in": bson.M{"$cond": []any{
+					bson.M{"$ifNull": []any{"$$p.memberships", false}},
+					"$$p",
+					bson.M{"$mergeObjects": []any{"$$p", bson.M{"memberships": []bson.M{{
+						"joined_at": "$created_at",
+						"left_at":   bson.M{"$ifNull": []any{"$$p.deleted_at", nil}},
+					}}}}},
+				}},
+			}},
+		}}}},
+	)
+	return err
+}
+
+func Down1784000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).UpdateMany(ctx,
+		bson.M{"participants.memberships": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"participants.$[].memberships": ""}},
+	)
+	return err
+}
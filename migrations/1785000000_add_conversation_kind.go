@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Conversations created before kinds existed were deduplicated by participants, which makes
+// them direct conversations.
+func Up1785000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).UpdateMany(ctx,
+		bson.M{"kind": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"kind": "direct"}},
+	)
+	return err
+}
+
+func Down1785000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	_, err := db.Collection(collections.Conversations).UpdateMany(ctx,
+		bson.M{"kind": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"kind": "", "title": ""}},
+	)
+	return err
+}
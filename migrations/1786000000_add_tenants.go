@@ -0,0 +1,169 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Existing documents belong to the default tenant. Every query is constrained to a tenant,
+// so the indexes are replaced by tenant-prefixed ones, and participants are unique per tenant.
+func Up1786000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	for _, name := range []string{collections.Conversations, collections.Messages, collections.Typing, collections.Presence} {
+		_, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{"tenant_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"tenant_id": ""}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := db.Collection(collections.Conversations).Indexes().DropOne(ctx, "participant_updated_at_id"); err != nil {
+		return err
+	}
+	_, err := db.Collection(collections.Conversations).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "tenant_id", Value: 1},
+			{Key: "participants.participant_id", Value: 1},
+			{Key: "updated_at", Value: -1},
+			{Key: "_id", Value: -1},
+		},
+		Options: options.Index().SetName("tenant_participant_updated_at_id"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Collection(collections.Messages).Indexes().DropOne(ctx, "conversation_id_id"); err != nil {
+		return err
+	}
+	// A collection can only have one text index, and a prefixed text index requires every
+	// text search to match the prefix, which tenant scoped searches always do.
+	if err := db.Collection(collections.Messages).Indexes().DropOne(ctx, "message_text"); err != nil {
+		return err
+	}
+	_, err = db.Collection(collections.Messages).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "tenant_id", Value: 1},
+				{Key: "conversation_id", Value: 1},
+				{Key: "_id", Value: 1},
+			},
+			Options: options.Index().SetName("tenant_conversation_id_id"),
+		},
+		{
+			Keys: bson.D{
+				{Key: "tenant_id", Value: 1},
+				{Key: "content", Value: "text"},
+				{Key: "attachments.metadata.name", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("tenant_message_text").
+				SetWeights(bson.D{{Key: "content", Value: 10}, {Key: "attachments.metadata.name", Value: 5}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Collection(collections.Typing).Indexes().DropOne(ctx, "conversation_participant"); err != nil {
+		return err
+	}
+	_, err = db.Collection(collections.Typing).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "conversation_id", Value: 1}, {Key: "participant_key", Value: 1}},
+		Options: options.Index().SetName("tenant_conversation_participant").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Collection(collections.Presence).Indexes().DropOne(ctx, "participant"); err != nil {
+		return err
+	}
+	_, err = db.Collection(collections.Presence).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tenant_id", Value: 1}, {Key: "participant_key", Value: 1}},
+		Options: options.Index().SetName("tenant_participant").SetUnique(true),
+	})
+	return err
+}
+
+func Down1786000000(ctx context.Context, db *mongo.Database) error {
+	collections := collectionsFrom(ctx)
+	if err := db.Collection(collections.Presence).Indexes().DropOne(ctx, "tenant_participant"); err != nil {
+		return err
+	}
+	_, err := db.Collection(collections.Presence).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "participant_key", Value: 1}},
+		Options: options.Index().SetName("participant").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Collection(collections.Typing).Indexes().DropOne(ctx, "tenant_conversation_participant"); err != nil {
+		return err
+	}
+	_, err = db.Collection(collections.Typing).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "participant_key", Value: 1}},
+		Options: options.Index().SetName("conversation_participant").SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Collection(collections.Messages).Indexes().DropOne(ctx, "tenant_message_text"); err != nil {
+		return err
+	}
+	if err := db.Collection(collections.Messages).Indexes().DropOne(ctx, "tenant_conversation_id_id"); err != nil {
+		return err
+	}
+	_, err = db.Collection(collections.Messages).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "conversation_id", Value: 1},
+				{Key: "_id", Value: 1},
+			},
+			Options: options.Index().SetName("conversation_id_id"),
+		},
+		{
+			Keys: bson.D{
+				{Key: "content", Value: "text"},
+				{Key: "attachments.metadata.name", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("message_text").
+				SetWeights(bson.D{{Key: "content", Value: 10}, {Key: "attachments.metadata.name", Value: 5}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := db.Collection(collections.Conversations).Indexes().DropOne(ctx, "tenant_participant_updated_at_id"); err != nil {
+		return err
+	}
+	_, err = db.Collection(collections.Conversations).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "participants.participant_id", Value: 1},
+			{Key: "updated_at", Value: -1},
+			{Key: "_id", Value: -1},
+		},
+		Options: options.Index().SetName("participant_updated_at_id"),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{collections.Conversations, collections.Messages, collections.Typing, collections.Presence} {
+		_, err := db.Collection(name).UpdateMany(ctx, bson.M{}, bson.M{"$unset": bson.M{"tenant_id": ""}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -8,26 +8,100 @@ import (
 	"slices"
 	"sort"
 
+	"github.com/davesavic/chatsavvy/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
+// Migration changes the schema in its Up function and reverts the change in its Down function.
+// The functions find the names of the collections to change in their context, see Run.
 type Migration struct {
 	Timestamp int64
 	Up        func(ctx context.Context, db *mongo.Database) error
 	Down      func(ctx context.Context, db *mongo.Database) error
 }
 
+// MigrationCollection is the default name of the collection recording the applied migrations.
+//
+// Deprecated: Use WithCollections, which takes precedence over MigrationCollection.
 var MigrationCollection = "migrations"
 
+type collectionsKey struct{}
+
+// collectionsFrom returns the collection names of the migration run the context belongs to,
+// the default names outside of one.
+func collectionsFrom(ctx context.Context) model.Collections {
+	if collections, ok := ctx.Value(collectionsKey{}).(model.Collections); ok {
+		return collections
+	}
+	return defaultCollections()
+}
+
+func defaultCollections() model.Collections {
+	collections := model.DefaultCollections()
+	collections.Migrations = MigrationCollection
+	return collections
+}
+
+// Option configures a migration run.
+type Option func(*config)
+
+type config struct {
+	database    string
+	collections model.Collections
+	logger      *slog.Logger
+}
+
+// WithDatabase replaces the default chatsavvy database the migrations run against.
+func WithDatabase(name string) Option {
+	return func(c *config) {
+		c.database = name
+	}
+}
+
+// WithCollections replaces the default collection names, including the collection
+// recording the applied migrations.
+func WithCollections(collections model.Collections) Option {
+	return func(c *config) {
+		c.collections = collections
+	}
+}
+
+// WithLogger replaces slog.Default as the logger of the migration run.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
 var Migrations = []Migration{
 	{Timestamp: 1739673768, Up: Up1739673768, Down: Down1739673768},
 	{Timestamp: 1770967803, Up: Up1770967803, Down: Down1770967803},
 	{Timestamp: 1774000000, Up: Up1774000000, Down: Down1774000000},
 	{Timestamp: 1777000000, Up: Up1777000000, Down: Down1777000000},
+	{Timestamp: 1778000000, Up: Up1778000000, Down: Down1778000000},
+	{Timestamp: 1779000000, Up: Up1779000000, Down: Down1779000000},
+	{Timestamp: 1780000000, Up: Up1780000000, Down: Down1780000000},
+	{Timestamp: 1781000000, Up: Up1781000000, Down: Down1781000000},
+	{Timestamp: 1782000000, Up: Up1782000000, Down: Down1782000000},
+	{Timestamp: 1783000000, Up: Up1783000000, Down: Down1783000000},
+	{Timestamp: 1784000000, Up: Up1784000000, Down: Down1784000000},
+	{Timestamp: 1785000000, Up: Up1785000000, Down: Down1785000000},
+	{Timestamp: 1786000000, Up: Up1786000000, Down: Down1786000000},
 }
 
-func Run(client *mongo.Client, direction string) error {
+// Run applies the migrations in the direction (up or down) that are not applied yet, or
+// reverts the applied ones. It stops at the first migration that fails and returns its error.
+func Run(client *mongo.Client, direction string, opts ...Option) error {
+	cfg := config{
+		database:    "chatsavvy",
+		collections: defaultCollections(),
+		logger:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	if direction != "up" && direction != "down" {
 		return fmt.Errorf("invalid direction: %s. Please provide either 'up' or 'down'.", direction)
 	}
@@ -42,56 +116,58 @@ func Run(client *mongo.Client, direction string) error {
 		}
 	})
 
-	db := client.Database("chatsavvy")
+	db := client.Database(cfg.database)
+	migrationCollection, logger := cfg.collections.Migrations, cfg.logger
+	ctx := context.WithValue(context.Background(), collectionsKey{}, cfg.collections)
 
-	appliedMigrations, err := getAppliedMigrations(context.Background(), db)
+	appliedMigrations, err := getAppliedMigrations(ctx, db, migrationCollection, logger)
 	if err != nil {
 		return err
 	}
 
 	for _, mg := range mgs {
 		if direction == "up" && appliedMigrations[mg.Timestamp] {
-			slog.Info("Migration already applied", "timestamp", mg.Timestamp)
+			logger.Info("Migration already applied", "timestamp", mg.Timestamp)
 			continue
 		}
 
 		if direction == "down" && !appliedMigrations[mg.Timestamp] {
-			slog.Info("Migration not applied", "timestamp", mg.Timestamp)
+			logger.Info("Migration not applied", "timestamp", mg.Timestamp)
 			continue
 		}
 
 		var err error
 		if direction == "up" {
-			err = mg.Up(context.Background(), db)
+			err = mg.Up(ctx, db)
 		} else {
-			err = mg.Down(context.Background(), db)
+			err = mg.Down(ctx, db)
 		}
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to run migration %d: %w", mg.Timestamp, err)
 		}
 
 		if direction == "up" {
-			_, err = db.Collection(MigrationCollection).InsertOne(context.Background(), bson.M{"timestamp": mg.Timestamp})
+			_, err = db.Collection(migrationCollection).InsertOne(ctx, bson.M{"timestamp": mg.Timestamp})
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("failed to record migration %d: %w", mg.Timestamp, err)
 			}
 
-			slog.Info("Migration applied", "timestamp", mg.Timestamp)
+			logger.Info("Migration applied", "timestamp", mg.Timestamp)
 		}
 
 		if direction == "down" {
-			_, err = db.Collection(MigrationCollection).DeleteOne(context.Background(), bson.M{"timestamp": mg.Timestamp})
+			_, err = db.Collection(migrationCollection).DeleteOne(ctx, bson.M{"timestamp": mg.Timestamp})
 			if err != nil {
-				panic(err)
+				return fmt.Errorf("failed to record migration %d: %w", mg.Timestamp, err)
 			}
 
-			slog.Info("Migration reverted", "timestamp", mg.Timestamp)
+			logger.Info("Migration reverted", "timestamp", mg.Timestamp)
 		}
 	}
 	return nil
 }
 
-func getAppliedMigrations(ctx context.Context, db *mongo.Database) (map[int64]bool, error) {
+func getAppliedMigrations(ctx context.Context, db *mongo.Database, migrationCollection string, logger *slog.Logger) (map[int64]bool, error) {
 	appliedMigrations := make(map[int64]bool)
 
 	collections, err := db.ListCollectionNames(ctx, bson.M{})
@@ -99,18 +175,18 @@ func getAppliedMigrations(ctx context.Context, db *mongo.Database) (map[int64]bo
 		return nil, err
 	}
 
-	collectionExists := slices.Contains(collections, MigrationCollection)
+	collectionExists := slices.Contains(collections, migrationCollection)
 	if !collectionExists {
-		err = db.CreateCollection(ctx, MigrationCollection)
+		err = db.CreateCollection(ctx, migrationCollection)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	cursor, err := db.Collection(MigrationCollection).Find(ctx, bson.M{})
+	cursor, err := db.Collection(migrationCollection).Find(ctx, bson.M{})
 	if err != nil {
 		if errors.Is(err, mongo.ErrNilDocument) {
-			slog.Info("No migrations found")
+			logger.Info("No migrations found")
 			return appliedMigrations, nil
 		}
 
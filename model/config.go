@@ -0,0 +1,40 @@
+package model
+
+// Collections names the collections the documents are stored in.
+type Collections struct {
+	Conversations string
+	Messages      string
+	Typing        string
+	Presence      string
+	Migrations    string
+}
+
+// DefaultCollections returns the default collection names.
+func DefaultCollections() Collections {
+	return Collections{
+		Conversations: "conversations",
+		Messages:      "messages",
+		Typing:        "typing",
+		Presence:      "presence",
+		Migrations:    "migrations",
+	}
+}
+
+// Limits bounds the size of conversations and messages. A zero limit is not enforced.
+type Limits struct {
+	// MaxParticipants is the maximum number of non-deleted participants in a conversation.
+	MaxParticipants int
+	// MaxContentLength is the maximum number of characters of a message's content.
+	MaxContentLength int
+	// MaxAttachments is the maximum number of attachments of a message.
+	MaxAttachments int
+}
+
+// DefaultLimits returns the default limits.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxParticipants:  10,
+		MaxContentLength: 5000,
+		MaxAttachments:   10,
+	}
+}
@@ -6,11 +6,94 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+// ConversationKind tells direct conversations, identified by their participants, from groups.
+type ConversationKind string
+
+const (
+	// ConversationKindDirect conversations are unique per set of participants. It is the default.
+	ConversationKindDirect ConversationKind = "direct"
+	// ConversationKindGroup conversations have a title, and the same participants may share
+	// any number of groups.
+	ConversationKindGroup ConversationKind = "group"
+)
+
+// HistoryVisibility controls which messages participants see depending on when they joined.
+type HistoryVisibility string
+
+const (
+	// HistoryVisibilityShared lets participants see the messages sent before they joined.
+	// It is the default.
+	HistoryVisibilityShared HistoryVisibility = "shared"
+	// HistoryVisibilityJoined limits participants to the messages sent while they were members.
+	HistoryVisibilityJoined HistoryVisibility = "joined"
+)
+
 type Conversation struct {
-	ID           bson.ObjectID  `bson:"_id"`
-	Participants []Participant  `bson:"participants"`
-	Metadata     map[string]any `bson:"metadata"`
-	LastMessage  *Message       `bson:"last_message"`
-	CreatedAt    time.Time      `bson:"created_at"`
-	UpdatedAt    time.Time      `bson:"updated_at"`
+	ID                bson.ObjectID     `bson:"_id" json:"id"`
+	TenantID          string            `bson:"tenant_id" json:"tenant_id,omitempty"`
+	Kind              ConversationKind  `bson:"kind" json:"kind"`
+	Title             string            `bson:"title,omitempty" json:"title,omitempty"`
+	Participants      []Participant     `bson:"participants" json:"participants"`
+	Metadata          map[string]any    `bson:"metadata" json:"metadata"`
+	HistoryVisibility HistoryVisibility `bson:"history_visibility" json:"history_visibility"`
+	LastMessage       *Message          `bson:"last_message" json:"last_message"`
+	CreatedAt         time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+// ActiveParticipant returns the non-deleted participant matching the id and metadata, or nil.
+func (c *Conversation) ActiveParticipant(participantID string, metadata map[string]any) *Participant {
+	for i, p := range c.Participants {
+		if p.DeletedAt != nil {
+			continue
+		}
+		if p.ParticipantID == participantID && MetadataEqual(p.Metadata, metadata) {
+			return &c.Participants[i]
+		}
+	}
+	return nil
+}
+
+// Participant returns the participant matching the id and metadata, deleted or not, or nil.
+// A non-deleted match is preferred.
+func (c *Conversation) Participant(participantID string, metadata map[string]any) *Participant {
+	if p := c.ActiveParticipant(participantID, metadata); p != nil {
+		return p
+	}
+	var found *Participant
+	for i, p := range c.Participants {
+		if p.ParticipantID == participantID && MetadataEqual(p.Metadata, metadata) {
+			found = &c.Participants[i]
+		}
+	}
+	return found
+}
+
+// ConversationPage is a page of conversations loaded with a cursor.
+// NextCursor loads the following page and is empty when HasMore is false.
+// Total is only set when counting was requested.
+type ConversationPage struct {
+	Conversations []Conversation `json:"conversations"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	HasMore       bool           `json:"has_more"`
+	Total         *uint          `json:"total,omitempty"`
+}
+
+// InboxEntry is a conversation as seen from the inbox of one of its participants.
+// Participant holds the participant's own read cursor.
+type InboxEntry struct {
+	Conversation Conversation `json:"conversation"`
+	Participant  Participant  `json:"participant"`
+	UnreadCount  uint         `json:"unread_count"`
+}
+
+// Inbox is a page of a participant's inbox loaded with a cursor, see ConversationPage.
+// UnreadMessages and UnreadConversations are the badge counts over the whole inbox,
+// regardless of the page and of the unread only filter.
+type Inbox struct {
+	Entries             []InboxEntry `json:"entries"`
+	NextCursor          string       `json:"next_cursor,omitempty"`
+	HasMore             bool         `json:"has_more"`
+	UnreadMessages      uint         `json:"unread_messages"`
+	UnreadConversations uint         `json:"unread_conversations"`
 }
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type EventKind string
+
+const (
+	EventKindMessageCreated     EventKind = "message.created"
+	EventKindReactionToggled    EventKind = "reaction.toggled"
+	EventKindParticipantAdded   EventKind = "participant.added"
+	EventKindParticipantRemoved EventKind = "participant.removed"
+	EventKindReadAdvanced       EventKind = "read.advanced"
+)
+
+// Event is a change to a conversation or one of its messages.
+// Message is set for message and reaction events, Participant for participant and read events.
+// ResumeToken identifies the change the event was derived from. It is only set on the last
+// event of a change, as resuming after it skips every event of the change.
+type Event struct {
+	Kind           EventKind    `json:"kind"`
+	ConversationID string       `json:"conversation_id"`
+	Message        *Message     `json:"message,omitempty"`
+	Participant    *Participant `json:"participant,omitempty"`
+	OccurredAt     time.Time    `json:"occurred_at"`
+	ResumeToken    bson.Raw     `json:"-"`
+}
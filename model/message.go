@@ -6,33 +6,89 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+// MessageKindSystem is the kind of messages posted by the application rather than by a
+// participant, e.g. "Alice joined the conversation". Their sender does not have to be a participant.
+const MessageKindSystem = "system"
+
 type MessageSender struct {
-	ParticipantID string         `bson:"participant_id"`
-	Metadata      map[string]any `bson:"metadata"`
+	ParticipantID string         `bson:"participant_id" json:"participant_id"`
+	Metadata      map[string]any `bson:"metadata" json:"metadata"`
 }
 
 type Attachment struct {
-	Kind     string         `bson:"kind"`
-	Metadata map[string]any `bson:"metadata"`
+	Kind     string         `bson:"kind" json:"kind"`
+	Metadata map[string]any `bson:"metadata" json:"metadata"`
 }
 
 type Message struct {
-	ID             bson.ObjectID `bson:"_id"`
-	ConversationID bson.ObjectID `bson:"conversation_id"`
-	Sender         MessageSender `bson:"sender"`
-	Kind           string        `bson:"kind"`
-	Content        string        `bson:"content,omitempty"`
-	Attachments    []Attachment  `bson:"attachments"`
-	Reactions      []Reaction    `bson:"reactions"`
-	CreatedAt      time.Time     `bson:"created_at"`
+	ID             bson.ObjectID       `bson:"_id" json:"id"`
+	TenantID       string              `bson:"tenant_id" json:"tenant_id,omitempty"`
+	ConversationID bson.ObjectID       `bson:"conversation_id" json:"conversation_id"`
+	Sender         MessageSender       `bson:"sender" json:"sender"`
+	Kind           string              `bson:"kind" json:"kind"`
+	Content        string              `bson:"content,omitempty" json:"content,omitempty"`
+	Attachments    []Attachment        `bson:"attachments" json:"attachments"`
+	Reactions      []Reaction          `bson:"reactions" json:"reactions"`
+	ParentID       *bson.ObjectID      `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	ReplyCount     uint                `bson:"reply_count,omitempty" json:"reply_count,omitempty"`
+	LastReplyAt    *time.Time          `bson:"last_reply_at,omitempty" json:"last_reply_at,omitempty"`
+	ReplyTo        *Quote              `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	Revisions      []Revision          `bson:"revisions,omitempty" json:"revisions,omitempty"`
+	HiddenFor      []HiddenParticipant `bson:"hidden_for,omitempty" json:"-"`
+	PinnedAt       *time.Time          `bson:"pinned_at,omitempty" json:"pinned_at,omitempty"`
+	PinnedBy       *MessageSender      `bson:"pinned_by,omitempty" json:"pinned_by,omitempty"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	EditedAt       *time.Time          `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	DeletedAt      *time.Time          `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// Revision is a previous version of an edited message.
+// EditedBy and EditedAt describe the edit that replaced it.
+type Revision struct {
+	Content     string        `bson:"content,omitempty" json:"content,omitempty"`
+	Attachments []Attachment  `bson:"attachments" json:"attachments"`
+	EditedBy    MessageSender `bson:"edited_by" json:"edited_by"`
+	EditedAt    time.Time     `bson:"edited_at" json:"edited_at"`
 }
 
 type ReactionParticipant struct {
-	ParticipantID string         `bson:"participant_id"`
-	Metadata      map[string]any `bson:"metadata"`
+	ParticipantID string         `bson:"participant_id" json:"participant_id"`
+	Metadata      map[string]any `bson:"metadata" json:"metadata"`
 }
 
 type Reaction struct {
-	Emoji        string                `bson:"emoji"`
-	Participants []ReactionParticipant `bson:"participants"`
+	Emoji        string                `bson:"emoji" json:"emoji"`
+	Participants []ReactionParticipant `bson:"participants" json:"participants"`
+}
+
+// Quote is a snapshot of the message being replied to.
+// It is kept in sync when the quoted message is edited or deleted.
+type Quote struct {
+	MessageID      bson.ObjectID `bson:"message_id" json:"message_id"`
+	Sender         MessageSender `bson:"sender" json:"sender"`
+	Excerpt        string        `bson:"excerpt,omitempty" json:"excerpt,omitempty"`
+	AttachmentKind string        `bson:"attachment_kind,omitempty" json:"attachment_kind,omitempty"`
+	DeletedAt      *time.Time    `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+}
+
+// HiddenParticipant is a participant that deleted the message for themselves only.
+type HiddenParticipant struct {
+	ParticipantID string         `bson:"participant_id" json:"participant_id"`
+	Metadata      map[string]any `bson:"metadata" json:"metadata"`
+}
+
+// MessageWindow is a slice of a conversation's timeline, newest message first.
+// HasOlder and HasNewer tell whether there are messages before and after the window.
+type MessageWindow struct {
+	Messages []Message `json:"messages"`
+	HasOlder bool      `json:"has_older"`
+	HasNewer bool      `json:"has_newer"`
+}
+
+// SearchResult is a message matching a search query along with its conversation.
+// Score is the relevance of the match, higher is more relevant.
+type SearchResult struct {
+	Message      Message      `json:"message"`
+	Conversation Conversation `json:"conversation"`
+	Score        float64      `json:"score"`
 }
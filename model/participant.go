@@ -7,9 +7,65 @@ import (
 )
 
 type Participant struct {
-	ParticipantID     string         `bson:"participant_id"`
-	Metadata          map[string]any `bson:"metadata"`
-	DeletedAt         *time.Time     `bson:"deleted_at"`
-	LastReadMessageID *bson.ObjectID `bson:"last_read_message_id"`
-	LastReadAt        *time.Time     `bson:"last_read_at"`
+	ParticipantID     string         `bson:"participant_id" json:"participant_id"`
+	Metadata          map[string]any `bson:"metadata" json:"metadata"`
+	Role              Role           `bson:"role" json:"role"`
+	DeletedAt         *time.Time     `bson:"deleted_at" json:"deleted_at"`
+	LastReadMessageID *bson.ObjectID `bson:"last_read_message_id" json:"last_read_message_id"`
+	LastReadAt        *time.Time     `bson:"last_read_at" json:"last_read_at"`
+	Memberships       []Membership   `bson:"memberships" json:"memberships"`
+	PinnedAt          *time.Time     `bson:"pinned_at,omitempty" json:"pinned_at,omitempty"`
+	ArchivedAt        *time.Time     `bson:"archived_at,omitempty" json:"archived_at,omitempty"`
+	MutedAt           *time.Time     `bson:"muted_at,omitempty" json:"muted_at,omitempty"`
+	MutedUntil        *time.Time     `bson:"muted_until,omitempty" json:"muted_until,omitempty"`
+}
+
+// Muted reports whether the participant has muted the conversation at the given time.
+// A mute without an end time lasts until the participant unmutes.
+func (p Participant) Muted(now time.Time) bool {
+	return p.MutedAt != nil && (p.MutedUntil == nil || now.Before(*p.MutedUntil))
+}
+
+// Membership is a period during which the participant was part of the conversation.
+// LeftAt is nil for the current period of a non-deleted participant.
+type Membership struct {
+	JoinedAt time.Time  `bson:"joined_at" json:"joined_at"`
+	LeftAt   *time.Time `bson:"left_at" json:"left_at"`
+}
+
+// MetadataEqual reports whether two participant metadata maps hold the same keys and values.
+// A nil map equals an empty map.
+func MetadataEqual(a, b map[string]any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, va := range a {
+		vb, ok := b[key]
+		if !ok || !valuesEqual(va, vb) {
+			return false
+		}
+	}
+	return true
+}
+
+func valuesEqual(a, b any) bool {
+	switch va := a.(type) {
+	case int:
+		vb, ok := b.(int)
+		return ok && va == vb
+	case float64:
+		vb, ok := b.(float64)
+		return ok && va == vb
+	case string:
+		vb, ok := b.(string)
+		return ok && va == vb
+	case bool:
+		vb, ok := b.(bool)
+		return ok && va == vb
+	case map[string]any:
+		vb, ok := b.(map[string]any)
+		return ok && MetadataEqual(va, vb)
+	default:
+		return false
+	}
 }
@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Typing is a participant currently typing in a conversation.
+type Typing struct {
+	ConversationID string         `bson:"conversation_id" json:"conversation_id"`
+	ParticipantID  string         `bson:"participant_id" json:"participant_id"`
+	Metadata       map[string]any `bson:"metadata" json:"metadata"`
+	ExpiresAt      time.Time      `bson:"expires_at" json:"expires_at"`
+}
+
+// Presence is the last time a participant was seen.
+// Online is derived from LastSeenAt when the presence is loaded and is not stored.
+type Presence struct {
+	ParticipantID string         `bson:"participant_id" json:"participant_id"`
+	Metadata      map[string]any `bson:"metadata" json:"metadata"`
+	LastSeenAt    time.Time      `bson:"last_seen_at" json:"last_seen_at"`
+	Online        bool           `bson:"-" json:"online"`
+}
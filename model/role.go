@@ -0,0 +1,61 @@
+package model
+
+import "slices"
+
+// Role is the role of a participant in a conversation.
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// Permission is an action on a conversation that is granted per role.
+type Permission string
+
+const (
+	PermissionAddParticipant    Permission = "add_participant"
+	PermissionRemoveParticipant Permission = "remove_participant"
+	PermissionPostMessage       Permission = "post_message"
+	PermissionUpdateMetadata    Permission = "update_metadata"
+	PermissionPinMessage        Permission = "pin_message"
+	PermissionManageRoles       Permission = "manage_roles"
+)
+
+// Permissions is the permission matrix, the permissions granted to each role.
+type Permissions map[Role][]Permission
+
+// DefaultPermissions returns the default permission matrix.
+// Owners may do anything, admins may do anything but manage roles and members may only post.
+func DefaultPermissions() Permissions {
+	return Permissions{
+		RoleOwner: {
+			PermissionAddParticipant,
+			PermissionRemoveParticipant,
+			PermissionPostMessage,
+			PermissionUpdateMetadata,
+			PermissionPinMessage,
+			PermissionManageRoles,
+		},
+		RoleAdmin: {
+			PermissionAddParticipant,
+			PermissionRemoveParticipant,
+			PermissionPostMessage,
+			PermissionUpdateMetadata,
+			PermissionPinMessage,
+		},
+		RoleMember: {
+			PermissionPostMessage,
+		},
+	}
+}
+
+// Allows reports whether the role is granted the permission.
+// Participants stored without a role are members.
+func (p Permissions) Allows(role Role, permission Permission) bool {
+	if role == "" {
+		role = RoleMember
+	}
+	return slices.Contains(p[role], permission)
+}
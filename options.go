@@ -0,0 +1,136 @@
+package chatsavvy
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/davesavic/chatsavvy/memory"
+	"github.com/davesavic/chatsavvy/migrations"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/sqlstore"
+	sqlmigrations "github.com/davesavic/chatsavvy/sqlstore/migrations"
+)
+
+// Option configures ChatSavvy and its migrations.
+type Option func(*options)
+
+type options struct {
+	database    string
+	collections *model.Collections
+	limits      model.Limits
+	permissions model.Permissions
+	now         func() time.Time
+	logger      *slog.Logger
+	tenant      string
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		database:    "chatsavvy",
+		limits:      model.DefaultLimits(),
+		permissions: model.DefaultPermissions(),
+		now:         time.Now,
+		logger:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithDatabase replaces the default chatsavvy database.
+func WithDatabase(name string) Option {
+	return func(o *options) {
+		o.database = name
+	}
+}
+
+// WithCollections replaces the default collection names.
+// Migrate must be given the same names as New.
+func WithCollections(collections model.Collections) Option {
+	return func(o *options) {
+		o.collections = &collections
+	}
+}
+
+// WithLimits replaces the default limits on conversations and messages.
+func WithLimits(limits model.Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// WithPermissions replaces the default permission matrix checked for actors.
+func WithPermissions(permissions model.Permissions) Option {
+	return func(o *options) {
+		o.permissions = permissions
+	}
+}
+
+// WithClock replaces time.Now as the source of the timestamps written by the repositories.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithLogger replaces slog.Default as the logger of the repositories and migrations.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+func (o options) repository() []repository.Option {
+	opts := []repository.Option{
+		repository.WithLimits(o.limits),
+		repository.WithPermissions(o.permissions),
+		repository.WithClock(o.now),
+		repository.WithLogger(o.logger),
+		repository.WithTenant(o.tenant),
+	}
+	if o.collections != nil {
+		opts = append(opts, repository.WithCollections(*o.collections))
+	}
+	return opts
+}
+
+func (o options) memory() []memory.Option {
+	return []memory.Option{
+		memory.WithLimits(o.limits),
+		memory.WithPermissions(o.permissions),
+		memory.WithClock(o.now),
+		memory.WithLogger(o.logger),
+		memory.WithTenant(o.tenant),
+	}
+}
+
+func (o options) sql() []sqlstore.Option {
+	return []sqlstore.Option{
+		sqlstore.WithLimits(o.limits),
+		sqlstore.WithPermissions(o.permissions),
+		sqlstore.WithClock(o.now),
+		sqlstore.WithLogger(o.logger),
+		sqlstore.WithTenant(o.tenant),
+	}
+}
+
+// migrations leaves the collection names to the migrations unless they were set, so that the
+// deprecated migrations.MigrationCollection still applies.
+func (o options) migrations() []migrations.Option {
+	opts := []migrations.Option{
+		migrations.WithDatabase(o.database),
+		migrations.WithLogger(o.logger),
+	}
+	if o.collections != nil {
+		opts = append(opts, migrations.WithCollections(*o.collections))
+	}
+	return opts
+}
+
+func (o options) sqlMigrations() []sqlmigrations.Option {
+	return []sqlmigrations.Option{
+		sqlmigrations.WithLogger(o.logger),
+	}
+}
@@ -0,0 +1,26 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/store/storetest"
+	"github.com/davesavic/chatsavvy/testutil"
+)
+
+func TestConformance(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	db := client.Database("chatsavvy")
+	storetest.Run(t, func(t *testing.T, now func() time.Time) storetest.Stores {
+		cr := repository.NewConversation(db, repository.WithClock(now))
+		return storetest.Stores{
+			Conversations: cr,
+			Messages:      repository.NewMessage(db, cr, repository.WithClock(now)),
+			Presence:      repository.NewPresence(db, cr, repository.WithClock(now)),
+		}
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/chat"
 	"github.com/davesavic/chatsavvy/model"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -14,10 +16,21 @@ import (
 
 type Conversation struct {
 	db *mongo.Database
+	config
 }
 
-func NewConversation(db *mongo.Database) *Conversation {
-	return &Conversation{db: db}
+func NewConversation(db *mongo.Database, opts ...Option) *Conversation {
+	return &Conversation{db: db, config: newConfig(opts)}
+}
+
+// Collections returns the collection names the repository reads and writes.
+func (c Conversation) Collections() model.Collections {
+	return c.collections
+}
+
+// Tenant returns the tenant the repository is scoped to. The default tenant is empty.
+func (c Conversation) Tenant() string {
+	return c.tenant
 }
 
 func (c Conversation) ParticipantExists(ctx context.Context, conversationID string, d data.ParticipantExists) (bool, error) {
@@ -44,7 +57,7 @@ func (c Conversation) ParticipantExists(ctx context.Context, conversationID stri
 		"participants": bson.M{"$elemMatch": participantMatch},
 	}
 
-	count, err := c.db.Collection("conversations").CountDocuments(ctx, filter)
+	count, err := c.collection(c.collections.Conversations).CountDocuments(ctx, filter)
 	if err != nil {
 		return false, fmt.Errorf("failed to count participants: %w", err)
 	}
@@ -53,33 +66,66 @@ func (c Conversation) ParticipantExists(ctx context.Context, conversationID stri
 }
 
 // AddParticipant adds a participant to the conversation.
+// The actor needs the add participant permission, and the manage roles permission to add
+// the participant with a role other than member. Participants without a role are members.
+// A participant that was deleted from the conversation is revived in place, keeping its read
+// cursor and starting a new membership period. Adding a current participant does nothing.
+// Since direct conversations are unique per set of participants, only their deleted
+// participants can be added back. Adding anyone else fails with ErrNotGroup.
+// Adding or reviving a participant fails with ErrTooManyParticipants once the conversation
+// has as many participants as the configured limit allows.
 // It returns the updated conversation or an error.
-func (c Conversation) AddParticipant(ctx context.Context, conversationID string, d data.AddParticipant) (*model.Conversation, error) {
+func (c Conversation) AddParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.AddParticipant) (*model.Conversation, error) {
 	if err := d.Validate(); err != nil {
 		return nil, fmt.Errorf("failed to validate create participant data: %w", err)
 	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+	d = chat.WithDefaultRole(d)
+
+	if err := c.hooks.RunBefore(ctx, hook.AddParticipant{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
 
 	thisConversation, err := c.Find(ctx, conversationID)
 	if err != nil || thisConversation == nil {
 		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
 
-	thisConversationParticipants := thisConversation.Participants
-	participantsToCheck := make([]data.AddParticipant, 0, len(thisConversationParticipants)+1)
-	participantsToCheck = append(participantsToCheck, d)
-	for _, p := range thisConversationParticipants {
-		participantsToCheck = append(participantsToCheck, data.AddParticipant{
-			ParticipantID: p.ParticipantID,
-			Metadata:      p.Metadata,
-		})
+	if _, err := c.authorize(thisConversation, actor, model.PermissionAddParticipant); err != nil {
+		return nil, err
+	}
+	if d.Role != model.RoleMember {
+		if _, err := c.authorize(thisConversation, actor, model.PermissionManageRoles); err != nil {
+			return nil, err
+		}
 	}
 
-	exists, existingConversation, err := c.conversationWithParticipantsExists(ctx, participantsToCheck)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check if conversation exists: %w", err)
+	revive, active := -1, 0
+	for i, p := range thisConversation.Participants {
+		if p.DeletedAt == nil {
+			active++
+		}
+		if p.ParticipantID != d.ParticipantID || !model.MetadataEqual(p.Metadata, d.Metadata) {
+			continue
+		}
+		if p.DeletedAt == nil {
+			return thisConversation, nil
+		}
+		revive = i
 	}
-	if exists {
-		return existingConversation, nil
+
+	if c.limits.MaxParticipants > 0 && active >= c.limits.MaxParticipants {
+		return nil, ErrTooManyParticipants
+	}
+
+	if revive >= 0 {
+		return c.reviveParticipant(ctx, thisConversation.ID, revive, d)
+	}
+
+	if thisConversation.Kind != model.ConversationKindGroup {
+		return nil, ErrNotGroup
 	}
 
 	conversationIDHex, err := bson.ObjectIDFromHex(conversationID)
@@ -93,34 +139,65 @@ func (c Conversation) AddParticipant(ctx context.Context, conversationID string,
 
 	update := bson.M{
 		"$push": bson.M{
-			"participants": d,
+			"participants": newParticipant(d, c.now()),
 		},
 		"$set": bson.M{
-			"updated_at": bson.NewDateTimeFromTime(time.Now()),
+			"updated_at": bson.NewDateTimeFromTime(c.now()),
 		},
 	}
 
-	res, err := c.db.Collection("conversations").UpdateOne(ctx, filter, update)
+	res, err := c.collection(c.collections.Conversations).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return nil, fmt.Errorf("failed to add participant: %w", err)
 	}
 
 	if res.MatchedCount == 0 {
-		return nil, fmt.Errorf("conversation not found")
+		return nil, ErrConversationNotFound
 	}
 
 	var conversation model.Conversation
-	err = c.db.Collection("conversations").FindOne(ctx, filter).Decode(&conversation)
+	err = c.collection(c.collections.Conversations).FindOne(ctx, filter).Decode(&conversation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
 	}
 
+	c.hooks.RunAfter(ctx, hook.ParticipantAdded{Conversation: &conversation, Participant: d})
+
 	return &conversation, nil
 }
 
-// DeleteParticipant deletes a participant from the conversation.
+// DeleteParticipant deletes a participant from the conversation and ends its current
+// membership period. Deleting a participant that is already deleted does nothing.
+// Actors may always remove themselves. Removing someone else needs the remove participant
+// permission, and removing an owner also needs the manage roles permission.
 // It returns the updated conversation or an error.
-func (c Conversation) DeleteParticipant(ctx context.Context, conversationID string, d data.DeleteParticipant) (*model.Conversation, error) {
+func (c Conversation) DeleteParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.DeleteParticipant) (*model.Conversation, error) {
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	if err := c.hooks.RunBefore(ctx, hook.DeleteParticipant{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	if actor != nil && (actor.ParticipantID != d.ParticipantID || !model.MetadataEqual(actor.Metadata, d.Metadata)) {
+		thisConversation, err := c.Find(ctx, conversationID)
+		if err != nil || thisConversation == nil {
+			return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+
+		if _, err := c.authorize(thisConversation, actor, model.PermissionRemoveParticipant); err != nil {
+			return nil, err
+		}
+
+		target := thisConversation.ActiveParticipant(d.ParticipantID, d.Metadata)
+		if target != nil && target.Role == model.RoleOwner {
+			if _, err := c.authorize(thisConversation, actor, model.PermissionManageRoles); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	conversationIDHex, err := bson.ObjectIDFromHex(conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse conversation id: %w", err)
@@ -130,11 +207,12 @@ func (c Conversation) DeleteParticipant(ctx context.Context, conversationID stri
 		"_id": conversationIDHex,
 	}
 
-	now := time.Now()
+	now := c.now()
 	update := bson.M{
 		"$set": bson.M{
-			"participants.$[participant].deleted_at": bson.NewDateTimeFromTime(now),
-			"updated_at":                             bson.NewDateTimeFromTime(now),
+			"participants.$[participant].deleted_at":                        bson.NewDateTimeFromTime(now),
+			"participants.$[participant].memberships.$[membership].left_at": bson.NewDateTimeFromTime(now),
+			"updated_at": bson.NewDateTimeFromTime(now),
 		},
 	}
 
@@ -142,28 +220,94 @@ func (c Conversation) DeleteParticipant(ctx context.Context, conversationID stri
 		bson.M{
 			"participant.participant_id": d.ParticipantID,
 			"participant.metadata":       d.Metadata,
+			"participant.deleted_at":     nil,
+		},
+		bson.M{
+			"membership.left_at": nil,
 		},
 	}
 
-	res, err := c.db.Collection("conversations").UpdateOne(ctx, filter, update, options.UpdateOne().SetArrayFilters(arrayFilters))
+	res, err := c.collection(c.collections.Conversations).UpdateOne(ctx, filter, update, options.UpdateOne().SetArrayFilters(arrayFilters))
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete participant: %w", err)
 	}
 
 	if res.MatchedCount == 0 {
-		return nil, fmt.Errorf("conversation not found")
+		return nil, ErrConversationNotFound
+	}
+
+	var conversation model.Conversation
+	err = c.collection(c.collections.Conversations).FindOne(ctx, filter).Decode(&conversation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+
+	if res.ModifiedCount > 0 {
+		c.hooks.RunAfter(ctx, hook.ParticipantDeleted{Conversation: &conversation, Participant: d})
+	}
+
+	return &conversation, nil
+}
+
+// reviveParticipant undeletes the participant at the index, sets its role and starts a new
+// membership period. The read cursor is left untouched.
+// It returns the updated conversation or an error.
+func (c Conversation) reviveParticipant(ctx context.Context, conversationID bson.ObjectID, index int, d data.AddParticipant) (*model.Conversation, error) {
+	path := fmt.Sprintf("participants.%d", index)
+	now := c.now()
+
+	// The participant id and deleted_at guard against the participants changing since they were read.
+	filter := bson.M{
+		"_id":                    conversationID,
+		path + ".participant_id": d.ParticipantID,
+		path + ".deleted_at":     bson.M{"$ne": nil},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			path + ".deleted_at": nil,
+			path + ".role":       d.Role,
+			"updated_at":         bson.NewDateTimeFromTime(now),
+		},
+		"$push": bson.M{
+			path + ".memberships": model.Membership{JoinedAt: now},
+		},
+	}
+
+	res, err := c.collection(c.collections.Conversations).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revive participant: %w", err)
 	}
 
 	var conversation model.Conversation
-	err = c.db.Collection("conversations").FindOne(ctx, filter).Decode(&conversation)
+	err = c.collection(c.collections.Conversations).FindOne(ctx, bson.M{"_id": conversationID}).Decode(&conversation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
 	}
 
+	if res.ModifiedCount > 0 {
+		c.hooks.RunAfter(ctx, hook.ParticipantAdded{Conversation: &conversation, Participant: d})
+	}
+
 	return &conversation, nil
 }
 
-// conversationWithParticipantsExists checks if a conversation with the participants exists.
+// participantDocument is a participant as stored when it joins the conversation.
+type participantDocument struct {
+	data.AddParticipant `bson:",inline"`
+	Memberships         []model.Membership `bson:"memberships"`
+}
+
+// newParticipant returns the participant with its first membership period starting now.
+func newParticipant(d data.AddParticipant, now time.Time) participantDocument {
+	return participantDocument{
+		AddParticipant: d,
+		Memberships:    []model.Membership{{JoinedAt: now}},
+	}
+}
+
+// conversationWithParticipantsExists checks if a direct conversation with the participants exists.
+// Conversations created before kinds existed are direct conversations.
 // It returns a boolean indicating if the conversation exists, the conversation or an error.
 func (c Conversation) conversationWithParticipantsExists(ctx context.Context, participants []data.AddParticipant) (bool, *model.Conversation, error) {
 	participantCount := len(participants)
@@ -180,6 +324,9 @@ func (c Conversation) conversationWithParticipantsExists(ctx context.Context, pa
 
 	filter := bson.M{
 		"$and": []bson.M{
+			{
+				"kind": bson.M{"$ne": model.ConversationKindGroup},
+			},
 			{
 				"$expr": bson.M{
 					"$eq": []interface{}{
@@ -197,7 +344,7 @@ func (c Conversation) conversationWithParticipantsExists(ctx context.Context, pa
 	}
 
 	var existingConversation model.Conversation
-	err := c.db.Collection("conversations").FindOne(ctx, filter).Decode(&existingConversation)
+	err := c.collection(c.collections.Conversations).FindOne(ctx, filter).Decode(&existingConversation)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return false, nil, nil
@@ -209,41 +356,415 @@ func (c Conversation) conversationWithParticipantsExists(ctx context.Context, pa
 }
 
 // Create creates a conversation with the participants.
+// Creating a direct conversation returns the existing direct conversation with the same
+// participants if there is one. Groups are always created.
 // It returns the created conversation or an error.
 func (c Conversation) Create(ctx context.Context, d data.CreateConversation) (*model.Conversation, error) {
-	if err := d.Validate(); err != nil {
+	if err := d.ValidateWith(c.limits); err != nil {
 		return nil, fmt.Errorf("failed to validate create conversation data: %w", err)
 	}
 
-	exists, existingConversation, err := c.conversationWithParticipantsExists(ctx, d.Participants)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check if conversation exists: %w", err)
+	if err := c.hooks.RunBefore(ctx, hook.CreateConversation{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	kind := d.Kind
+	if kind == "" {
+		kind = model.ConversationKindDirect
+	}
+
+	if kind == model.ConversationKindDirect {
+		exists, existingConversation, err := c.conversationWithParticipantsExists(ctx, d.Participants)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if conversation exists: %w", err)
+		}
+		if exists {
+			return existingConversation, nil
+		}
+	}
+
+	now := c.now()
+	participants := make([]participantDocument, 0, len(d.Participants))
+	for _, p := range d.Participants {
+		participants = append(participants, newParticipant(chat.WithDefaultRole(p), now))
 	}
-	if exists {
-		return existingConversation, nil
+
+	historyVisibility := d.HistoryVisibility
+	if historyVisibility == "" {
+		historyVisibility = model.HistoryVisibilityShared
+	}
+
+	document := bson.M{
+		"kind":               kind,
+		"participants":       participants,
+		"metadata":           d.Metadata,
+		"history_visibility": historyVisibility,
+		"created_at":         bson.NewDateTimeFromTime(now),
+		"updated_at":         bson.NewDateTimeFromTime(now),
+	}
+	if d.Title != "" {
+		document["title"] = d.Title
 	}
 
-	res, err := c.db.Collection("conversations").InsertOne(ctx, bson.M{
-		"participants": d.Participants,
-		"metadata":     d.Metadata,
-		"created_at":   bson.NewDateTimeFromTime(time.Now()),
-		"updated_at":   bson.NewDateTimeFromTime(time.Now()),
-	})
+	res, err := c.collection(c.collections.Conversations).InsertOne(ctx, document)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create conversation: %w", err)
 	}
 
 	var conversation model.Conversation
-	err = c.db.Collection("conversations").FindOne(ctx, bson.M{"_id": res.InsertedID}).Decode(&conversation)
+	err = c.collection(c.collections.Conversations).FindOne(ctx, bson.M{"_id": res.InsertedID}).Decode(&conversation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch raw conversation: %w", err)
 	}
 
+	c.hooks.RunAfter(ctx, hook.ConversationCreated{Conversation: &conversation})
+
 	return &conversation, nil
 }
 
+// UpdateMetadata replaces the metadata of the conversation.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) UpdateMetadata(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversationMetadata) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate update conversation metadata data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	conversation, err := c.Find(ctx, conversationID)
+	if err != nil || conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": conversation.ID}
+	update := bson.M{
+		"$set": bson.M{
+			"metadata":   d.Metadata,
+			"updated_at": bson.NewDateTimeFromTime(c.now()),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Conversation
+	err = c.collection(c.collections.Conversations).FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, fmt.Errorf("failed to update conversation metadata: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// SetTitle changes the title of a group. Direct conversations have no title.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetTitle(ctx context.Context, conversationID string, actor *data.Actor, d data.SetTitle) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set title data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	conversation, err := c.Find(ctx, conversationID)
+	if err != nil || conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if conversation.Kind != model.ConversationKindGroup {
+		return nil, ErrNotGroup
+	}
+
+	if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": conversation.ID}
+	update := bson.M{
+		"$set": bson.M{
+			"title":      d.Title,
+			"updated_at": bson.NewDateTimeFromTime(c.now()),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Conversation
+	err = c.collection(c.collections.Conversations).FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, fmt.Errorf("failed to update conversation title: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// SetHistoryVisibility changes whether participants see the messages sent before they joined.
+// It only affects reads, so switching back to shared restores the full history.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetHistoryVisibility(ctx context.Context, conversationID string, actor *data.Actor, d data.SetHistoryVisibility) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set history visibility data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	conversation, err := c.Find(ctx, conversationID)
+	if err != nil || conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"_id": conversation.ID}
+	update := bson.M{
+		"$set": bson.M{
+			"history_visibility": d.HistoryVisibility,
+			"updated_at":         bson.NewDateTimeFromTime(c.now()),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Conversation
+	err = c.collection(c.collections.Conversations).FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, fmt.Errorf("failed to update history visibility: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// Update changes the title, metadata and history visibility that are set, at once, see
+// SetTitle, UpdateMetadata and SetHistoryVisibility. Nothing is changed if any of them fails.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) Update(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversation) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate update conversation data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	conversation, err := c.Find(ctx, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if d.Title != nil && conversation.Kind != model.ConversationKindGroup {
+		return nil, ErrNotGroup
+	}
+
+	if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+		return nil, err
+	}
+
+	set := bson.M{"updated_at": bson.NewDateTimeFromTime(c.now())}
+	if d.Title != nil {
+		set["title"] = *d.Title
+	}
+	if d.Metadata != nil {
+		set["metadata"] = *d.Metadata
+	}
+	if d.HistoryVisibility != nil {
+		set["history_visibility"] = *d.HistoryVisibility
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Conversation
+	err = c.collection(c.collections.Conversations).FindOneAndUpdate(ctx, bson.M{"_id": conversation.ID}, bson.M{"$set": set}, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// SetRole changes the role of a non-deleted participant.
+// The actor needs the manage roles permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetRole(ctx context.Context, conversationID string, actor *data.Actor, d data.SetParticipantRole) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set participant role data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	conversation, err := c.Find(ctx, conversationID)
+	if err != nil || conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if _, err := c.authorize(conversation, actor, model.PermissionManageRoles); err != nil {
+		return nil, err
+	}
+
+	if conversation.ActiveParticipant(d.ParticipantID, d.Metadata) == nil {
+		return nil, ErrNotParticipant
+	}
+
+	filter := bson.M{"_id": conversation.ID}
+	update := bson.M{
+		"$set": bson.M{
+			"participants.$[participant].role": d.Role,
+			"updated_at":                       bson.NewDateTimeFromTime(c.now()),
+		},
+	}
+
+	arrayFilters := []any{
+		bson.M{
+			"participant.participant_id": d.ParticipantID,
+			"participant.metadata":       d.Metadata,
+			"participant.deleted_at":     nil,
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetArrayFilters(arrayFilters).SetReturnDocument(options.After)
+	var updated model.Conversation
+	err = c.collection(c.collections.Conversations).FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, fmt.Errorf("failed to set participant role: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// SetPinned pins or unpins the conversation for the participant only.
+// It returns the updated conversation or an error.
+func (c Conversation) SetPinned(ctx context.Context, conversationID string, d data.SetPinned) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set pinned data: %w", err)
+	}
+
+	update := bson.M{"$unset": bson.M{"participants.$[participant].pinned_at": ""}}
+	if d.Pinned {
+		update = bson.M{"$set": bson.M{"participants.$[participant].pinned_at": bson.NewDateTimeFromTime(c.now())}}
+	}
+
+	return c.updateParticipantState(ctx, conversationID, d.Participant, update)
+}
+
+// SetArchived archives or unarchives the conversation for the participant only.
+// An archived conversation is unarchived when a new message arrives.
+// It returns the updated conversation or an error.
+func (c Conversation) SetArchived(ctx context.Context, conversationID string, d data.SetArchived) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set archived data: %w", err)
+	}
+
+	update := bson.M{"$unset": bson.M{"participants.$[participant].archived_at": ""}}
+	if d.Archived {
+		update = bson.M{"$set": bson.M{"participants.$[participant].archived_at": bson.NewDateTimeFromTime(c.now())}}
+	}
+
+	return c.updateParticipantState(ctx, conversationID, d.Participant, update)
+}
+
+// SetMuted mutes or unmutes the conversation for the participant only.
+// The mute ends on its own at Until, if set.
+// It returns the updated conversation or an error.
+func (c Conversation) SetMuted(ctx context.Context, conversationID string, d data.SetMuted) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set muted data: %w", err)
+	}
+
+	if !d.Muted {
+		return c.updateParticipantState(ctx, conversationID, d.Participant, bson.M{"$unset": bson.M{
+			"participants.$[participant].muted_at":    "",
+			"participants.$[participant].muted_until": "",
+		}})
+	}
+
+	set := bson.M{"participants.$[participant].muted_at": bson.NewDateTimeFromTime(c.now())}
+	update := bson.M{"$set": set}
+	if d.Until != nil {
+		set["participants.$[participant].muted_until"] = bson.NewDateTimeFromTime(*d.Until)
+	} else {
+		update["$unset"] = bson.M{"participants.$[participant].muted_until": ""}
+	}
+
+	return c.updateParticipantState(ctx, conversationID, d.Participant, update)
+}
+
+// updateParticipantState applies the update to the participant's own entry. The conversation's
+// updated_at is left untouched since the change is only visible to the participant.
+// It returns the updated conversation or an error.
+func (c Conversation) updateParticipantState(ctx context.Context, conversationID string, participant data.ReadParticipant, update bson.M) (*model.Conversation, error) {
+	conversation, err := c.Find(ctx, conversationID)
+	if err != nil || conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if conversation.ActiveParticipant(participant.ParticipantID, participant.Metadata) == nil {
+		return nil, ErrNotParticipant
+	}
+
+	arrayFilters := []any{
+		bson.M{
+			"participant.participant_id": participant.ParticipantID,
+			"participant.metadata":       participant.Metadata,
+			"participant.deleted_at":     nil,
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetArrayFilters(arrayFilters).SetReturnDocument(options.After)
+	var updated model.Conversation
+	err = c.collection(c.collections.Conversations).FindOneAndUpdate(ctx, bson.M{"_id": conversation.ID}, update, opts).Decode(&updated)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrConversationNotFound
+		}
+		return nil, fmt.Errorf("failed to update participant: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// Unarchive brings the conversation back for every participant that archived it.
+// It returns an error.
+func (c Conversation) Unarchive(ctx context.Context, conversationID string) error {
+	conversationIDHex, err := bson.ObjectIDFromHex(conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to parse conversation id: %w", err)
+	}
+
+	_, err = c.collection(c.collections.Conversations).UpdateOne(ctx,
+		bson.M{"_id": conversationIDHex, "participants.archived_at": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"participants.$[participant].archived_at": ""}},
+		options.UpdateOne().SetArrayFilters([]any{bson.M{"participant.archived_at": bson.M{"$ne": nil}}}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive conversation: %w", err)
+	}
+
+	return nil
+}
+
 // Find fetches the conversation by id.
-// It returns the conversation or an error.
+// It returns the conversation, or ErrConversationNotFound if there is none, or an error.
 func (c Conversation) Find(ctx context.Context, id string) (*model.Conversation, error) {
 	obID, err := bson.ObjectIDFromHex(id)
 	if err != nil {
@@ -251,10 +772,10 @@ func (c Conversation) Find(ctx context.Context, id string) (*model.Conversation,
 	}
 
 	var conversation model.Conversation
-	err = c.db.Collection("conversations").FindOne(ctx, bson.M{"_id": obID}).Decode(&conversation)
+	err = c.collection(c.collections.Conversations).FindOne(ctx, bson.M{"_id": obID}).Decode(&conversation)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, nil
+			return nil, ErrConversationNotFound
 		}
 		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
 	}
@@ -262,28 +783,38 @@ func (c Conversation) Find(ctx context.Context, id string) (*model.Conversation,
 	return &conversation, nil
 }
 
-// Paginate fetches conversations for the participant.
+// Paginate fetches the conversations the participant is a non-deleted member of, the ones the
+// participant pinned first, most recently pinned first, then the others by last activity.
+// Conversations the participant archived are excluded unless IncludeArchived is set.
 // It returns the conversations and the total number of conversations or an error.
 func (c Conversation) Paginate(ctx context.Context, d data.PaginateConversations) ([]model.Conversation, uint, error) {
 	if err := d.Validate(); err != nil {
 		return nil, 0, fmt.Errorf("failed to validate paginate conversations data: %w", err)
 	}
 
-	filter := bson.M{
-		"participants.participant_id": d.ParticipantID,
-	}
+	filter := memberFilter(d.ParticipantID, d.Metadata, d.IncludeArchived)
+	me := memberExpr(d.ParticipantID, d.Metadata)
 
-	total, err := c.db.Collection("conversations").CountDocuments(ctx, filter)
+	total, err := c.collection(c.collections.Conversations).CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count conversations: %w", err)
 	}
 
-	opts := options.Find().
-		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
-		SetSkip(int64(d.Page-1) * int64(d.PerPage)).
-		SetLimit(int64(d.PerPage))
+	// Dates sort after null in descending order, so unpinned conversations come last.
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"participant_pinned_at": bson.M{"$max": bson.M{"$map": bson.M{
+			"input": me,
+			"as":    "p",
+			"in":    "$$p.pinned_at",
+		}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "participant_pinned_at", Value: -1}, {Key: "updated_at", Value: -1}}}},
+		{{Key: "$skip", Value: int64(d.Page-1) * int64(d.PerPage)}},
+		{{Key: "$limit", Value: int64(d.PerPage)}},
+		{{Key: "$project", Value: bson.M{"participant_pinned_at": 0}}},
+	}
 
-	cursor, err := c.db.Collection("conversations").Find(ctx, filter, opts)
+	cursor, err := c.collection(c.collections.Conversations).Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to fetch conversations: %w", err)
 	}
@@ -297,8 +828,8 @@ func (c Conversation) Paginate(ctx context.Context, d data.PaginateConversations
 	return conversations, uint(total), nil
 }
 
-// FindByParticipants finds a conversation where all specified participants exist.
-// Returns nil, nil if no matching conversation exists.
+// FindByParticipants finds the direct conversation between exactly the specified participants.
+// It returns ErrConversationNotFound if there is none.
 func (c Conversation) FindByParticipants(ctx context.Context, d data.FindByParticipants) (*model.Conversation, error) {
 	if err := d.Validate(); err != nil {
 		return nil, fmt.Errorf("failed to validate find by participants data: %w", err)
@@ -319,7 +850,7 @@ func (c Conversation) FindByParticipants(ctx context.Context, d data.FindByParti
 	}
 
 	if !exists {
-		return nil, nil
+		return nil, ErrConversationNotFound
 	}
 
 	return conversation, nil
@@ -335,26 +866,9 @@ func (c Conversation) FindByMetadata(ctx context.Context, d data.FindByMetadata)
 		return nil, 0, fmt.Errorf("failed to validate find by metadata data: %w", err)
 	}
 
-	participantMatch := bson.M{}
-
-	switch d.MatchMode {
-	case data.MetadataMatchModeKeyValue:
-		for key, value := range d.Metadata {
-			participantMatch[fmt.Sprintf("metadata.%s", key)] = value
-		}
-	case data.MetadataMatchModeExact:
-		participantMatch["metadata"] = d.Metadata
-	}
-
-	if !d.IncludeDeleted {
-		participantMatch["deleted_at"] = nil
-	}
+	filter := metadataFilter(d.Metadata, d.MatchMode, d.IncludeDeleted)
 
-	filter := bson.M{
-		"participants": bson.M{"$elemMatch": participantMatch},
-	}
-
-	total, err := c.db.Collection("conversations").CountDocuments(ctx, filter)
+	total, err := c.collection(c.collections.Conversations).CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count conversations: %w", err)
 	}
@@ -364,7 +878,7 @@ func (c Conversation) FindByMetadata(ctx context.Context, d data.FindByMetadata)
 		SetSkip(int64(d.Page-1) * int64(d.PerPage)).
 		SetLimit(int64(d.PerPage))
 
-	cursor, err := c.db.Collection("conversations").Find(ctx, filter, opts)
+	cursor, err := c.collection(c.collections.Conversations).Find(ctx, filter, opts)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to fetch conversations: %w", err)
 	}
@@ -378,7 +892,142 @@ func (c Conversation) FindByMetadata(ctx context.Context, d data.FindByMetadata)
 	return conversations, uint(total), nil
 }
 
-// UpdateLastMessage updates the last message in the conversation.
+// LoadConversations fetches the conversations the participant is a non-deleted member of,
+// most recently updated first.
+// Conversations the participant archived are excluded unless IncludeArchived is set.
+// Unlike Paginate, it continues from the cursor of the previous page, so conversations
+// bumped by new messages while paging are neither repeated nor shifting the pages.
+// It returns the page or an error.
+func (c Conversation) LoadConversations(ctx context.Context, d data.LoadConversations) (*model.ConversationPage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load conversations data: %w", err)
+	}
+
+	return c.loadPage(ctx, memberFilter(d.ParticipantID, d.Metadata, d.IncludeArchived), d.Cursor, d.PerPage, d.Count)
+}
+
+// LoadByMetadata is the cursor based variant of FindByMetadata, see LoadConversations.
+// It returns the page or an error.
+func (c Conversation) LoadByMetadata(ctx context.Context, d data.LoadByMetadata) (*model.ConversationPage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load by metadata data: %w", err)
+	}
+
+	return c.loadPage(ctx, metadataFilter(d.Metadata, d.MatchMode, d.IncludeDeleted), d.Cursor, d.PerPage, d.Count)
+}
+
+// loadPage fetches the page of conversations matching the filter after the cursor,
+// ordered by updated_at then _id, both descending.
+func (c Conversation) loadPage(ctx context.Context, filter bson.M, cursor string, perPage uint, count bool) (*model.ConversationPage, error) {
+	page := &model.ConversationPage{Conversations: []model.Conversation{}}
+
+	if count {
+		total, err := c.collection(c.collections.Conversations).CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count conversations: %w", err)
+		}
+		totalUint := uint(total)
+		page.Total = &totalUint
+	}
+
+	pageFilter := filter
+	if cursor != "" {
+		after, err := cursorFilter(cursor)
+		if err != nil {
+			return nil, err
+		}
+		pageFilter = bson.M{"$and": []bson.M{filter, after}}
+	}
+
+	// One extra conversation tells whether there is a next page.
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(perPage) + 1)
+
+	results, err := c.collection(c.collections.Conversations).Find(ctx, pageFilter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+	defer results.Close(ctx)
+
+	if err := results.All(ctx, &page.Conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode conversations: %w", err)
+	}
+
+	if uint(len(page.Conversations)) > perPage {
+		page.Conversations = page.Conversations[:perPage]
+		last := page.Conversations[perPage-1]
+		page.HasMore = true
+		page.NextCursor = chat.EncodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+// memberExpr is the expression selecting the participant's own non-deleted entry of the
+// conversation, matched like SetPinned and SetArchived match it.
+func memberExpr(participantID string, metadata map[string]any) bson.M {
+	return bson.M{"$filter": bson.M{
+		"input": "$participants",
+		"as":    "p",
+		"cond": bson.M{"$and": []any{
+			identityExpr("$$p", participantID, metadata),
+			bson.M{"$eq": []any{bson.M{"$ifNull": []any{"$$p.deleted_at", nil}}, nil}},
+		}},
+	}}
+}
+
+// memberFilter matches the conversations the participant is a non-deleted member of, and
+// has not archived unless includeArchived is set.
+func memberFilter(participantID string, metadata map[string]any, includeArchived bool) bson.M {
+	me := memberExpr(participantID, metadata)
+
+	// The plain match narrows down the conversations with the index, the expression compares
+	// the metadata exactly.
+	visible := bson.M{"$gt": []any{bson.M{"$size": me}, 0}}
+	if !includeArchived {
+		visible = bson.M{"$in": []any{nil, bson.M{"$map": bson.M{
+			"input": me,
+			"as":    "p",
+			"in":    bson.M{"$ifNull": []any{"$$p.archived_at", nil}},
+		}}}}
+	}
+
+	return bson.M{
+		"participants": bson.M{"$elemMatch": bson.M{
+			"participant_id": participantID,
+			"deleted_at":     nil,
+		}},
+		"$expr": visible,
+	}
+}
+
+// metadataFilter matches the conversations with a participant matching the metadata.
+func metadataFilter(metadata map[string]any, matchMode data.MetadataMatchMode, includeDeleted bool) bson.M {
+	participantMatch := bson.M{}
+
+	switch matchMode {
+	case data.MetadataMatchModeKeyValue:
+		for key, value := range metadata {
+			participantMatch[fmt.Sprintf("metadata.%s", key)] = value
+		}
+	case data.MetadataMatchModeExact:
+		participantMatch["metadata"] = metadata
+	}
+
+	if !includeDeleted {
+		participantMatch["deleted_at"] = nil
+	}
+
+	return bson.M{
+		"participants": bson.M{"$elemMatch": participantMatch},
+	}
+}
+
+// UpdateLastMessage sets the message as the conversation's last message and advances the
+// conversation's updated_at to the message's creation time.
+// Neither goes backwards: the last message is only replaced by a message with a greater id,
+// so a create that loses a race, or a message written late, cannot overwrite a newer one.
 // It returns an error.
 func (c Conversation) UpdateLastMessage(ctx context.Context, conversationID string, message model.Message) error {
 	conversationIDHex, err := bson.ObjectIDFromHex(conversationID)
@@ -390,20 +1039,55 @@ func (c Conversation) UpdateLastMessage(ctx context.Context, conversationID stri
 		"_id": conversationIDHex,
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"last_message": message,
-			"updated_at":   bson.NewDateTimeFromTime(message.CreatedAt),
-		},
+	isNewer := bson.M{"$or": []any{
+		bson.M{"$eq": []any{bson.M{"$ifNull": []any{"$last_message._id", nil}}, nil}},
+		bson.M{"$lt": []any{"$last_message._id", message.ID}},
+	}}
+
+	// The message is wrapped in $literal so that content starting with $ is not read as a field path.
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"last_message": bson.M{"$cond": []any{isNewer, bson.M{"$literal": message}, "$last_message"}},
+			"updated_at":   bson.M{"$max": []any{"$updated_at", bson.NewDateTimeFromTime(message.CreatedAt)}},
+		}}},
 	}
 
-	res, err := c.db.Collection("conversations").UpdateOne(ctx, filter, update)
+	res, err := c.collection(c.collections.Conversations).UpdateOne(ctx, filter, update)
 	if err != nil {
 		return fmt.Errorf("failed to update last message: %w", err)
 	}
 
 	if res.MatchedCount == 0 {
-		return fmt.Errorf("conversation not found")
+		return ErrConversationNotFound
+	}
+
+	return nil
+}
+
+// RefreshLastMessage replaces the conversation's last message with the given version of it,
+// e.g. after the message has been edited. Revisions are not copied into the conversation.
+// It is a no-op when the message is no longer the conversation's last message.
+func (c Conversation) RefreshLastMessage(ctx context.Context, conversationID string, message model.Message) error {
+	conversationIDHex, err := bson.ObjectIDFromHex(conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to parse conversation id: %w", err)
+	}
+
+	filter := bson.M{
+		"_id":              conversationIDHex,
+		"last_message._id": message.ID,
+	}
+
+	message.Revisions = nil
+	update := bson.M{
+		"$set": bson.M{
+			"last_message": message,
+		},
+	}
+
+	_, err = c.collection(c.collections.Conversations).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to refresh last message: %w", err)
 	}
 
 	return nil
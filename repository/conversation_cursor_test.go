@@ -0,0 +1,131 @@
+package repository_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationRepository_LoadConversations(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConvs := func(t *testing.T, user string, n int) []string {
+		t.Helper()
+		ids := make([]string, 0, n)
+		for i := range n {
+			conv, err := cr.Create(t.Context(), data.CreateConversation{
+				Participants: []data.AddParticipant{
+					{ParticipantID: user},
+					{ParticipantID: fmt.Sprintf("%s-other-%d", user, i), Metadata: map[string]any{"team": user}},
+				},
+			})
+			require.NoError(t, err)
+			ids = append(ids, conv.ID.Hex())
+		}
+		return ids
+	}
+
+	t.Run("pages through every conversation once", func(t *testing.T) {
+		ids := createConvs(t, "cc-all", 5)
+
+		var seen []string
+		cursor := ""
+		for {
+			page, err := cr.LoadConversations(t.Context(), data.LoadConversations{
+				ParticipantID: "cc-all",
+				Cursor:        cursor,
+				PerPage:       2,
+			})
+			require.NoError(t, err)
+			assert.Nil(t, page.Total)
+
+			for _, conv := range page.Conversations {
+				seen = append(seen, conv.ID.Hex())
+			}
+			if !page.HasMore {
+				assert.Empty(t, page.NextCursor)
+				break
+			}
+			cursor = page.NextCursor
+		}
+
+		assert.ElementsMatch(t, ids, seen)
+	})
+
+	t.Run("a bumped conversation does not shift later pages", func(t *testing.T) {
+		createConvs(t, "cc-bump", 4)
+
+		first, err := cr.LoadConversations(t.Context(), data.LoadConversations{
+			ParticipantID: "cc-bump",
+			PerPage:       2,
+		})
+		require.NoError(t, err)
+		require.True(t, first.HasMore)
+
+		_, err = mr.Create(t.Context(), first.Conversations[1].ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "cc-bump"},
+			Content: "bump",
+		})
+		require.NoError(t, err)
+
+		second, err := cr.LoadConversations(t.Context(), data.LoadConversations{
+			ParticipantID: "cc-bump",
+			Cursor:        first.NextCursor,
+			PerPage:       2,
+		})
+		require.NoError(t, err)
+		require.Len(t, second.Conversations, 2)
+		assert.False(t, second.HasMore)
+		for _, conv := range second.Conversations {
+			assert.NotEqual(t, first.Conversations[0].ID, conv.ID)
+			assert.NotEqual(t, first.Conversations[1].ID, conv.ID)
+		}
+	})
+
+	t.Run("counts on request", func(t *testing.T) {
+		createConvs(t, "cc-count", 3)
+
+		page, err := cr.LoadConversations(t.Context(), data.LoadConversations{
+			ParticipantID: "cc-count",
+			PerPage:       2,
+			Count:         true,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, page.Total)
+		assert.Equal(t, uint(3), *page.Total)
+	})
+
+	t.Run("loads by metadata", func(t *testing.T) {
+		ids := createConvs(t, "cc-meta", 3)
+
+		page, err := cr.LoadByMetadata(t.Context(), data.LoadByMetadata{
+			Metadata:  map[string]any{"team": "cc-meta"},
+			MatchMode: data.MetadataMatchModeKeyValue,
+			PerPage:   10,
+		})
+		require.NoError(t, err)
+		assert.False(t, page.HasMore)
+		assert.Len(t, page.Conversations, len(ids))
+	})
+
+	t.Run("rejects an invalid cursor", func(t *testing.T) {
+		page, err := cr.LoadConversations(t.Context(), data.LoadConversations{
+			ParticipantID: "cc-invalid",
+			Cursor:        "not a cursor",
+			PerPage:       2,
+		})
+		assert.ErrorIs(t, err, repository.ErrInvalidCursor)
+		assert.Nil(t, page)
+	})
+}
@@ -0,0 +1,109 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationRepository_Kinds(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+
+	t.Run("deduplicates direct conversations only", func(t *testing.T) {
+		participants := []data.AddParticipant{
+			{ParticipantID: "ck-dedupe-a"},
+			{ParticipantID: "ck-dedupe-b"},
+		}
+
+		direct, err := cr.Create(t.Context(), data.CreateConversation{Participants: participants})
+		require.NoError(t, err)
+		assert.Equal(t, model.ConversationKindDirect, direct.Kind)
+
+		again, err := cr.Create(t.Context(), data.CreateConversation{Kind: model.ConversationKindDirect, Participants: participants})
+		require.NoError(t, err)
+		assert.Equal(t, direct.ID.Hex(), again.ID.Hex())
+
+		first, err := cr.Create(t.Context(), data.CreateConversation{Kind: model.ConversationKindGroup, Title: "Lunch", Participants: participants})
+		require.NoError(t, err)
+		second, err := cr.Create(t.Context(), data.CreateConversation{Kind: model.ConversationKindGroup, Title: "Book club", Participants: participants})
+		require.NoError(t, err)
+
+		assert.NotEqual(t, direct.ID.Hex(), first.ID.Hex())
+		assert.NotEqual(t, first.ID.Hex(), second.ID.Hex())
+		assert.Equal(t, model.ConversationKindGroup, first.Kind)
+		assert.Equal(t, "Lunch", first.Title)
+
+		found, err := cr.FindByParticipants(t.Context(), data.FindByParticipants{
+			Participants: []data.FindParticipant{{ParticipantID: "ck-dedupe-a"}, {ParticipantID: "ck-dedupe-b"}},
+		})
+		require.NoError(t, err)
+		require.NotNil(t, found)
+		assert.Equal(t, direct.ID.Hex(), found.ID.Hex())
+	})
+
+	t.Run("adds participants to groups in place", func(t *testing.T) {
+		_, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "ck-add-a"},
+				{ParticipantID: "ck-add-b"},
+				{ParticipantID: "ck-add-c"},
+			},
+		})
+		require.NoError(t, err)
+
+		group, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "ck-add-a"},
+				{ParticipantID: "ck-add-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		updated, err := cr.AddParticipant(t.Context(), group.ID.Hex(), nil, data.AddParticipant{ParticipantID: "ck-add-c"})
+		require.NoError(t, err)
+		assert.Equal(t, group.ID.Hex(), updated.ID.Hex())
+		assert.Len(t, updated.Participants, 3)
+	})
+
+	t.Run("sets the title of groups only", func(t *testing.T) {
+		owner := &data.Actor{ParticipantID: "ck-title-a"}
+
+		group, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "ck-title-a", Role: model.RoleOwner},
+				{ParticipantID: "ck-title-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		updated, err := cr.SetTitle(t.Context(), group.ID.Hex(), owner, data.SetTitle{Title: "Renamed"})
+		require.NoError(t, err)
+		assert.Equal(t, "Renamed", updated.Title)
+
+		_, err = cr.SetTitle(t.Context(), group.ID.Hex(), &data.Actor{ParticipantID: "ck-title-b"}, data.SetTitle{Title: "Mine"})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		direct, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "ck-title-a", Role: model.RoleOwner},
+				{ParticipantID: "ck-title-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = cr.SetTitle(t.Context(), direct.ID.Hex(), owner, data.SetTitle{Title: "Renamed"})
+		assert.ErrorIs(t, err, repository.ErrNotGroup)
+	})
+}
@@ -91,7 +91,7 @@ func TestConversationRepository_Paginate(t *testing.T) {
 
 	conv2, err := cr.Create(t.Context(), data.CreateConversation{
 		Participants: []data.AddParticipant{
-			{ParticipantID: "1234567890", Metadata: map[string]any{"business_id": "999999999"}},
+			{ParticipantID: "1234567890"},
 			{ParticipantID: "2222222222"},
 		},
 	})
@@ -124,11 +124,27 @@ func TestConversationRepository_Paginate(t *testing.T) {
 			},
 			asserts: func(t *testing.T, convs []model.Conversation, total uint, err error) {
 				assert.NoError(t, err)
-				assert.Len(t, convs, 3)
-				assert.Equal(t, uint(3), total)
+				assert.Len(t, convs, 2)
+				assert.Equal(t, uint(2), total)
 				assert.Equal(t, conv3.ID.Hex(), convs[0].ID.Hex())
 				assert.Equal(t, conv2.ID.Hex(), convs[1].ID.Hex())
-				assert.Equal(t, conv1.ID.Hex(), convs[2].ID.Hex())
+			},
+		},
+		{
+			name: "valid with metadata",
+			prepData: func(t *testing.T) data.PaginateConversations {
+				return data.PaginateConversations{
+					ParticipantID: "1234567890",
+					Metadata:      map[string]any{"business_id": "0987654321"},
+					Page:          1,
+					PerPage:       10,
+				}
+			},
+			asserts: func(t *testing.T, convs []model.Conversation, total uint, err error) {
+				assert.NoError(t, err)
+				assert.Len(t, convs, 1)
+				assert.Equal(t, uint(1), total)
+				assert.Equal(t, conv1.ID.Hex(), convs[0].ID.Hex())
 			},
 		},
 		{
@@ -137,15 +153,14 @@ func TestConversationRepository_Paginate(t *testing.T) {
 				return data.PaginateConversations{
 					ParticipantID: "1234567890",
 					Page:          1,
-					PerPage:       2,
+					PerPage:       1,
 				}
 			},
 			asserts: func(t *testing.T, convs []model.Conversation, total uint, err error) {
 				assert.NoError(t, err)
-				assert.Len(t, convs, 2)
-				assert.Equal(t, uint(3), total)
+				assert.Len(t, convs, 1)
+				assert.Equal(t, uint(2), total)
 				assert.Equal(t, conv3.ID.Hex(), convs[0].ID.Hex())
-				assert.Equal(t, conv2.ID.Hex(), convs[1].ID.Hex())
 			},
 		},
 		{
@@ -247,7 +262,7 @@ func TestConversationRepository_Find(t *testing.T) {
 			name: "invalid with not found id",
 			id:   "5f0c5b7a1a3f4b0c1c8d3b6b",
 			asserts: func(t *testing.T, conv *model.Conversation, err error) {
-				assert.NoError(t, err)
+				assert.ErrorIs(t, err, repository.ErrConversationNotFound)
 				assert.Nil(t, conv)
 			},
 		},
@@ -269,7 +284,7 @@ func TestConversationRepository_AddParticipant(t *testing.T) {
 		expects     func(t *testing.T, resultConv *model.Conversation, expectedConv *model.Conversation, err error)
 	}{
 		{
-			name: "returns existing conversation if participants already exist",
+			name: "rejects new participants of a direct conversation even if another conversation has them",
 			setup: func(t *testing.T, cr *repository.Conversation) (*model.Conversation, *model.Conversation) {
 				conv1, err := cr.Create(t.Context(), data.CreateConversation{
 					Participants: []data.AddParticipant{
@@ -296,20 +311,15 @@ func TestConversationRepository_AddParticipant(t *testing.T) {
 				}
 			},
 			expects: func(t *testing.T, resultConv *model.Conversation, expectedConv *model.Conversation, err error) {
-				assert.NoError(t, err)
-				assert.Equal(t, expectedConv.ID.Hex(), resultConv.ID.Hex())
-				assert.Len(t, resultConv.Participants, 3)
-				assert.Equal(t, expectedConv.Participants[0].ParticipantID, resultConv.Participants[0].ParticipantID)
-				assert.Equal(t, expectedConv.Participants[1].ParticipantID, resultConv.Participants[1].ParticipantID)
-				assert.Equal(t, expectedConv.Participants[2].ParticipantID, resultConv.Participants[2].ParticipantID)
-				assert.Equal(t, expectedConv.Participants[0].Metadata["business_id"], resultConv.Participants[0].Metadata["business_id"])
-				assert.Equal(t, expectedConv.Participants[1].Metadata["business_id"], resultConv.Participants[1].Metadata["business_id"])
+				assert.ErrorIs(t, err, repository.ErrNotGroup)
+				assert.Nil(t, resultConv)
 			},
 		},
 		{
-			name: "adds participant to the conversation if participants do not exist",
+			name: "adds participant to a group",
 			setup: func(t *testing.T, cr *repository.Conversation) (*model.Conversation, *model.Conversation) {
 				conv, err := cr.Create(t.Context(), data.CreateConversation{
+					Kind: model.ConversationKindGroup,
 					Participants: []data.AddParticipant{
 						{ParticipantID: "1234567890", Metadata: map[string]any{"business_id": "0987654321"}},
 						{ParticipantID: "2222222222", Metadata: map[string]any{"business_id": "999999999"}},
@@ -331,26 +341,17 @@ func TestConversationRepository_AddParticipant(t *testing.T) {
 			},
 		},
 		{
-			name: "returns existing conversation if participant exists with metadata",
+			name: "does nothing if participant exists with metadata",
 			setup: func(t *testing.T, cr *repository.Conversation) (*model.Conversation, *model.Conversation) {
-				conv1, err := cr.Create(t.Context(), data.CreateConversation{
+				conv, err := cr.Create(t.Context(), data.CreateConversation{
 					Participants: []data.AddParticipant{
 						{ParticipantID: "1234567890", Metadata: map[string]any{"business_id": "0987654321"}},
 						{ParticipantID: "2222222222", Metadata: map[string]any{"business_id": "999999999"}},
-						{ParticipantID: "1111111111"},
 					},
 				})
 				assert.NoError(t, err)
 
-				conv2, err := cr.Create(t.Context(), data.CreateConversation{
-					Participants: []data.AddParticipant{
-						{ParticipantID: "1234567890", Metadata: map[string]any{"business_id": "0987654321"}},
-						{ParticipantID: "1111111111"},
-					},
-				})
-				assert.NoError(t, err)
-
-				return conv1, conv2
+				return conv, conv
 			},
 			participant: func(t *testing.T) data.AddParticipant {
 				return data.AddParticipant{
@@ -361,35 +362,21 @@ func TestConversationRepository_AddParticipant(t *testing.T) {
 			expects: func(t *testing.T, resultConv *model.Conversation, expectedConv *model.Conversation, err error) {
 				assert.NoError(t, err)
 				assert.Equal(t, expectedConv.ID.Hex(), resultConv.ID.Hex())
-				assert.Len(t, resultConv.Participants, 3)
-				assert.Equal(t, expectedConv.Participants[0].ParticipantID, resultConv.Participants[0].ParticipantID)
-				assert.Equal(t, expectedConv.Participants[1].ParticipantID, resultConv.Participants[1].ParticipantID)
-				assert.Equal(t, expectedConv.Participants[2].ParticipantID, resultConv.Participants[2].ParticipantID)
-				assert.Equal(t, expectedConv.Participants[0].Metadata["business_id"], resultConv.Participants[0].Metadata["business_id"])
-				assert.Equal(t, expectedConv.Participants[1].Metadata["business_id"], resultConv.Participants[1].Metadata["business_id"])
+				assert.Len(t, resultConv.Participants, 2)
 			},
 		},
 		{
-			name: "returns new conversation if participant exists with different metadata",
+			name: "rejects participant with different metadata in a direct conversation",
 			setup: func(t *testing.T, cr *repository.Conversation) (*model.Conversation, *model.Conversation) {
-				conv1, err := cr.Create(t.Context(), data.CreateConversation{
+				conv, err := cr.Create(t.Context(), data.CreateConversation{
 					Participants: []data.AddParticipant{
 						{ParticipantID: "1234567890", Metadata: map[string]any{"business_id": "0987654321"}},
 						{ParticipantID: "2222222222", Metadata: map[string]any{"business_id": "999999999"}},
-						{ParticipantID: "1111111111"},
 					},
 				})
 				assert.NoError(t, err)
 
-				conv2, err := cr.Create(t.Context(), data.CreateConversation{
-					Participants: []data.AddParticipant{
-						{ParticipantID: "1234567890", Metadata: map[string]any{"business_id": "0987654321"}},
-						{ParticipantID: "1111111111"},
-					},
-				})
-				assert.NoError(t, err)
-
-				return conv1, conv2
+				return conv, conv
 			},
 			participant: func(t *testing.T) data.AddParticipant {
 				return data.AddParticipant{
@@ -398,9 +385,8 @@ func TestConversationRepository_AddParticipant(t *testing.T) {
 				}
 			},
 			expects: func(t *testing.T, resultConv *model.Conversation, expectedConv *model.Conversation, err error) {
-				assert.NoError(t, err)
-				assert.NotEqual(t, expectedConv.ID.Hex(), resultConv.ID.Hex())
-				assert.Len(t, resultConv.Participants, 3)
+				assert.ErrorIs(t, err, repository.ErrNotGroup)
+				assert.Nil(t, resultConv)
 			},
 		},
 	}
@@ -415,8 +401,7 @@ func TestConversationRepository_AddParticipant(t *testing.T) {
 			cr := repository.NewConversation(client.Database("chatsavvy"))
 			expectedConv, currentConv := tt.setup(t, cr)
 
-			resultConv, err := cr.AddParticipant(t.Context(), currentConv.ID.Hex(), tt.participant(t))
-			assert.NoError(t, err)
+			resultConv, err := cr.AddParticipant(t.Context(), currentConv.ID.Hex(), nil, tt.participant(t))
 
 			tt.expects(t, resultConv, expectedConv, err)
 		})
@@ -542,7 +527,7 @@ func TestConversationRepository_DeleteParticipant(t *testing.T) {
 			cr := repository.NewConversation(client.Database("chatsavvy"))
 			conv := tt.setup(t, cr)
 
-			resultConv, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), tt.participant(t))
+			resultConv, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, tt.participant(t))
 			assert.NoError(t, err)
 
 			tt.expects(t, resultConv, err)
@@ -623,7 +608,7 @@ func TestConversationRepository_FindByParticipants(t *testing.T) {
 			},
 		},
 		{
-			name: "returns nil when no matching conversation exists",
+			name: "returns not found when no matching conversation exists",
 			prepData: func(t *testing.T) data.FindByParticipants {
 				return data.FindByParticipants{
 					Participants: []data.FindParticipant{
@@ -633,12 +618,12 @@ func TestConversationRepository_FindByParticipants(t *testing.T) {
 				}
 			},
 			asserts: func(t *testing.T, result *model.Conversation, err error) {
-				assert.NoError(t, err)
+				assert.ErrorIs(t, err, repository.ErrConversationNotFound)
 				assert.Nil(t, result)
 			},
 		},
 		{
-			name: "returns nil when metadata does not match",
+			name: "returns not found when metadata does not match",
 			prepData: func(t *testing.T) data.FindByParticipants {
 				return data.FindByParticipants{
 					Participants: []data.FindParticipant{
@@ -648,12 +633,12 @@ func TestConversationRepository_FindByParticipants(t *testing.T) {
 				}
 			},
 			asserts: func(t *testing.T, result *model.Conversation, err error) {
-				assert.NoError(t, err)
+				assert.ErrorIs(t, err, repository.ErrConversationNotFound)
 				assert.Nil(t, result)
 			},
 		},
 		{
-			name: "returns nil when only one participant matches",
+			name: "returns not found when only one participant matches",
 			prepData: func(t *testing.T) data.FindByParticipants {
 				return data.FindByParticipants{
 					Participants: []data.FindParticipant{
@@ -663,7 +648,7 @@ func TestConversationRepository_FindByParticipants(t *testing.T) {
 				}
 			},
 			asserts: func(t *testing.T, result *model.Conversation, err error) {
-				assert.NoError(t, err)
+				assert.ErrorIs(t, err, repository.ErrConversationNotFound)
 				assert.Nil(t, result)
 			},
 		},
@@ -737,7 +722,7 @@ func TestConversationRepository_FindByMetadata(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Soft-delete the participant with metadata
-	_, err = cr.DeleteParticipant(t.Context(), conv4.ID.Hex(), data.DeleteParticipant{
+	_, err = cr.DeleteParticipant(t.Context(), conv4.ID.Hex(), nil, data.DeleteParticipant{
 		ParticipantID: "meta-test-7",
 		Metadata:      map[string]any{"businessId": "biz-789"},
 	})
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// cursorFilter decodes the cursor into a filter matching the conversations after it.
+func cursorFilter(cursor string) (bson.M, error) {
+	updatedAt, id, err := chat.DecodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	after := bson.NewDateTimeFromTime(updatedAt)
+	return bson.M{"$or": []bson.M{
+		{"updated_at": bson.M{"$lt": after}},
+		{"updated_at": after, "_id": bson.M{"$lt": id}},
+	}}, nil
+}
@@ -0,0 +1,24 @@
+package repository
+
+import "github.com/davesavic/chatsavvy/store"
+
+// The errors are shared by every storage backend, see the store package.
+var (
+	ErrNotFound               = store.ErrNotFound
+	ErrConflict               = store.ErrConflict
+	ErrValidation             = store.ErrValidation
+	ErrConversationNotFound   = store.ErrConversationNotFound
+	ErrMessageNotFound        = store.ErrMessageNotFound
+	ErrNotParticipant         = store.ErrNotParticipant
+	ErrParticipantDeleted     = store.ErrParticipantDeleted
+	ErrNotSender              = store.ErrNotSender
+	ErrMessageDeleted         = store.ErrMessageDeleted
+	ErrConcurrentModification = store.ErrConcurrentModification
+	ErrInvalidCursor          = store.ErrInvalidCursor
+	ErrPermissionDenied       = store.ErrPermissionDenied
+	ErrNotGroup               = store.ErrNotGroup
+	ErrTooManyParticipants    = store.ErrTooManyParticipants
+)
+
+// ValidationError is returned when the data of the operation is invalid, see store.ValidationError.
+type ValidationError = store.ValidationError
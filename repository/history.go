@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// historyFilter returns the filter restricting messages to the participant's history window,
+// or nil when the participant sees the whole conversation.
+// Deleted participants only see the messages sent up to their deletion. In conversations with
+// joined history visibility, participants only see the messages sent during their membership
+// periods.
+func historyFilter(conv *model.Conversation, p *model.Participant) bson.M {
+	if conv.HistoryVisibility == model.HistoryVisibilityJoined && len(p.Memberships) > 0 {
+		periods := make([]bson.M, 0, len(p.Memberships))
+		for _, membership := range p.Memberships {
+			createdAt := bson.M{"$gte": membership.JoinedAt}
+			if membership.LeftAt != nil {
+				createdAt["$lte"] = *membership.LeftAt
+			}
+			periods = append(periods, bson.M{"created_at": createdAt})
+		}
+		return bson.M{"$or": periods}
+	}
+
+	if p.DeletedAt != nil {
+		return bson.M{"created_at": bson.M{"$lte": *p.DeletedAt}}
+	}
+
+	return nil
+}
+
+// historyExpr is the aggregation expression counterpart of historyFilter for a non-deleted
+// participant. It is true when the message is within the participant's history window, given
+// the conversation's history visibility and the participant's memberships.
+func historyExpr(historyVisibility any, memberships any) bson.M {
+	return bson.M{"$or": []any{
+		bson.M{"$ne": []any{historyVisibility, model.HistoryVisibilityJoined}},
+		bson.M{"$eq": []any{bson.M{"$size": memberships}, 0}},
+		bson.M{"$anyElementTrue": []any{bson.M{"$map": bson.M{
+			"input": memberships,
+			"as":    "m",
+			"in": bson.M{"$and": []any{
+				bson.M{"$gte": []any{"$created_at", "$$m.joined_at"}},
+				bson.M{"$or": []any{
+					bson.M{"$eq": []any{bson.M{"$ifNull": []any{"$$m.left_at", nil}}, nil}},
+					bson.M{"$lte": []any{"$created_at", "$$m.left_at"}},
+				}},
+			}},
+		}}}},
+	}}
+}
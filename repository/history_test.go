@@ -0,0 +1,178 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_HistoryVisibility(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	send := func(t *testing.T, convID string, sender string, content string) *model.Message {
+		t.Helper()
+		msg, err := mr.Create(t.Context(), convID, data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: sender},
+			Content: content,
+		})
+		require.NoError(t, err)
+		// Membership periods compare creation times, keep them apart.
+		time.Sleep(5 * time.Millisecond)
+		return msg
+	}
+
+	load := func(t *testing.T, convID string, viewer string) []string {
+		t.Helper()
+		messages, err := mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: convID,
+			Viewer:         &data.ReadParticipant{ParticipantID: viewer},
+			PerPage:        100,
+		})
+		require.NoError(t, err)
+		contents := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			contents = append(contents, msg.Content)
+		}
+		return contents
+	}
+
+	t.Run("deleted participants only see messages up to their deletion", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "hv-left-a"},
+				{ParticipantID: "hv-left-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		send(t, conv.ID.Hex(), "hv-left-a", "before")
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "hv-left-b"})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		send(t, conv.ID.Hex(), "hv-left-a", "after")
+
+		assert.Equal(t, []string{"before"}, load(t, conv.ID.Hex(), "hv-left-b"))
+		assert.Equal(t, []string{"after", "before"}, load(t, conv.ID.Hex(), "hv-left-a"))
+	})
+
+	t.Run("late joiners see the whole history by default", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "hv-shared-a"},
+				{ParticipantID: "hv-shared-b"},
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.HistoryVisibilityShared, conv.HistoryVisibility)
+
+		send(t, conv.ID.Hex(), "hv-shared-a", "before")
+		_, err = cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "hv-shared-c"})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"before"}, load(t, conv.ID.Hex(), "hv-shared-c"))
+	})
+
+	t.Run("late joiners only see messages since they joined when opted in", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "hv-joined-a"},
+				{ParticipantID: "hv-joined-b"},
+			},
+			HistoryVisibility: model.HistoryVisibilityJoined,
+		})
+		require.NoError(t, err)
+
+		send(t, conv.ID.Hex(), "hv-joined-a", "before")
+		_, err = cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "hv-joined-c"})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		send(t, conv.ID.Hex(), "hv-joined-a", "after")
+
+		assert.Equal(t, []string{"after"}, load(t, conv.ID.Hex(), "hv-joined-c"))
+		assert.Equal(t, []string{"after", "before"}, load(t, conv.ID.Hex(), "hv-joined-b"))
+
+		count, err := mr.UnreadCount(t.Context(), data.UnreadCount{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "hv-joined-c"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), count)
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "hv-joined-c"},
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, inbox.Entries, 1)
+		assert.Equal(t, uint(1), inbox.Entries[0].UnreadCount)
+
+		results, total, err := mr.Search(t.Context(), data.SearchMessages{
+			Participant: data.ReadParticipant{ParticipantID: "hv-joined-c"},
+			Query:       "before after",
+			Page:        1,
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), total)
+		require.Len(t, results, 1)
+		assert.Equal(t, "after", results[0].Message.Content)
+	})
+
+	t.Run("revived participants do not see the gap while they were away", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "hv-gap-a"},
+				{ParticipantID: "hv-gap-b"},
+			},
+			HistoryVisibility: model.HistoryVisibilityJoined,
+		})
+		require.NoError(t, err)
+
+		send(t, conv.ID.Hex(), "hv-gap-a", "first")
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "hv-gap-b"})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		send(t, conv.ID.Hex(), "hv-gap-a", "gap")
+		_, err = cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "hv-gap-b"})
+		require.NoError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		send(t, conv.ID.Hex(), "hv-gap-a", "back")
+
+		assert.Equal(t, []string{"back", "first"}, load(t, conv.ID.Hex(), "hv-gap-b"))
+	})
+
+	t.Run("switching the setting needs the update metadata permission", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "hv-set-owner", Role: model.RoleOwner},
+				{ParticipantID: "hv-set-member"},
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = cr.SetHistoryVisibility(t.Context(), conv.ID.Hex(), &data.Actor{ParticipantID: "hv-set-member"}, data.SetHistoryVisibility{
+			HistoryVisibility: model.HistoryVisibilityJoined,
+		})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		updated, err := cr.SetHistoryVisibility(t.Context(), conv.ID.Hex(), &data.Actor{ParticipantID: "hv-set-owner"}, data.SetHistoryVisibility{
+			HistoryVisibility: model.HistoryVisibilityJoined,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.HistoryVisibilityJoined, updated.HistoryVisibility)
+	})
+}
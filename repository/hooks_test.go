@@ -0,0 +1,147 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Hooks(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	hooks := hook.NewRegistry()
+	cr := repository.NewConversation(client.Database("chatsavvy"), repository.WithHooks(hooks))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr, repository.WithHooks(hooks))
+
+	var created []hook.MessageCreated
+	hook.After(hooks, func(ctx context.Context, e hook.MessageCreated) {
+		created = append(created, e)
+	})
+
+	errBlocked := errors.New("blocked")
+	hook.Before(hooks, func(ctx context.Context, e hook.CreateMessage) error {
+		if e.Data.Content == "blocked" {
+			return errBlocked
+		}
+		return nil
+	})
+
+	var edited []hook.MessageEdited
+	hook.After(hooks, func(ctx context.Context, e hook.MessageEdited) {
+		edited = append(edited, e)
+	})
+
+	hook.Before(hooks, func(ctx context.Context, e hook.EditMessage) error {
+		if e.Data.Content == "blocked" {
+			return errBlocked
+		}
+		return nil
+	})
+
+	var reads []hook.ReadMarked
+	hook.After(hooks, func(ctx context.Context, e hook.ReadMarked) {
+		reads = append(reads, e)
+	})
+
+	conv, err := cr.Create(t.Context(), data.CreateConversation{
+		Participants: []data.AddParticipant{
+			{ParticipantID: "hk-a"},
+			{ParticipantID: "hk-b"},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("after hooks receive the written message", func(t *testing.T) {
+		created = nil
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "hk-a"},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+
+		require.Len(t, created, 1)
+		assert.Equal(t, msg.ID.Hex(), created[0].Message.ID.Hex())
+	})
+
+	t.Run("before hooks veto the write", func(t *testing.T) {
+		created = nil
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "hk-a"},
+			Content: "blocked",
+		})
+		assert.ErrorIs(t, err, errBlocked)
+		assert.Nil(t, msg)
+		assert.Empty(t, created)
+
+		messages, err := mr.LoadMessages(t.Context(), data.LoadMessages{ConversationID: conv.ID.Hex(), PerPage: 10})
+		require.NoError(t, err)
+		for _, m := range messages {
+			assert.NotEqual(t, "blocked", m.Content)
+		}
+	})
+
+	t.Run("edit hooks veto and receive the edit", func(t *testing.T) {
+		edited = nil
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "hk-a"},
+			Content: "draft",
+		})
+		require.NoError(t, err)
+
+		_, err = mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "hk-a"},
+			Content:   "blocked",
+		})
+		assert.ErrorIs(t, err, errBlocked)
+		assert.Empty(t, edited)
+
+		_, err = mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "hk-a"},
+			Content:   "final",
+		})
+		require.NoError(t, err)
+
+		require.Len(t, edited, 1)
+		assert.Equal(t, "final", edited[0].Message.Content)
+	})
+
+	t.Run("read hooks only run when the cursor advances", func(t *testing.T) {
+		reads = nil
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "hk-a"},
+			Content: "read me",
+		})
+		require.NoError(t, err)
+
+		for range 2 {
+			_, err = mr.MarkRead(t.Context(), data.MarkRead{
+				ConversationID: conv.ID.Hex(),
+				Participant:    data.ReadParticipant{ParticipantID: "hk-b"},
+				MessageID:      msg.ID.Hex(),
+			})
+			require.NoError(t, err)
+		}
+
+		require.Len(t, reads, 1)
+		assert.Equal(t, "hk-b", reads[0].Data.Participant.ParticipantID)
+	})
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Inbox fetches the page of conversations the participant is a non-deleted member of, each
+// with its unread count and the participant's own read cursor, along with the unread badge
+// counts over the whole inbox. Everything is computed in a single aggregation.
+// Archived conversations are skipped unless requested, and muted conversations do not count
+// towards the badge.
+// Unread messages are counted as in Message.UnreadCount, within the participant's history window.
+// It returns the inbox page or an error.
+func (c Conversation) Inbox(ctx context.Context, d data.LoadInbox) (*model.Inbox, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load inbox data: %w", err)
+	}
+
+	participantID, metadata := d.Participant.ParticipantID, d.Participant.Metadata
+
+	entries := []bson.M{}
+	if !d.IncludeArchived {
+		entries = append(entries, bson.M{"$match": bson.M{"me.archived_at": nil}})
+	}
+	if d.UnreadOnly {
+		entries = append(entries, bson.M{"$match": bson.M{"unread_count": bson.M{"$gt": 0}}})
+	}
+	if d.Cursor != "" {
+		after, err := cursorFilter(d.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, bson.M{"$match": after})
+	}
+	// One extra conversation tells whether there is a next page.
+	entries = append(entries,
+		bson.M{"$sort": bson.D{{Key: "updated_at", Value: -1}, {Key: "_id", Value: -1}}},
+		bson.M{"$limit": int64(d.PerPage) + 1},
+	)
+
+	pipeline := mongo.Pipeline{
+		// The plain match narrows down the conversations with the index, the identity
+		// match below compares the metadata exactly.
+		{{Key: "$match", Value: bson.M{"participants": bson.M{"$elemMatch": bson.M{
+			"participant_id": participantID,
+			"deleted_at":     nil,
+		}}}}},
+		{{Key: "$addFields", Value: bson.M{"me": bson.M{"$arrayElemAt": []any{
+			bson.M{"$filter": bson.M{
+				"input": "$participants",
+				"as":    "p",
+				"cond": bson.M{"$and": []any{
+					identityExpr("$$p", participantID, metadata),
+					bson.M{"$eq": []any{bson.M{"$ifNull": []any{"$$p.deleted_at", nil}}, nil}},
+				}},
+			}},
+			0,
+		}}}}},
+		{{Key: "$match", Value: bson.M{"me": bson.M{"$exists": true}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": c.collections.Messages,
+			"let": bson.M{
+				"conversation_id": bson.M{"$toString": "$_id"},
+				"last_read":       bson.M{"$ifNull": []any{"$me.last_read_message_id", nil}},
+				"visibility":      "$history_visibility",
+				"memberships":     bson.M{"$ifNull": []any{"$me.memberships", []any{}}},
+			},
+			"pipeline": []bson.M{
+				{"$match": bson.M{
+					"tenant_id":  c.tenant,
+					"deleted_at": nil,
+					"$expr": bson.M{"$and": []any{
+						bson.M{"$eq": []any{"$conversation_id", "$$conversation_id"}},
+						bson.M{"$or": []any{
+							bson.M{"$eq": []any{"$$last_read", nil}},
+							bson.M{"$gt": []any{"$_id", "$$last_read"}},
+						}},
+						bson.M{"$not": []any{hiddenForExpr(participantID, metadata)}},
+						bson.M{"$not": []any{identityExpr("$sender", participantID, metadata)}},
+						historyExpr("$$visibility", "$$memberships"),
+					}},
+				}},
+				{"$count": "count"},
+			},
+			"as": "unread",
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"unread_count": bson.M{"$ifNull": []any{bson.M{"$arrayElemAt": []any{"$unread.count", 0}}, 0}},
+		}}},
+		{{Key: "$project", Value: bson.M{"unread": 0}}},
+		{{Key: "$facet", Value: bson.M{
+			"entries": entries,
+			"badge": []bson.M{
+				{"$match": bson.M{"$or": []bson.M{
+					{"me.muted_at": nil},
+					{"me.muted_until": bson.M{"$lte": c.now()}},
+				}}},
+				{"$group": bson.M{
+					"_id":      nil,
+					"messages": bson.M{"$sum": "$unread_count"},
+					"conversations": bson.M{"$sum": bson.M{"$cond": []any{
+						bson.M{"$gt": []any{"$unread_count", 0}}, 1, 0,
+					}}},
+				}},
+			},
+		}}},
+	}
+
+	cursor, err := c.collection(c.collections.Conversations).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate inbox: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Entries []struct {
+			model.Conversation `bson:",inline"`
+			Me                 model.Participant `bson:"me"`
+			UnreadCount        int64             `bson:"unread_count"`
+		} `bson:"entries"`
+		Badge []struct {
+			Messages      int64 `bson:"messages"`
+			Conversations int64 `bson:"conversations"`
+		} `bson:"badge"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode inbox: %w", err)
+	}
+
+	inbox := &model.Inbox{Entries: []model.InboxEntry{}}
+	if len(results) == 0 {
+		return inbox, nil
+	}
+	result := results[0]
+
+	for _, entry := range result.Entries {
+		inbox.Entries = append(inbox.Entries, model.InboxEntry{
+			Conversation: entry.Conversation,
+			Participant:  entry.Me,
+			UnreadCount:  uint(entry.UnreadCount),
+		})
+	}
+
+	if uint(len(inbox.Entries)) > d.PerPage {
+		inbox.Entries = inbox.Entries[:d.PerPage]
+		last := inbox.Entries[d.PerPage-1].Conversation
+		inbox.HasMore = true
+		inbox.NextCursor = chat.EncodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	if len(result.Badge) > 0 {
+		inbox.UnreadMessages = uint(result.Badge[0].Messages)
+		inbox.UnreadConversations = uint(result.Badge[0].Conversations)
+	}
+
+	return inbox, nil
+}
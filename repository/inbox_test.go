@@ -0,0 +1,162 @@
+package repository_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationRepository_Inbox(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConv := func(t *testing.T, participants ...data.AddParticipant) *model.Conversation {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{Participants: participants})
+		require.NoError(t, err)
+		return conv
+	}
+
+	send := func(t *testing.T, convID string, sender data.MessageSender, n int) *model.Message {
+		t.Helper()
+		var msg *model.Message
+		for i := range n {
+			var err error
+			msg, err = mr.Create(t.Context(), convID, data.CreateMessage{
+				Kind:    "general",
+				Sender:  sender,
+				Content: fmt.Sprintf("message %d", i),
+			})
+			require.NoError(t, err)
+		}
+		return msg
+	}
+
+	entryOf := func(t *testing.T, inbox *model.Inbox, convID string) model.InboxEntry {
+		t.Helper()
+		for _, entry := range inbox.Entries {
+			if entry.Conversation.ID.Hex() == convID {
+				return entry
+			}
+		}
+		t.Fatalf("conversation %s not in inbox", convID)
+		return model.InboxEntry{}
+	}
+
+	t.Run("returns unread counts and badge totals", func(t *testing.T) {
+		first := createConv(t, data.AddParticipant{ParticipantID: "ib-count-a"}, data.AddParticipant{ParticipantID: "ib-count-b"})
+		second := createConv(t, data.AddParticipant{ParticipantID: "ib-count-a"}, data.AddParticipant{ParticipantID: "ib-count-c"})
+		read := createConv(t, data.AddParticipant{ParticipantID: "ib-count-a"}, data.AddParticipant{ParticipantID: "ib-count-d"})
+
+		send(t, first.ID.Hex(), data.MessageSender{ParticipantID: "ib-count-b"}, 3)
+		send(t, first.ID.Hex(), data.MessageSender{ParticipantID: "ib-count-a"}, 1)
+		send(t, second.ID.Hex(), data.MessageSender{ParticipantID: "ib-count-c"}, 2)
+		last := send(t, read.ID.Hex(), data.MessageSender{ParticipantID: "ib-count-d"}, 2)
+
+		_, err := mr.MarkRead(t.Context(), data.MarkRead{
+			ConversationID: read.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "ib-count-a"},
+			MessageID:      last.ID.Hex(),
+		})
+		require.NoError(t, err)
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ib-count-a"},
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, inbox.Entries, 3)
+		assert.False(t, inbox.HasMore)
+
+		assert.Equal(t, uint(3), entryOf(t, inbox, first.ID.Hex()).UnreadCount)
+		assert.Equal(t, uint(2), entryOf(t, inbox, second.ID.Hex()).UnreadCount)
+
+		readEntry := entryOf(t, inbox, read.ID.Hex())
+		assert.Equal(t, uint(0), readEntry.UnreadCount)
+		require.NotNil(t, readEntry.Participant.LastReadMessageID)
+		assert.Equal(t, last.ID.Hex(), readEntry.Participant.LastReadMessageID.Hex())
+		require.NotNil(t, readEntry.Conversation.LastMessage)
+		assert.Equal(t, last.ID.Hex(), readEntry.Conversation.LastMessage.ID.Hex())
+
+		assert.Equal(t, uint(5), inbox.UnreadMessages)
+		assert.Equal(t, uint(2), inbox.UnreadConversations)
+	})
+
+	t.Run("filters unread conversations only", func(t *testing.T) {
+		unread := createConv(t, data.AddParticipant{ParticipantID: "ib-unread-a"}, data.AddParticipant{ParticipantID: "ib-unread-b"})
+		quiet := createConv(t, data.AddParticipant{ParticipantID: "ib-unread-a"}, data.AddParticipant{ParticipantID: "ib-unread-c"})
+
+		send(t, unread.ID.Hex(), data.MessageSender{ParticipantID: "ib-unread-b"}, 1)
+		send(t, quiet.ID.Hex(), data.MessageSender{ParticipantID: "ib-unread-a"}, 1)
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ib-unread-a"},
+			UnreadOnly:  true,
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, inbox.Entries, 1)
+		assert.Equal(t, unread.ID.Hex(), inbox.Entries[0].Conversation.ID.Hex())
+		assert.Equal(t, uint(1), inbox.UnreadMessages)
+	})
+
+	t.Run("matches the participant metadata exactly", func(t *testing.T) {
+		createConv(t,
+			data.AddParticipant{ParticipantID: "ib-meta-a", Metadata: map[string]any{"tenant": "x"}},
+			data.AddParticipant{ParticipantID: "ib-meta-b"},
+		)
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ib-meta-a"},
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, inbox.Entries)
+
+		inbox, err = cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ib-meta-a", Metadata: map[string]any{"tenant": "x"}},
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Len(t, inbox.Entries, 1)
+	})
+
+	t.Run("pages with a cursor", func(t *testing.T) {
+		for i := range 3 {
+			conv := createConv(t,
+				data.AddParticipant{ParticipantID: "ib-page-a"},
+				data.AddParticipant{ParticipantID: fmt.Sprintf("ib-page-other-%d", i)},
+			)
+			send(t, conv.ID.Hex(), data.MessageSender{ParticipantID: fmt.Sprintf("ib-page-other-%d", i)}, 1)
+		}
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ib-page-a"},
+			PerPage:     2,
+		})
+		require.NoError(t, err)
+		require.Len(t, inbox.Entries, 2)
+		require.True(t, inbox.HasMore)
+		assert.Equal(t, uint(3), inbox.UnreadMessages)
+
+		next, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ib-page-a"},
+			Cursor:      inbox.NextCursor,
+			PerPage:     2,
+		})
+		require.NoError(t, err)
+		require.Len(t, next.Entries, 1)
+		assert.False(t, next.HasMore)
+		assert.Equal(t, uint(3), next.UnreadMessages)
+	})
+}
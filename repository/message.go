@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"maps"
 	"slices"
-	"time"
 
 	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/chat"
 	"github.com/davesavic/chatsavvy/model"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -17,57 +19,363 @@ import (
 type Message struct {
 	db           *mongo.Database
 	conversation *Conversation
+	transactor   *transactor
+	config
 }
 
-func NewMessage(db *mongo.Database, conversation *Conversation) *Message {
+func NewMessage(db *mongo.Database, conversation *Conversation, opts ...Option) *Message {
+	c := newConfig(opts)
 	return &Message{
 		db:           db,
 		conversation: conversation,
+		transactor:   newTransactor(db, c.logger),
+		config:       c,
 	}
 }
 
 // Create creates a new message in the conversation.
+// The sender must be a non-deleted participant whose role grants the post message permission,
+// unless the message is a system message.
+// If a parent id is provided, the message is created as a thread reply and the parent's
+// reply count and last reply time are updated. Replying to a thread reply files the
+// message under the thread's root.
+// If a reply-to id is provided, a snapshot of the quoted message is embedded in the message.
+// The message, the thread parent and the conversation's last message are written in one
+// transaction when the deployment supports transactions. Participants who archived the
+// conversation get it back.
 // It returns the created message or an error.
 func (m Message) Create(ctx context.Context, conversationID string, d data.CreateMessage) (*model.Message, error) {
-	if err := d.Validate(); err != nil {
+	if err := d.ValidateWith(m.limits); err != nil {
 		return nil, err
 	}
 
+	if err := m.hooks.RunBefore(ctx, hook.CreateMessage{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
 	conversation, err := m.conversation.Find(ctx, conversationID)
-	if err != nil || conversation == nil {
+	if err != nil {
 		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
 
-	now := time.Now()
+	if d.Kind != model.MessageKindSystem {
+		if _, err := chat.RequireParticipant(conversation, d.Sender.ParticipantID, d.Sender.Metadata); err != nil {
+			return nil, err
+		}
+
+		actor := &data.Actor{ParticipantID: d.Sender.ParticipantID, Metadata: d.Sender.Metadata}
+		if _, err := m.conversation.authorize(conversation, actor, model.PermissionPostMessage); err != nil {
+			return nil, err
+		}
+	}
+
+	var parent *model.Message
+	if d.ParentID != nil {
+		parent, err = m.threadParent(ctx, conversation.ID.Hex(), *d.ParentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var quote *model.Quote
+	if d.ReplyToID != nil {
+		quote, err = m.quote(ctx, conversation.ID.Hex(), *d.ReplyToID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := m.now()
 	bsonNow := bson.NewDateTimeFromTime(now)
 
-	res, err := m.db.Collection("messages").InsertOne(ctx, bson.M{
+	document := bson.M{
 		"conversation_id": conversation.ID.Hex(),
 		"sender":          d.Sender,
 		"kind":            d.Kind,
 		"content":         d.Content,
 		"attachments":     d.Attachments,
 		"created_at":      bsonNow,
+	}
+	if parent != nil {
+		document["parent_id"] = parent.ID
+	}
+	if quote != nil {
+		document["reply_to"] = quote
+	}
+
+	var message model.Message
+	err = m.transactor.run(ctx, func(ctx context.Context) error {
+		res, err := m.collection(m.collections.Messages).InsertOne(ctx, document)
+		if err != nil {
+			return fmt.Errorf("failed to insert message: %w", err)
+		}
+
+		err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": res.InsertedID}).Decode(&message)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the message: %w", err)
+		}
+
+		if parent != nil {
+			update := bson.M{
+				"$inc": bson.M{"reply_count": 1},
+				"$max": bson.M{"last_reply_at": bsonNow},
+			}
+			_, err = m.collection(m.collections.Messages).UpdateOne(ctx, bson.M{"_id": parent.ID}, update)
+			if err != nil {
+				return fmt.Errorf("failed to update the thread parent: %w", err)
+			}
+		}
+
+		err = m.conversation.UpdateLastMessage(ctx, conversationID, message)
+		if err != nil {
+			return fmt.Errorf("failed to touch the conversation: %w", err)
+		}
+
+		return m.conversation.Unarchive(ctx, conversationID)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert message: %w", err)
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.MessageCreated{Message: &message})
+
+	return &message, nil
+}
+
+// Edit replaces the content and attachments of a message. Only the original sender may edit,
+// while they are a non-deleted participant of the conversation.
+// The replaced version is appended to the message revisions and, if the message is the
+// conversation's last message, the conversation is kept in sync. Quotes of the message
+// in replies are updated as well.
+// It returns the edited message or an error.
+func (m Message) Edit(ctx context.Context, d data.EditMessage) (*model.Message, error) {
+	if err := d.ValidateWith(m.limits); err != nil {
+		return nil, fmt.Errorf("failed to validate edit message data: %w", err)
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.EditMessage{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
 	}
 
 	var message model.Message
-	err = m.db.Collection("messages").FindOne(ctx, bson.M{"_id": res.InsertedID}).Decode(&message)
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the message: %w", ErrMessageNotFound)
+		}
 		return nil, fmt.Errorf("failed to fetch the message: %w", err)
 	}
 
-	err = m.conversation.UpdateLastMessage(ctx, conversationID, message)
+	conversation, err := m.conversation.Find(ctx, message.ConversationID.Hex())
 	if err != nil {
-		return nil, fmt.Errorf("failed to touch the conversation: %w", err)
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
 
-	return &message, nil
+	if _, err := chat.RequireParticipant(conversation, d.Editor.ParticipantID, d.Editor.Metadata); err != nil {
+		return nil, err
+	}
+
+	if message.Sender.ParticipantID != d.Editor.ParticipantID || !model.MetadataEqual(message.Sender.Metadata, d.Editor.Metadata) {
+		return nil, ErrNotSender
+	}
+
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
+	now := m.now()
+	revision := model.Revision{
+		Content:     message.Content,
+		Attachments: message.Attachments,
+		EditedBy: model.MessageSender{
+			ParticipantID: d.Editor.ParticipantID,
+			Metadata:      d.Editor.Metadata,
+		},
+		EditedAt: now,
+	}
+
+	// Matching the previous edited_at makes concurrent edits fail instead of
+	// recording a revision that was never visible.
+	filter := bson.M{
+		"_id":        messageObID,
+		"edited_at":  message.EditedAt,
+		"deleted_at": nil,
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"content":     d.Content,
+			"attachments": d.Attachments,
+			"edited_at":   bson.NewDateTimeFromTime(now),
+		},
+		"$push": bson.M{
+			"revisions": revision,
+		},
+	}
+
+	res, err := m.collection(m.collections.Messages).UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return nil, ErrConcurrentModification
+	}
+
+	var edited model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&edited)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
+	}
+
+	err = m.conversation.RefreshLastMessage(ctx, edited.ConversationID.Hex(), edited)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync the conversation: %w", err)
+	}
+
+	if err := m.syncQuotes(ctx, edited); err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.MessageEdited{Message: &edited})
+
+	return &edited, nil
+}
+
+// DeleteForEveryone tombstones a message. Only the original sender may delete it.
+// Content, attachments, revisions, reactions and the pin are cleared while deleted_at is kept so
+// clients can render the message as deleted. If the message is the conversation's last
+// message, the conversation's last message becomes the tombstone. Quotes of the message
+// in replies are marked as deleted.
+// Deleting an already deleted message is a no-op.
+// It returns the deleted message or an error.
+func (m Message) DeleteForEveryone(ctx context.Context, d data.DeleteMessageForEveryone) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate delete message data: %w", err)
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	var message model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the message: %w", ErrMessageNotFound)
+		}
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
+	}
+
+	if message.Sender.ParticipantID != d.Sender.ParticipantID || !model.MetadataEqual(message.Sender.Metadata, d.Sender.Metadata) {
+		return nil, ErrNotSender
+	}
+
+	if message.DeletedAt != nil {
+		return &message, nil
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"content":     "",
+			"attachments": []model.Attachment{},
+			"reactions":   []model.Reaction{},
+			"deleted_at":  bson.NewDateTimeFromTime(m.now()),
+		},
+		"$unset": bson.M{
+			"revisions": "",
+			"pinned_at": "",
+			"pinned_by": "",
+		},
+	}
+
+	_, err = m.collection(m.collections.Messages).UpdateOne(ctx, bson.M{"_id": messageObID, "deleted_at": nil}, update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	var deleted model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&deleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
+	}
+
+	err = m.conversation.RefreshLastMessage(ctx, deleted.ConversationID.Hex(), deleted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync the conversation: %w", err)
+	}
+
+	if err := m.syncQuotes(ctx, deleted); err != nil {
+		return nil, err
+	}
+
+	return &deleted, nil
+}
+
+// DeleteForMe hides a message from the participant only. Hidden messages are excluded
+// from Paginate and LoadMessages when the participant is passed as the viewer, and from
+// the participant's UnreadCount. The conversation's last message is shared by all
+// participants and is therefore left untouched.
+func (m Message) DeleteForMe(ctx context.Context, d data.DeleteMessageForMe) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate delete message data: %w", err)
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	var message model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to fetch the message: %w", ErrMessageNotFound)
+		}
+		return fmt.Errorf("failed to fetch the message: %w", err)
+	}
+
+	conv, err := m.conversation.Find(ctx, message.ConversationID.Hex())
+	if err != nil {
+		return fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if conv.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) == nil {
+		return ErrNotParticipant
+	}
+
+	for _, h := range message.HiddenFor {
+		if h.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(h.Metadata, d.Participant.Metadata) {
+			return nil
+		}
+	}
+
+	update := bson.M{
+		"$push": bson.M{
+			"hidden_for": model.HiddenParticipant{
+				ParticipantID: d.Participant.ParticipantID,
+				Metadata:      d.Participant.Metadata,
+			},
+		},
+	}
+
+	_, err = m.collection(m.collections.Messages).UpdateOne(ctx, bson.M{"_id": messageObID}, update)
+	if err != nil {
+		return fmt.Errorf("failed to hide message: %w", err)
+	}
+
+	return nil
 }
 
 // Paginate fetches messages in the conversation.
+// Thread replies are only included if IncludeThreadReplies is set.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded, see historyFilter.
 // It returns the messages and the total number of messages in the conversation or an error.
 func (m Message) Paginate(ctx context.Context, d data.PaginateMessages) ([]model.Message, uint, error) {
 	if err := d.Validate(); err != nil {
@@ -75,14 +383,19 @@ func (m Message) Paginate(ctx context.Context, d data.PaginateMessages) ([]model
 	}
 
 	conv, err := m.conversation.Find(ctx, d.ConversationID)
-	if err != nil || conv == nil {
+	if err != nil {
 		return nil, 0, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
 
+	filter := visibleFilter(conv, d.Viewer)
+	if !d.IncludeThreadReplies {
+		filter["parent_id"] = nil
+	}
+
 	skip := (d.Page - 1) * d.PerPage
 	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetSkip(int64(skip)).SetLimit(int64(d.PerPage))
 
-	cursor, err := m.db.Collection("messages").Find(ctx, bson.M{"conversation_id": d.ConversationID}, opts)
+	cursor, err := m.collection(m.collections.Messages).Find(ctx, filter, opts)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to fetch messages: %w", err)
 	}
@@ -93,7 +406,7 @@ func (m Message) Paginate(ctx context.Context, d data.PaginateMessages) ([]model
 		return nil, 0, fmt.Errorf("failed to decode messages: %w", err)
 	}
 
-	total, err := m.db.Collection("messages").CountDocuments(ctx, bson.M{"conversation_id": d.ConversationID})
+	total, err := m.collection(m.collections.Messages).CountDocuments(ctx, filter)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count messages: %w", err)
 	}
@@ -104,6 +417,9 @@ func (m Message) Paginate(ctx context.Context, d data.PaginateMessages) ([]model
 // LoadMessages fetches messages in the conversation.
 // It differs from Paginate in that it fetches messages older than the last message id provided.
 // If the last message id is nil, it fetches the latest messages.
+// Thread replies are only included if IncludeThreadReplies is set.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded, see historyFilter.
 // It returns the messages or an error.
 func (m Message) LoadMessages(ctx context.Context, d data.LoadMessages) ([]model.Message, error) {
 	if err := d.Validate(); err != nil {
@@ -111,12 +427,13 @@ func (m Message) LoadMessages(ctx context.Context, d data.LoadMessages) ([]model
 	}
 
 	conv, err := m.conversation.Find(ctx, d.ConversationID)
-	if err != nil || conv == nil {
+	if err != nil {
 		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
 
-	filter := bson.M{
-		"conversation_id": d.ConversationID,
+	filter := visibleFilter(conv, d.Viewer)
+	if !d.IncludeThreadReplies {
+		filter["parent_id"] = nil
 	}
 
 	if d.LastMessageID != nil {
@@ -129,7 +446,7 @@ func (m Message) LoadMessages(ctx context.Context, d data.LoadMessages) ([]model
 	}
 
 	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(d.PerPage))
-	cursor, err := m.db.Collection("messages").Find(ctx, filter, opts)
+	cursor, err := m.collection(m.collections.Messages).Find(ctx, filter, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch messages: %w", err)
 	}
@@ -143,22 +460,497 @@ func (m Message) LoadMessages(ctx context.Context, d data.LoadMessages) ([]model
 	return messages, nil
 }
 
+// LoadNewerMessages fetches messages in the conversation newer than the first message id
+// provided, i.e. it walks the timeline forwards where LoadMessages walks it backwards.
+// Thread replies are only included if IncludeThreadReplies is set.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded, see historyFilter.
+// It returns the window of messages, newest first, or an error.
+func (m Message) LoadNewerMessages(ctx context.Context, d data.LoadNewerMessages) (*model.MessageWindow, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	conv, err := m.conversation.Find(ctx, d.ConversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	firstObID, err := bson.ObjectIDFromHex(d.FirstMessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the first message id: %w", err)
+	}
+
+	filter := visibleFilter(conv, d.Viewer)
+	if !d.IncludeThreadReplies {
+		filter["parent_id"] = nil
+	}
+
+	newer, hasNewer, err := m.loadSide(ctx, filter, bson.M{"$gt": firstObID}, 1, d.PerPage)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(newer)
+
+	_, hasOlder, err := m.loadSide(ctx, filter, bson.M{"$lte": firstObID}, -1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.MessageWindow{Messages: newer, HasOlder: hasOlder, HasNewer: hasNewer}, nil
+}
+
+// LoadMessagesAround fetches the message with the given id along with up to Before older
+// and After newer messages, e.g. to open the conversation at a search hit or at the first
+// unread message.
+// Thread replies are only included if IncludeThreadReplies is set.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded, see historyFilter.
+// It returns the window of messages, newest first, or an error.
+func (m Message) LoadMessagesAround(ctx context.Context, d data.LoadMessagesAround) (*model.MessageWindow, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	conv, err := m.conversation.Find(ctx, d.ConversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	filter := visibleFilter(conv, d.Viewer)
+	if !d.IncludeThreadReplies {
+		filter["parent_id"] = nil
+	}
+
+	anchor, _, err := m.loadSide(ctx, filter, messageObID, -1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(anchor) == 0 {
+		return nil, fmt.Errorf("%w in conversation", ErrMessageNotFound)
+	}
+
+	newer, hasNewer, err := m.loadSide(ctx, filter, bson.M{"$gt": messageObID}, 1, d.After)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(newer)
+
+	older, hasOlder, err := m.loadSide(ctx, filter, bson.M{"$lt": messageObID}, -1, d.Before)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]model.Message, 0, len(newer)+1+len(older))
+	messages = append(messages, newer...)
+	messages = append(messages, anchor...)
+	messages = append(messages, older...)
+
+	return &model.MessageWindow{Messages: messages, HasOlder: hasOlder, HasNewer: hasNewer}, nil
+}
+
+// loadSide fetches up to limit messages matching the filter and the _id condition, walking
+// the timeline in the given direction, 1 for newer and -1 for older.
+// It reports whether more messages follow the ones returned.
+func (m Message) loadSide(ctx context.Context, filter bson.M, id any, direction int, limit uint) ([]model.Message, bool, error) {
+	sideFilter := maps.Clone(filter)
+	sideFilter["_id"] = id
+
+	// One extra message tells whether there are more.
+	opts := options.Find().SetSort(bson.M{"_id": direction}).SetLimit(int64(limit) + 1)
+	cursor, err := m.collection(m.collections.Messages).Find(ctx, sideFilter, opts)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	messages := []model.Message{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, false, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	if uint(len(messages)) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+// LoadThread fetches the replies in a message thread.
+// Like LoadMessages, it fetches replies older than the last message id provided,
+// or the latest replies if the last message id is nil.
+// If a viewer is provided, replies the viewer deleted for themselves and replies outside
+// the viewer's history window are excluded, see historyFilter.
+// It returns the replies or an error.
+func (m Message) LoadThread(ctx context.Context, d data.LoadThread) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	conv, err := m.conversation.Find(ctx, d.ConversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	parent, err := m.threadParent(ctx, d.ConversationID, d.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := visibleFilter(conv, d.Viewer)
+	filter["parent_id"] = parent.ID
+
+	if d.LastMessageID != nil {
+		messageObID, err := bson.ObjectIDFromHex(*d.LastMessageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the last message id: %w", err)
+		}
+
+		filter["_id"] = bson.M{"$lt": messageObID}
+	}
+
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(int64(d.PerPage))
+	cursor, err := m.collection(m.collections.Messages).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var messages []model.Message
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// threadParent fetches the root message of the thread the given message belongs to.
+// The message must belong to the conversation.
+func (m Message) threadParent(ctx context.Context, conversationID string, messageID string) (*model.Message, error) {
+	messageObID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the parent message id: %w", err)
+	}
+
+	var parent model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&parent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the parent message: %w", ErrMessageNotFound)
+		}
+		return nil, fmt.Errorf("failed to fetch the parent message: %w", err)
+	}
+
+	if parent.ConversationID.Hex() != conversationID {
+		return nil, fmt.Errorf("parent message does not belong to conversation: %w", ErrMessageNotFound)
+	}
+
+	if parent.ParentID != nil {
+		return m.threadParent(ctx, conversationID, parent.ParentID.Hex())
+	}
+
+	return &parent, nil
+}
+
+// quote builds a snapshot of the message being replied to.
+// The message must belong to the conversation and must not be deleted.
+func (m Message) quote(ctx context.Context, conversationID string, messageID string) (*model.Quote, error) {
+	messageObID, err := bson.ObjectIDFromHex(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the reply to message id: %w", err)
+	}
+
+	var quoted model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&quoted)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the reply to message: %w", ErrMessageNotFound)
+		}
+		return nil, fmt.Errorf("failed to fetch the reply to message: %w", err)
+	}
+
+	if quoted.ConversationID.Hex() != conversationID {
+		return nil, fmt.Errorf("reply to message does not belong to conversation: %w", ErrMessageNotFound)
+	}
+
+	if quoted.DeletedAt != nil {
+		return nil, fmt.Errorf("reply to %w", ErrMessageDeleted)
+	}
+
+	quote := chat.QuoteOf(quoted)
+	return &quote, nil
+}
+
+// syncQuotes refreshes the snapshots of the message embedded in replies,
+// including a reply that is cached as a conversation's last message.
+func (m Message) syncQuotes(ctx context.Context, message model.Message) error {
+	quote := chat.QuoteOf(message)
+
+	_, err := m.collection(m.collections.Messages).UpdateMany(ctx,
+		bson.M{"reply_to.message_id": message.ID},
+		bson.M{"$set": bson.M{"reply_to": quote}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to sync quotes: %w", err)
+	}
+
+	_, err = m.collection(m.collections.Conversations).UpdateMany(ctx,
+		bson.M{"last_message.reply_to.message_id": message.ID},
+		bson.M{"$set": bson.M{"last_message.reply_to": quote}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to sync quotes: %w", err)
+	}
+
+	return nil
+}
+
+// Search runs a full-text query over the content and attachment file names of the messages
+// in the conversations the participant is a non-deleted member of.
+// Messages deleted for everyone or deleted by the participant for themselves are excluded,
+// as are messages outside the participant's history window.
+// It returns the matches ordered by relevance along with their conversation, and the total
+// number of matches or an error.
+func (m Message) Search(ctx context.Context, d data.SearchMessages) ([]model.SearchResult, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate search messages data: %w", err)
+	}
+
+	cursor, err := m.collection(m.collections.Conversations).Find(ctx, bson.M{
+		"participants": bson.M{"$elemMatch": bson.M{
+			"participant_id": d.Participant.ParticipantID,
+			"deleted_at":     nil,
+		}},
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+
+	var conversations []model.Conversation
+	if err = cursor.All(ctx, &conversations); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode conversations: %w", err)
+	}
+
+	// Conversations where the participant sees the whole history are matched together,
+	// the others each within the participant's history window.
+	memberOf := make(map[string]model.Conversation, len(conversations))
+	conversationIDs := make([]string, 0, len(conversations))
+	scopes := []bson.M{}
+	for _, conversation := range conversations {
+		participant := conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata)
+		if participant == nil {
+			continue
+		}
+		memberOf[conversation.ID.Hex()] = conversation
+
+		if history := historyFilter(&conversation, participant); history != nil {
+			scopes = append(scopes, bson.M{"conversation_id": conversation.ID.Hex(), "$and": []bson.M{history}})
+			continue
+		}
+		conversationIDs = append(conversationIDs, conversation.ID.Hex())
+	}
+
+	if len(memberOf) == 0 {
+		return []model.SearchResult{}, 0, nil
+	}
+	if len(conversationIDs) > 0 {
+		scopes = append(scopes, bson.M{"conversation_id": bson.M{"$in": conversationIDs}})
+	}
+
+	filter := bson.M{
+		"$text":      bson.M{"$search": d.Query},
+		"$or":        scopes,
+		"deleted_at": nil,
+		"$expr":      bson.M{"$not": []any{hiddenForExpr(d.Participant.ParticipantID, d.Participant.Metadata)}},
+	}
+
+	skip := (d.Page - 1) * d.PerPage
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(d.PerPage))
+
+	cursor, err = m.collection(m.collections.Messages).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	var matches []struct {
+		model.Message `bson:",inline"`
+		Score         float64 `bson:"score"`
+	}
+	if err = cursor.All(ctx, &matches); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	total, err := m.collection(m.collections.Messages).CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+
+	results := make([]model.SearchResult, 0, len(matches))
+	for _, match := range matches {
+		results = append(results, model.SearchResult{
+			Message:      match.Message,
+			Conversation: memberOf[match.ConversationID.Hex()],
+			Score:        match.Score,
+		})
+	}
+
+	return results, uint(total), nil
+}
+
+// Pin pins a message in its conversation. The actor needs the pin message permission.
+// Pinning a pinned message keeps the original pin.
+// It returns the pinned message or an error.
+func (m Message) Pin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error) {
+	return m.setPinned(ctx, actor, d, true)
+}
+
+// Unpin unpins a message. The actor needs the pin message permission.
+// It returns the unpinned message or an error.
+func (m Message) Unpin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error) {
+	return m.setPinned(ctx, actor, d, false)
+}
+
+func (m Message) setPinned(ctx context.Context, actor *data.Actor, d data.PinMessage, pinned bool) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate pin message data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	var message model.Message
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the message: %w", ErrMessageNotFound)
+		}
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
+	}
+
+	conversation, err := m.conversation.Find(ctx, message.ConversationID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if _, err := m.conversation.authorize(conversation, actor, model.PermissionPinMessage); err != nil {
+		return nil, err
+	}
+
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
+	if pinned == (message.PinnedAt != nil) {
+		return &message, nil
+	}
+
+	var update bson.M
+	if pinned {
+		var pinnedBy *model.MessageSender
+		if actor != nil {
+			pinnedBy = &model.MessageSender{ParticipantID: actor.ParticipantID, Metadata: actor.Metadata}
+		}
+		update = bson.M{"$set": bson.M{
+			"pinned_at": bson.NewDateTimeFromTime(m.now()),
+			"pinned_by": pinnedBy,
+		}}
+	} else {
+		update = bson.M{"$unset": bson.M{"pinned_at": "", "pinned_by": ""}}
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updated model.Message
+	err = m.collection(m.collections.Messages).FindOneAndUpdate(ctx, bson.M{"_id": messageObID}, update, opts).Decode(&updated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update the message: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// LoadPinned fetches the pinned messages of the conversation, most recently pinned first.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded, see historyFilter.
+// It returns the messages or an error.
+func (m Message) LoadPinned(ctx context.Context, d data.LoadPinnedMessages) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	conv, err := m.conversation.Find(ctx, d.ConversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	filter := visibleFilter(conv, d.Viewer)
+	filter["pinned_at"] = bson.M{"$ne": nil}
+
+	cursor, err := m.collection(m.collections.Messages).Find(ctx, filter, options.Find().SetSort(bson.M{"pinned_at": -1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pinned messages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	messages := []model.Message{}
+	if err = cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %w", err)
+	}
+
+	return messages, nil
+}
+
 // ToggleReaction toggles a reaction on the message for the participant.
+// The participant must be a non-deleted participant of the message's conversation.
 // It returns the updated message or an error.
 func (m Message) ToggleReaction(ctx context.Context, d data.ToggleReaction) (*model.Message, error) {
 	if err := d.Validate(); err != nil {
 		return nil, err
 	}
+
+	if err := m.hooks.RunBefore(ctx, hook.ToggleReaction{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
 	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse the message id: %w", err)
 	}
 	var message model.Message
-	err = m.db.Collection("messages").FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the message: %w", ErrMessageNotFound)
+		}
 		return nil, fmt.Errorf("failed to fetch the message: %w", err)
 	}
 
+	conversation, err := m.conversation.Find(ctx, message.ConversationID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if _, err := chat.RequireParticipant(conversation, d.Participant.ParticipantID, d.Participant.Metadata); err != nil {
+		return nil, err
+	}
+
+	if message.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+
 	reactionIndex := slices.IndexFunc(message.Reactions, func(r model.Reaction) bool {
 		return r.Emoji == d.Emoji
 	})
@@ -167,7 +959,7 @@ func (m Message) ToggleReaction(ctx context.Context, d data.ToggleReaction) (*mo
 		reaction := message.Reactions[reactionIndex]
 
 		participantIndex := slices.IndexFunc(reaction.Participants, func(p model.ReactionParticipant) bool {
-			return p.ParticipantID == d.Participant.ParticipantID && mapsEqual(p.Metadata, d.Participant.Metadata)
+			return p.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(p.Metadata, d.Participant.Metadata)
 		})
 
 		if participantIndex == -1 {
@@ -204,13 +996,16 @@ func (m Message) ToggleReaction(ctx context.Context, d data.ToggleReaction) (*mo
 			"reactions": message.Reactions,
 		},
 	}
-	res, err := m.db.Collection("messages").UpdateOne(ctx, bson.M{"_id": messageObID}, update)
+	res, err := m.collection(m.collections.Messages).UpdateOne(ctx, bson.M{"_id": messageObID}, update)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update message: %w", err)
 	}
 	if res.MatchedCount == 0 {
-		return nil, fmt.Errorf("message not found")
+		return nil, ErrMessageNotFound
 	}
+
+	m.hooks.RunAfter(ctx, hook.ReactionToggled{Message: &message, Data: d})
+
 	return &message, nil
 }
 
@@ -222,6 +1017,10 @@ func (m Message) MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversa
 		return nil, fmt.Errorf("failed to validate mark read data: %w", err)
 	}
 
+	if err := m.hooks.RunBefore(ctx, hook.MarkRead{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
 	conversationObID, err := bson.ObjectIDFromHex(d.ConversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse conversation id: %w", err)
@@ -233,35 +1032,35 @@ func (m Message) MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversa
 	}
 
 	var message model.Message
-	err = m.db.Collection("messages").FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
+	err = m.collection(m.collections.Messages).FindOne(ctx, bson.M{"_id": messageObID}).Decode(&message)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to fetch the message: %w", ErrMessageNotFound)
+		}
 		return nil, fmt.Errorf("failed to fetch the message: %w", err)
 	}
 
 	if message.ConversationID.Hex() != d.ConversationID {
-		return nil, fmt.Errorf("message does not belong to conversation")
+		return nil, fmt.Errorf("message does not belong to conversation: %w", ErrMessageNotFound)
 	}
 
 	conv, err := m.conversation.Find(ctx, d.ConversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
-	if conv == nil {
-		return nil, fmt.Errorf("conversation not found")
-	}
 
 	var matched bool
 	for _, p := range conv.Participants {
 		if p.DeletedAt != nil {
 			continue
 		}
-		if p.ParticipantID == d.Participant.ParticipantID && mapsEqual(p.Metadata, d.Participant.Metadata) {
+		if p.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(p.Metadata, d.Participant.Metadata) {
 			matched = true
 			break
 		}
 	}
 	if !matched {
-		return nil, fmt.Errorf("participant not found in conversation")
+		return nil, ErrNotParticipant
 	}
 
 	update := bson.M{
@@ -283,7 +1082,7 @@ func (m Message) MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversa
 		},
 	}
 
-	res, err := m.db.Collection("conversations").UpdateOne(
+	res, err := m.collection(m.collections.Conversations).UpdateOne(
 		ctx,
 		bson.M{"_id": conversationObID},
 		update,
@@ -293,33 +1092,34 @@ func (m Message) MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversa
 		return nil, fmt.Errorf("failed to mark read: %w", err)
 	}
 	if res.MatchedCount == 0 {
-		return nil, fmt.Errorf("conversation not found")
+		return nil, ErrConversationNotFound
 	}
 
 	updated, err := m.conversation.Find(ctx, d.ConversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
-	if updated == nil {
-		return nil, fmt.Errorf("conversation not found")
-	}
 
 	if res.ModifiedCount == 0 {
 		var found *model.Participant
 		for i, p := range updated.Participants {
-			if p.ParticipantID == d.Participant.ParticipantID && mapsEqual(p.Metadata, d.Participant.Metadata) {
+			if p.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(p.Metadata, d.Participant.Metadata) {
 				found = &updated.Participants[i]
 				break
 			}
 		}
 		if found == nil || found.DeletedAt != nil {
-			return nil, fmt.Errorf("participant not found in conversation")
+			return nil, ErrNotParticipant
 		}
 		if found.LastReadMessageID == nil || bytes.Compare((*found.LastReadMessageID)[:], messageObID[:]) < 0 {
-			return nil, fmt.Errorf("participant not found in conversation")
+			return nil, ErrNotParticipant
 		}
 	}
 
+	if res.ModifiedCount > 0 {
+		m.hooks.RunAfter(ctx, hook.ReadMarked{Conversation: updated, Data: d})
+	}
+
 	return updated, nil
 }
 
@@ -332,16 +1132,13 @@ func (m Message) MarkAllRead(ctx context.Context, d data.MarkAllRead) (*model.Co
 
 	opts := options.FindOne().SetSort(bson.M{"_id": -1})
 	var latest model.Message
-	err := m.db.Collection("messages").FindOne(ctx, bson.M{"conversation_id": d.ConversationID}, opts).Decode(&latest)
+	err := m.collection(m.collections.Messages).FindOne(ctx, bson.M{"conversation_id": d.ConversationID}, opts).Decode(&latest)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			conv, ferr := m.conversation.Find(ctx, d.ConversationID)
 			if ferr != nil {
 				return nil, fmt.Errorf("failed to fetch the conversation: %w", ferr)
 			}
-			if conv == nil {
-				return nil, fmt.Errorf("conversation not found")
-			}
 			return conv, nil
 		}
 		return nil, fmt.Errorf("failed to fetch the latest message: %w", err)
@@ -375,9 +1172,6 @@ func (m Message) ReadersOf(ctx context.Context, d data.ReadersOf) ([]model.Parti
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
-	if conv == nil {
-		return nil, fmt.Errorf("conversation not found")
-	}
 
 	readers := make([]model.Participant, 0)
 	for _, p := range conv.Participants {
@@ -397,6 +1191,8 @@ func (m Message) ReadersOf(ctx context.Context, d data.ReadersOf) ([]model.Parti
 
 // UnreadCount returns the number of unread messages in the conversation for the participant.
 // If the participant has never read any message, all messages are considered unread.
+// Messages deleted for everyone or deleted by the participant for themselves are not counted,
+// nor are messages outside the participant's history window, see historyFilter.
 func (m Message) UnreadCount(ctx context.Context, d data.UnreadCount) (uint, error) {
 	if err := d.Validate(); err != nil {
 		return 0, fmt.Errorf("failed to validate unread count data: %w", err)
@@ -406,25 +1202,29 @@ func (m Message) UnreadCount(ctx context.Context, d data.UnreadCount) (uint, err
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch the conversation: %w", err)
 	}
-	if conv == nil {
-		return 0, fmt.Errorf("conversation not found")
-	}
 
 	var found *model.Participant
 	for i, p := range conv.Participants {
-		if p.ParticipantID == d.Participant.ParticipantID && mapsEqual(p.Metadata, d.Participant.Metadata) {
+		if p.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(p.Metadata, d.Participant.Metadata) {
 			found = &conv.Participants[i]
 			break
 		}
 	}
 	if found == nil || found.DeletedAt != nil {
-		return 0, fmt.Errorf("participant not found in conversation")
+		return 0, ErrNotParticipant
 	}
 
-	filter := bson.M{"conversation_id": d.ConversationID}
+	filter := bson.M{
+		"conversation_id": d.ConversationID,
+		"deleted_at":      nil,
+		"$expr":           bson.M{"$not": []any{hiddenForExpr(d.Participant.ParticipantID, d.Participant.Metadata)}},
+	}
 	if found.LastReadMessageID != nil {
 		filter["_id"] = bson.M{"$gt": *found.LastReadMessageID}
 	}
+	if history := historyFilter(conv, found); history != nil {
+		filter["$and"] = []bson.M{history}
+	}
 
 	// Exclude messages authored by the caller. Mirrors mapsEqual semantics:
 	// key-count match via $objectToArray+$size treats null/missing/{} as equal,
@@ -447,7 +1247,7 @@ func (m Message) UnreadCount(ctx context.Context, d data.UnreadCount) (uint, err
 	}})
 	filter["$nor"] = []bson.M{{"$and": selfClauses}}
 
-	count, err := m.db.Collection("messages").CountDocuments(ctx, filter)
+	count, err := m.collection(m.collections.Messages).CountDocuments(ctx, filter)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count unread messages: %w", err)
 	}
@@ -455,37 +1255,50 @@ func (m Message) UnreadCount(ctx context.Context, d data.UnreadCount) (uint, err
 	return uint(count), nil
 }
 
-func mapsEqual(a, b map[string]any) bool {
-	if len(a) != len(b) {
-		return false
+// visibleFilter returns the filter for messages in the conversation that are visible to the viewer.
+// A nil viewer sees every message. A viewer that is not a participant of the conversation
+// only has the messages they deleted for themselves excluded.
+func visibleFilter(conv *model.Conversation, viewer *data.ReadParticipant) bson.M {
+	filter := bson.M{"conversation_id": conv.ID.Hex()}
+	if viewer == nil {
+		return filter
 	}
-	for key, va := range a {
-		vb, ok := b[key]
-		if !ok || !valuesEqual(va, vb) {
-			return false
+
+	filter["$expr"] = bson.M{"$not": []any{hiddenForExpr(viewer.ParticipantID, viewer.Metadata)}}
+	if p := conv.Participant(viewer.ParticipantID, viewer.Metadata); p != nil {
+		if history := historyFilter(conv, p); history != nil {
+			filter["$and"] = []bson.M{history}
 		}
 	}
-	return true
+	return filter
+}
+
+// hiddenForExpr returns an aggregation expression that is true when the message has been
+// deleted for the participant.
+func hiddenForExpr(participantID string, metadata map[string]any) bson.M {
+	return bson.M{"$anyElementTrue": []any{
+		bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": []any{"$hidden_for", []any{}}},
+			"as":    "h",
+			"in":    identityExpr("$$h", participantID, metadata),
+		}},
+	}}
 }
 
-func valuesEqual(a, b any) bool {
-	switch va := a.(type) {
-	case int:
-		vb, ok := b.(int)
-		return ok && va == vb
-	case float64:
-		vb, ok := b.(float64)
-		return ok && va == vb
-	case string:
-		vb, ok := b.(string)
-		return ok && va == vb
-	case bool:
-		vb, ok := b.(bool)
-		return ok && va == vb
-	case map[string]any:
-		vb, ok := b.(map[string]any)
-		return ok && mapsEqual(va, vb)
-	default:
-		return false
+// identityExpr returns an aggregation expression that is true when the participant_id and
+// metadata under the field path are the participant's. Identity matching mirrors
+// model.MetadataEqual in the same way as the self-authored exclusion in UnreadCount.
+func identityExpr(field string, participantID string, metadata map[string]any) bson.M {
+	conditions := []any{
+		bson.M{"$eq": []any{field + ".participant_id", participantID}},
+		bson.M{"$eq": []any{
+			bson.M{"$size": bson.M{"$objectToArray": bson.M{"$ifNull": []any{field + ".metadata", bson.M{}}}}},
+			len(metadata),
+		}},
+	}
+	for k, v := range metadata {
+		conditions = append(conditions, bson.M{"$eq": []any{field + ".metadata." + k, v}})
 	}
+
+	return bson.M{"$and": conditions}
 }
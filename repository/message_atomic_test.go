@@ -0,0 +1,111 @@
+package repository_test
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_LastMessageNeverRegresses(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConv := func(t *testing.T, userA, userB string) *model.Conversation {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+		return conv
+	}
+
+	t.Run("an older message does not replace the last message", func(t *testing.T) {
+		conv := createConv(t, "at-old-a", "at-old-b")
+
+		older, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "at-old-a"},
+			Content: "older",
+		})
+		require.NoError(t, err)
+
+		newer, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "at-old-b"},
+			Content: "$newer",
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, cr.UpdateLastMessage(t.Context(), conv.ID.Hex(), *older))
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, newer.ID.Hex(), updated.LastMessage.ID.Hex())
+		assert.Equal(t, "$newer", updated.LastMessage.Content)
+		assert.WithinDuration(t, newer.CreatedAt, updated.UpdatedAt, time.Millisecond)
+	})
+
+	t.Run("concurrent creates keep the latest message", func(t *testing.T) {
+		conv := createConv(t, "at-race-a", "at-race-b")
+
+		var wg sync.WaitGroup
+		messages := make([]*model.Message, 10)
+		for i := range messages {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+					Kind:    "general",
+					Sender:  data.MessageSender{ParticipantID: "at-race-a"},
+					Content: fmt.Sprintf("m%d", i),
+				})
+				assert.NoError(t, err)
+				messages[i] = msg
+			}()
+		}
+		wg.Wait()
+		for _, msg := range messages {
+			require.NotNil(t, msg)
+		}
+
+		latest := messages[0]
+		for _, msg := range messages[1:] {
+			if msg.ID.Hex() > latest.ID.Hex() {
+				latest = msg
+			}
+		}
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, latest.ID.Hex(), updated.LastMessage.ID.Hex())
+	})
+
+	t.Run("a missing conversation is reported", func(t *testing.T) {
+		conv := createConv(t, "at-missing-a", "at-missing-b")
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "at-missing-a"},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+
+		err = cr.UpdateLastMessage(t.Context(), msg.ID.Hex(), *msg)
+		assert.ErrorIs(t, err, repository.ErrConversationNotFound)
+	})
+}
@@ -0,0 +1,329 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_DeleteForEveryone(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConvWith2Messages := func(t *testing.T, userA, userB string) (*model.Conversation, *model.Message, *model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+
+		m1, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:        "general",
+			Sender:      data.MessageSender{ParticipantID: userA},
+			Content:     "m1",
+			Attachments: []data.CreateAttachment{{Kind: "file"}},
+		})
+		require.NoError(t, err)
+
+		m2, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: userA},
+			Content: "m2",
+		})
+		require.NoError(t, err)
+
+		return conv, m1, m2
+	}
+
+	t.Run("tombstones the message", func(t *testing.T) {
+		_, m1, _ := createConvWith2Messages(t, "dfe-ok-a", "dfe-ok-b")
+
+		_, err := mr.ToggleReaction(t.Context(), data.ToggleReaction{
+			MessageID:   m1.ID.Hex(),
+			Emoji:       ":+1:",
+			Participant: data.ReactionParticipant{ParticipantID: "dfe-ok-b"},
+		})
+		require.NoError(t, err)
+
+		deleted, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-ok-a"},
+		})
+		require.NoError(t, err)
+
+		require.NotNil(t, deleted.DeletedAt)
+		assert.Empty(t, deleted.Content)
+		assert.Empty(t, deleted.Attachments)
+		assert.Empty(t, deleted.Reactions)
+	})
+
+	t.Run("keeps the tombstone in the timeline", func(t *testing.T) {
+		conv, m1, _ := createConvWith2Messages(t, "dfe-tl-a", "dfe-tl-b")
+
+		_, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-tl-a"},
+		})
+		require.NoError(t, err)
+
+		messages, total, err := mr.Paginate(t.Context(), data.PaginateMessages{
+			ConversationID: conv.ID.Hex(),
+			Page:           1,
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(2), total)
+		require.Len(t, messages, 2)
+		for _, msg := range messages {
+			assert.Equal(t, msg.ID == m1.ID, msg.DeletedAt != nil)
+		}
+	})
+
+	t.Run("replaces the last message with the tombstone", func(t *testing.T) {
+		conv, _, m2 := createConvWith2Messages(t, "dfe-last-a", "dfe-last-b")
+
+		_, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m2.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-last-a"},
+		})
+		require.NoError(t, err)
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		require.NotNil(t, updated.LastMessage)
+		assert.Equal(t, m2.ID.Hex(), updated.LastMessage.ID.Hex())
+		assert.NotNil(t, updated.LastMessage.DeletedAt)
+		assert.Empty(t, updated.LastMessage.Content)
+	})
+
+	t.Run("deleted messages are not unread", func(t *testing.T) {
+		conv, m1, _ := createConvWith2Messages(t, "dfe-unread-a", "dfe-unread-b")
+
+		_, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-unread-a"},
+		})
+		require.NoError(t, err)
+
+		count, err := mr.UnreadCount(t.Context(), data.UnreadCount{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "dfe-unread-b"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), count)
+	})
+
+	t.Run("deleted messages can no longer be edited or reacted to", func(t *testing.T) {
+		_, m1, _ := createConvWith2Messages(t, "dfe-frozen-a", "dfe-frozen-b")
+
+		_, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-frozen-a"},
+		})
+		require.NoError(t, err)
+
+		_, err = mr.Edit(t.Context(), data.EditMessage{
+			MessageID: m1.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "dfe-frozen-a"},
+			Content:   "resurrected",
+		})
+		assert.Error(t, err)
+
+		_, err = mr.ToggleReaction(t.Context(), data.ToggleReaction{
+			MessageID:   m1.ID.Hex(),
+			Emoji:       ":+1:",
+			Participant: data.ReactionParticipant{ParticipantID: "dfe-frozen-b"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("deleting twice is a no-op", func(t *testing.T) {
+		_, m1, _ := createConvWith2Messages(t, "dfe-twice-a", "dfe-twice-b")
+
+		first, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-twice-a"},
+		})
+		require.NoError(t, err)
+
+		second, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-twice-a"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, *first.DeletedAt, *second.DeletedAt)
+	})
+
+	t.Run("rejects deletion by anyone but the sender", func(t *testing.T) {
+		_, m1, _ := createConvWith2Messages(t, "dfe-other-a", "dfe-other-b")
+
+		deleted, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: m1.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "dfe-other-b"},
+		})
+		assert.Error(t, err)
+		assert.Nil(t, deleted)
+	})
+}
+
+func TestMessageRepository_DeleteForMe(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	businessA := map[string]any{"business_id": "a"}
+
+	createConvWith2Messages := func(t *testing.T, userA, userB string) (*model.Conversation, *model.Message, *model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB, Metadata: businessA},
+			},
+		})
+		require.NoError(t, err)
+
+		m1, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: userA},
+			Content: "m1",
+		})
+		require.NoError(t, err)
+
+		m2, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: userA},
+			Content: "m2",
+		})
+		require.NoError(t, err)
+
+		return conv, m1, m2
+	}
+
+	t.Run("hides the message from the participant only", func(t *testing.T) {
+		conv, m1, m2 := createConvWith2Messages(t, "dfm-ok-a", "dfm-ok-b")
+
+		err := mr.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+			MessageID:   m1.ID.Hex(),
+			Participant: data.ReadParticipant{ParticipantID: "dfm-ok-b", Metadata: businessA},
+		})
+		require.NoError(t, err)
+
+		messages, total, err := mr.Paginate(t.Context(), data.PaginateMessages{
+			ConversationID: conv.ID.Hex(),
+			Viewer:         &data.ReadParticipant{ParticipantID: "dfm-ok-b", Metadata: businessA},
+			Page:           1,
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), total)
+		require.Len(t, messages, 1)
+		assert.Equal(t, m2.ID.Hex(), messages[0].ID.Hex())
+
+		loaded, err := mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: conv.ID.Hex(),
+			Viewer:         &data.ReadParticipant{ParticipantID: "dfm-ok-b", Metadata: businessA},
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		require.Len(t, loaded, 1)
+		assert.Equal(t, m2.ID.Hex(), loaded[0].ID.Hex())
+
+		loaded, err = mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: conv.ID.Hex(),
+			Viewer:         &data.ReadParticipant{ParticipantID: "dfm-ok-a"},
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		assert.Len(t, loaded, 2)
+	})
+
+	t.Run("matches the participant metadata exactly", func(t *testing.T) {
+		conv, m1, _ := createConvWith2Messages(t, "dfm-meta-a", "dfm-meta-b")
+
+		err := mr.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+			MessageID:   m1.ID.Hex(),
+			Participant: data.ReadParticipant{ParticipantID: "dfm-meta-b", Metadata: businessA},
+		})
+		require.NoError(t, err)
+
+		loaded, err := mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: conv.ID.Hex(),
+			Viewer:         &data.ReadParticipant{ParticipantID: "dfm-meta-b"},
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		assert.Len(t, loaded, 2)
+	})
+
+	t.Run("hidden messages are not unread and the last message is untouched", func(t *testing.T) {
+		conv, _, m2 := createConvWith2Messages(t, "dfm-unread-a", "dfm-unread-b")
+
+		err := mr.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+			MessageID:   m2.ID.Hex(),
+			Participant: data.ReadParticipant{ParticipantID: "dfm-unread-b", Metadata: businessA},
+		})
+		require.NoError(t, err)
+
+		count, err := mr.UnreadCount(t.Context(), data.UnreadCount{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "dfm-unread-b", Metadata: businessA},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), count)
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, m2.ID.Hex(), updated.LastMessage.ID.Hex())
+		assert.Equal(t, "m2", updated.LastMessage.Content)
+	})
+
+	t.Run("hiding twice is a no-op", func(t *testing.T) {
+		conv, m1, _ := createConvWith2Messages(t, "dfm-twice-a", "dfm-twice-b")
+
+		for range 2 {
+			err := mr.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+				MessageID:   m1.ID.Hex(),
+				Participant: data.ReadParticipant{ParticipantID: "dfm-twice-a"},
+			})
+			require.NoError(t, err)
+		}
+
+		messages, _, err := mr.Paginate(t.Context(), data.PaginateMessages{
+			ConversationID: conv.ID.Hex(),
+			Page:           1,
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		require.Len(t, messages, 2)
+		for _, msg := range messages {
+			if msg.ID == m1.ID {
+				assert.Len(t, msg.HiddenFor, 1)
+			}
+		}
+	})
+
+	t.Run("rejects participants outside the conversation", func(t *testing.T) {
+		_, m1, _ := createConvWith2Messages(t, "dfm-out-a", "dfm-out-b")
+
+		err := mr.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+			MessageID:   m1.ID.Hex(),
+			Participant: data.ReadParticipant{ParticipantID: "dfm-out-stranger"},
+		})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,164 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Edit(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConvWithMessage := func(t *testing.T, userA, userB string) (*model.Conversation, *model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: userA},
+			Content: "helo",
+		})
+		require.NoError(t, err)
+
+		return conv, msg
+	}
+
+	t.Run("sender edits content and a revision is recorded", func(t *testing.T) {
+		_, msg := createConvWithMessage(t, "ed-ok-a", "ed-ok-b")
+
+		edited, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "ed-ok-a"},
+			Content:   "hello",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "hello", edited.Content)
+		require.NotNil(t, edited.EditedAt)
+		require.Len(t, edited.Revisions, 1)
+		assert.Equal(t, "helo", edited.Revisions[0].Content)
+		assert.Equal(t, "ed-ok-a", edited.Revisions[0].EditedBy.ParticipantID)
+		assert.WithinDuration(t, *edited.EditedAt, edited.Revisions[0].EditedAt, time.Millisecond)
+	})
+
+	t.Run("every edit appends a revision", func(t *testing.T) {
+		_, msg := createConvWithMessage(t, "ed-many-a", "ed-many-b")
+
+		for _, content := range []string{"one", "two"} {
+			_, err := mr.Edit(t.Context(), data.EditMessage{
+				MessageID: msg.ID.Hex(),
+				Editor:    data.MessageSender{ParticipantID: "ed-many-a"},
+				Content:   content,
+			})
+			require.NoError(t, err)
+		}
+
+		edited, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID:   msg.ID.Hex(),
+			Editor:      data.MessageSender{ParticipantID: "ed-many-a"},
+			Attachments: []data.CreateAttachment{{Kind: "file", Metadata: map[string]any{"filename": "a.pdf"}}},
+		})
+		require.NoError(t, err)
+
+		assert.Empty(t, edited.Content)
+		assert.Len(t, edited.Attachments, 1)
+		require.Len(t, edited.Revisions, 3)
+		assert.Equal(t, "helo", edited.Revisions[0].Content)
+		assert.Equal(t, "one", edited.Revisions[1].Content)
+		assert.Equal(t, "two", edited.Revisions[2].Content)
+	})
+
+	t.Run("keeps the conversation last message in sync", func(t *testing.T) {
+		conv, msg := createConvWithMessage(t, "ed-last-a", "ed-last-b")
+
+		_, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "ed-last-a"},
+			Content:   "hello",
+		})
+		require.NoError(t, err)
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		require.NotNil(t, updated.LastMessage)
+		assert.Equal(t, "hello", updated.LastMessage.Content)
+		assert.NotNil(t, updated.LastMessage.EditedAt)
+		assert.Empty(t, updated.LastMessage.Revisions)
+		assert.WithinDuration(t, msg.CreatedAt, updated.UpdatedAt, time.Millisecond)
+	})
+
+	t.Run("does not touch the last message when an older message is edited", func(t *testing.T) {
+		conv, msg := createConvWithMessage(t, "ed-old-a", "ed-old-b")
+
+		latest, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "ed-old-b"},
+			Content: "latest",
+		})
+		require.NoError(t, err)
+
+		_, err = mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "ed-old-a"},
+			Content:   "hello",
+		})
+		require.NoError(t, err)
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		assert.Equal(t, latest.ID.Hex(), updated.LastMessage.ID.Hex())
+		assert.Equal(t, "latest", updated.LastMessage.Content)
+	})
+
+	t.Run("rejects edits from anyone but the sender", func(t *testing.T) {
+		_, msg := createConvWithMessage(t, "ed-other-a", "ed-other-b")
+
+		edited, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "ed-other-b"},
+			Content:   "hijacked",
+		})
+		assert.Error(t, err)
+		assert.Nil(t, edited)
+	})
+
+	t.Run("rejects edits from the sender id with different metadata", func(t *testing.T) {
+		_, msg := createConvWithMessage(t, "ed-meta-a", "ed-meta-b")
+
+		edited, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "ed-meta-a", Metadata: map[string]any{"business_id": "1"}},
+			Content:   "hijacked",
+		})
+		assert.Error(t, err)
+		assert.Nil(t, edited)
+	})
+
+	t.Run("rejects an edit with no content and no attachments", func(t *testing.T) {
+		_, msg := createConvWithMessage(t, "ed-empty-a", "ed-empty-b")
+
+		edited, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "ed-empty-a"},
+		})
+		assert.Error(t, err)
+		assert.Nil(t, edited)
+	})
+}
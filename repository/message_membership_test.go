@@ -0,0 +1,128 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestMessageRepository_Membership(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	businessA := map[string]any{"business_id": "a"}
+
+	createConvWithMessage := func(t *testing.T, userA, userB string) (*model.Conversation, *model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB, Metadata: businessA},
+			},
+		})
+		require.NoError(t, err)
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: userA},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+
+		return conv, msg
+	}
+
+	t.Run("rejects senders outside the conversation", func(t *testing.T) {
+		conv, _ := createConvWithMessage(t, "mb-out-a", "mb-out-b")
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "mb-out-stranger"},
+			Content: "hi",
+		})
+		assert.ErrorIs(t, err, repository.ErrNotParticipant)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("matches the sender metadata exactly", func(t *testing.T) {
+		conv, _ := createConvWithMessage(t, "mb-meta-a", "mb-meta-b")
+
+		_, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "mb-meta-b"},
+			Content: "hi",
+		})
+		assert.ErrorIs(t, err, repository.ErrNotParticipant)
+
+		_, err = mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "mb-meta-b", Metadata: businessA},
+			Content: "hi",
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("rejects removed participants", func(t *testing.T) {
+		conv, msg := createConvWithMessage(t, "mb-removed-a", "mb-removed-b")
+
+		_, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "mb-removed-a"})
+		require.NoError(t, err)
+
+		_, err = mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "mb-removed-a"},
+			Content: "still here?",
+		})
+		assert.ErrorIs(t, err, repository.ErrParticipantDeleted)
+
+		_, err = mr.ToggleReaction(t.Context(), data.ToggleReaction{
+			MessageID:   msg.ID.Hex(),
+			Emoji:       ":+1:",
+			Participant: data.ReactionParticipant{ParticipantID: "mb-removed-a"},
+		})
+		assert.ErrorIs(t, err, repository.ErrParticipantDeleted)
+	})
+
+	t.Run("rejects reactions from outside the conversation", func(t *testing.T) {
+		_, msg := createConvWithMessage(t, "mb-react-a", "mb-react-b")
+
+		reacted, err := mr.ToggleReaction(t.Context(), data.ToggleReaction{
+			MessageID:   msg.ID.Hex(),
+			Emoji:       ":+1:",
+			Participant: data.ReactionParticipant{ParticipantID: "mb-react-stranger"},
+		})
+		assert.ErrorIs(t, err, repository.ErrNotParticipant)
+		assert.Nil(t, reacted)
+	})
+
+	t.Run("rejects messages to a missing conversation", func(t *testing.T) {
+		msg, err := mr.Create(t.Context(), bson.NewObjectID().Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "mb-missing-a"},
+			Content: "hi",
+		})
+		assert.ErrorIs(t, err, repository.ErrConversationNotFound)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("system messages need no participant", func(t *testing.T) {
+		conv, _ := createConvWithMessage(t, "mb-system-a", "mb-system-b")
+
+		_, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    model.MessageKindSystem,
+			Sender:  data.MessageSender{ParticipantID: "mb-system-bot"},
+			Content: "mb-system-b joined",
+		})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,181 @@
+package repository_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Quotes(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConvWithMessage := func(t *testing.T, userA, userB string, d data.CreateMessage) (*model.Conversation, *model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+
+		d.Kind = "general"
+		d.Sender = data.MessageSender{ParticipantID: userA}
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), d)
+		require.NoError(t, err)
+
+		return conv, msg
+	}
+
+	replyTo := func(t *testing.T, convID string, quotedID string, sender string) *model.Message {
+		t.Helper()
+		msg, err := mr.Create(t.Context(), convID, data.CreateMessage{
+			Kind:      "general",
+			Sender:    data.MessageSender{ParticipantID: sender},
+			Content:   "reply",
+			ReplyToID: &quotedID,
+		})
+		require.NoError(t, err)
+		return msg
+	}
+
+	findMessage := func(t *testing.T, convID string, id string) model.Message {
+		t.Helper()
+		messages, err := mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: convID,
+			PerPage:        100,
+		})
+		require.NoError(t, err)
+		for _, msg := range messages {
+			if msg.ID.Hex() == id {
+				return msg
+			}
+		}
+		t.Fatalf("message %s not found", id)
+		return model.Message{}
+	}
+
+	t.Run("embeds a snapshot of the quoted message", func(t *testing.T) {
+		conv, quoted := createConvWithMessage(t, "qt-ok-a", "qt-ok-b", data.CreateMessage{
+			Content: "original",
+			Attachments: []data.CreateAttachment{
+				{Kind: "image"},
+				{Kind: "file"},
+			},
+		})
+
+		reply := replyTo(t, conv.ID.Hex(), quoted.ID.Hex(), "qt-ok-b")
+
+		require.NotNil(t, reply.ReplyTo)
+		assert.Equal(t, quoted.ID.Hex(), reply.ReplyTo.MessageID.Hex())
+		assert.Equal(t, "qt-ok-a", reply.ReplyTo.Sender.ParticipantID)
+		assert.Equal(t, "original", reply.ReplyTo.Excerpt)
+		assert.Equal(t, "image", reply.ReplyTo.AttachmentKind)
+		assert.Nil(t, reply.ReplyTo.DeletedAt)
+	})
+
+	t.Run("truncates the excerpt", func(t *testing.T) {
+		conv, quoted := createConvWithMessage(t, "qt-long-a", "qt-long-b", data.CreateMessage{
+			Content: strings.Repeat("é", 150),
+		})
+
+		reply := replyTo(t, conv.ID.Hex(), quoted.ID.Hex(), "qt-long-b")
+
+		require.NotNil(t, reply.ReplyTo)
+		assert.Equal(t, strings.Repeat("é", 100), reply.ReplyTo.Excerpt)
+	})
+
+	t.Run("rejects quoting a message from another conversation", func(t *testing.T) {
+		conv, _ := createConvWithMessage(t, "qt-other-a", "qt-other-b", data.CreateMessage{Content: "mine"})
+		_, other := createConvWithMessage(t, "qt-other-c", "qt-other-d", data.CreateMessage{Content: "theirs"})
+
+		otherID := other.ID.Hex()
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:      "general",
+			Sender:    data.MessageSender{ParticipantID: "qt-other-a"},
+			Content:   "reply",
+			ReplyToID: &otherID,
+		})
+		assert.Error(t, err)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("refreshes the snapshot when the quoted message is edited", func(t *testing.T) {
+		conv, quoted := createConvWithMessage(t, "qt-edit-a", "qt-edit-b", data.CreateMessage{Content: "tpyo"})
+		reply := replyTo(t, conv.ID.Hex(), quoted.ID.Hex(), "qt-edit-b")
+
+		_, err := mr.Edit(t.Context(), data.EditMessage{
+			MessageID: quoted.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "qt-edit-a"},
+			Content:   "typo",
+		})
+		require.NoError(t, err)
+
+		updated := findMessage(t, conv.ID.Hex(), reply.ID.Hex())
+		require.NotNil(t, updated.ReplyTo)
+		assert.Equal(t, "typo", updated.ReplyTo.Excerpt)
+
+		updatedConv, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		require.NotNil(t, updatedConv.LastMessage.ReplyTo)
+		assert.Equal(t, "typo", updatedConv.LastMessage.ReplyTo.Excerpt)
+	})
+
+	t.Run("marks the snapshot as deleted when the quoted message is deleted", func(t *testing.T) {
+		conv, quoted := createConvWithMessage(t, "qt-del-a", "qt-del-b", data.CreateMessage{
+			Content:     "secret",
+			Attachments: []data.CreateAttachment{{Kind: "image"}},
+		})
+		reply := replyTo(t, conv.ID.Hex(), quoted.ID.Hex(), "qt-del-b")
+
+		_, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: quoted.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "qt-del-a"},
+		})
+		require.NoError(t, err)
+
+		updated := findMessage(t, conv.ID.Hex(), reply.ID.Hex())
+		require.NotNil(t, updated.ReplyTo)
+		assert.NotNil(t, updated.ReplyTo.DeletedAt)
+		assert.Empty(t, updated.ReplyTo.Excerpt)
+		assert.Empty(t, updated.ReplyTo.AttachmentKind)
+		assert.Equal(t, "qt-del-a", updated.ReplyTo.Sender.ParticipantID)
+
+		updatedConv, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		require.NotNil(t, updatedConv.LastMessage.ReplyTo)
+		assert.NotNil(t, updatedConv.LastMessage.ReplyTo.DeletedAt)
+		assert.Empty(t, updatedConv.LastMessage.ReplyTo.Excerpt)
+	})
+
+	t.Run("rejects quoting a deleted message", func(t *testing.T) {
+		conv, quoted := createConvWithMessage(t, "qt-gone-a", "qt-gone-b", data.CreateMessage{Content: "gone"})
+
+		_, err := mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: quoted.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "qt-gone-a"},
+		})
+		require.NoError(t, err)
+
+		quotedID := quoted.ID.Hex()
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:      "general",
+			Sender:    data.MessageSender{ParticipantID: "qt-gone-b"},
+			Content:   "reply",
+			ReplyToID: &quotedID,
+		})
+		assert.Error(t, err)
+		assert.Nil(t, msg)
+	})
+}
@@ -207,7 +207,7 @@ func TestMessageRepository_MarkRead(t *testing.T) {
 	t.Run("errors on soft-deleted participant with identical error string", func(t *testing.T) {
 		conv, _, m2, _ := createConvWith3Messages(t, "mr-soft-a", "mr-soft-b")
 
-		_, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), data.DeleteParticipant{
+		_, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{
 			ParticipantID: "mr-soft-a",
 		})
 		require.NoError(t, err)
@@ -538,7 +538,7 @@ func TestMessageRepository_MarkRead_PreflightSoftDelete(t *testing.T) {
 	require.NotEmpty(t, msgs)
 	targetMsgID := msgs[0].ID.Hex()
 
-	_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), data.DeleteParticipant{
+	_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{
 		ParticipantID: userA,
 	})
 	require.NoError(t, err)
@@ -780,7 +780,7 @@ func TestMessageRepository_ReadersOf(t *testing.T) {
 		MessageID:      m3.ID.Hex(),
 	})
 	require.NoError(t, err)
-	_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), data.DeleteParticipant{
+	_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{
 		ParticipantID: "D",
 	})
 	require.NoError(t, err)
@@ -978,7 +978,7 @@ func TestMessageRepository_UnreadCount(t *testing.T) {
 		})
 		require.NoError(t, err)
 
-		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), data.DeleteParticipant{
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{
 			ParticipantID: "uc-soft-a",
 		})
 		require.NoError(t, err)
@@ -0,0 +1,134 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Search(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConv := func(t *testing.T, userA, userB string) *model.Conversation {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+		return conv
+	}
+
+	send := func(t *testing.T, convID string, sender string, d data.CreateMessage) *model.Message {
+		t.Helper()
+		d.Kind = "general"
+		d.Sender = data.MessageSender{ParticipantID: sender}
+		msg, err := mr.Create(t.Context(), convID, d)
+		require.NoError(t, err)
+		return msg
+	}
+
+	t.Run("finds messages in the participant's conversations only", func(t *testing.T) {
+		mine := createConv(t, "se-scope-a", "se-scope-b")
+		other := createConv(t, "se-scope-c", "se-scope-d")
+
+		msg := send(t, mine.ID.Hex(), "se-scope-b", data.CreateMessage{Content: "the zanzibar invoice is attached"})
+		send(t, other.ID.Hex(), "se-scope-c", data.CreateMessage{Content: "zanzibar trip photos"})
+
+		results, total, err := mr.Search(t.Context(), data.SearchMessages{
+			Participant: data.ReadParticipant{ParticipantID: "se-scope-a"},
+			Query:       "zanzibar",
+			Page:        1,
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), total)
+		require.Len(t, results, 1)
+		assert.Equal(t, msg.ID.Hex(), results[0].Message.ID.Hex())
+		assert.Equal(t, mine.ID.Hex(), results[0].Conversation.ID.Hex())
+		assert.Greater(t, results[0].Score, 0.0)
+	})
+
+	t.Run("orders by relevance and matches attachment file names", func(t *testing.T) {
+		conv := createConv(t, "se-rank-a", "se-rank-b")
+
+		file := send(t, conv.ID.Hex(), "se-rank-a", data.CreateMessage{
+			Attachments: []data.CreateAttachment{{Kind: "file", Metadata: map[string]any{"name": "quokka.pdf"}}},
+		})
+		both := send(t, conv.ID.Hex(), "se-rank-a", data.CreateMessage{Content: "quokka quokka, see the quokka"})
+
+		results, total, err := mr.Search(t.Context(), data.SearchMessages{
+			Participant: data.ReadParticipant{ParticipantID: "se-rank-b"},
+			Query:       "quokka",
+			Page:        1,
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(2), total)
+		require.Len(t, results, 2)
+		assert.Equal(t, both.ID.Hex(), results[0].Message.ID.Hex())
+		assert.Equal(t, file.ID.Hex(), results[1].Message.ID.Hex())
+	})
+
+	t.Run("excludes conversations the participant left and deleted messages", func(t *testing.T) {
+		left := createConv(t, "se-left-a", "se-left-b")
+		send(t, left.ID.Hex(), "se-left-b", data.CreateMessage{Content: "axolotl"})
+		_, err := cr.DeleteParticipant(t.Context(), left.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "se-left-a"})
+		require.NoError(t, err)
+
+		conv := createConv(t, "se-left-a", "se-left-c")
+		gone := send(t, conv.ID.Hex(), "se-left-c", data.CreateMessage{Content: "axolotl"})
+		hidden := send(t, conv.ID.Hex(), "se-left-c", data.CreateMessage{Content: "axolotl again"})
+
+		_, err = mr.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+			MessageID: gone.ID.Hex(),
+			Sender:    data.MessageSender{ParticipantID: "se-left-c"},
+		})
+		require.NoError(t, err)
+
+		err = mr.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+			MessageID:   hidden.ID.Hex(),
+			Participant: data.ReadParticipant{ParticipantID: "se-left-a"},
+		})
+		require.NoError(t, err)
+
+		results, total, err := mr.Search(t.Context(), data.SearchMessages{
+			Participant: data.ReadParticipant{ParticipantID: "se-left-a"},
+			Query:       "axolotl",
+			Page:        1,
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(0), total)
+		assert.Empty(t, results)
+	})
+
+	t.Run("paginates", func(t *testing.T) {
+		conv := createConv(t, "se-page-a", "se-page-b")
+		for range 3 {
+			send(t, conv.ID.Hex(), "se-page-a", data.CreateMessage{Content: "pangolin"})
+		}
+
+		results, total, err := mr.Search(t.Context(), data.SearchMessages{
+			Participant: data.ReadParticipant{ParticipantID: "se-page-b"},
+			Query:       "pangolin",
+			Page:        2,
+			PerPage:     2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(3), total)
+		assert.Len(t, results, 1)
+	})
+}
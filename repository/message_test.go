@@ -505,6 +505,7 @@ func TestMessageRepository_LoadMessages(t *testing.T) {
 			expects: func(t *testing.T, msgs []model.Message, err error) {
 				assert.Error(t, err)
 				assert.Contains(t, err.Error(), "failed to fetch the conversation")
+				assert.ErrorIs(t, err, repository.ErrConversationNotFound)
 			},
 		},
 		{
@@ -747,6 +748,7 @@ func TestMessageRepository_ToggleReaction(t *testing.T) {
 				assert.Error(t, err)
 				assert.Nil(t, msg)
 				assert.Contains(t, err.Error(), "failed to fetch the message")
+				assert.ErrorIs(t, err, repository.ErrMessageNotFound)
 			},
 		},
 		{
@@ -0,0 +1,192 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Threads(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConvWithRoot := func(t *testing.T, userA, userB string) (*model.Conversation, *model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+
+		root, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: userA},
+			Content: "root",
+		})
+		require.NoError(t, err)
+
+		return conv, root
+	}
+
+	reply := func(t *testing.T, convID string, parentID string, sender string, content string) *model.Message {
+		t.Helper()
+		msg, err := mr.Create(t.Context(), convID, data.CreateMessage{
+			Kind:     "general",
+			Sender:   data.MessageSender{ParticipantID: sender},
+			Content:  content,
+			ParentID: &parentID,
+		})
+		require.NoError(t, err)
+		return msg
+	}
+
+	findMessage := func(t *testing.T, convID string, id string) model.Message {
+		t.Helper()
+		messages, err := mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID:       convID,
+			IncludeThreadReplies: true,
+			PerPage:              100,
+		})
+		require.NoError(t, err)
+		for _, msg := range messages {
+			if msg.ID.Hex() == id {
+				return msg
+			}
+		}
+		t.Fatalf("message %s not found", id)
+		return model.Message{}
+	}
+
+	t.Run("updates the reply count and last reply time on the parent", func(t *testing.T) {
+		conv, root := createConvWithRoot(t, "th-count-a", "th-count-b")
+
+		reply(t, conv.ID.Hex(), root.ID.Hex(), "th-count-b", "r1")
+		r2 := reply(t, conv.ID.Hex(), root.ID.Hex(), "th-count-a", "r2")
+
+		require.NotNil(t, r2.ParentID)
+		assert.Equal(t, root.ID.Hex(), r2.ParentID.Hex())
+
+		parent := findMessage(t, conv.ID.Hex(), root.ID.Hex())
+		assert.Equal(t, uint(2), parent.ReplyCount)
+		require.NotNil(t, parent.LastReplyAt)
+		assert.WithinDuration(t, r2.CreatedAt, *parent.LastReplyAt, time.Millisecond)
+	})
+
+	t.Run("replying to a reply files it under the thread root", func(t *testing.T) {
+		conv, root := createConvWithRoot(t, "th-nested-a", "th-nested-b")
+
+		r1 := reply(t, conv.ID.Hex(), root.ID.Hex(), "th-nested-b", "r1")
+		r2 := reply(t, conv.ID.Hex(), r1.ID.Hex(), "th-nested-a", "r2")
+
+		assert.Equal(t, root.ID.Hex(), r2.ParentID.Hex())
+		assert.Equal(t, uint(2), findMessage(t, conv.ID.Hex(), root.ID.Hex()).ReplyCount)
+		assert.Equal(t, uint(0), findMessage(t, conv.ID.Hex(), r1.ID.Hex()).ReplyCount)
+	})
+
+	t.Run("rejects a parent from another conversation", func(t *testing.T) {
+		conv, _ := createConvWithRoot(t, "th-other-a", "th-other-b")
+		_, otherRoot := createConvWithRoot(t, "th-other-c", "th-other-d")
+
+		parentID := otherRoot.ID.Hex()
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:     "general",
+			Sender:   data.MessageSender{ParticipantID: "th-other-a"},
+			Content:  "r1",
+			ParentID: &parentID,
+		})
+		assert.Error(t, err)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("replies are excluded from the main timeline unless requested", func(t *testing.T) {
+		conv, root := createConvWithRoot(t, "th-timeline-a", "th-timeline-b")
+		reply(t, conv.ID.Hex(), root.ID.Hex(), "th-timeline-b", "r1")
+
+		messages, total, err := mr.Paginate(t.Context(), data.PaginateMessages{
+			ConversationID: conv.ID.Hex(),
+			Page:           1,
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(1), total)
+		require.Len(t, messages, 1)
+		assert.Equal(t, root.ID.Hex(), messages[0].ID.Hex())
+
+		loaded, err := mr.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: conv.ID.Hex(),
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		assert.Len(t, loaded, 1)
+
+		messages, total, err = mr.Paginate(t.Context(), data.PaginateMessages{
+			ConversationID:       conv.ID.Hex(),
+			IncludeThreadReplies: true,
+			Page:                 1,
+			PerPage:              10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(2), total)
+		assert.Len(t, messages, 2)
+	})
+
+	t.Run("loads a thread with a cursor", func(t *testing.T) {
+		conv, root := createConvWithRoot(t, "th-load-a", "th-load-b")
+		r1 := reply(t, conv.ID.Hex(), root.ID.Hex(), "th-load-b", "r1")
+		r2 := reply(t, conv.ID.Hex(), root.ID.Hex(), "th-load-a", "r2")
+		r3 := reply(t, conv.ID.Hex(), root.ID.Hex(), "th-load-b", "r3")
+
+		_, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "th-load-a"},
+			Content: "not in thread",
+		})
+		require.NoError(t, err)
+
+		page, err := mr.LoadThread(t.Context(), data.LoadThread{
+			ConversationID: conv.ID.Hex(),
+			ParentID:       root.ID.Hex(),
+			PerPage:        2,
+		})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, r3.ID.Hex(), page[0].ID.Hex())
+		assert.Equal(t, r2.ID.Hex(), page[1].ID.Hex())
+
+		lastID := page[1].ID.Hex()
+		page, err = mr.LoadThread(t.Context(), data.LoadThread{
+			ConversationID: conv.ID.Hex(),
+			ParentID:       root.ID.Hex(),
+			LastMessageID:  &lastID,
+			PerPage:        2,
+		})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, r1.ID.Hex(), page[0].ID.Hex())
+	})
+
+	t.Run("rejects loading a thread from another conversation", func(t *testing.T) {
+		conv, _ := createConvWithRoot(t, "th-loadother-a", "th-loadother-b")
+		_, otherRoot := createConvWithRoot(t, "th-loadother-c", "th-loadother-d")
+
+		page, err := mr.LoadThread(t.Context(), data.LoadThread{
+			ConversationID: conv.ID.Hex(),
+			ParentID:       otherRoot.ID.Hex(),
+			PerPage:        10,
+		})
+		assert.Error(t, err)
+		assert.Nil(t, page)
+	})
+}
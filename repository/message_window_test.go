@@ -0,0 +1,126 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageRepository_Windows(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConvWithMessages := func(t *testing.T, userA, userB string, count int) (*model.Conversation, []*model.Message) {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+
+		messages := make([]*model.Message, 0, count)
+		for range count {
+			msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+				Kind:    "general",
+				Sender:  data.MessageSender{ParticipantID: userA},
+				Content: "hello",
+			})
+			require.NoError(t, err)
+			messages = append(messages, msg)
+		}
+
+		return conv, messages
+	}
+
+	ids := func(messages []model.Message) []string {
+		out := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			out = append(out, msg.ID.Hex())
+		}
+		return out
+	}
+
+	t.Run("loads newer messages after a cursor", func(t *testing.T) {
+		conv, messages := createConvWithMessages(t, "wn-newer-a", "wn-newer-b", 5)
+
+		window, err := mr.LoadNewerMessages(t.Context(), data.LoadNewerMessages{
+			ConversationID: conv.ID.Hex(),
+			FirstMessageID: messages[1].ID.Hex(),
+			PerPage:        2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{messages[3].ID.Hex(), messages[2].ID.Hex()}, ids(window.Messages))
+		assert.True(t, window.HasOlder)
+		assert.True(t, window.HasNewer)
+
+		window, err = mr.LoadNewerMessages(t.Context(), data.LoadNewerMessages{
+			ConversationID: conv.ID.Hex(),
+			FirstMessageID: messages[3].ID.Hex(),
+			PerPage:        2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{messages[4].ID.Hex()}, ids(window.Messages))
+		assert.True(t, window.HasOlder)
+		assert.False(t, window.HasNewer)
+	})
+
+	t.Run("loads a window around a message", func(t *testing.T) {
+		conv, messages := createConvWithMessages(t, "wn-around-a", "wn-around-b", 7)
+
+		window, err := mr.LoadMessagesAround(t.Context(), data.LoadMessagesAround{
+			ConversationID: conv.ID.Hex(),
+			MessageID:      messages[3].ID.Hex(),
+			Before:         2,
+			After:          2,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			messages[5].ID.Hex(),
+			messages[4].ID.Hex(),
+			messages[3].ID.Hex(),
+			messages[2].ID.Hex(),
+			messages[1].ID.Hex(),
+		}, ids(window.Messages))
+		assert.True(t, window.HasOlder)
+		assert.True(t, window.HasNewer)
+	})
+
+	t.Run("reports the edges of the conversation", func(t *testing.T) {
+		conv, messages := createConvWithMessages(t, "wn-edge-a", "wn-edge-b", 3)
+
+		window, err := mr.LoadMessagesAround(t.Context(), data.LoadMessagesAround{
+			ConversationID: conv.ID.Hex(),
+			MessageID:      messages[1].ID.Hex(),
+			Before:         5,
+			After:          5,
+		})
+		require.NoError(t, err)
+		assert.Len(t, window.Messages, 3)
+		assert.False(t, window.HasOlder)
+		assert.False(t, window.HasNewer)
+	})
+
+	t.Run("rejects a message from another conversation", func(t *testing.T) {
+		conv, _ := createConvWithMessages(t, "wn-other-a", "wn-other-b", 1)
+		_, others := createConvWithMessages(t, "wn-other-c", "wn-other-d", 1)
+
+		window, err := mr.LoadMessagesAround(t.Context(), data.LoadMessagesAround{
+			ConversationID: conv.ID.Hex(),
+			MessageID:      others[0].ID.Hex(),
+			After:          5,
+		})
+		assert.Error(t, err)
+		assert.Nil(t, window)
+	})
+}
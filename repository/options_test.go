@@ -0,0 +1,124 @@
+package repository_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/migrations"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRepository_Options(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	collections := model.Collections{
+		Conversations: "opt_conversations",
+		Messages:      "opt_messages",
+		Typing:        "opt_typing",
+		Presence:      "opt_presence",
+		Migrations:    "opt_migrations",
+	}
+	migrationOpts := []migrations.Option{
+		migrations.WithDatabase("chatsavvy_options"),
+		migrations.WithCollections(collections),
+	}
+	require.NoError(t, migrations.Run(client, "down", migrationOpts...))
+	require.NoError(t, migrations.Run(client, "up", migrationOpts...))
+
+	db := client.Database("chatsavvy_options")
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	opts := []repository.Option{
+		repository.WithCollections(collections),
+		repository.WithLimits(model.Limits{MaxParticipants: 3, MaxContentLength: 10, MaxAttachments: 1}),
+		repository.WithClock(func() time.Time { return now }),
+	}
+	cr := repository.NewConversation(db, opts...)
+	mr := repository.NewMessage(db, cr, opts...)
+
+	t.Run("writes to the configured collections with the configured clock", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "opt-coll-a"},
+				{ParticipantID: "opt-coll-b"},
+			},
+		})
+		require.NoError(t, err)
+		assert.True(t, now.Equal(conv.CreatedAt))
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "opt-coll-a"},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+		assert.True(t, now.Equal(msg.CreatedAt))
+
+		count, err := db.Collection("opt_messages").CountDocuments(t.Context(), bson.M{"_id": msg.ID})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		count, err = db.Collection("messages").CountDocuments(t.Context(), bson.M{"_id": msg.ID})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("enforces the configured message limits", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "opt-msg-a"},
+				{ParticipantID: "opt-msg-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "opt-msg-a"},
+			Content: strings.Repeat("a", 11),
+		})
+		assert.Error(t, err)
+		assert.Nil(t, msg)
+	})
+
+	t.Run("enforces the configured participant limit", func(t *testing.T) {
+		_, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "opt-max-a"},
+				{ParticipantID: "opt-max-b"},
+				{ParticipantID: "opt-max-c"},
+				{ParticipantID: "opt-max-d"},
+			},
+		})
+		assert.Error(t, err)
+
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "opt-max-a"},
+				{ParticipantID: "opt-max-b"},
+				{ParticipantID: "opt-max-c"},
+			},
+		})
+		require.NoError(t, err)
+
+		_, err = cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "opt-max-d"})
+		assert.ErrorIs(t, err, repository.ErrTooManyParticipants)
+
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "opt-max-c"})
+		require.NoError(t, err)
+
+		updated, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "opt-max-d"})
+		require.NoError(t, err)
+		assert.NotNil(t, updated.ActiveParticipant("opt-max-d", nil))
+	})
+}
@@ -0,0 +1,126 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationRepository_ReviveParticipant(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	t.Run("records the first membership period on create", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "rv-create-a"},
+				{ParticipantID: "rv-create-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		for _, p := range conv.Participants {
+			require.Len(t, p.Memberships, 1)
+			assert.WithinDuration(t, conv.CreatedAt, p.Memberships[0].JoinedAt, time.Second)
+			assert.Nil(t, p.Memberships[0].LeftAt)
+		}
+	})
+
+	t.Run("revives a deleted participant in place", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "rv-back-a", Metadata: map[string]any{"team": "x"}},
+				{ParticipantID: "rv-back-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "rv-back-b"},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+
+		_, err = mr.MarkRead(t.Context(), data.MarkRead{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "rv-back-a", Metadata: map[string]any{"team": "x"}},
+			MessageID:      msg.ID.Hex(),
+		})
+		require.NoError(t, err)
+
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{
+			ParticipantID: "rv-back-a",
+			Metadata:      map[string]any{"team": "x"},
+		})
+		require.NoError(t, err)
+
+		revived, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{
+			ParticipantID: "rv-back-a",
+			Metadata:      map[string]any{"team": "x"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, conv.ID.Hex(), revived.ID.Hex())
+		require.Len(t, revived.Participants, 2)
+
+		p := revived.Participants[0]
+		assert.Nil(t, p.DeletedAt)
+		require.NotNil(t, p.LastReadMessageID)
+		assert.Equal(t, msg.ID.Hex(), p.LastReadMessageID.Hex())
+
+		require.Len(t, p.Memberships, 2)
+		assert.NotNil(t, p.Memberships[0].LeftAt)
+		assert.Nil(t, p.Memberships[1].LeftAt)
+		assert.False(t, p.Memberships[1].JoinedAt.Before(*p.Memberships[0].LeftAt))
+
+		removed, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{
+			ParticipantID: "rv-back-a",
+			Metadata:      map[string]any{"team": "x"},
+		})
+		require.NoError(t, err)
+		require.Len(t, removed.Participants[0].Memberships, 2)
+		assert.Equal(t, p.Memberships[0].LeftAt, removed.Participants[0].Memberships[0].LeftAt)
+		assert.NotNil(t, removed.Participants[0].Memberships[1].LeftAt)
+	})
+
+	t.Run("adding a current participant does nothing", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "rv-dup-a"},
+				{ParticipantID: "rv-dup-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		updated, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "rv-dup-a"})
+		require.NoError(t, err)
+		assert.Len(t, updated.Participants, 2)
+		assert.Len(t, updated.Participants[0].Memberships, 1)
+	})
+
+	t.Run("deleting a deleted participant keeps the first deletion", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "rv-twice-a"},
+				{ParticipantID: "rv-twice-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		first, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "rv-twice-a"})
+		require.NoError(t, err)
+
+		second, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "rv-twice-a"})
+		require.NoError(t, err)
+		assert.Equal(t, first.Participants[0].DeletedAt, second.Participants[0].DeletedAt)
+	})
+}
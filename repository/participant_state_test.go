@@ -0,0 +1,154 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationRepository_ParticipantState(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createConv := func(t *testing.T, userA, userB string) *model.Conversation {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB},
+			},
+		})
+		require.NoError(t, err)
+		return conv
+	}
+
+	paginate := func(t *testing.T, participantID string, includeArchived bool) []string {
+		t.Helper()
+		conversations, _, err := cr.Paginate(t.Context(), data.PaginateConversations{
+			ParticipantID:   participantID,
+			Page:            1,
+			PerPage:         10,
+			IncludeArchived: includeArchived,
+		})
+		require.NoError(t, err)
+		ids := make([]string, 0, len(conversations))
+		for _, conv := range conversations {
+			ids = append(ids, conv.ID.Hex())
+		}
+		return ids
+	}
+
+	t.Run("floats pinned conversations to the top", func(t *testing.T) {
+		pinned := createConv(t, "ps-pin-a", "ps-pin-b")
+		other := createConv(t, "ps-pin-a", "ps-pin-c")
+
+		updated, err := cr.SetPinned(t.Context(), pinned.ID.Hex(), data.SetPinned{
+			Participant: data.ReadParticipant{ParticipantID: "ps-pin-a"},
+			Pinned:      true,
+		})
+		require.NoError(t, err)
+		assert.NotNil(t, updated.Participants[0].PinnedAt)
+		assert.Nil(t, updated.Participants[1].PinnedAt)
+
+		assert.Equal(t, []string{pinned.ID.Hex(), other.ID.Hex()}, paginate(t, "ps-pin-a", false))
+		assert.Equal(t, []string{pinned.ID.Hex()}, paginate(t, "ps-pin-b", false))
+
+		_, err = cr.SetPinned(t.Context(), pinned.ID.Hex(), data.SetPinned{
+			Participant: data.ReadParticipant{ParticipantID: "ps-pin-a"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{other.ID.Hex(), pinned.ID.Hex()}, paginate(t, "ps-pin-a", false))
+	})
+
+	t.Run("excludes archived conversations until a new message arrives", func(t *testing.T) {
+		conv := createConv(t, "ps-archive-a", "ps-archive-b")
+
+		_, err := cr.SetArchived(t.Context(), conv.ID.Hex(), data.SetArchived{
+			Participant: data.ReadParticipant{ParticipantID: "ps-archive-a"},
+			Archived:    true,
+		})
+		require.NoError(t, err)
+
+		assert.Empty(t, paginate(t, "ps-archive-a", false))
+		assert.Equal(t, []string{conv.ID.Hex()}, paginate(t, "ps-archive-a", true))
+		assert.Equal(t, []string{conv.ID.Hex()}, paginate(t, "ps-archive-b", false))
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ps-archive-a"},
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		assert.Empty(t, inbox.Entries)
+
+		_, err = mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "ps-archive-b"},
+			Content: "are you there?",
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{conv.ID.Hex()}, paginate(t, "ps-archive-a", false))
+
+		updated, err := cr.Find(t.Context(), conv.ID.Hex())
+		require.NoError(t, err)
+		assert.Nil(t, updated.Participants[0].ArchivedAt)
+	})
+
+	t.Run("mutes until the given time", func(t *testing.T) {
+		conv := createConv(t, "ps-mute-a", "ps-mute-b")
+		until := time.Now().Add(time.Hour)
+
+		updated, err := cr.SetMuted(t.Context(), conv.ID.Hex(), data.SetMuted{
+			Participant: data.ReadParticipant{ParticipantID: "ps-mute-a"},
+			Muted:       true,
+			Until:       &until,
+		})
+		require.NoError(t, err)
+		me := updated.Participants[0]
+		assert.True(t, me.Muted(time.Now()))
+		assert.False(t, me.Muted(until.Add(time.Second)))
+
+		_, err = mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "ps-mute-b"},
+			Content: "ping",
+		})
+		require.NoError(t, err)
+
+		inbox, err := cr.Inbox(t.Context(), data.LoadInbox{
+			Participant: data.ReadParticipant{ParticipantID: "ps-mute-a"},
+			PerPage:     10,
+		})
+		require.NoError(t, err)
+		require.Len(t, inbox.Entries, 1)
+		assert.Equal(t, uint(1), inbox.Entries[0].UnreadCount)
+		assert.Equal(t, uint(0), inbox.UnreadMessages)
+
+		updated, err = cr.SetMuted(t.Context(), conv.ID.Hex(), data.SetMuted{
+			Participant: data.ReadParticipant{ParticipantID: "ps-mute-a"},
+		})
+		require.NoError(t, err)
+		assert.False(t, updated.Participants[0].Muted(time.Now()))
+		assert.Nil(t, updated.Participants[0].MutedUntil)
+	})
+
+	t.Run("rejects non-participants", func(t *testing.T) {
+		conv := createConv(t, "ps-stranger-a", "ps-stranger-b")
+
+		_, err := cr.SetPinned(t.Context(), conv.ID.Hex(), data.SetPinned{
+			Participant: data.ReadParticipant{ParticipantID: "ps-stranger-c"},
+			Pinned:      true,
+		})
+		assert.ErrorIs(t, err, repository.ErrNotParticipant)
+	})
+}
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// authorize checks the actor against the repository's permission matrix, see chat.Authorize.
+// It returns the actor's participant entry, nil for a trusted call, or an error.
+func (c Conversation) authorize(conversation *model.Conversation, actor *data.Actor, permission model.Permission) (*model.Participant, error) {
+	return chat.Authorize(c.permissions, conversation, actor, permission)
+}
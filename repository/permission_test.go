@@ -0,0 +1,155 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Permissions(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+	createGroup := func(t *testing.T, prefix string) *model.Conversation {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: prefix + "-owner", Role: model.RoleOwner},
+				{ParticipantID: prefix + "-admin", Role: model.RoleAdmin},
+				{ParticipantID: prefix + "-member"},
+			},
+		})
+		require.NoError(t, err)
+		return conv
+	}
+
+	actor := func(id string) *data.Actor {
+		return &data.Actor{ParticipantID: id}
+	}
+
+	t.Run("participants default to members", func(t *testing.T) {
+		conv := createGroup(t, "pm-default")
+
+		assert.Equal(t, model.RoleOwner, conv.Participants[0].Role)
+		assert.Equal(t, model.RoleAdmin, conv.Participants[1].Role)
+		assert.Equal(t, model.RoleMember, conv.Participants[2].Role)
+	})
+
+	t.Run("only admins and owners add participants", func(t *testing.T) {
+		conv := createGroup(t, "pm-add")
+
+		_, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), actor("pm-add-member"), data.AddParticipant{ParticipantID: "pm-add-new"})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		_, err = cr.AddParticipant(t.Context(), conv.ID.Hex(), actor("pm-add-stranger"), data.AddParticipant{ParticipantID: "pm-add-new"})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		updated, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), actor("pm-add-admin"), data.AddParticipant{ParticipantID: "pm-add-new"})
+		require.NoError(t, err)
+		assert.Len(t, updated.Participants, 4)
+	})
+
+	t.Run("only owners grant roles", func(t *testing.T) {
+		conv := createGroup(t, "pm-role")
+
+		_, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), actor("pm-role-admin"), data.AddParticipant{ParticipantID: "pm-role-new", Role: model.RoleAdmin})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		_, err = cr.SetRole(t.Context(), conv.ID.Hex(), actor("pm-role-admin"), data.SetParticipantRole{ParticipantID: "pm-role-member", Role: model.RoleAdmin})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		updated, err := cr.SetRole(t.Context(), conv.ID.Hex(), actor("pm-role-owner"), data.SetParticipantRole{ParticipantID: "pm-role-member", Role: model.RoleAdmin})
+		require.NoError(t, err)
+		assert.Equal(t, model.RoleAdmin, updated.ActiveParticipant("pm-role-member", nil).Role)
+	})
+
+	t.Run("members may only remove themselves", func(t *testing.T) {
+		conv := createGroup(t, "pm-remove")
+
+		_, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), actor("pm-remove-member"), data.DeleteParticipant{ParticipantID: "pm-remove-admin"})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), actor("pm-remove-admin"), data.DeleteParticipant{ParticipantID: "pm-remove-owner"})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		updated, err := cr.DeleteParticipant(t.Context(), conv.ID.Hex(), actor("pm-remove-member"), data.DeleteParticipant{ParticipantID: "pm-remove-member"})
+		require.NoError(t, err)
+		assert.Nil(t, updated.ActiveParticipant("pm-remove-member", nil))
+	})
+
+	t.Run("only admins and owners change metadata and pin", func(t *testing.T) {
+		conv := createGroup(t, "pm-meta")
+
+		_, err := cr.UpdateMetadata(t.Context(), conv.ID.Hex(), actor("pm-meta-member"), data.UpdateConversationMetadata{Metadata: map[string]any{"title": "x"}})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		updated, err := cr.UpdateMetadata(t.Context(), conv.ID.Hex(), actor("pm-meta-admin"), data.UpdateConversationMetadata{Metadata: map[string]any{"title": "x"}})
+		require.NoError(t, err)
+		assert.Equal(t, "x", updated.Metadata["title"])
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "pm-meta-member"},
+			Content: "pin me",
+		})
+		require.NoError(t, err)
+
+		_, err = mr.Pin(t.Context(), actor("pm-meta-member"), data.PinMessage{MessageID: msg.ID.Hex()})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		pinned, err := mr.Pin(t.Context(), actor("pm-meta-owner"), data.PinMessage{MessageID: msg.ID.Hex()})
+		require.NoError(t, err)
+		require.NotNil(t, pinned.PinnedAt)
+		assert.Equal(t, "pm-meta-owner", pinned.PinnedBy.ParticipantID)
+
+		messages, err := mr.LoadPinned(t.Context(), data.LoadPinnedMessages{ConversationID: conv.ID.Hex()})
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, msg.ID.Hex(), messages[0].ID.Hex())
+
+		unpinned, err := mr.Unpin(t.Context(), actor("pm-meta-admin"), data.PinMessage{MessageID: msg.ID.Hex()})
+		require.NoError(t, err)
+		assert.Nil(t, unpinned.PinnedAt)
+	})
+
+	t.Run("uses a custom permission matrix", func(t *testing.T) {
+		permissions := model.DefaultPermissions()
+		permissions[model.RoleMember] = nil
+
+		cr := repository.NewConversation(client.Database("chatsavvy"), repository.WithPermissions(permissions))
+		mr := repository.NewMessage(client.Database("chatsavvy"), cr)
+
+		conv := createGroup(t, "pm-custom")
+
+		_, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "pm-custom-member"},
+			Content: "announcement",
+		})
+		assert.ErrorIs(t, err, repository.ErrPermissionDenied)
+
+		_, err = mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "pm-custom-admin"},
+			Content: "announcement",
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("a nil actor is trusted", func(t *testing.T) {
+		conv := createGroup(t, "pm-trusted")
+
+		_, err := cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "pm-trusted-new", Role: model.RoleOwner})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// typingTimeout is how long a participant is shown as typing after the last SetTyping.
+	typingTimeout = 6 * time.Second
+	// onlineTimeout is how long a participant is shown as online after the last Heartbeat.
+	onlineTimeout = time.Minute
+	// lastSeenRetention is how long the last seen time is kept after the last Heartbeat.
+	lastSeenRetention = 30 * 24 * time.Hour
+)
+
+type Presence struct {
+	db           *mongo.Database
+	conversation *Conversation
+	config
+}
+
+func NewPresence(db *mongo.Database, conversation *Conversation, opts ...Option) *Presence {
+	return &Presence{
+		db:           db,
+		conversation: conversation,
+		config:       newConfig(opts),
+	}
+}
+
+// SetTyping marks the participant as typing in the conversation for a few seconds.
+// Clients call it repeatedly while the participant keeps typing.
+func (p Presence) SetTyping(ctx context.Context, d data.SetTyping) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate set typing data: %w", err)
+	}
+
+	conversation, err := p.conversation.Find(ctx, d.ConversationID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+
+	if conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) == nil {
+		return ErrNotParticipant
+	}
+
+	key, err := chat.ParticipantKey(d.Participant.ParticipantID, d.Participant.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.collection(p.collections.Typing).UpdateOne(ctx,
+		bson.M{"conversation_id": d.ConversationID, "participant_key": key},
+		bson.M{"$set": bson.M{
+			"participant_id": d.Participant.ParticipantID,
+			"metadata":       d.Participant.Metadata,
+			"expires_at":     p.now().Add(typingTimeout),
+		}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set typing: %w", err)
+	}
+
+	return nil
+}
+
+// StopTyping clears the typing indicator of the participant, e.g. once the message is sent.
+func (p Presence) StopTyping(ctx context.Context, d data.SetTyping) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate set typing data: %w", err)
+	}
+
+	key, err := chat.ParticipantKey(d.Participant.ParticipantID, d.Participant.Metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.collection(p.collections.Typing).DeleteOne(ctx, bson.M{"conversation_id": d.ConversationID, "participant_key": key})
+	if err != nil {
+		return fmt.Errorf("failed to stop typing: %w", err)
+	}
+
+	return nil
+}
+
+// Typing returns the participants currently typing in the conversation.
+func (p Presence) Typing(ctx context.Context, d data.LoadTyping) ([]model.Typing, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load typing data: %w", err)
+	}
+
+	// Expired entries are removed by the TTL monitor, which only runs once a minute.
+	cursor, err := p.collection(p.collections.Typing).Find(ctx, bson.M{
+		"conversation_id": d.ConversationID,
+		"expires_at":      bson.M{"$gt": p.now()},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find typing participants: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	typing := []model.Typing{}
+	if err := cursor.All(ctx, &typing); err != nil {
+		return nil, fmt.Errorf("failed to decode typing participants: %w", err)
+	}
+
+	return typing, nil
+}
+
+// Heartbeat records that the participant is online now.
+// Clients call it periodically while connected.
+func (p Presence) Heartbeat(ctx context.Context, d data.Heartbeat) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate heartbeat data: %w", err)
+	}
+
+	key, err := chat.ParticipantKey(d.Participant.ParticipantID, d.Participant.Metadata)
+	if err != nil {
+		return err
+	}
+
+	now := p.now()
+	_, err = p.collection(p.collections.Presence).UpdateOne(ctx,
+		bson.M{"participant_key": key},
+		bson.M{"$set": bson.M{
+			"participant_id": d.Participant.ParticipantID,
+			"metadata":       d.Participant.Metadata,
+			"last_seen_at":   now,
+			"expires_at":     now.Add(lastSeenRetention),
+		}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+
+	return nil
+}
+
+// LastSeen returns the last seen time of the participants and whether they are online.
+// Participants that were never seen, or not within the retention period, are omitted.
+func (p Presence) LastSeen(ctx context.Context, d data.LoadPresence) ([]model.Presence, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load presence data: %w", err)
+	}
+
+	keys := make([]string, 0, len(d.Participants))
+	for _, participant := range d.Participants {
+		key, err := chat.ParticipantKey(participant.ParticipantID, participant.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	now := p.now()
+	cursor, err := p.collection(p.collections.Presence).Find(ctx, bson.M{
+		"participant_key": bson.M{"$in": keys},
+		"expires_at":      bson.M{"$gt": now},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find presence: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	presence := []model.Presence{}
+	if err := cursor.All(ctx, &presence); err != nil {
+		return nil, fmt.Errorf("failed to decode presence: %w", err)
+	}
+
+	for i := range presence {
+		presence[i].Online = now.Sub(presence[i].LastSeenAt) < onlineTimeout
+	}
+
+	return presence, nil
+}
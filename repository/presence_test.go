@@ -0,0 +1,93 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceRepository(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	cr := repository.NewConversation(client.Database("chatsavvy"))
+	pr := repository.NewPresence(client.Database("chatsavvy"), cr)
+
+	businessA := map[string]any{"business_id": "a"}
+
+	createConv := func(t *testing.T, userA, userB string) *model.Conversation {
+		t.Helper()
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: userA},
+				{ParticipantID: userB, Metadata: businessA},
+			},
+		})
+		require.NoError(t, err)
+		return conv
+	}
+
+	t.Run("lists typing participants", func(t *testing.T) {
+		conv := createConv(t, "pr-typing-a", "pr-typing-b")
+
+		for range 2 {
+			err := pr.SetTyping(t.Context(), data.SetTyping{
+				ConversationID: conv.ID.Hex(),
+				Participant:    data.ReadParticipant{ParticipantID: "pr-typing-b", Metadata: businessA},
+			})
+			require.NoError(t, err)
+		}
+
+		typing, err := pr.Typing(t.Context(), data.LoadTyping{ConversationID: conv.ID.Hex()})
+		require.NoError(t, err)
+		require.Len(t, typing, 1)
+		assert.Equal(t, "pr-typing-b", typing[0].ParticipantID)
+		assert.Equal(t, "a", typing[0].Metadata["business_id"])
+
+		err = pr.StopTyping(t.Context(), data.SetTyping{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "pr-typing-b", Metadata: businessA},
+		})
+		require.NoError(t, err)
+
+		typing, err = pr.Typing(t.Context(), data.LoadTyping{ConversationID: conv.ID.Hex()})
+		require.NoError(t, err)
+		assert.Empty(t, typing)
+	})
+
+	t.Run("rejects typing from outside the conversation", func(t *testing.T) {
+		conv := createConv(t, "pr-out-a", "pr-out-b")
+
+		err := pr.SetTyping(t.Context(), data.SetTyping{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "pr-out-stranger"},
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("reports the last seen time", func(t *testing.T) {
+		err := pr.Heartbeat(t.Context(), data.Heartbeat{
+			Participant: data.ReadParticipant{ParticipantID: "pr-seen-a", Metadata: businessA},
+		})
+		require.NoError(t, err)
+
+		presence, err := pr.LastSeen(t.Context(), data.LoadPresence{
+			Participants: []data.ReadParticipant{
+				{ParticipantID: "pr-seen-a", Metadata: businessA},
+				{ParticipantID: "pr-seen-a"},
+				{ParticipantID: "pr-seen-never"},
+			},
+		})
+		require.NoError(t, err)
+		require.Len(t, presence, 1)
+		assert.Equal(t, "pr-seen-a", presence[0].ParticipantID)
+		assert.True(t, presence[0].Online)
+		assert.False(t, presence[0].LastSeenAt.IsZero())
+	})
+}
@@ -0,0 +1,9 @@
+package repository
+
+import "github.com/davesavic/chatsavvy/store"
+
+var (
+	_ store.ConversationStore = (*Conversation)(nil)
+	_ store.MessageStore      = (*Message)(nil)
+	_ store.PresenceStore     = (*Presence)(nil)
+)
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"maps"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// collection scopes the reads and writes of a collection to a tenant.
+// Filters and inserted documents get the tenant id and aggregations start by matching it,
+// so the repositories cannot reach the documents of another tenant.
+// Documents without a tenant belong to the default tenant, whose id is empty.
+type collection struct {
+	coll   *mongo.Collection
+	tenant string
+}
+
+func (c Conversation) collection(name string) collection {
+	return collection{coll: c.db.Collection(name), tenant: c.tenant}
+}
+
+func (m Message) collection(name string) collection {
+	return collection{coll: m.db.Collection(name), tenant: m.tenant}
+}
+
+func (p Presence) collection(name string) collection {
+	return collection{coll: p.db.Collection(name), tenant: p.tenant}
+}
+
+// scope returns a copy of the filter constrained to the tenant.
+func (c collection) scope(filter bson.M) bson.M {
+	scoped := maps.Clone(filter)
+	if scoped == nil {
+		scoped = bson.M{}
+	}
+	scoped["tenant_id"] = c.tenant
+	return scoped
+}
+
+func (c collection) InsertOne(ctx context.Context, document bson.M, opts ...options.Lister[options.InsertOneOptions]) (*mongo.InsertOneResult, error) {
+	return c.coll.InsertOne(ctx, c.scope(document), opts...)
+}
+
+func (c collection) FindOne(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult {
+	return c.coll.FindOne(ctx, c.scope(filter), opts...)
+}
+
+func (c collection) Find(ctx context.Context, filter bson.M, opts ...options.Lister[options.FindOptions]) (*mongo.Cursor, error) {
+	return c.coll.Find(ctx, c.scope(filter), opts...)
+}
+
+func (c collection) CountDocuments(ctx context.Context, filter bson.M, opts ...options.Lister[options.CountOptions]) (int64, error) {
+	return c.coll.CountDocuments(ctx, c.scope(filter), opts...)
+}
+
+func (c collection) UpdateOne(ctx context.Context, filter bson.M, update any, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateOne(ctx, c.scope(filter), update, opts...)
+}
+
+func (c collection) UpdateMany(ctx context.Context, filter bson.M, update any, opts ...options.Lister[options.UpdateManyOptions]) (*mongo.UpdateResult, error) {
+	return c.coll.UpdateMany(ctx, c.scope(filter), update, opts...)
+}
+
+func (c collection) FindOneAndUpdate(ctx context.Context, filter bson.M, update any, opts ...options.Lister[options.FindOneAndUpdateOptions]) *mongo.SingleResult {
+	return c.coll.FindOneAndUpdate(ctx, c.scope(filter), update, opts...)
+}
+
+func (c collection) DeleteOne(ctx context.Context, filter bson.M, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	return c.coll.DeleteOne(ctx, c.scope(filter), opts...)
+}
+
+func (c collection) Aggregate(ctx context.Context, pipeline mongo.Pipeline, opts ...options.Lister[options.AggregateOptions]) (*mongo.Cursor, error) {
+	scoped := append(mongo.Pipeline{{{Key: "$match", Value: bson.M{"tenant_id": c.tenant}}}}, pipeline...)
+	return c.coll.Aggregate(ctx, scoped, opts...)
+}
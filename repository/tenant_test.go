@@ -0,0 +1,111 @@
+package repository_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Tenants(t *testing.T) {
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(t.Context()) })
+
+	db := client.Database("chatsavvy")
+	acmeConversations := repository.NewConversation(db, repository.WithTenant("acme"))
+	acmeMessages := repository.NewMessage(db, acmeConversations, repository.WithTenant("acme"))
+	globexConversations := repository.NewConversation(db, repository.WithTenant("globex"))
+	globexMessages := repository.NewMessage(db, globexConversations, repository.WithTenant("globex"))
+
+	participants := func(a, b string) data.CreateConversation {
+		return data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: a},
+				{ParticipantID: b},
+			},
+		}
+	}
+
+	t.Run("records the tenant on conversations and messages", func(t *testing.T) {
+		conv, err := acmeConversations.Create(t.Context(), participants("tn-rec-a", "tn-rec-b"))
+		require.NoError(t, err)
+		assert.Equal(t, "acme", conv.TenantID)
+
+		msg, err := acmeMessages.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "tn-rec-a"},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "acme", msg.TenantID)
+	})
+
+	t.Run("hides the conversations of other tenants", func(t *testing.T) {
+		conv, err := acmeConversations.Create(t.Context(), participants("tn-hide-a", "tn-hide-b"))
+		require.NoError(t, err)
+
+		found, err := globexConversations.Find(t.Context(), conv.ID.Hex())
+		assert.ErrorIs(t, err, repository.ErrConversationNotFound)
+		assert.Nil(t, found)
+
+		conversations, total, err := globexConversations.Paginate(t.Context(), data.PaginateConversations{
+			ParticipantID: "tn-hide-a",
+			Page:          1,
+			PerPage:       10,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint(0), total)
+		assert.Empty(t, conversations)
+
+		_, err = globexMessages.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "tn-hide-a"},
+			Content: "hello",
+		})
+		assert.Error(t, err)
+	})
+
+	t.Run("deduplicates direct conversations per tenant", func(t *testing.T) {
+		acme, err := acmeConversations.Create(t.Context(), participants("tn-dup-a", "tn-dup-b"))
+		require.NoError(t, err)
+
+		globex, err := globexConversations.Create(t.Context(), participants("tn-dup-a", "tn-dup-b"))
+		require.NoError(t, err)
+		assert.NotEqual(t, acme.ID.Hex(), globex.ID.Hex())
+
+		again, err := globexConversations.Create(t.Context(), participants("tn-dup-a", "tn-dup-b"))
+		require.NoError(t, err)
+		assert.Equal(t, globex.ID.Hex(), again.ID.Hex())
+	})
+
+	t.Run("rejects writes to messages of other tenants", func(t *testing.T) {
+		conv, err := acmeConversations.Create(t.Context(), participants("tn-edit-a", "tn-edit-b"))
+		require.NoError(t, err)
+
+		msg, err := acmeMessages.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "tn-edit-a"},
+			Content: "original",
+		})
+		require.NoError(t, err)
+
+		_, err = globexMessages.Edit(t.Context(), data.EditMessage{
+			MessageID: msg.ID.Hex(),
+			Editor:    data.MessageSender{ParticipantID: "tn-edit-a"},
+			Content:   "changed",
+		})
+		assert.Error(t, err)
+
+		messages, err := acmeMessages.LoadMessages(t.Context(), data.LoadMessages{
+			ConversationID: conv.ID.Hex(),
+			PerPage:        10,
+		})
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "original", messages[0].Content)
+	})
+}
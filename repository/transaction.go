@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// transactor runs writes in a multi-document transaction when the deployment supports them,
+// i.e. on a replica set or a sharded cluster. On a standalone server the writes run as is.
+type transactor struct {
+	db     *mongo.Database
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	checked   bool
+	supported bool
+}
+
+func newTransactor(db *mongo.Database, logger *slog.Logger) *transactor {
+	return &transactor{db: db, logger: logger}
+}
+
+// run calls fn inside a transaction if transactions are supported. fn may be retried on
+// transient transaction errors and must only write through the context it is given.
+func (t *transactor) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	supported, err := t.transactionsSupported(ctx)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fn(ctx)
+	}
+
+	session, err := t.db.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// transactionsSupported asks the server once whether it is a replica set member or a mongos.
+func (t *transactor) transactionsSupported(ctx context.Context) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.checked {
+		return t.supported, nil
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := t.db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, fmt.Errorf("failed to check transaction support: %w", err)
+	}
+
+	t.checked = true
+	t.supported = hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !t.supported {
+		t.logger.Info("transactions are not supported by the deployment, writing without them")
+	}
+	return t.supported, nil
+}
@@ -0,0 +1,1115 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Conversation struct {
+	db *DB
+	config
+}
+
+func NewConversation(db *DB, opts ...Option) *Conversation {
+	return &Conversation{db: db, config: newConfig(opts)}
+}
+
+// Tenant returns the tenant the repository is scoped to. The default tenant is empty.
+func (c Conversation) Tenant() string {
+	return c.tenant
+}
+
+func (c Conversation) ParticipantExists(ctx context.Context, conversationID string, d data.ParticipantExists) (bool, error) {
+	if err := d.Validate(); err != nil {
+		return false, fmt.Errorf("failed to validate participant exists data: %w", err)
+	}
+
+	contains, args, err := metadataContains(c.db.dialect, "p.metadata", d.Metadata)
+	if err != nil {
+		return false, err
+	}
+
+	var count uint
+	err = c.db.view(ctx, func(q conn) error {
+		var err error
+		count, err = q.count(ctx, `SELECT COUNT(*) FROM participants p JOIN conversations c ON c.id = p.conversation_id
+			WHERE c.id = ? AND c.tenant_id = ? AND p.participant_id = ?`+contains,
+			append([]any{conversationID, c.tenant, d.ParticipantID}, args...)...)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check if participant exists: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// AddParticipant adds a participant to the conversation, see repository.Conversation.AddParticipant.
+// It returns the updated conversation or an error.
+func (c Conversation) AddParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.AddParticipant) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate create participant data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+	d = chat.WithDefaultRole(d)
+
+	if err := c.hooks.RunBefore(ctx, hook.AddParticipant{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var conversation *model.Conversation
+	var added bool
+	err := c.db.update(ctx, c.logger, func(q conn) error {
+		current, err := c.get(ctx, q, conversationID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.authorize(current, actor, model.PermissionAddParticipant); err != nil {
+			return err
+		}
+		if d.Role != model.RoleMember {
+			if _, err := c.authorize(current, actor, model.PermissionManageRoles); err != nil {
+				return err
+			}
+		}
+
+		revive, active := -1, 0
+		for i, p := range current.Participants {
+			if p.DeletedAt == nil {
+				active++
+			}
+			if p.ParticipantID != d.ParticipantID || !metadataEqual(p.Metadata, d.Metadata) {
+				continue
+			}
+			if p.DeletedAt == nil {
+				conversation = current
+				return nil
+			}
+			revive = i
+		}
+
+		if c.limits.MaxParticipants > 0 && active >= c.limits.MaxParticipants {
+			return store.ErrTooManyParticipants
+		}
+
+		now := c.now()
+		if revive >= 0 {
+			participant := &current.Participants[revive]
+			participant.DeletedAt = nil
+			participant.Role = d.Role
+			participant.Memberships = append(participant.Memberships, model.Membership{JoinedAt: now})
+		} else {
+			if current.Kind != model.ConversationKindGroup {
+				return store.ErrNotGroup
+			}
+
+			current.Participants = append(current.Participants, newParticipant(d, now))
+		}
+		current.UpdatedAt = now
+
+		conversation, err = c.save(ctx, q, current)
+		added = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if added {
+		c.hooks.RunAfter(ctx, hook.ParticipantAdded{Conversation: conversation, Participant: d})
+	}
+
+	return conversation, nil
+}
+
+// DeleteParticipant deletes a participant from the conversation and ends its current
+// membership period, see repository.Conversation.DeleteParticipant.
+// It returns the updated conversation or an error.
+func (c Conversation) DeleteParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.DeleteParticipant) (*model.Conversation, error) {
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	if err := c.hooks.RunBefore(ctx, hook.DeleteParticipant{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var conversation *model.Conversation
+	var deleted bool
+	err := c.db.update(ctx, c.logger, func(q conn) error {
+		current, err := c.get(ctx, q, conversationID)
+		if err != nil {
+			return err
+		}
+
+		if actor != nil && (actor.ParticipantID != d.ParticipantID || !model.MetadataEqual(actor.Metadata, d.Metadata)) {
+			if _, err := c.authorize(current, actor, model.PermissionRemoveParticipant); err != nil {
+				return err
+			}
+
+			target := current.ActiveParticipant(d.ParticipantID, d.Metadata)
+			if target != nil && target.Role == model.RoleOwner {
+				if _, err := c.authorize(current, actor, model.PermissionManageRoles); err != nil {
+					return err
+				}
+			}
+		}
+
+		now := c.now()
+		for i := range current.Participants {
+			participant := &current.Participants[i]
+			if participant.DeletedAt != nil || participant.ParticipantID != d.ParticipantID || !metadataEqual(participant.Metadata, d.Metadata) {
+				continue
+			}
+
+			participant.DeletedAt = &now
+			for j := range participant.Memberships {
+				if participant.Memberships[j].LeftAt == nil {
+					participant.Memberships[j].LeftAt = &now
+				}
+			}
+			deleted = true
+		}
+		current.UpdatedAt = now
+
+		conversation, err = c.save(ctx, q, current)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted {
+		c.hooks.RunAfter(ctx, hook.ParticipantDeleted{Conversation: conversation, Participant: d})
+	}
+
+	return conversation, nil
+}
+
+// newParticipant returns the participant with its first membership period starting now.
+func newParticipant(d data.AddParticipant, now time.Time) model.Participant {
+	return model.Participant{
+		ParticipantID: d.ParticipantID,
+		Metadata:      d.Metadata,
+		Role:          d.Role,
+		Memberships:   []model.Membership{{JoinedAt: now}},
+	}
+}
+
+// withParticipants returns the direct conversation with exactly the participants, deleted
+// participants included, or nil.
+func (c Conversation) withParticipants(ctx context.Context, q conn, participants []data.AddParticipant) (*model.Conversation, error) {
+	clause := "c.kind <> ? AND (SELECT COUNT(*) FROM participants p WHERE p.conversation_id = c.id) = ?"
+	args := []any{model.ConversationKindGroup, len(participants)}
+	for _, p := range participants {
+		metadata, err := identity(p.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		clause += " AND EXISTS (SELECT 1 FROM participants p WHERE p.conversation_id = c.id AND p.participant_id = ? AND p.metadata = ?)"
+		args = append(args, p.ParticipantID, metadata)
+	}
+
+	conversations, err := c.query(ctx, q, clause+" ORDER BY c.id LIMIT 1", args...)
+	if err != nil || len(conversations) == 0 {
+		return nil, err
+	}
+
+	return &conversations[0], nil
+}
+
+// Create creates a conversation with the participants, see repository.Conversation.Create.
+// It returns the created conversation or an error.
+func (c Conversation) Create(ctx context.Context, d data.CreateConversation) (*model.Conversation, error) {
+	if err := d.ValidateWith(c.limits); err != nil {
+		return nil, fmt.Errorf("failed to validate create conversation data: %w", err)
+	}
+
+	if err := c.hooks.RunBefore(ctx, hook.CreateConversation{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	kind := d.Kind
+	if kind == "" {
+		kind = model.ConversationKindDirect
+	}
+
+	historyVisibility := d.HistoryVisibility
+	if historyVisibility == "" {
+		historyVisibility = model.HistoryVisibilityShared
+	}
+
+	var conversation *model.Conversation
+	var created bool
+	err := c.db.update(ctx, c.logger, func(q conn) error {
+		if kind == model.ConversationKindDirect {
+			existing, err := c.withParticipants(ctx, q, d.Participants)
+			if err != nil {
+				return fmt.Errorf("failed to check if conversation exists: %w", err)
+			}
+			if existing != nil {
+				conversation = existing
+				return nil
+			}
+		}
+
+		now := c.now()
+		participants := make([]model.Participant, 0, len(d.Participants))
+		for _, p := range d.Participants {
+			participants = append(participants, newParticipant(chat.WithDefaultRole(p), now))
+		}
+
+		var err error
+		conversation, err = c.save(ctx, q, &model.Conversation{
+			ID:                bson.NewObjectID(),
+			TenantID:          c.tenant,
+			Kind:              kind,
+			Title:             d.Title,
+			Participants:      participants,
+			Metadata:          d.Metadata,
+			HistoryVisibility: historyVisibility,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		})
+		created = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		c.hooks.RunAfter(ctx, hook.ConversationCreated{Conversation: conversation})
+	}
+
+	return conversation, nil
+}
+
+// UpdateMetadata replaces the metadata of the conversation.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) UpdateMetadata(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversationMetadata) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate update conversation metadata data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(ctx, conversationID, func(conversation *model.Conversation) error {
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		conversation.Metadata = d.Metadata
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetTitle changes the title of a group. Direct conversations have no title.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetTitle(ctx context.Context, conversationID string, actor *data.Actor, d data.SetTitle) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set title data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(ctx, conversationID, func(conversation *model.Conversation) error {
+		if conversation.Kind != model.ConversationKindGroup {
+			return store.ErrNotGroup
+		}
+
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		conversation.Title = d.Title
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetHistoryVisibility changes whether participants see the messages sent before they joined.
+// It only affects reads, so switching back to shared restores the full history.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetHistoryVisibility(ctx context.Context, conversationID string, actor *data.Actor, d data.SetHistoryVisibility) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set history visibility data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(ctx, conversationID, func(conversation *model.Conversation) error {
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		conversation.HistoryVisibility = d.HistoryVisibility
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// Update changes the title, metadata and history visibility that are set, at once, see
+// SetTitle, UpdateMetadata and SetHistoryVisibility. Nothing is changed if any of them fails.
+// The actor needs the update metadata permission.
+// It returns the updated conversation or an error.
+func (c Conversation) Update(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversation) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate update conversation data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(ctx, conversationID, func(conversation *model.Conversation) error {
+		if d.Title != nil && conversation.Kind != model.ConversationKindGroup {
+			return store.ErrNotGroup
+		}
+
+		if _, err := c.authorize(conversation, actor, model.PermissionUpdateMetadata); err != nil {
+			return err
+		}
+
+		if d.Title != nil {
+			conversation.Title = *d.Title
+		}
+		if d.Metadata != nil {
+			conversation.Metadata = *d.Metadata
+		}
+		if d.HistoryVisibility != nil {
+			conversation.HistoryVisibility = *d.HistoryVisibility
+		}
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetRole changes the role of a non-deleted participant.
+// The actor needs the manage roles permission.
+// It returns the updated conversation or an error.
+func (c Conversation) SetRole(ctx context.Context, conversationID string, actor *data.Actor, d data.SetParticipantRole) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set participant role data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	return c.modify(ctx, conversationID, func(conversation *model.Conversation) error {
+		if _, err := c.authorize(conversation, actor, model.PermissionManageRoles); err != nil {
+			return err
+		}
+
+		participant := conversation.ActiveParticipant(d.ParticipantID, d.Metadata)
+		if participant == nil {
+			return store.ErrNotParticipant
+		}
+
+		participant.Role = d.Role
+		conversation.UpdatedAt = c.now()
+		return nil
+	})
+}
+
+// SetPinned pins or unpins the conversation for the participant only.
+// It returns the updated conversation or an error.
+func (c Conversation) SetPinned(ctx context.Context, conversationID string, d data.SetPinned) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set pinned data: %w", err)
+	}
+
+	return c.updateParticipantState(ctx, conversationID, d.Participant, func(participant *model.Participant) {
+		participant.PinnedAt = nil
+		if d.Pinned {
+			now := c.now()
+			participant.PinnedAt = &now
+		}
+	})
+}
+
+// SetArchived archives or unarchives the conversation for the participant only.
+// An archived conversation is unarchived when a new message arrives.
+// It returns the updated conversation or an error.
+func (c Conversation) SetArchived(ctx context.Context, conversationID string, d data.SetArchived) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set archived data: %w", err)
+	}
+
+	return c.updateParticipantState(ctx, conversationID, d.Participant, func(participant *model.Participant) {
+		participant.ArchivedAt = nil
+		if d.Archived {
+			now := c.now()
+			participant.ArchivedAt = &now
+		}
+	})
+}
+
+// SetMuted mutes or unmutes the conversation for the participant only.
+// The mute ends on its own at Until, if set.
+// It returns the updated conversation or an error.
+func (c Conversation) SetMuted(ctx context.Context, conversationID string, d data.SetMuted) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate set muted data: %w", err)
+	}
+
+	return c.updateParticipantState(ctx, conversationID, d.Participant, func(participant *model.Participant) {
+		participant.MutedAt, participant.MutedUntil = nil, nil
+		if d.Muted {
+			now := c.now()
+			participant.MutedAt, participant.MutedUntil = &now, d.Until
+		}
+	})
+}
+
+// updateParticipantState applies the update to the participant's own entry. The conversation's
+// updated_at is left untouched since the change is only visible to the participant.
+// It returns the updated conversation or an error.
+func (c Conversation) updateParticipantState(ctx context.Context, conversationID string, participant data.ReadParticipant, update func(*model.Participant)) (*model.Conversation, error) {
+	return c.modify(ctx, conversationID, func(conversation *model.Conversation) error {
+		p := conversation.ActiveParticipant(participant.ParticipantID, participant.Metadata)
+		if p == nil {
+			return store.ErrNotParticipant
+		}
+
+		update(p)
+		return nil
+	})
+}
+
+// Unarchive brings the conversation back for every participant that archived it.
+// It returns an error.
+func (c Conversation) Unarchive(ctx context.Context, conversationID string) error {
+	return c.db.update(ctx, c.logger, func(q conn) error {
+		return c.unarchive(ctx, q, conversationID)
+	})
+}
+
+// unarchive is Unarchive within the caller's transaction.
+func (c Conversation) unarchive(ctx context.Context, q conn, conversationID string) error {
+	_, err := q.exec(ctx, `UPDATE participants SET archived_at = NULL
+		WHERE conversation_id IN (SELECT id FROM conversations WHERE id = ? AND tenant_id = ?)`,
+		conversationID, c.tenant)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive conversation: %w", err)
+	}
+
+	return nil
+}
+
+// Find fetches the conversation by id.
+// It returns the conversation, or ErrConversationNotFound if there is none, or an error.
+func (c Conversation) Find(ctx context.Context, id string) (*model.Conversation, error) {
+	var conversation *model.Conversation
+	err := c.db.view(ctx, func(q conn) error {
+		var err error
+		conversation, err = c.get(ctx, q, id)
+		return err
+	})
+
+	return conversation, err
+}
+
+// Paginate fetches conversations for the participant, the ones the participant pinned first,
+// most recently pinned first, then the others by last activity.
+// Conversations the participant archived are excluded unless IncludeArchived is set.
+// It returns the conversations and the total number of conversations or an error.
+func (c Conversation) Paginate(ctx context.Context, d data.PaginateConversations) ([]model.Conversation, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate paginate conversations data: %w", err)
+	}
+
+	match, args, err := memberMatch(d.ParticipantID, d.Metadata, d.IncludeArchived)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var conversations []model.Conversation
+	var total uint
+	err = c.db.view(ctx, func(q conn) error {
+		var err error
+		total, err = c.count(ctx, q, match, args...)
+		if err != nil {
+			return err
+		}
+
+		conversations, err = c.query(ctx, q, match+`
+			ORDER BY COALESCE((SELECT p.pinned_at FROM participants p WHERE `+memberClause+`), 0) DESC,
+				c.updated_at DESC, c.id DESC
+			LIMIT ? OFFSET ?`,
+			// The pinned_at subquery takes the member arguments again.
+			append(append(args, args...), d.PerPage, (d.Page-1)*d.PerPage)...)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return conversations, total, nil
+}
+
+// FindByParticipants finds the direct conversation between exactly the specified participants.
+// It returns ErrConversationNotFound if there is none.
+func (c Conversation) FindByParticipants(ctx context.Context, d data.FindByParticipants) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate find by participants data: %w", err)
+	}
+
+	participants := make([]data.AddParticipant, len(d.Participants))
+	for i, p := range d.Participants {
+		participants[i] = data.AddParticipant{
+			ParticipantID: p.ParticipantID,
+			Metadata:      p.Metadata,
+		}
+	}
+
+	var conversation *model.Conversation
+	err := c.db.view(ctx, func(q conn) error {
+		var err error
+		conversation, err = c.withParticipants(ctx, q, participants)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find conversation: %w", err)
+	}
+	if conversation == nil {
+		return nil, store.ErrConversationNotFound
+	}
+
+	return conversation, nil
+}
+
+// FindByMetadata finds conversations that have participants matching the specified metadata,
+// see repository.Conversation.FindByMetadata.
+// It returns paginated conversations, total count, or an error.
+func (c Conversation) FindByMetadata(ctx context.Context, d data.FindByMetadata) ([]model.Conversation, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate find by metadata data: %w", err)
+	}
+
+	var conversations []model.Conversation
+	var total uint
+	err := c.db.view(ctx, func(q conn) error {
+		match, args, err := metadataMatch(c.db.dialect, d.Metadata, d.MatchMode, d.IncludeDeleted)
+		if err != nil {
+			return err
+		}
+
+		total, err = c.count(ctx, q, match, args...)
+		if err != nil {
+			return err
+		}
+
+		conversations, err = c.query(ctx, q, match+" ORDER BY c.updated_at DESC, c.id DESC LIMIT ? OFFSET ?",
+			append(args, d.PerPage, (d.Page-1)*d.PerPage)...)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return conversations, total, nil
+}
+
+// LoadConversations fetches the conversations the participant is a non-deleted member of,
+// most recently updated first, continuing from the cursor of the previous page.
+// Conversations the participant archived are excluded unless IncludeArchived is set.
+// It returns the page or an error.
+func (c Conversation) LoadConversations(ctx context.Context, d data.LoadConversations) (*model.ConversationPage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load conversations data: %w", err)
+	}
+
+	match, args, err := memberMatch(d.ParticipantID, d.Metadata, d.IncludeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.loadPage(ctx, match, args, d.Cursor, d.PerPage, d.Count)
+}
+
+// LoadByMetadata is the cursor based variant of FindByMetadata, see LoadConversations.
+// It returns the page or an error.
+func (c Conversation) LoadByMetadata(ctx context.Context, d data.LoadByMetadata) (*model.ConversationPage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load by metadata data: %w", err)
+	}
+
+	match, args, err := metadataMatch(c.db.dialect, d.Metadata, d.MatchMode, d.IncludeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.loadPage(ctx, match, args, d.Cursor, d.PerPage, d.Count)
+}
+
+// loadPage fetches the page of conversations matching after the cursor,
+// ordered by updated_at then id, both descending.
+func (c Conversation) loadPage(ctx context.Context, match string, args []any, cursor string, perPage uint, count bool) (*model.ConversationPage, error) {
+	after, afterArgs := "", []any{}
+	if cursor != "" {
+		updatedAt, id, err := chat.DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = " AND (c.updated_at < ? OR (c.updated_at = ? AND c.id < ?))"
+		afterArgs = []any{millis(updatedAt), millis(updatedAt), id.Hex()}
+	}
+
+	page := &model.ConversationPage{Conversations: []model.Conversation{}}
+	err := c.db.view(ctx, func(q conn) error {
+		if count {
+			total, err := c.count(ctx, q, match, args...)
+			if err != nil {
+				return err
+			}
+			page.Total = &total
+		}
+
+		conversations, err := c.query(ctx, q, match+after+" ORDER BY c.updated_at DESC, c.id DESC LIMIT ?",
+			append(append(args, afterArgs...), perPage+1)...)
+		if err != nil {
+			return err
+		}
+
+		page.Conversations = append(page.Conversations, conversations...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if uint(len(page.Conversations)) > perPage {
+		page.Conversations = page.Conversations[:perPage]
+		last := page.Conversations[perPage-1]
+		page.HasMore = true
+		page.NextCursor = chat.EncodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+// UpdateLastMessage sets the message as the conversation's last message and advances the
+// conversation's updated_at to the message's creation time. Neither goes backwards.
+// It returns an error.
+func (c Conversation) UpdateLastMessage(ctx context.Context, conversationID string, message model.Message) error {
+	return c.db.update(ctx, c.logger, func(q conn) error {
+		return c.updateLastMessage(ctx, q, conversationID, message)
+	})
+}
+
+// updateLastMessage is UpdateLastMessage within the caller's transaction.
+func (c Conversation) updateLastMessage(ctx context.Context, q conn, conversationID string, message model.Message) error {
+	result, err := q.exec(ctx, `UPDATE conversations SET
+			last_message_id = CASE WHEN last_message_id IS NULL OR last_message_id < ? THEN ? ELSE last_message_id END,
+			updated_at = CASE WHEN updated_at < ? THEN ? ELSE updated_at END
+		WHERE id = ? AND tenant_id = ?`,
+		message.ID.Hex(), message.ID.Hex(), millis(message.CreatedAt), millis(message.CreatedAt), conversationID, c.tenant)
+	if err != nil {
+		return fmt.Errorf("failed to update last message: %w", err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update last message: %w", err)
+	}
+	if updated == 0 {
+		return store.ErrConversationNotFound
+	}
+
+	return nil
+}
+
+// RefreshLastMessage is a no-op kept for the store interface. The last message of a
+// conversation is read from the messages table rather than copied into the conversation,
+// so it is always the current version of the message.
+func (c Conversation) RefreshLastMessage(ctx context.Context, conversationID string, message model.Message) error {
+	return nil
+}
+
+// authorize checks the actor against the repository's permission matrix, see chat.Authorize.
+func (c Conversation) authorize(conversation *model.Conversation, actor *data.Actor, permission model.Permission) (*model.Participant, error) {
+	return chat.Authorize(c.permissions, conversation, actor, permission)
+}
+
+// find fetches the conversation of the tenant by id, or nil. Within a transaction the
+// conversation is locked until the transaction ends.
+func (c Conversation) find(ctx context.Context, q conn, id string) (*model.Conversation, error) {
+	obID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse conversation id: %w", err)
+	}
+
+	conversations, err := c.query(ctx, q, "c.id = ?"+q.forUpdate(), obID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+	if len(conversations) == 0 {
+		return nil, nil
+	}
+
+	return &conversations[0], nil
+}
+
+// get is find for operations on an existing conversation. It returns ErrConversationNotFound
+// if there is none.
+func (c Conversation) get(ctx context.Context, q conn, id string) (*model.Conversation, error) {
+	conversation, err := c.find(ctx, q, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", err)
+	}
+	if conversation == nil {
+		return nil, fmt.Errorf("failed to fetch the conversation: %w", store.ErrConversationNotFound)
+	}
+
+	return conversation, nil
+}
+
+// modify applies the update to the conversation and saves it, atomically.
+// It returns the updated conversation or an error.
+func (c Conversation) modify(ctx context.Context, conversationID string, update func(*model.Conversation) error) (*model.Conversation, error) {
+	var conversation *model.Conversation
+	err := c.db.update(ctx, c.logger, func(q conn) error {
+		current, err := c.get(ctx, q, conversationID)
+		if err != nil {
+			return err
+		}
+
+		if err := update(current); err != nil {
+			return err
+		}
+
+		conversation, err = c.save(ctx, q, current)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return conversation, nil
+}
+
+// save writes the conversation and its participants and returns the stored version of it.
+// The last message is only referenced by id, see UpdateLastMessage.
+func (c Conversation) save(ctx context.Context, q conn, conversation *model.Conversation) (*model.Conversation, error) {
+	metadata, err := encodeJSON(conversation.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	var lastMessageID any
+	if conversation.LastMessage != nil {
+		lastMessageID = conversation.LastMessage.ID.Hex()
+	}
+
+	id := conversation.ID.Hex()
+	_, err = q.exec(ctx, `INSERT INTO conversations
+			(id, tenant_id, kind, title, metadata, history_visibility, last_message_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			kind = excluded.kind,
+			title = excluded.title,
+			metadata = excluded.metadata,
+			history_visibility = excluded.history_visibility,
+			last_message_id = excluded.last_message_id,
+			updated_at = excluded.updated_at`,
+		id, conversation.TenantID, conversation.Kind, conversation.Title, metadata, conversation.HistoryVisibility,
+		lastMessageID, millis(conversation.CreatedAt), millis(conversation.UpdatedAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	for _, table := range []string{"memberships", "participants"} {
+		if _, err := q.exec(ctx, "DELETE FROM "+table+" WHERE conversation_id = ?", id); err != nil {
+			return nil, fmt.Errorf("failed to save participants: %w", err)
+		}
+	}
+
+	for seq, p := range conversation.Participants {
+		metadata, err := identity(p.Metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastReadMessageID any
+		if p.LastReadMessageID != nil {
+			lastReadMessageID = p.LastReadMessageID.Hex()
+		}
+
+		_, err = q.exec(ctx, `INSERT INTO participants
+				(conversation_id, seq, participant_id, metadata, role, deleted_at, last_read_message_id, last_read_at,
+					pinned_at, archived_at, muted_at, muted_until)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, seq, p.ParticipantID, metadata, p.Role, nullMillis(p.DeletedAt), lastReadMessageID, nullMillis(p.LastReadAt),
+			nullMillis(p.PinnedAt), nullMillis(p.ArchivedAt), nullMillis(p.MutedAt), nullMillis(p.MutedUntil))
+		if err != nil {
+			return nil, fmt.Errorf("failed to save participants: %w", err)
+		}
+
+		for _, membership := range p.Memberships {
+			_, err := q.exec(ctx, "INSERT INTO memberships (conversation_id, seq, joined_at, left_at) VALUES (?, ?, ?, ?)",
+				id, seq, millis(membership.JoinedAt), nullMillis(membership.LeftAt))
+			if err != nil {
+				return nil, fmt.Errorf("failed to save participants: %w", err)
+			}
+		}
+	}
+
+	saved, err := c.find(ctx, q, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save conversation: %w", err)
+	}
+
+	return saved, nil
+}
+
+// count returns the number of conversations of the tenant matching the clause.
+func (c Conversation) count(ctx context.Context, q conn, clause string, args ...any) (uint, error) {
+	count, err := q.count(ctx, "SELECT COUNT(*) FROM conversations c WHERE c.tenant_id = ? AND "+clause,
+		append([]any{c.tenant}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count conversations: %w", err)
+	}
+
+	return count, nil
+}
+
+// query fetches the conversations of the tenant matching the clause, which may end with an
+// ORDER BY and a LIMIT, along with their participants and last message.
+func (c Conversation) query(ctx context.Context, q conn, clause string, args ...any) ([]model.Conversation, error) {
+	rows, err := q.query(ctx, `SELECT c.id, c.tenant_id, c.kind, c.title, c.metadata, c.history_visibility,
+			c.last_message_id, c.created_at, c.updated_at
+		FROM conversations c WHERE c.tenant_id = ? AND `+clause,
+		append([]any{c.tenant}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []model.Conversation{}
+	lastMessageIDs := map[string]string{}
+	for rows.Next() {
+		var conversation model.Conversation
+		var id string
+		var metadata, lastMessageID sql.NullString
+		var createdAt, updatedAt int64
+		err := rows.Scan(&id, &conversation.TenantID, &conversation.Kind, &conversation.Title, &metadata,
+			&conversation.HistoryVisibility, &lastMessageID, &createdAt, &updatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch conversations: %w", err)
+		}
+
+		if conversation.ID, err = bson.ObjectIDFromHex(id); err != nil {
+			return nil, fmt.Errorf("failed to parse conversation id: %w", err)
+		}
+		if conversation.Metadata, err = decodeJSON[map[string]any](metadata); err != nil {
+			return nil, err
+		}
+		conversation.CreatedAt, conversation.UpdatedAt = timeOf(createdAt), timeOf(updatedAt)
+		if lastMessageID.Valid {
+			lastMessageIDs[id] = lastMessageID.String
+		}
+
+		conversations = append(conversations, conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+	rows.Close()
+
+	if len(conversations) == 0 {
+		return conversations, nil
+	}
+
+	if err := loadParticipants(ctx, q, conversations); err != nil {
+		return nil, err
+	}
+
+	if len(lastMessageIDs) > 0 {
+		ids := make([]any, 0, len(lastMessageIDs))
+		for _, id := range lastMessageIDs {
+			ids = append(ids, id)
+		}
+
+		messages, err := queryMessages(ctx, q, c.tenant, "m.id IN ("+placeholders(len(ids))+")", ids...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch last messages: %w", err)
+		}
+
+		byID := make(map[string]model.Message, len(messages))
+		for _, message := range messages {
+			message.Revisions = nil
+			byID[message.ID.Hex()] = message
+		}
+
+		for i := range conversations {
+			if message, ok := byID[lastMessageIDs[conversations[i].ID.Hex()]]; ok {
+				conversations[i].LastMessage = &message
+			}
+		}
+	}
+
+	return conversations, nil
+}
+
+// loadParticipants fetches the participants of the conversations, with their memberships,
+// in the order they joined.
+func loadParticipants(ctx context.Context, q conn, conversations []model.Conversation) error {
+	ids := make([]any, len(conversations))
+	index := make(map[string]int, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID.Hex()
+		index[conversation.ID.Hex()] = i
+	}
+	in := "(" + placeholders(len(ids)) + ")"
+
+	rows, err := q.query(ctx, `SELECT conversation_id, participant_id, metadata, role, deleted_at, last_read_message_id,
+			last_read_at, pinned_at, archived_at, muted_at, muted_until
+		FROM participants WHERE conversation_id IN `+in+` ORDER BY conversation_id, seq`, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch participants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p model.Participant
+		var conversationID string
+		var metadata, lastReadMessageID sql.NullString
+		var deletedAt, lastReadAt, pinnedAt, archivedAt, mutedAt, mutedUntil sql.NullInt64
+		err := rows.Scan(&conversationID, &p.ParticipantID, &metadata, &p.Role, &deletedAt, &lastReadMessageID,
+			&lastReadAt, &pinnedAt, &archivedAt, &mutedAt, &mutedUntil)
+		if err != nil {
+			return fmt.Errorf("failed to fetch participants: %w", err)
+		}
+
+		if p.Metadata, err = metadataOf(metadata); err != nil {
+			return err
+		}
+		if lastReadMessageID.Valid {
+			id, err := bson.ObjectIDFromHex(lastReadMessageID.String)
+			if err != nil {
+				return fmt.Errorf("failed to parse last read message id: %w", err)
+			}
+			p.LastReadMessageID = &id
+		}
+		p.DeletedAt, p.LastReadAt = nullTimeOf(deletedAt), nullTimeOf(lastReadAt)
+		p.PinnedAt, p.ArchivedAt = nullTimeOf(pinnedAt), nullTimeOf(archivedAt)
+		p.MutedAt, p.MutedUntil = nullTimeOf(mutedAt), nullTimeOf(mutedUntil)
+
+		conversation := &conversations[index[conversationID]]
+		conversation.Participants = append(conversation.Participants, p)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch participants: %w", err)
+	}
+	rows.Close()
+
+	rows, err = q.query(ctx, `SELECT conversation_id, seq, joined_at, left_at
+		FROM memberships WHERE conversation_id IN `+in+` ORDER BY conversation_id, seq, joined_at`, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to fetch memberships: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID string
+		var seq int
+		var joinedAt int64
+		var leftAt sql.NullInt64
+		if err := rows.Scan(&conversationID, &seq, &joinedAt, &leftAt); err != nil {
+			return fmt.Errorf("failed to fetch memberships: %w", err)
+		}
+
+		participants := conversations[index[conversationID]].Participants
+		if seq < len(participants) {
+			participants[seq].Memberships = append(participants[seq].Memberships, model.Membership{
+				JoinedAt: timeOf(joinedAt),
+				LeftAt:   nullTimeOf(leftAt),
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch memberships: %w", err)
+	}
+
+	return nil
+}
+
+// memberClause matches the participant's own non-deleted row of the conversation c, given
+// the participant id and the metadata encoded by identity.
+const memberClause = "p.conversation_id = c.id AND p.participant_id = ? AND p.metadata = ? AND p.deleted_at IS NULL"
+
+// memberMatch returns the clause matching the conversations the participant is a non-deleted
+// member of, and has not archived unless includeArchived is set, along with its arguments.
+func memberMatch(participantID string, metadata map[string]any, includeArchived bool) (string, []any, error) {
+	encoded, err := identity(metadata)
+	if err != nil {
+		return "", nil, err
+	}
+
+	clause := "EXISTS (SELECT 1 FROM participants p WHERE " + memberClause
+	if !includeArchived {
+		clause += " AND p.archived_at IS NULL"
+	}
+	return clause + ")", []any{participantID, encoded}, nil
+}
+
+// metadataMatch returns the clause matching the conversations with a participant matching
+// the metadata, along with its arguments.
+func metadataMatch(dialect Dialect, metadata map[string]any, matchMode data.MetadataMatchMode, includeDeleted bool) (string, []any, error) {
+	clause := "EXISTS (SELECT 1 FROM participants p WHERE p.conversation_id = c.id"
+	if !includeDeleted {
+		clause += " AND p.deleted_at IS NULL"
+	}
+
+	if matchMode == data.MetadataMatchModeExact {
+		encoded, err := identity(metadata)
+		if err != nil {
+			return "", nil, err
+		}
+		return clause + " AND p.metadata = ?)", []any{encoded}, nil
+	}
+
+	contains, args, err := metadataContains(dialect, "p.metadata", metadata)
+	if err != nil {
+		return "", nil, err
+	}
+	return clause + contains + ")", args, nil
+}
+
+// metadataContains returns the conditions matching the rows whose metadata column holds
+// every key and value of the metadata, like a MongoDB query on the metadata's fields.
+func metadataContains(dialect Dialect, column string, metadata map[string]any) (string, []any, error) {
+	var b strings.Builder
+	args := []any{}
+	for _, key := range slices.Sorted(maps.Keys(metadata)) {
+		condition, conditionArgs, err := dialect.metadataField(column, key, metadata[key])
+		if err != nil {
+			return "", nil, err
+		}
+		b.WriteString(" AND " + condition)
+		args = append(args, conditionArgs...)
+	}
+	return b.String(), args, nil
+}
@@ -0,0 +1,238 @@
+// Package sqlstore implements the storage interfaces of the store package on a relational
+// database through database/sql, for deployments that cannot run MongoDB.
+// It behaves like the MongoDB repositories. SQLite and PostgreSQL are supported, the schema
+// is created by the migrations of the sqlstore/migrations package and the caller registers
+// the database driver, e.g. github.com/mattn/go-sqlite3 or github.com/jackc/pgx/v5/stdlib.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is the SQL dialect spoken by the database.
+type Dialect string
+
+const (
+	SQLite     Dialect = "sqlite"
+	PostgreSQL Dialect = "postgres"
+)
+
+// Rebind rewrites the ? placeholders of the query into the placeholders of the dialect.
+// Queries are written with ? placeholders, which SQLite understands as is.
+func (d Dialect) Rebind(query string) string {
+	if d != PostgreSQL {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// DB is the database the SQL repositories read and write.
+// Repositories built on the same DB see the same rows. SQLite only allows one writer at a time,
+// so SQLite databases are best opened with a single connection, see sql.DB.SetMaxOpenConns.
+type DB struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewDB(db *sql.DB, dialect Dialect) *DB {
+	return &DB{db: db, dialect: dialect}
+}
+
+// querier is implemented by both sql.DB and sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// conn runs the queries of a read or of a transaction, in the dialect of the database.
+type conn struct {
+	q       querier
+	dialect Dialect
+	tx      bool
+}
+
+// view runs fn on the database.
+func (db *DB) view(ctx context.Context, fn func(q conn) error) error {
+	return fn(conn{q: db.db, dialect: db.dialect})
+}
+
+// update runs fn in a transaction, so that fn reads and writes atomically.
+// The rows fn reads to update them are locked until the transaction ends, see forUpdate.
+// The transaction is committed if fn succeeds and rolled back otherwise, failing to roll back
+// is logged to the logger.
+func (db *DB) update(ctx context.Context, logger *slog.Logger, fn func(q conn) error) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(conn{q: tx, dialect: db.dialect, tx: true}); err != nil {
+		if rerr := tx.Rollback(); rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
+			logger.Error("failed to roll back transaction", "error", rerr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (q conn) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return q.q.ExecContext(ctx, q.dialect.Rebind(query), args...)
+}
+
+func (q conn) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return q.q.QueryContext(ctx, q.dialect.Rebind(query), args...)
+}
+
+// count runs the query, which selects a single count.
+func (q conn) count(ctx context.Context, query string, args ...any) (uint, error) {
+	rows, err := q.query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return 0, err
+		}
+	}
+	return uint(count), rows.Err()
+}
+
+// forUpdate is the clause locking the selected rows until the end of the transaction, if any.
+// SQLite locks the whole database for writes instead.
+func (q conn) forUpdate() string {
+	if q.tx && q.dialect == PostgreSQL {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// metadataField returns the condition comparing the value under the key of the JSON metadata
+// column with the value, along with its arguments.
+func (d Dialect) metadataField(column string, key string, value any) (string, []any, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode metadata value: %w", err)
+	}
+
+	if d == PostgreSQL {
+		return "(" + column + "::jsonb -> ?::text) = ?::jsonb", []any{key, string(encoded)}, nil
+	}
+	return "json_extract(" + column + ", ?) = json_extract(?, '$')", []any{`$."` + key + `"`, string(encoded)}, nil
+}
+
+// placeholders returns n comma separated placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// millis encodes the time as milliseconds since the epoch, the precision MongoDB stores.
+func millis(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// nullMillis encodes the optional time, see millis.
+func nullMillis(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+// timeOf decodes the time encoded by millis.
+func timeOf(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
+
+// nullTimeOf decodes the optional time encoded by nullMillis.
+func nullTimeOf(ms sql.NullInt64) *time.Time {
+	if !ms.Valid {
+		return nil
+	}
+	t := timeOf(ms.Int64)
+	return &t
+}
+
+// identity encodes the metadata identifying a participant so that it can be matched with a
+// plain equality. JSON encoding sorts map keys, so equal metadata always produces the same
+// encoding, and nil and empty metadata are the same.
+func identity(metadata map[string]any) (string, error) {
+	if len(metadata) == 0 {
+		return "{}", nil
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode participant metadata: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// metadataEqual reports whether the metadata identify the same participant, see identity.
+func metadataEqual(a, b map[string]any) bool {
+	encodedA, errA := identity(a)
+	encodedB, errB := identity(b)
+	return errA == nil && errB == nil && encodedA == encodedB
+}
+
+// metadataOf decodes the metadata encoded by identity or encodeJSON.
+func metadataOf(encoded sql.NullString) (map[string]any, error) {
+	if !encoded.Valid || encoded.String == "{}" {
+		return nil, nil
+	}
+
+	var metadata map[string]any
+	if err := json.Unmarshal([]byte(encoded.String), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// encodeJSON encodes the value of a JSON column, NULL for a nil value.
+func encodeJSON[T any](value T) (any, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode column: %w", err)
+	}
+	if string(encoded) == "null" {
+		return nil, nil
+	}
+	return string(encoded), nil
+}
+
+// decodeJSON decodes the value of a JSON column encoded by encodeJSON.
+func decodeJSON[T any](encoded sql.NullString) (T, error) {
+	var value T
+	if !encoded.Valid {
+		return value, nil
+	}
+	if err := json.Unmarshal([]byte(encoded.String), &value); err != nil {
+		return value, fmt.Errorf("failed to decode column: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,153 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// Inbox fetches the page of conversations the participant is a non-deleted member of, each
+// with its unread count and the participant's own read cursor, along with the unread badge
+// counts over the whole inbox, see repository.Conversation.Inbox.
+// It returns the inbox page or an error.
+func (c Conversation) Inbox(ctx context.Context, d data.LoadInbox) (*model.Inbox, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load inbox data: %w", err)
+	}
+
+	var after func(model.Conversation) bool
+	if d.Cursor != "" {
+		updatedAt, id, err := chat.DecodeCursor(d.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = func(conversation model.Conversation) bool {
+			if conversation.UpdatedAt.Equal(updatedAt) {
+				return conversation.ID.Hex() < id.Hex()
+			}
+			return conversation.UpdatedAt.Before(updatedAt)
+		}
+	}
+
+	metadata, err := identity(d.Participant.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	inbox := &model.Inbox{Entries: []model.InboxEntry{}}
+	err = c.db.view(ctx, func(q conn) error {
+		conversations, err := c.query(ctx, q, `EXISTS (SELECT 1 FROM participants p WHERE p.conversation_id = c.id
+			AND p.participant_id = ? AND p.metadata = ? AND p.deleted_at IS NULL) ORDER BY c.id`,
+			d.Participant.ParticipantID, metadata)
+		if err != nil {
+			return err
+		}
+
+		counts, err := unreadCounts(ctx, q, c.tenant, d.Participant, "")
+		if err != nil {
+			return err
+		}
+
+		now := c.now()
+		for _, conversation := range conversations {
+			me := *conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata)
+			unreadCount := counts[conversation.ID.Hex()]
+
+			if !me.Muted(now) {
+				inbox.UnreadMessages += unreadCount
+				if unreadCount > 0 {
+					inbox.UnreadConversations++
+				}
+			}
+
+			if !d.IncludeArchived && me.ArchivedAt != nil {
+				continue
+			}
+			if d.UnreadOnly && unreadCount == 0 {
+				continue
+			}
+			if after != nil && !after(conversation) {
+				continue
+			}
+
+			inbox.Entries = append(inbox.Entries, model.InboxEntry{
+				Conversation: conversation,
+				Participant:  me,
+				UnreadCount:  unreadCount,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(inbox.Entries, func(a, b model.InboxEntry) int {
+		if order := b.Conversation.UpdatedAt.Compare(a.Conversation.UpdatedAt); order != 0 {
+			return order
+		}
+		return strings.Compare(b.Conversation.ID.Hex(), a.Conversation.ID.Hex())
+	})
+
+	if uint(len(inbox.Entries)) > d.PerPage {
+		inbox.Entries = inbox.Entries[:d.PerPage]
+		last := inbox.Entries[d.PerPage-1].Conversation
+		inbox.HasMore = true
+		inbox.NextCursor = chat.EncodeCursor(last.UpdatedAt, last.ID)
+	}
+
+	return inbox, nil
+}
+
+// unreadCounts counts by conversation the messages the reader has not read in the
+// conversations the reader is a non-deleted participant of, restricted by the conditions
+// of the clause: the messages after the read cursor that are not deleted, not hidden from
+// the reader, not the reader's own and within the reader's history window.
+// Conversations without unread messages are omitted.
+func unreadCounts(ctx context.Context, q conn, tenant string, reader data.ReadParticipant, clause string, args ...any) (map[string]uint, error) {
+	metadata, err := identity(reader.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.query(ctx, `SELECT m.conversation_id, COUNT(*) FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN participants p ON p.conversation_id = m.conversation_id
+		WHERE m.tenant_id = ? AND p.participant_id = ? AND p.metadata = ? AND p.deleted_at IS NULL
+			AND m.deleted_at IS NULL
+			AND (p.last_read_message_id IS NULL OR m.id > p.last_read_message_id)
+			AND NOT (m.sender_id = p.participant_id AND m.sender_metadata = p.metadata)
+			AND NOT EXISTS (SELECT 1 FROM hidden_messages h
+				WHERE h.message_id = m.id AND h.participant_id = p.participant_id AND h.metadata = p.metadata)
+			AND (c.history_visibility <> ?
+				OR NOT EXISTS (SELECT 1 FROM memberships ms WHERE ms.conversation_id = p.conversation_id AND ms.seq = p.seq)
+				OR EXISTS (SELECT 1 FROM memberships ms WHERE ms.conversation_id = p.conversation_id AND ms.seq = p.seq
+					AND m.created_at >= ms.joined_at AND (ms.left_at IS NULL OR m.created_at <= ms.left_at)))`+clause+`
+		GROUP BY m.conversation_id`,
+		append([]any{tenant, reader.ParticipantID, metadata, model.HistoryVisibilityJoined}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]uint{}
+	for rows.Next() {
+		var conversationID string
+		var count int64
+		if err := rows.Scan(&conversationID, &count); err != nil {
+			return nil, fmt.Errorf("failed to count unread messages: %w", err)
+		}
+		counts[conversationID] = uint(count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,1234 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type Message struct {
+	db           *DB
+	conversation *Conversation
+	config
+}
+
+func NewMessage(db *DB, conversation *Conversation, opts ...Option) *Message {
+	return &Message{
+		db:           db,
+		conversation: conversation,
+		config:       newConfig(opts),
+	}
+}
+
+// Create creates a new message in the conversation, see repository.Message.Create.
+// The message, the thread parent and the conversation's last message are written atomically.
+// It returns the created message or an error.
+func (m Message) Create(ctx context.Context, conversationID string, d data.CreateMessage) (*model.Message, error) {
+	if err := d.ValidateWith(m.limits); err != nil {
+		return nil, err
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.CreateMessage{ConversationID: conversationID, Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var message *model.Message
+	err := m.db.update(ctx, m.logger, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, conversationID)
+		if err != nil {
+			return err
+		}
+
+		if d.Kind != model.MessageKindSystem {
+			if _, err := chat.RequireParticipant(conversation, d.Sender.ParticipantID, d.Sender.Metadata); err != nil {
+				return err
+			}
+
+			actor := &data.Actor{ParticipantID: d.Sender.ParticipantID, Metadata: d.Sender.Metadata}
+			if _, err := m.conversation.authorize(conversation, actor, model.PermissionPostMessage); err != nil {
+				return err
+			}
+		}
+
+		var parent *model.Message
+		if d.ParentID != nil {
+			parent, err = m.threadParent(ctx, q, conversation.ID.Hex(), *d.ParentID)
+			if err != nil {
+				return err
+			}
+		}
+
+		var quote *model.Quote
+		if d.ReplyToID != nil {
+			quote, err = m.quote(ctx, q, conversation.ID.Hex(), *d.ReplyToID)
+			if err != nil {
+				return err
+			}
+		}
+
+		now := m.now()
+		created := model.Message{
+			ID:             bson.NewObjectID(),
+			TenantID:       m.tenant,
+			ConversationID: conversation.ID,
+			Sender:         model.MessageSender{ParticipantID: d.Sender.ParticipantID, Metadata: d.Sender.Metadata},
+			Kind:           d.Kind,
+			Content:        d.Content,
+			Attachments:    attachmentsOf(d.Attachments),
+			ReplyTo:        quote,
+			CreatedAt:      now,
+		}
+		if parent != nil {
+			created.ParentID = &parent.ID
+		}
+
+		message, err = m.save(ctx, q, &created)
+		if err != nil {
+			return err
+		}
+
+		if parent != nil {
+			parent.ReplyCount++
+			if parent.LastReplyAt == nil || message.CreatedAt.After(*parent.LastReplyAt) {
+				parent.LastReplyAt = &message.CreatedAt
+			}
+			if _, err := m.save(ctx, q, parent); err != nil {
+				return fmt.Errorf("failed to update the thread parent: %w", err)
+			}
+		}
+
+		if err := m.conversation.updateLastMessage(ctx, q, conversationID, *message); err != nil {
+			return fmt.Errorf("failed to touch the conversation: %w", err)
+		}
+
+		return m.conversation.unarchive(ctx, q, conversationID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.MessageCreated{Message: message})
+
+	return message, nil
+}
+
+// Edit replaces the content and attachments of a message, see repository.Message.Edit.
+// It returns the edited message or an error.
+func (m Message) Edit(ctx context.Context, d data.EditMessage) (*model.Message, error) {
+	if err := d.ValidateWith(m.limits); err != nil {
+		return nil, fmt.Errorf("failed to validate edit message data: %w", err)
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.EditMessage{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var edited *model.Message
+	err := m.db.update(ctx, m.logger, func(q conn) error {
+		message, conversation, err := m.lock(ctx, q, d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := chat.RequireParticipant(conversation, d.Editor.ParticipantID, d.Editor.Metadata); err != nil {
+			return err
+		}
+
+		if message.Sender.ParticipantID != d.Editor.ParticipantID || !model.MetadataEqual(message.Sender.Metadata, d.Editor.Metadata) {
+			return store.ErrNotSender
+		}
+
+		if message.DeletedAt != nil {
+			return store.ErrMessageDeleted
+		}
+
+		now := m.now()
+		message.Revisions = append(message.Revisions, model.Revision{
+			Content:     message.Content,
+			Attachments: message.Attachments,
+			EditedBy: model.MessageSender{
+				ParticipantID: d.Editor.ParticipantID,
+				Metadata:      d.Editor.Metadata,
+			},
+			EditedAt: now,
+		})
+		message.Content = d.Content
+		message.Attachments = attachmentsOf(d.Attachments)
+		message.EditedAt = &now
+
+		edited, err = m.save(ctx, q, message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.MessageEdited{Message: edited})
+
+	return edited, nil
+}
+
+// DeleteForEveryone tombstones a message, see repository.Message.DeleteForEveryone.
+// It returns the deleted message or an error.
+func (m Message) DeleteForEveryone(ctx context.Context, d data.DeleteMessageForEveryone) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate delete message data: %w", err)
+	}
+
+	var deleted *model.Message
+	err := m.db.update(ctx, m.logger, func(q conn) error {
+		message, err := m.find(ctx, q, d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if message.Sender.ParticipantID != d.Sender.ParticipantID || !model.MetadataEqual(message.Sender.Metadata, d.Sender.Metadata) {
+			return store.ErrNotSender
+		}
+
+		if message.DeletedAt != nil {
+			deleted = message
+			return nil
+		}
+
+		now := m.now()
+		message.Content = ""
+		message.Attachments = []model.Attachment{}
+		message.Reactions = []model.Reaction{}
+		message.Revisions = nil
+		message.PinnedAt, message.PinnedBy = nil, nil
+		message.DeletedAt = &now
+
+		deleted, err = m.save(ctx, q, message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteForMe hides a message from the participant only, see repository.Message.DeleteForMe.
+func (m Message) DeleteForMe(ctx context.Context, d data.DeleteMessageForMe) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate delete message data: %w", err)
+	}
+
+	return m.db.update(ctx, m.logger, func(q conn) error {
+		message, conversation, err := m.lock(ctx, q, d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) == nil {
+			return store.ErrNotParticipant
+		}
+
+		for _, h := range message.HiddenFor {
+			if h.ParticipantID == d.Participant.ParticipantID && metadataEqual(h.Metadata, d.Participant.Metadata) {
+				return nil
+			}
+		}
+
+		message.HiddenFor = append(message.HiddenFor, model.HiddenParticipant{
+			ParticipantID: d.Participant.ParticipantID,
+			Metadata:      d.Participant.Metadata,
+		})
+
+		_, err = m.save(ctx, q, message)
+		return err
+	})
+}
+
+// Paginate fetches messages in the conversation, newest first.
+// Thread replies are only included if IncludeThreadReplies is set.
+// If a viewer is provided, messages the viewer deleted for themselves and messages outside
+// the viewer's history window are excluded.
+// It returns the messages and the total number of messages in the conversation or an error.
+func (m Message) Paginate(ctx context.Context, d data.PaginateMessages) ([]model.Message, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, err
+	}
+
+	var messages []model.Message
+	var total uint
+	err := m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		clause, args, err := visible(conversation, d.Viewer, d.IncludeThreadReplies)
+		if err != nil {
+			return err
+		}
+
+		total, err = q.count(ctx, "SELECT COUNT(*) FROM messages m WHERE m.tenant_id = ? AND m.conversation_id = ?"+clause,
+			append([]any{m.tenant, conversation.ID.Hex()}, args...)...)
+		if err != nil {
+			return fmt.Errorf("failed to count messages: %w", err)
+		}
+
+		messages, err = m.messages(ctx, q, conversation, clause+" ORDER BY m.created_at DESC, m.id DESC LIMIT ? OFFSET ?",
+			append(args, d.PerPage, (d.Page-1)*d.PerPage)...)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return messages, total, nil
+}
+
+// LoadMessages fetches the messages in the conversation older than the last message id
+// provided, or the latest messages if it is nil, newest first.
+// Thread replies and the viewer are handled as in Paginate.
+// It returns the messages or an error.
+func (m Message) LoadMessages(ctx context.Context, d data.LoadMessages) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		clause, args, err := visible(conversation, d.Viewer, d.IncludeThreadReplies)
+		if err != nil {
+			return err
+		}
+
+		if d.LastMessageID != nil {
+			lastObID, err := bson.ObjectIDFromHex(*d.LastMessageID)
+			if err != nil {
+				return fmt.Errorf("failed to parse the last message id: %w", err)
+			}
+			clause += " AND m.id < ?"
+			args = append(args, lastObID.Hex())
+		}
+
+		messages, err = m.messages(ctx, q, conversation, clause+" ORDER BY m.id DESC LIMIT ?", append(args, d.PerPage)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// LoadNewerMessages fetches the messages in the conversation newer than the first message id
+// provided. Thread replies and the viewer are handled as in Paginate.
+// It returns the window of messages, newest first, or an error.
+func (m Message) LoadNewerMessages(ctx context.Context, d data.LoadNewerMessages) (*model.MessageWindow, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	firstObID, err := bson.ObjectIDFromHex(d.FirstMessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the first message id: %w", err)
+	}
+
+	var newer []model.Message
+	var older uint
+	err = m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		clause, args, err := visible(conversation, d.Viewer, d.IncludeThreadReplies)
+		if err != nil {
+			return err
+		}
+
+		older, err = q.count(ctx, "SELECT COUNT(*) FROM messages m WHERE m.tenant_id = ? AND m.conversation_id = ?"+clause+" AND m.id <= ?",
+			append(append([]any{m.tenant, conversation.ID.Hex()}, args...), firstObID.Hex())...)
+		if err != nil {
+			return fmt.Errorf("failed to count messages: %w", err)
+		}
+
+		newer, err = m.messages(ctx, q, conversation, clause+" AND m.id > ? ORDER BY m.id LIMIT ?",
+			append(args, firstObID.Hex(), d.PerPage+1)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hasNewer := uint(len(newer)) > d.PerPage
+	newer = newer[:min(uint(len(newer)), d.PerPage)]
+	slices.Reverse(newer)
+
+	return &model.MessageWindow{Messages: newer, HasOlder: older > 0, HasNewer: hasNewer}, nil
+}
+
+// LoadMessagesAround fetches the message with the given id along with up to Before older
+// and After newer messages. Thread replies and the viewer are handled as in Paginate.
+// It returns the window of messages, newest first, or an error.
+func (m Message) LoadMessagesAround(ctx context.Context, d data.LoadMessagesAround) (*model.MessageWindow, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	var anchor, older, newer []model.Message
+	err = m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		clause, args, err := visible(conversation, d.Viewer, d.IncludeThreadReplies)
+		if err != nil {
+			return err
+		}
+
+		anchor, err = m.messages(ctx, q, conversation, clause+" AND m.id = ?", append(slices.Clone(args), messageObID.Hex())...)
+		if err != nil {
+			return err
+		}
+		if len(anchor) == 0 {
+			return fmt.Errorf("%w in conversation", store.ErrMessageNotFound)
+		}
+
+		older, err = m.messages(ctx, q, conversation, clause+" AND m.id < ? ORDER BY m.id DESC LIMIT ?",
+			append(slices.Clone(args), messageObID.Hex(), d.Before+1)...)
+		if err != nil {
+			return err
+		}
+
+		newer, err = m.messages(ctx, q, conversation, clause+" AND m.id > ? ORDER BY m.id LIMIT ?",
+			append(slices.Clone(args), messageObID.Hex(), d.After+1)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hasOlder, hasNewer := uint(len(older)) > d.Before, uint(len(newer)) > d.After
+	older, newer = older[:min(uint(len(older)), d.Before)], newer[:min(uint(len(newer)), d.After)]
+	slices.Reverse(newer)
+
+	window := make([]model.Message, 0, len(newer)+1+len(older))
+	window = append(append(append(window, newer...), anchor[0]), older...)
+
+	return &model.MessageWindow{Messages: window, HasOlder: hasOlder, HasNewer: hasNewer}, nil
+}
+
+// LoadThread fetches the replies in a message thread older than the last message id
+// provided, or the latest replies if it is nil, newest first.
+// The viewer is handled as in Paginate.
+// It returns the replies or an error.
+func (m Message) LoadThread(ctx context.Context, d data.LoadThread) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		parent, err := m.threadParent(ctx, q, d.ConversationID, d.ParentID)
+		if err != nil {
+			return err
+		}
+
+		clause, args, err := visible(conversation, d.Viewer, true)
+		if err != nil {
+			return err
+		}
+		clause += " AND m.parent_id = ?"
+		args = append(args, parent.ID.Hex())
+
+		if d.LastMessageID != nil {
+			lastObID, err := bson.ObjectIDFromHex(*d.LastMessageID)
+			if err != nil {
+				return fmt.Errorf("failed to parse the last message id: %w", err)
+			}
+			clause += " AND m.id < ?"
+			args = append(args, lastObID.Hex())
+		}
+
+		messages, err = m.messages(ctx, q, conversation, clause+" ORDER BY m.id DESC LIMIT ?", append(args, d.PerPage)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// threadParent fetches the root message of the thread the given message belongs to.
+// The message must belong to the conversation.
+func (m Message) threadParent(ctx context.Context, q conn, conversationID string, messageID string) (*model.Message, error) {
+	parent, err := m.find(ctx, q, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the parent message: %w", err)
+	}
+
+	if parent.ConversationID.Hex() != conversationID {
+		return nil, fmt.Errorf("parent message does not belong to conversation: %w", store.ErrMessageNotFound)
+	}
+
+	if parent.ParentID != nil {
+		return m.threadParent(ctx, q, conversationID, parent.ParentID.Hex())
+	}
+
+	return parent, nil
+}
+
+// quote builds a snapshot of the message being replied to.
+// The message must belong to the conversation and must not be deleted.
+func (m Message) quote(ctx context.Context, q conn, conversationID string, messageID string) (*model.Quote, error) {
+	quoted, err := m.find(ctx, q, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the reply to message: %w", err)
+	}
+
+	if quoted.ConversationID.Hex() != conversationID {
+		return nil, fmt.Errorf("reply to message does not belong to conversation: %w", store.ErrMessageNotFound)
+	}
+
+	if quoted.DeletedAt != nil {
+		return nil, fmt.Errorf("reply to %w", store.ErrMessageDeleted)
+	}
+
+	quote := chat.QuoteOf(*quoted)
+	return &quote, nil
+}
+
+// Pin pins a message in its conversation. The actor needs the pin message permission.
+// Pinning a pinned message keeps the original pin.
+// It returns the pinned message or an error.
+func (m Message) Pin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error) {
+	return m.setPinned(ctx, actor, d, true)
+}
+
+// Unpin unpins a message. The actor needs the pin message permission.
+// It returns the unpinned message or an error.
+func (m Message) Unpin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error) {
+	return m.setPinned(ctx, actor, d, false)
+}
+
+func (m Message) setPinned(ctx context.Context, actor *data.Actor, d data.PinMessage, pinned bool) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate pin message data: %w", err)
+	}
+	if err := chat.ValidateActor(actor); err != nil {
+		return nil, err
+	}
+
+	var updated *model.Message
+	err := m.db.update(ctx, m.logger, func(q conn) error {
+		message, conversation, err := m.lock(ctx, q, d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := m.conversation.authorize(conversation, actor, model.PermissionPinMessage); err != nil {
+			return err
+		}
+
+		if message.DeletedAt != nil {
+			return store.ErrMessageDeleted
+		}
+
+		if pinned == (message.PinnedAt != nil) {
+			updated = message
+			return nil
+		}
+
+		message.PinnedAt, message.PinnedBy = nil, nil
+		if pinned {
+			now := m.now()
+			message.PinnedAt = &now
+			if actor != nil {
+				message.PinnedBy = &model.MessageSender{ParticipantID: actor.ParticipantID, Metadata: actor.Metadata}
+			}
+		}
+
+		updated, err = m.save(ctx, q, message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// LoadPinned fetches the pinned messages of the conversation, most recently pinned first.
+// The viewer is handled as in Paginate.
+// It returns the messages or an error.
+func (m Message) LoadPinned(ctx context.Context, d data.LoadPinnedMessages) ([]model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	var messages []model.Message
+	err := m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		clause, args, err := visible(conversation, d.Viewer, true)
+		if err != nil {
+			return err
+		}
+
+		messages, err = m.messages(ctx, q, conversation, clause+" AND m.pinned_at IS NOT NULL ORDER BY m.pinned_at DESC", args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// ToggleReaction toggles a reaction on the message for the participant.
+// The participant must be a non-deleted participant of the message's conversation.
+// It returns the updated message or an error.
+func (m Message) ToggleReaction(ctx context.Context, d data.ToggleReaction) (*model.Message, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.ToggleReaction{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var updated *model.Message
+	err := m.db.update(ctx, m.logger, func(q conn) error {
+		message, conversation, err := m.lock(ctx, q, d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := chat.RequireParticipant(conversation, d.Participant.ParticipantID, d.Participant.Metadata); err != nil {
+			return err
+		}
+
+		if message.DeletedAt != nil {
+			return store.ErrMessageDeleted
+		}
+
+		reactor := model.ReactionParticipant{ParticipantID: d.Participant.ParticipantID, Metadata: d.Participant.Metadata}
+		reactionIndex := slices.IndexFunc(message.Reactions, func(r model.Reaction) bool {
+			return r.Emoji == d.Emoji
+		})
+
+		if reactionIndex == -1 {
+			message.Reactions = append(message.Reactions, model.Reaction{
+				Emoji:        d.Emoji,
+				Participants: []model.ReactionParticipant{reactor},
+			})
+		} else {
+			reaction := &message.Reactions[reactionIndex]
+			participantIndex := slices.IndexFunc(reaction.Participants, func(p model.ReactionParticipant) bool {
+				return p.ParticipantID == reactor.ParticipantID && metadataEqual(p.Metadata, reactor.Metadata)
+			})
+
+			if participantIndex == -1 {
+				reaction.Participants = append(reaction.Participants, reactor)
+			} else {
+				reaction.Participants = slices.Delete(reaction.Participants, participantIndex, participantIndex+1)
+			}
+		}
+
+		message.Reactions = slices.DeleteFunc(message.Reactions, func(r model.Reaction) bool {
+			return len(r.Participants) == 0
+		})
+
+		updated, err = m.save(ctx, q, message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.hooks.RunAfter(ctx, hook.ReactionToggled{Message: updated, Data: d})
+
+	return updated, nil
+}
+
+// MarkRead advances the participant's read cursor to the given message.
+// A backward (older-or-equal) call is a no-op. Soft-deleted participants are rejected.
+func (m Message) MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate mark read data: %w", err)
+	}
+
+	if err := m.hooks.RunBefore(ctx, hook.MarkRead{Data: d}); err != nil {
+		return nil, fmt.Errorf("rejected by hook: %w", err)
+	}
+
+	var updated *model.Conversation
+	var advanced bool
+	err := m.db.update(ctx, m.logger, func(q conn) error {
+		message, conversation, err := m.lock(ctx, q, d.MessageID)
+		if err != nil {
+			return err
+		}
+
+		if message.ConversationID.Hex() != d.ConversationID {
+			return fmt.Errorf("message does not belong to conversation: %w", store.ErrMessageNotFound)
+		}
+
+		participant := conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata)
+		if participant == nil {
+			return store.ErrNotParticipant
+		}
+
+		updated = conversation
+		if participant.LastReadMessageID != nil && participant.LastReadMessageID.Hex() >= message.ID.Hex() {
+			return nil
+		}
+
+		participant.LastReadMessageID = &message.ID
+		participant.LastReadAt = &message.CreatedAt
+
+		updated, err = m.conversation.save(ctx, q, conversation)
+		advanced = err == nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if advanced {
+		m.hooks.RunAfter(ctx, hook.ReadMarked{Conversation: updated, Data: d})
+	}
+
+	return updated, nil
+}
+
+// MarkAllRead advances the participant's read cursor to the latest message of the conversation.
+func (m Message) MarkAllRead(ctx context.Context, d data.MarkAllRead) (*model.Conversation, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate mark all read data: %w", err)
+	}
+
+	var conversation *model.Conversation
+	var latest []model.Message
+	err := m.db.view(ctx, func(q conn) error {
+		var err error
+		conversation, err = m.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		latest, err = m.messages(ctx, q, conversation, " ORDER BY m.id DESC LIMIT 1")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(latest) == 0 {
+		return conversation, nil
+	}
+
+	return m.MarkRead(ctx, data.MarkRead{
+		ConversationID: d.ConversationID,
+		Participant:    d.Participant,
+		MessageID:      latest[0].ID.Hex(),
+	})
+}
+
+// ReadersOf returns the participants that have read the given message
+// (i.e. whose last read message id is greater than or equal to the message id).
+// Soft-deleted participants are excluded.
+func (m Message) ReadersOf(ctx context.Context, d data.ReadersOf) ([]model.Participant, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate readers of data: %w", err)
+	}
+
+	messageObID, err := bson.ObjectIDFromHex(d.MessageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message id: %w", err)
+	}
+
+	var conversation *model.Conversation
+	err = m.db.view(ctx, func(q conn) error {
+		var err error
+		conversation, err = m.conversation.find(ctx, q, d.ConversationID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if conversation == nil {
+		return nil, store.ErrConversationNotFound
+	}
+
+	readers := make([]model.Participant, 0)
+	for _, p := range conversation.Participants {
+		if p.DeletedAt == nil && p.LastReadMessageID != nil && p.LastReadMessageID.Hex() >= messageObID.Hex() {
+			readers = append(readers, p)
+		}
+	}
+
+	return readers, nil
+}
+
+// UnreadCount returns the number of unread messages in the conversation for the participant,
+// see repository.Message.UnreadCount.
+func (m Message) UnreadCount(ctx context.Context, d data.UnreadCount) (uint, error) {
+	if err := d.Validate(); err != nil {
+		return 0, fmt.Errorf("failed to validate unread count data: %w", err)
+	}
+
+	var count uint
+	err := m.db.view(ctx, func(q conn) error {
+		conversation, err := m.conversation.find(ctx, q, d.ConversationID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		if conversation == nil {
+			return store.ErrConversationNotFound
+		}
+
+		index := slices.IndexFunc(conversation.Participants, func(p model.Participant) bool {
+			return p.ParticipantID == d.Participant.ParticipantID && model.MetadataEqual(p.Metadata, d.Participant.Metadata)
+		})
+		if index == -1 || conversation.Participants[index].DeletedAt != nil {
+			return store.ErrNotParticipant
+		}
+
+		counts, err := unreadCounts(ctx, q, m.tenant, d.Participant, " AND m.conversation_id = ?", conversation.ID.Hex())
+		if err != nil {
+			return err
+		}
+
+		count = counts[conversation.ID.Hex()]
+		return nil
+	})
+
+	return count, err
+}
+
+// visible returns the conditions restricting the messages of the conversation to the ones
+// visible to the viewer, along with their arguments.
+// A nil viewer sees every message. A viewer that is not a participant of the conversation
+// only has the messages they deleted for themselves excluded.
+func visible(conversation *model.Conversation, viewer *data.ReadParticipant, includeThreadReplies bool) (string, []any, error) {
+	clause, args := "", []any{}
+	if !includeThreadReplies {
+		clause += " AND m.parent_id IS NULL"
+	}
+	if viewer == nil {
+		return clause, args, nil
+	}
+
+	metadata, err := identity(viewer.Metadata)
+	if err != nil {
+		return "", nil, err
+	}
+	clause += " AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.participant_id = ? AND h.metadata = ?)"
+	args = append(args, viewer.ParticipantID, metadata)
+
+	if participant := conversation.Participant(viewer.ParticipantID, viewer.Metadata); participant != nil {
+		history, historyArgs := historyClause(conversation, participant)
+		clause += history
+		args = append(args, historyArgs...)
+	}
+
+	return clause, args, nil
+}
+
+// historyClause returns the condition restricting messages to the participant's history
+// window along with its arguments, see chat.InHistory.
+func historyClause(conversation *model.Conversation, p *model.Participant) (string, []any) {
+	if conversation.HistoryVisibility == model.HistoryVisibilityJoined && len(p.Memberships) > 0 {
+		periods, args := make([]string, 0, len(p.Memberships)), []any{}
+		for _, membership := range p.Memberships {
+			if membership.LeftAt == nil {
+				periods = append(periods, "m.created_at >= ?")
+				args = append(args, millis(membership.JoinedAt))
+				continue
+			}
+			periods = append(periods, "(m.created_at >= ? AND m.created_at <= ?)")
+			args = append(args, millis(membership.JoinedAt), millis(*membership.LeftAt))
+		}
+		return " AND (" + strings.Join(periods, " OR ") + ")", args
+	}
+
+	if p.DeletedAt != nil {
+		return " AND m.created_at <= ?", []any{millis(*p.DeletedAt)}
+	}
+
+	return "", nil
+}
+
+func attachmentsOf(attachments []data.CreateAttachment) []model.Attachment {
+	if attachments == nil {
+		return nil
+	}
+
+	converted := make([]model.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		converted = append(converted, model.Attachment{Kind: a.Kind, Metadata: a.Metadata})
+	}
+	return converted
+}
+
+// find fetches the message of the tenant by id. Within a transaction the message is locked
+// until the transaction ends.
+func (m Message) find(ctx context.Context, q conn, id string) (*model.Message, error) {
+	obID, err := bson.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the message id: %w", err)
+	}
+
+	messages, err := queryMessages(ctx, q, m.tenant, "m.id = ?"+q.forUpdate(), obID.Hex())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the message: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("failed to fetch the message: %w", store.ErrMessageNotFound)
+	}
+
+	return &messages[0], nil
+}
+
+// lock fetches the message and its conversation and locks both until the transaction ends.
+// The conversation is locked before the message, the order Create takes its locks in, so that
+// concurrent writes to a conversation and its messages cannot deadlock.
+func (m Message) lock(ctx context.Context, q conn, id string) (*model.Message, *model.Conversation, error) {
+	unlocked := q
+	unlocked.tx = false
+	message, err := m.find(ctx, unlocked, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conversation, err := m.conversation.get(ctx, q, message.ConversationID.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	message, err = m.find(ctx, q, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return message, conversation, nil
+}
+
+// messages fetches the messages of the conversation matching the clause, which starts with
+// its conditions and may end with an ORDER BY and a LIMIT. Messages are in id order by default.
+func (m Message) messages(ctx context.Context, q conn, conversation *model.Conversation, clause string, args ...any) ([]model.Message, error) {
+	if !strings.Contains(clause, "ORDER BY") {
+		clause += " ORDER BY m.id"
+	}
+
+	messages, err := queryMessages(ctx, q, m.tenant, "m.conversation_id = ?"+clause,
+		append([]any{conversation.ID.Hex()}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// save writes the message with its reactions, the participants it is hidden from and its
+// words for Search, and returns the stored version of it. The quoted message is only referenced by id.
+func (m Message) save(ctx context.Context, q conn, message *model.Message) (*model.Message, error) {
+	senderMetadata, err := identity(message.Sender.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := encodeJSON(message.Attachments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	revisions, err := encodeJSON(message.Revisions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	var parentID, replyToID, pinnedByID, pinnedByMetadata any
+	if message.ParentID != nil {
+		parentID = message.ParentID.Hex()
+	}
+	if message.ReplyTo != nil {
+		replyToID = message.ReplyTo.MessageID.Hex()
+	}
+	if message.PinnedBy != nil {
+		pinnedByID = message.PinnedBy.ParticipantID
+		if pinnedByMetadata, err = identity(message.PinnedBy.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	id := message.ID.Hex()
+	_, err = q.exec(ctx, `INSERT INTO messages
+			(id, tenant_id, conversation_id, sender_id, sender_metadata, kind, content, attachments, revisions,
+				parent_id, reply_count, last_reply_at, reply_to_id, pinned_at, pinned_by_id, pinned_by_metadata,
+				created_at, edited_at, deleted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			content = excluded.content,
+			attachments = excluded.attachments,
+			revisions = excluded.revisions,
+			reply_count = excluded.reply_count,
+			last_reply_at = excluded.last_reply_at,
+			pinned_at = excluded.pinned_at,
+			pinned_by_id = excluded.pinned_by_id,
+			pinned_by_metadata = excluded.pinned_by_metadata,
+			edited_at = excluded.edited_at,
+			deleted_at = excluded.deleted_at`,
+		id, message.TenantID, message.ConversationID.Hex(), message.Sender.ParticipantID, senderMetadata, message.Kind,
+		message.Content, attachments, revisions, parentID, int64(message.ReplyCount), nullMillis(message.LastReplyAt),
+		replyToID, nullMillis(message.PinnedAt), pinnedByID, pinnedByMetadata,
+		millis(message.CreatedAt), nullMillis(message.EditedAt), nullMillis(message.DeletedAt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	for _, table := range []string{"reactions", "hidden_messages", "search_terms"} {
+		if _, err := q.exec(ctx, "DELETE FROM "+table+" WHERE message_id = ?", id); err != nil {
+			return nil, fmt.Errorf("failed to save message: %w", err)
+		}
+	}
+
+	for term, score := range chat.SearchIndex(*message) {
+		_, err = q.exec(ctx, "INSERT INTO search_terms (message_id, term, score) VALUES (?, ?, ?)", id, term, score)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index message: %w", err)
+		}
+	}
+
+	seq := 0
+	for _, reaction := range message.Reactions {
+		for _, p := range reaction.Participants {
+			metadata, err := identity(p.Metadata)
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = q.exec(ctx, "INSERT INTO reactions (message_id, seq, emoji, participant_id, metadata) VALUES (?, ?, ?, ?, ?)",
+				id, seq, reaction.Emoji, p.ParticipantID, metadata)
+			if err != nil {
+				return nil, fmt.Errorf("failed to save reactions: %w", err)
+			}
+			seq++
+		}
+	}
+
+	for seq, h := range message.HiddenFor {
+		metadata, err := identity(h.Metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = q.exec(ctx, "INSERT INTO hidden_messages (message_id, seq, participant_id, metadata) VALUES (?, ?, ?, ?)",
+			id, seq, h.ParticipantID, metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save message: %w", err)
+		}
+	}
+
+	saved, err := m.find(ctx, q, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %w", err)
+	}
+
+	return saved, nil
+}
+
+// queryMessages fetches the messages of the tenant matching the clause, which may end with an
+// ORDER BY and a LIMIT, along with their reactions, the participants they are hidden from
+// and the quote of the current version of the message they reply to.
+func queryMessages(ctx context.Context, q conn, tenant string, clause string, args ...any) ([]model.Message, error) {
+	messages, replyTo, err := scanMessages(ctx, q, tenant, clause, args...)
+	if err != nil || len(messages) == 0 {
+		return messages, err
+	}
+
+	ids := make([]any, len(messages))
+	index := make(map[string]int, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID.Hex()
+		index[message.ID.Hex()] = i
+	}
+	in := "(" + placeholders(len(ids)) + ")"
+
+	rows, err := q.query(ctx, "SELECT message_id, emoji, participant_id, metadata FROM reactions WHERE message_id IN "+in+" ORDER BY message_id, seq", ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, emoji string
+		var p model.ReactionParticipant
+		var metadata sql.NullString
+		if err := rows.Scan(&messageID, &emoji, &p.ParticipantID, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to fetch reactions: %w", err)
+		}
+		if p.Metadata, err = metadataOf(metadata); err != nil {
+			return nil, err
+		}
+
+		message := &messages[index[messageID]]
+		i := slices.IndexFunc(message.Reactions, func(r model.Reaction) bool { return r.Emoji == emoji })
+		if i == -1 {
+			message.Reactions = append(message.Reactions, model.Reaction{Emoji: emoji})
+			i = len(message.Reactions) - 1
+		}
+		message.Reactions[i].Participants = append(message.Reactions[i].Participants, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch reactions: %w", err)
+	}
+	rows.Close()
+
+	rows, err = q.query(ctx, "SELECT message_id, participant_id, metadata FROM hidden_messages WHERE message_id IN "+in+" ORDER BY message_id, seq", ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hidden messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var h model.HiddenParticipant
+		var metadata sql.NullString
+		if err := rows.Scan(&messageID, &h.ParticipantID, &metadata); err != nil {
+			return nil, fmt.Errorf("failed to fetch hidden messages: %w", err)
+		}
+		if h.Metadata, err = metadataOf(metadata); err != nil {
+			return nil, err
+		}
+
+		message := &messages[index[messageID]]
+		message.HiddenFor = append(message.HiddenFor, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch hidden messages: %w", err)
+	}
+	rows.Close()
+
+	quotedIDs := []any{}
+	for _, id := range replyTo {
+		if id != "" {
+			quotedIDs = append(quotedIDs, id)
+		}
+	}
+	if len(quotedIDs) == 0 {
+		return messages, nil
+	}
+
+	quoted, _, err := scanMessages(ctx, q, tenant, "m.id IN ("+placeholders(len(quotedIDs))+")", quotedIDs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch quoted messages: %w", err)
+	}
+
+	quotes := make(map[string]model.Quote, len(quoted))
+	for _, message := range quoted {
+		quotes[message.ID.Hex()] = chat.QuoteOf(message)
+	}
+	for i, id := range replyTo {
+		if quote, ok := quotes[id]; ok {
+			messages[i].ReplyTo = &quote
+		}
+	}
+
+	return messages, nil
+}
+
+// scanMessages fetches the message rows of the tenant matching the clause.
+// It returns the messages along with the id of the message each one replies to, if any.
+func scanMessages(ctx context.Context, q conn, tenant string, clause string, args ...any) ([]model.Message, []string, error) {
+	rows, err := q.query(ctx, `SELECT m.id, m.tenant_id, m.conversation_id, m.sender_id, m.sender_metadata, m.kind, m.content,
+			m.attachments, m.revisions, m.parent_id, m.reply_count, m.last_reply_at, m.reply_to_id, m.pinned_at,
+			m.pinned_by_id, m.pinned_by_metadata, m.created_at, m.edited_at, m.deleted_at
+		FROM messages m WHERE m.tenant_id = ? AND `+clause,
+		append([]any{tenant}, args...)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages, replyTo := []model.Message{}, []string{}
+	for rows.Next() {
+		var message model.Message
+		var id, conversationID string
+		var senderMetadata, attachments, revisions, parentID, replyToID, pinnedByID, pinnedByMetadata sql.NullString
+		var replyCount, createdAt int64
+		var lastReplyAt, pinnedAt, editedAt, deletedAt sql.NullInt64
+		err := rows.Scan(&id, &message.TenantID, &conversationID, &message.Sender.ParticipantID, &senderMetadata,
+			&message.Kind, &message.Content, &attachments, &revisions, &parentID, &replyCount, &lastReplyAt, &replyToID,
+			&pinnedAt, &pinnedByID, &pinnedByMetadata, &createdAt, &editedAt, &deletedAt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch messages: %w", err)
+		}
+
+		if message.ID, err = bson.ObjectIDFromHex(id); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse message id: %w", err)
+		}
+		if message.ConversationID, err = bson.ObjectIDFromHex(conversationID); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse conversation id: %w", err)
+		}
+		if parentID.Valid {
+			parent, err := bson.ObjectIDFromHex(parentID.String)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse parent id: %w", err)
+			}
+			message.ParentID = &parent
+		}
+		if message.Sender.Metadata, err = metadataOf(senderMetadata); err != nil {
+			return nil, nil, err
+		}
+		if message.Attachments, err = decodeJSON[[]model.Attachment](attachments); err != nil {
+			return nil, nil, err
+		}
+		if message.Revisions, err = decodeJSON[[]model.Revision](revisions); err != nil {
+			return nil, nil, err
+		}
+		if pinnedByID.Valid {
+			message.PinnedBy = &model.MessageSender{ParticipantID: pinnedByID.String}
+			if message.PinnedBy.Metadata, err = metadataOf(pinnedByMetadata); err != nil {
+				return nil, nil, err
+			}
+		}
+		message.ReplyCount = uint(replyCount)
+		message.LastReplyAt, message.PinnedAt = nullTimeOf(lastReplyAt), nullTimeOf(pinnedAt)
+		message.CreatedAt, message.EditedAt, message.DeletedAt = timeOf(createdAt), nullTimeOf(editedAt), nullTimeOf(deletedAt)
+
+		messages = append(messages, message)
+		replyTo = append(replyTo, replyToID.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	return messages, replyTo, nil
+}
@@ -0,0 +1,134 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/davesavic/chatsavvy/sqlstore"
+)
+
+// Times are stored as milliseconds since the epoch and metadata as JSON text. Ids are the hex
+// encoded object ids, compared bytewise so that they sort by creation time like in MongoDB.
+// Participant metadata is stored in a canonical encoding, so that participants are matched
+// with a plain equality.
+func Up1787000000(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect) error {
+	// {id} is the type of the id columns.
+	id := "TEXT"
+	if dialect == sqlstore.PostgreSQL {
+		id = `TEXT COLLATE "C"`
+	}
+
+	statements := []string{
+		`CREATE TABLE conversations (
+			id {id} PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			metadata TEXT,
+			history_visibility TEXT NOT NULL,
+			last_message_id {id},
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX conversations_tenant_updated_at_id ON conversations (tenant_id, updated_at, id)`,
+		`CREATE TABLE participants (
+			conversation_id {id} NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+			seq INTEGER NOT NULL,
+			participant_id TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			role TEXT NOT NULL,
+			deleted_at BIGINT,
+			last_read_message_id {id},
+			last_read_at BIGINT,
+			pinned_at BIGINT,
+			archived_at BIGINT,
+			muted_at BIGINT,
+			muted_until BIGINT,
+			PRIMARY KEY (conversation_id, seq),
+			UNIQUE (conversation_id, participant_id, metadata)
+		)`,
+		`CREATE INDEX participants_participant ON participants (participant_id, metadata)`,
+		`CREATE TABLE memberships (
+			conversation_id {id} NOT NULL,
+			seq INTEGER NOT NULL,
+			joined_at BIGINT NOT NULL,
+			left_at BIGINT,
+			FOREIGN KEY (conversation_id, seq) REFERENCES participants (conversation_id, seq) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX memberships_participant ON memberships (conversation_id, seq)`,
+		`CREATE TABLE messages (
+			id {id} PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			conversation_id {id} NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+			sender_id TEXT NOT NULL,
+			sender_metadata TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			content TEXT NOT NULL,
+			attachments TEXT,
+			revisions TEXT,
+			parent_id {id},
+			reply_count INTEGER NOT NULL DEFAULT 0,
+			last_reply_at BIGINT,
+			reply_to_id {id},
+			pinned_at BIGINT,
+			pinned_by_id TEXT,
+			pinned_by_metadata TEXT,
+			created_at BIGINT NOT NULL,
+			edited_at BIGINT,
+			deleted_at BIGINT
+		)`,
+		`CREATE INDEX messages_tenant_conversation_id ON messages (tenant_id, conversation_id, id)`,
+		`CREATE INDEX messages_parent ON messages (parent_id)`,
+		`CREATE INDEX messages_reply_to ON messages (reply_to_id)`,
+		`CREATE TABLE reactions (
+			message_id {id} NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+			seq INTEGER NOT NULL,
+			emoji TEXT NOT NULL,
+			participant_id TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			PRIMARY KEY (message_id, seq)
+		)`,
+		`CREATE TABLE hidden_messages (
+			message_id {id} NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+			seq INTEGER NOT NULL,
+			participant_id TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			PRIMARY KEY (message_id, seq),
+			UNIQUE (message_id, participant_id, metadata)
+		)`,
+		`CREATE TABLE typing (
+			tenant_id TEXT NOT NULL,
+			conversation_id {id} NOT NULL,
+			participant_id TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			expires_at BIGINT NOT NULL,
+			PRIMARY KEY (tenant_id, conversation_id, participant_id, metadata)
+		)`,
+		`CREATE TABLE presence (
+			tenant_id TEXT NOT NULL,
+			participant_id TEXT NOT NULL,
+			metadata TEXT NOT NULL,
+			last_seen_at BIGINT NOT NULL,
+			PRIMARY KEY (tenant_id, participant_id, metadata)
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, strings.ReplaceAll(statement, "{id}", id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Down1787000000(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect) error {
+	for _, table := range []string{"presence", "typing", "hidden_messages", "reactions", "messages", "memberships", "participants", "conversations"} {
+		if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/sqlstore"
+)
+
+// searchBackfillBatch is the number of messages indexed at a time by Up1788000000.
+const searchBackfillBatch = 500
+
+// Up1788000000 indexes the words of the messages for Message.Search, weighted by their
+// relevance, see chat.SearchIndex. The words of the existing messages are indexed as well.
+func Up1788000000(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect) error {
+	id := "TEXT"
+	if dialect == sqlstore.PostgreSQL {
+		id = `TEXT COLLATE "C"`
+	}
+
+	statements := []string{
+		`CREATE TABLE search_terms (
+			message_id {id} NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+			term TEXT NOT NULL,
+			score DOUBLE PRECISION NOT NULL,
+			PRIMARY KEY (message_id, term)
+		)`,
+		`CREATE INDEX search_terms_term ON search_terms (term, message_id)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, strings.ReplaceAll(statement, "{id}", id)); err != nil {
+			return err
+		}
+	}
+
+	after := ""
+	for {
+		messages, err := messagesAfter(ctx, tx, dialect, after)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		for _, message := range messages {
+			for term, score := range chat.SearchIndex(message.Message) {
+				_, err := tx.ExecContext(ctx, dialect.Rebind("INSERT INTO search_terms (message_id, term, score) VALUES (?, ?, ?)"),
+					message.id, term, score)
+				if err != nil {
+					return fmt.Errorf("failed to index message %s: %w", message.id, err)
+				}
+			}
+		}
+		after = messages[len(messages)-1].id
+	}
+}
+
+func Down1788000000(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect) error {
+	_, err := tx.ExecContext(ctx, "DROP TABLE search_terms")
+	return err
+}
+
+// indexedMessage is the part of a message that is searched, along with its id.
+type indexedMessage struct {
+	model.Message
+	id string
+}
+
+// messagesAfter fetches the next batch of messages in id order after the id, from the start
+// if the id is empty.
+func messagesAfter(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect, after string) ([]indexedMessage, error) {
+	rows, err := tx.QueryContext(ctx, dialect.Rebind("SELECT id, content, attachments FROM messages WHERE id > ? ORDER BY id LIMIT ?"),
+		after, searchBackfillBatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []indexedMessage
+	for rows.Next() {
+		var message indexedMessage
+		var attachments sql.NullString
+		if err := rows.Scan(&message.id, &message.Content, &attachments); err != nil {
+			return nil, fmt.Errorf("failed to fetch messages: %w", err)
+		}
+		if attachments.Valid {
+			if err := json.Unmarshal([]byte(attachments.String), &message.Attachments); err != nil {
+				return nil, fmt.Errorf("failed to decode the attachments of message %s: %w", message.id, err)
+			}
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
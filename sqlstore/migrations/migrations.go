@@ -0,0 +1,145 @@
+// Package migrations creates and evolves the schema of the sqlstore package, in the style of
+// the MongoDB migrations. Each migration runs in a transaction along with its record in the
+// migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/davesavic/chatsavvy/sqlstore"
+)
+
+type Migration struct {
+	Timestamp int64
+	Up        func(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect) error
+	Down      func(ctx context.Context, tx *sql.Tx, dialect sqlstore.Dialect) error
+}
+
+// Option configures a migration run.
+type Option func(*config)
+
+type config struct {
+	logger *slog.Logger
+}
+
+// WithLogger replaces slog.Default as the logger of the migration run.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+var Migrations = []Migration{
+	{Timestamp: 1787000000, Up: Up1787000000, Down: Down1787000000},
+	{Timestamp: 1788000000, Up: Up1788000000, Down: Down1788000000},
+}
+
+// Run applies the migrations in the direction (up or down) that are not applied yet, or
+// reverts the applied ones.
+func Run(db *sql.DB, dialect sqlstore.Dialect, direction string, opts ...Option) error {
+	cfg := config{
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if direction != "up" && direction != "down" {
+		return fmt.Errorf("invalid direction: %s. Please provide either 'up' or 'down'.", direction)
+	}
+
+	mgs := Migrations
+
+	sort.Slice(mgs, func(i, j int) bool {
+		if direction == "up" {
+			return mgs[i].Timestamp < mgs[j].Timestamp
+		} else {
+			return mgs[i].Timestamp > mgs[j].Timestamp
+		}
+	})
+
+	ctx, logger := context.Background(), cfg.logger
+
+	appliedMigrations, err := getAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, mg := range mgs {
+		if direction == "up" && appliedMigrations[mg.Timestamp] {
+			logger.Info("Migration already applied", "timestamp", mg.Timestamp)
+			continue
+		}
+
+		if direction == "down" && !appliedMigrations[mg.Timestamp] {
+			logger.Info("Migration not applied", "timestamp", mg.Timestamp)
+			continue
+		}
+
+		if err := runOne(ctx, db, dialect, mg, direction); err != nil {
+			return fmt.Errorf("failed to run migration %d: %w", mg.Timestamp, err)
+		}
+
+		if direction == "up" {
+			logger.Info("Migration applied", "timestamp", mg.Timestamp)
+		} else {
+			logger.Info("Migration reverted", "timestamp", mg.Timestamp)
+		}
+	}
+	return nil
+}
+
+// runOne runs the migration in the direction and records it, in a single transaction.
+func runOne(ctx context.Context, db *sql.DB, dialect sqlstore.Dialect, mg Migration, direction string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if direction == "up" {
+		if err := mg.Up(ctx, tx, dialect); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, dialect.Rebind("INSERT INTO migrations (timestamp) VALUES (?)"), mg.Timestamp)
+	} else {
+		if err := mg.Down(ctx, tx, dialect); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, dialect.Rebind("DELETE FROM migrations WHERE timestamp = ?"), mg.Timestamp)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getAppliedMigrations(ctx context.Context, db *sql.DB) (map[int64]bool, error) {
+	appliedMigrations := make(map[int64]bool)
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS migrations (timestamp BIGINT PRIMARY KEY)"); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT timestamp FROM migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var timestamp int64
+		if err := rows.Scan(&timestamp); err != nil {
+			return nil, err
+		}
+
+		appliedMigrations[timestamp] = true
+	}
+
+	return appliedMigrations, rows.Err()
+}
@@ -0,0 +1,78 @@
+package sqlstore
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/davesavic/chatsavvy/hook"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// Option configures an SQL repository.
+type Option func(*config)
+
+type config struct {
+	hooks       *hook.Registry
+	permissions model.Permissions
+	limits      model.Limits
+	now         func() time.Time
+	logger      *slog.Logger
+	tenant      string
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		permissions: model.DefaultPermissions(),
+		limits:      model.DefaultLimits(),
+		now:         time.Now,
+		logger:      slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithHooks runs the hooks of the registry around the repository's writes.
+func WithHooks(hooks *hook.Registry) Option {
+	return func(c *config) {
+		c.hooks = hooks
+	}
+}
+
+// WithPermissions replaces the default permission matrix checked for actors.
+// Message operations use the permissions of the conversation repository they are built on.
+func WithPermissions(permissions model.Permissions) Option {
+	return func(c *config) {
+		c.permissions = permissions
+	}
+}
+
+// WithLimits replaces the default limits on conversations and messages.
+func WithLimits(limits model.Limits) Option {
+	return func(c *config) {
+		c.limits = limits
+	}
+}
+
+// WithClock replaces time.Now as the source of the timestamps written by the repository.
+func WithClock(now func() time.Time) Option {
+	return func(c *config) {
+		c.now = now
+	}
+}
+
+// WithLogger replaces slog.Default as the logger of the repository, which reports the
+// transactions that failed to roll back.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithTenant scopes every read and write of the repository to the tenant.
+func WithTenant(tenant string) Option {
+	return func(c *config) {
+		c.tenant = tenant
+	}
+}
@@ -0,0 +1,213 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+)
+
+const (
+	// typingTimeout is how long a participant is shown as typing after the last SetTyping.
+	typingTimeout = 6 * time.Second
+	// onlineTimeout is how long a participant is shown as online after the last Heartbeat.
+	onlineTimeout = time.Minute
+	// lastSeenRetention is how long the last seen time is kept after the last Heartbeat.
+	lastSeenRetention = 30 * 24 * time.Hour
+)
+
+type Presence struct {
+	db           *DB
+	conversation *Conversation
+	config
+}
+
+func NewPresence(db *DB, conversation *Conversation, opts ...Option) *Presence {
+	return &Presence{
+		db:           db,
+		conversation: conversation,
+		config:       newConfig(opts),
+	}
+}
+
+// SetTyping marks the participant as typing in the conversation for a few seconds.
+// Clients call it repeatedly while the participant keeps typing.
+func (p Presence) SetTyping(ctx context.Context, d data.SetTyping) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate set typing data: %w", err)
+	}
+
+	metadata, err := identity(d.Participant.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return p.db.update(ctx, p.logger, func(q conn) error {
+		conversation, err := p.conversation.get(ctx, q, d.ConversationID)
+		if err != nil {
+			return err
+		}
+
+		if conversation.ActiveParticipant(d.Participant.ParticipantID, d.Participant.Metadata) == nil {
+			return store.ErrNotParticipant
+		}
+
+		_, err = q.exec(ctx, `INSERT INTO typing (tenant_id, conversation_id, participant_id, metadata, expires_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (tenant_id, conversation_id, participant_id, metadata) DO UPDATE SET expires_at = excluded.expires_at`,
+			p.tenant, d.ConversationID, d.Participant.ParticipantID, metadata, millis(p.now().Add(typingTimeout)))
+		if err != nil {
+			return fmt.Errorf("failed to set typing: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// StopTyping clears the typing indicator of the participant, e.g. once the message is sent.
+func (p Presence) StopTyping(ctx context.Context, d data.SetTyping) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate set typing data: %w", err)
+	}
+
+	metadata, err := identity(d.Participant.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return p.db.update(ctx, p.logger, func(q conn) error {
+		_, err := q.exec(ctx, `DELETE FROM typing
+			WHERE tenant_id = ? AND conversation_id = ? AND participant_id = ? AND metadata = ?`,
+			p.tenant, d.ConversationID, d.Participant.ParticipantID, metadata)
+		if err != nil {
+			return fmt.Errorf("failed to stop typing: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// Typing returns the participants currently typing in the conversation.
+func (p Presence) Typing(ctx context.Context, d data.LoadTyping) ([]model.Typing, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load typing data: %w", err)
+	}
+
+	// Expired indicators are dropped here rather than by a TTL monitor as in MongoDB.
+	now := millis(p.now())
+	typing := []model.Typing{}
+	err := p.db.update(ctx, p.logger, func(q conn) error {
+		if _, err := q.exec(ctx, "DELETE FROM typing WHERE expires_at <= ?", now); err != nil {
+			return fmt.Errorf("failed to find typing participants: %w", err)
+		}
+
+		rows, err := q.query(ctx, `SELECT conversation_id, participant_id, metadata, expires_at FROM typing
+			WHERE tenant_id = ? AND conversation_id = ? ORDER BY participant_id, metadata`,
+			p.tenant, d.ConversationID)
+		if err != nil {
+			return fmt.Errorf("failed to find typing participants: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t model.Typing
+			var metadata sql.NullString
+			var expiresAt int64
+			if err := rows.Scan(&t.ConversationID, &t.ParticipantID, &metadata, &expiresAt); err != nil {
+				return fmt.Errorf("failed to find typing participants: %w", err)
+			}
+			if t.Metadata, err = metadataOf(metadata); err != nil {
+				return err
+			}
+			t.ExpiresAt = timeOf(expiresAt)
+			typing = append(typing, t)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return typing, nil
+}
+
+// Heartbeat records that the participant is online now.
+// Clients call it periodically while connected.
+func (p Presence) Heartbeat(ctx context.Context, d data.Heartbeat) error {
+	if err := d.Validate(); err != nil {
+		return fmt.Errorf("failed to validate heartbeat data: %w", err)
+	}
+
+	metadata, err := identity(d.Participant.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return p.db.update(ctx, p.logger, func(q conn) error {
+		_, err := q.exec(ctx, `INSERT INTO presence (tenant_id, participant_id, metadata, last_seen_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (tenant_id, participant_id, metadata) DO UPDATE SET last_seen_at = excluded.last_seen_at`,
+			p.tenant, d.Participant.ParticipantID, metadata, millis(p.now()))
+		if err != nil {
+			return fmt.Errorf("failed to record heartbeat: %w", err)
+		}
+		return nil
+	})
+}
+
+// LastSeen returns the last seen time of the participants and whether they are online.
+// Participants that were never seen, or not within the retention period, are omitted.
+func (p Presence) LastSeen(ctx context.Context, d data.LoadPresence) ([]model.Presence, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate load presence data: %w", err)
+	}
+
+	now := p.now()
+	presence := []model.Presence{}
+	err := p.db.view(ctx, func(q conn) error {
+		for _, participant := range d.Participants {
+			metadata, err := identity(participant.Metadata)
+			if err != nil {
+				return err
+			}
+
+			rows, err := q.query(ctx, `SELECT last_seen_at FROM presence
+				WHERE tenant_id = ? AND participant_id = ? AND metadata = ? AND last_seen_at > ?`,
+				p.tenant, participant.ParticipantID, metadata, millis(now.Add(-lastSeenRetention)))
+			if err != nil {
+				return fmt.Errorf("failed to find presence: %w", err)
+			}
+
+			var lastSeenAt int64
+			found := rows.Next()
+			if found {
+				err = rows.Scan(&lastSeenAt)
+			}
+			rows.Close()
+			if err != nil {
+				return fmt.Errorf("failed to find presence: %w", err)
+			}
+			if !found {
+				continue
+			}
+
+			presence = append(presence, model.Presence{
+				ParticipantID: participant.ParticipantID,
+				Metadata:      participant.Metadata,
+				LastSeenAt:    timeOf(lastSeenAt),
+				Online:        now.Sub(timeOf(lastSeenAt)) < onlineTimeout,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return presence, nil
+}
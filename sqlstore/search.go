@@ -0,0 +1,145 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/chat"
+	"github.com/davesavic/chatsavvy/model"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Search runs a full-text query over the content and attachment file names of the messages
+// in the conversations the participant is a non-deleted member of, with the same scope as
+// repository.Message.Search.
+// The words of the messages are indexed in the search_terms table when the messages are
+// saved, so the matches are found, scored and paged by the database, see chat.SearchIndex.
+// It returns the matches ordered by relevance along with their conversation, and the total
+// number of matches or an error.
+func (m Message) Search(ctx context.Context, d data.SearchMessages) ([]model.SearchResult, uint, error) {
+	if err := d.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("failed to validate search messages data: %w", err)
+	}
+
+	include, exclude := chat.SearchTerms(d.Query)
+	slices.Sort(include)
+	include = slices.Compact(include)
+	if len(include) == 0 {
+		return nil, 0, nil
+	}
+
+	reader := d.Participant
+	metadata, err := identity(reader.Metadata)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The reader sees the messages of the conversations they are a member of, within their
+	// history window, see chat.InHistory. Non-deleted members only have the joined history
+	// visibility restricting them.
+	matches := `FROM search_terms t
+		JOIN messages m ON m.id = t.message_id
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN participants p ON p.conversation_id = m.conversation_id
+			AND p.participant_id = ? AND p.metadata = ? AND p.deleted_at IS NULL
+		WHERE m.tenant_id = ? AND m.deleted_at IS NULL AND t.term IN (` + placeholders(len(include)) + `)
+			AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.participant_id = ? AND h.metadata = ?)
+			AND (c.history_visibility <> ?
+				OR NOT EXISTS (SELECT 1 FROM memberships ms WHERE ms.conversation_id = p.conversation_id AND ms.seq = p.seq)
+				OR EXISTS (SELECT 1 FROM memberships ms WHERE ms.conversation_id = p.conversation_id AND ms.seq = p.seq
+					AND m.created_at >= ms.joined_at AND (ms.left_at IS NULL OR m.created_at <= ms.left_at)))`
+	args := []any{reader.ParticipantID, metadata, m.tenant}
+	for _, term := range include {
+		args = append(args, term)
+	}
+	args = append(args, reader.ParticipantID, metadata, string(model.HistoryVisibilityJoined))
+
+	if len(exclude) > 0 {
+		matches += " AND NOT EXISTS (SELECT 1 FROM search_terms x WHERE x.message_id = m.id AND x.term IN (" + placeholders(len(exclude)) + "))"
+		for _, term := range exclude {
+			args = append(args, term)
+		}
+	}
+	matches += " GROUP BY m.id"
+
+	var results []model.SearchResult
+	var total uint
+	err = m.db.view(ctx, func(q conn) error {
+		var err error
+		total, err = q.count(ctx, "SELECT COUNT(*) FROM (SELECT m.id "+matches+") found", args...)
+		if err != nil {
+			return fmt.Errorf("failed to count messages: %w", err)
+		}
+
+		scores, ids, err := m.rank(ctx, q, "SELECT m.id, SUM(t.score) AS score "+matches+
+			" ORDER BY score DESC, m.id DESC LIMIT ? OFFSET ?",
+			append(args, d.PerPage, (d.Page-1)*d.PerPage)...)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		messages, err := queryMessages(ctx, q, m.tenant, "m.id IN ("+placeholders(len(ids))+")", ids...)
+		if err != nil {
+			return fmt.Errorf("failed to search messages: %w", err)
+		}
+
+		found := make(map[string]model.Message, len(messages))
+		conversationIDs := []any{}
+		for _, message := range messages {
+			found[message.ID.Hex()] = message
+			if !slices.Contains(conversationIDs, any(message.ConversationID.Hex())) {
+				conversationIDs = append(conversationIDs, message.ConversationID.Hex())
+			}
+		}
+
+		conversations, err := m.conversation.query(ctx, q, "c.id IN ("+placeholders(len(conversationIDs))+")", conversationIDs...)
+		if err != nil {
+			return err
+		}
+		conversationOf := make(map[bson.ObjectID]model.Conversation, len(conversations))
+		for _, conversation := range conversations {
+			conversationOf[conversation.ID] = conversation
+		}
+
+		for _, id := range ids {
+			message := found[id.(string)]
+			results = append(results, model.SearchResult{
+				Message:      message,
+				Conversation: conversationOf[message.ConversationID],
+				Score:        scores[id.(string)],
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// rank runs the query selecting the ids of the matching messages along with their score, and
+// returns the scores by message id and the ids in the order of the query.
+func (m Message) rank(ctx context.Context, q conn, query string, args ...any) (map[string]float64, []any, error) {
+	rows, err := q.query(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	scores, ids := map[string]float64{}, []any{}
+	for rows.Next() {
+		var id string
+		var score float64
+		if err := rows.Scan(&id, &score); err != nil {
+			return nil, nil, fmt.Errorf("failed to search messages: %w", err)
+		}
+		scores[id] = score
+		ids = append(ids, id)
+	}
+
+	return scores, ids, rows.Err()
+}
@@ -0,0 +1,9 @@
+package sqlstore
+
+import "github.com/davesavic/chatsavvy/store"
+
+var (
+	_ store.ConversationStore = (*Conversation)(nil)
+	_ store.MessageStore      = (*Message)(nil)
+	_ store.PresenceStore     = (*Presence)(nil)
+)
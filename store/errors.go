@@ -0,0 +1,88 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/davesavic/chatsavvy/data"
+)
+
+// The general errors classify failures, e.g. for mapping them to status codes. Every specific
+// error below matches one of them with errors.Is, e.g. errors.Is(ErrConversationNotFound, ErrNotFound).
+var (
+	// ErrNotFound is matched when the conversation or message does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrNotParticipant is returned when no participant of the conversation matches the
+	// participant id and metadata of the caller.
+	ErrNotParticipant = errors.New("participant not found in conversation")
+
+	// ErrPermissionDenied is returned when the actor's role does not grant the permission
+	// required by the operation, or when the actor is not a participant of the conversation.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrConflict is matched when the operation does not apply to the current state of the
+	// conversation or message, e.g. editing a deleted message.
+	ErrConflict = errors.New("conflict")
+
+	// ErrValidation is matched when the data of the operation is invalid. Use errors.As with
+	// *ValidationError for the invalid fields.
+	ErrValidation = data.ErrValidation
+)
+
+// ValidationError is returned when the data of the operation is invalid, see data.ValidationError.
+type ValidationError = data.ValidationError
+
+// FieldError describes an invalid field of a ValidationError.
+type FieldError = data.FieldError
+
+var (
+	// ErrConversationNotFound is returned when the conversation does not exist.
+	ErrConversationNotFound = kindOf(ErrNotFound, "conversation not found")
+
+	// ErrMessageNotFound is returned when the message does not exist, or does not belong to
+	// the conversation of the operation.
+	ErrMessageNotFound = kindOf(ErrNotFound, "message not found")
+
+	// ErrParticipantDeleted is returned when the caller matches a participant that has been
+	// removed from the conversation.
+	ErrParticipantDeleted = kindOf(ErrNotParticipant, "participant has been removed from the conversation")
+
+	// ErrNotSender is returned when someone other than the sender edits or deletes a message.
+	ErrNotSender = kindOf(ErrPermissionDenied, "only the sender can change the message")
+
+	// ErrMessageDeleted is returned when the operation requires a message that has not been
+	// deleted for everyone.
+	ErrMessageDeleted = kindOf(ErrConflict, "message has been deleted")
+
+	// ErrConcurrentModification is returned when the message changed while being edited.
+	// Retrying the operation is safe.
+	ErrConcurrentModification = kindOf(ErrConflict, "message was modified concurrently")
+
+	// ErrNotGroup is returned when a group only operation is applied to a direct conversation.
+	ErrNotGroup = kindOf(ErrConflict, "conversation is not a group")
+
+	// ErrTooManyParticipants is returned when adding a participant would exceed the
+	// configured participant limit.
+	ErrTooManyParticipants = kindOf(ErrConflict, "too many participants")
+
+	// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+	ErrInvalidCursor = kindOf(ErrValidation, "invalid cursor")
+)
+
+// kindError is a specific error that matches the general error of its kind.
+type kindError struct {
+	kind    error
+	message string
+}
+
+func kindOf(kind error, message string) error {
+	return &kindError{kind: kind, message: message}
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Unwrap() error {
+	return e.kind
+}
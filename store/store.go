@@ -0,0 +1,128 @@
+// Package store defines the storage interfaces ChatSavvy depends on and the errors shared by
+// their implementations. The repository package implements them on MongoDB and the memory
+// package in memory. The storetest package checks that an implementation behaves like the others.
+package store
+
+import (
+	"context"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+)
+
+// ConversationStore reads and writes conversations and their participants.
+// Operations that take an actor check its role against the permission matrix, a nil actor
+// is a trusted call.
+type ConversationStore interface {
+	// Create creates a conversation. Creating a direct conversation returns the existing direct
+	// conversation with the same participants if there is one.
+	Create(ctx context.Context, d data.CreateConversation) (*model.Conversation, error)
+	// Find fetches the conversation by id. It returns ErrConversationNotFound if there is none.
+	Find(ctx context.Context, id string) (*model.Conversation, error)
+	// FindByParticipants finds the direct conversation between exactly the participants.
+	// It returns ErrConversationNotFound if there is none.
+	FindByParticipants(ctx context.Context, d data.FindByParticipants) (*model.Conversation, error)
+	// FindByMetadata pages through the conversations with a participant matching the metadata.
+	FindByMetadata(ctx context.Context, d data.FindByMetadata) ([]model.Conversation, uint, error)
+	// LoadByMetadata is the cursor based variant of FindByMetadata.
+	LoadByMetadata(ctx context.Context, d data.LoadByMetadata) (*model.ConversationPage, error)
+	// Paginate pages through the participant's conversations, pinned ones first.
+	Paginate(ctx context.Context, d data.PaginateConversations) ([]model.Conversation, uint, error)
+	// LoadConversations is the cursor based variant of Paginate, most recently updated first.
+	LoadConversations(ctx context.Context, d data.LoadConversations) (*model.ConversationPage, error)
+	// Inbox loads the participant's conversations with their unread counts.
+	Inbox(ctx context.Context, d data.LoadInbox) (*model.Inbox, error)
+
+	// ParticipantExists reports whether a participant, deleted or not, matches.
+	ParticipantExists(ctx context.Context, conversationID string, d data.ParticipantExists) (bool, error)
+	// AddParticipant adds a participant, or revives a deleted one.
+	AddParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.AddParticipant) (*model.Conversation, error)
+	// DeleteParticipant soft deletes a participant.
+	DeleteParticipant(ctx context.Context, conversationID string, actor *data.Actor, d data.DeleteParticipant) (*model.Conversation, error)
+	// SetRole changes the role of a non-deleted participant.
+	SetRole(ctx context.Context, conversationID string, actor *data.Actor, d data.SetParticipantRole) (*model.Conversation, error)
+
+	// UpdateMetadata replaces the metadata of the conversation.
+	UpdateMetadata(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversationMetadata) (*model.Conversation, error)
+	// SetTitle changes the title of a group.
+	SetTitle(ctx context.Context, conversationID string, actor *data.Actor, d data.SetTitle) (*model.Conversation, error)
+	// SetHistoryVisibility changes whether participants see the messages sent before they joined.
+	SetHistoryVisibility(ctx context.Context, conversationID string, actor *data.Actor, d data.SetHistoryVisibility) (*model.Conversation, error)
+	// Update changes the title, metadata and history visibility that are set at once. Nothing
+	// is changed if any of them cannot be.
+	Update(ctx context.Context, conversationID string, actor *data.Actor, d data.UpdateConversation) (*model.Conversation, error)
+
+	// SetPinned pins or unpins the conversation for the participant only.
+	SetPinned(ctx context.Context, conversationID string, d data.SetPinned) (*model.Conversation, error)
+	// SetArchived archives or unarchives the conversation for the participant only.
+	SetArchived(ctx context.Context, conversationID string, d data.SetArchived) (*model.Conversation, error)
+	// SetMuted mutes or unmutes the conversation for the participant only.
+	SetMuted(ctx context.Context, conversationID string, d data.SetMuted) (*model.Conversation, error)
+	// Unarchive brings the conversation back for every participant that archived it.
+	Unarchive(ctx context.Context, conversationID string) error
+
+	// UpdateLastMessage sets the message as the conversation's last message unless a newer
+	// one is already set.
+	UpdateLastMessage(ctx context.Context, conversationID string, message model.Message) error
+	// RefreshLastMessage replaces the conversation's last message with the given version of it.
+	RefreshLastMessage(ctx context.Context, conversationID string, message model.Message) error
+}
+
+// MessageStore reads and writes the messages of conversations, their reactions and the
+// participants' read cursors.
+type MessageStore interface {
+	// Create creates a message in the conversation.
+	Create(ctx context.Context, conversationID string, d data.CreateMessage) (*model.Message, error)
+	// Edit replaces the content and attachments of a message, keeping the replaced version.
+	Edit(ctx context.Context, d data.EditMessage) (*model.Message, error)
+	// DeleteForEveryone tombstones a message.
+	DeleteForEveryone(ctx context.Context, d data.DeleteMessageForEveryone) (*model.Message, error)
+	// DeleteForMe hides a message from the participant only.
+	DeleteForMe(ctx context.Context, d data.DeleteMessageForMe) error
+
+	// Paginate pages through the messages of the conversation, newest first.
+	Paginate(ctx context.Context, d data.PaginateMessages) ([]model.Message, uint, error)
+	// LoadMessages loads the messages older than the last message id, newest first.
+	LoadMessages(ctx context.Context, d data.LoadMessages) ([]model.Message, error)
+	// LoadNewerMessages loads the messages newer than the first message id, newest first.
+	LoadNewerMessages(ctx context.Context, d data.LoadNewerMessages) (*model.MessageWindow, error)
+	// LoadMessagesAround loads the message along with the messages before and after it.
+	LoadMessagesAround(ctx context.Context, d data.LoadMessagesAround) (*model.MessageWindow, error)
+	// LoadThread loads the replies of a thread, newest first.
+	LoadThread(ctx context.Context, d data.LoadThread) ([]model.Message, error)
+	// Search runs a full-text query over the participant's conversations.
+	Search(ctx context.Context, d data.SearchMessages) ([]model.SearchResult, uint, error)
+
+	// Pin pins a message in its conversation.
+	Pin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error)
+	// Unpin unpins a message.
+	Unpin(ctx context.Context, actor *data.Actor, d data.PinMessage) (*model.Message, error)
+	// LoadPinned loads the pinned messages of the conversation, most recently pinned first.
+	LoadPinned(ctx context.Context, d data.LoadPinnedMessages) ([]model.Message, error)
+	// ToggleReaction toggles a reaction on the message for the participant.
+	ToggleReaction(ctx context.Context, d data.ToggleReaction) (*model.Message, error)
+
+	// MarkRead advances the participant's read cursor to the message. It never moves it back.
+	MarkRead(ctx context.Context, d data.MarkRead) (*model.Conversation, error)
+	// MarkAllRead advances the participant's read cursor to the latest message.
+	MarkAllRead(ctx context.Context, d data.MarkAllRead) (*model.Conversation, error)
+	// ReadersOf returns the non-deleted participants that have read the message.
+	ReadersOf(ctx context.Context, d data.ReadersOf) ([]model.Participant, error)
+	// UnreadCount returns the number of messages the participant has not read, excluding
+	// the participant's own messages.
+	UnreadCount(ctx context.Context, d data.UnreadCount) (uint, error)
+}
+
+// PresenceStore keeps the typing indicators and the last seen times of participants.
+type PresenceStore interface {
+	// SetTyping marks the participant as typing in the conversation for a few seconds.
+	SetTyping(ctx context.Context, d data.SetTyping) error
+	// StopTyping clears the typing indicator of the participant.
+	StopTyping(ctx context.Context, d data.SetTyping) error
+	// Typing returns the participants currently typing in the conversation.
+	Typing(ctx context.Context, d data.LoadTyping) ([]model.Typing, error)
+	// Heartbeat records that the participant is online now.
+	Heartbeat(ctx context.Context, d data.Heartbeat) error
+	// LastSeen returns the last seen time of the participants and whether they are online.
+	LastSeen(ctx context.Context, d data.LoadPresence) ([]model.Presence, error)
+}
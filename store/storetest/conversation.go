@@ -0,0 +1,452 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var conversationTests = []test{
+	{"creates a direct conversation once per set of participants", testCreateDirect},
+	{"finds no conversation for an unknown id", testFindUnknown},
+	{"adds, removes and revives participants", testParticipants},
+	{"checks the actor's permissions", testPermissions},
+	{"rejects group operations on direct conversations", testNotGroup},
+	{"updates the fields of a conversation all or nothing", testUpdate},
+	{"keeps the participants of direct conversations", testDirectParticipants},
+	{"limits the number of participants", testParticipantLimit},
+	{"pages through conversations with a cursor", testLoadConversations},
+	{"pages through the conversations of the participant's identity", testLoadIdentity},
+	{"lists pinned conversations first and hides archived ones", testPinnedAndArchived},
+	{"lists the conversations of the participant's identity", testPaginateIdentity},
+	{"finds conversations by participant metadata", testFindByMetadata},
+}
+
+func testCreateDirect(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+
+	direct := e.direct(t, a, b)
+	assert.Equal(t, model.ConversationKindDirect, direct.Kind)
+	assert.Equal(t, model.HistoryVisibilityShared, direct.HistoryVisibility)
+	require.Len(t, direct.Participants, 2)
+	assert.Equal(t, model.RoleMember, direct.Participants[0].Role)
+	assert.Len(t, direct.Participants[0].Memberships, 1)
+
+	again := e.direct(t, b, a)
+	assert.Equal(t, direct.ID, again.ID)
+
+	found, err := e.Conversations.FindByParticipants(t.Context(), data.FindByParticipants{
+		Participants: []data.FindParticipant{{ParticipantID: a}, {ParticipantID: b}},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, direct.ID, found.ID)
+
+	found, err = e.Conversations.FindByParticipants(t.Context(), data.FindByParticipants{
+		Participants: []data.FindParticipant{{ParticipantID: a}, {ParticipantID: e.id("c")}},
+	})
+	assert.ErrorIs(t, err, store.ErrConversationNotFound)
+	assert.Nil(t, found)
+
+	first := e.group(t, model.HistoryVisibilityShared, a, b)
+	second := e.group(t, model.HistoryVisibilityShared, a, b)
+	assert.NotEqual(t, direct.ID, first.ID)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, model.ConversationKindGroup, first.Kind)
+	assert.Equal(t, "group", first.Title)
+}
+
+func testFindUnknown(t *testing.T, e env) {
+	conversation, err := e.Conversations.Find(t.Context(), bson.NewObjectID().Hex())
+	assert.ErrorIs(t, err, store.ErrConversationNotFound)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.Nil(t, conversation)
+
+	_, err = e.Conversations.Find(t.Context(), "invalid")
+	assert.Error(t, err)
+
+	_, err = e.Conversations.AddParticipant(t.Context(), bson.NewObjectID().Hex(), nil, data.AddParticipant{
+		ParticipantID: e.id("a"),
+	})
+	assert.ErrorIs(t, err, store.ErrConversationNotFound)
+}
+
+func testParticipants(t *testing.T, e env) {
+	a, b, c := e.id("a"), e.id("b"), e.id("c")
+	group := e.group(t, model.HistoryVisibilityShared, a, b)
+	owner := &data.Actor{ParticipantID: a}
+
+	conversation, err := e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), owner, data.AddParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	require.NotNil(t, conversation.ActiveParticipant(c, nil))
+	assert.Equal(t, model.RoleMember, conversation.ActiveParticipant(c, nil).Role)
+
+	conversation, err = e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), owner, data.AddParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	assert.Len(t, conversation.Participants, 3)
+
+	message := e.send(t, group, a, "hello")
+	_, err = e.Messages.MarkRead(t.Context(), data.MarkRead{
+		ConversationID: group.ID.Hex(),
+		Participant:    data.ReadParticipant{ParticipantID: c},
+		MessageID:      message.ID.Hex(),
+	})
+	require.NoError(t, err)
+
+	conversation, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), owner, data.DeleteParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	assert.Nil(t, conversation.ActiveParticipant(c, nil))
+	removed := conversation.Participant(c, nil)
+	require.NotNil(t, removed)
+	require.NotNil(t, removed.DeletedAt)
+	require.Len(t, removed.Memberships, 1)
+	assert.NotNil(t, removed.Memberships[0].LeftAt)
+
+	exists, err := e.Conversations.ParticipantExists(t.Context(), group.ID.Hex(), data.ParticipantExists{ParticipantID: c})
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = e.Conversations.ParticipantExists(t.Context(), group.ID.Hex(), data.ParticipantExists{ParticipantID: e.id("x")})
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	conversation, err = e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), owner, data.AddParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	assert.Len(t, conversation.Participants, 3)
+	revived := conversation.ActiveParticipant(c, nil)
+	require.NotNil(t, revived)
+	assert.Len(t, revived.Memberships, 2)
+	require.NotNil(t, revived.LastReadMessageID)
+	assert.Equal(t, message.ID, *revived.LastReadMessageID)
+}
+
+func testPermissions(t *testing.T, e env) {
+	a, b, c, x := e.id("a"), e.id("b"), e.id("c"), e.id("x")
+	group := e.group(t, model.HistoryVisibilityShared, a, b)
+	owner, member, outsider := &data.Actor{ParticipantID: a}, &data.Actor{ParticipantID: b}, &data.Actor{ParticipantID: x}
+
+	_, err := e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), member, data.AddParticipant{ParticipantID: c})
+	assert.ErrorIs(t, err, store.ErrPermissionDenied)
+
+	_, err = e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), outsider, data.AddParticipant{ParticipantID: c})
+	assert.ErrorIs(t, err, store.ErrPermissionDenied)
+
+	_, err = e.Conversations.SetTitle(t.Context(), group.ID.Hex(), member, data.SetTitle{Title: "renamed"})
+	assert.ErrorIs(t, err, store.ErrPermissionDenied)
+
+	conversation, err := e.Conversations.SetTitle(t.Context(), group.ID.Hex(), owner, data.SetTitle{Title: "renamed"})
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", conversation.Title)
+
+	conversation, err = e.Conversations.SetRole(t.Context(), group.ID.Hex(), owner, data.SetParticipantRole{ParticipantID: b, Role: model.RoleAdmin})
+	require.NoError(t, err)
+	assert.Equal(t, model.RoleAdmin, conversation.ActiveParticipant(b, nil).Role)
+
+	conversation, err = e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), member, data.AddParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	assert.NotNil(t, conversation.ActiveParticipant(c, nil))
+
+	// Leaving needs no permission.
+	conversation, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), &data.Actor{ParticipantID: c}, data.DeleteParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	assert.Nil(t, conversation.ActiveParticipant(c, nil))
+}
+
+func testNotGroup(t *testing.T, e env) {
+	direct := e.direct(t, e.id("a"), e.id("b"))
+
+	_, err := e.Conversations.SetTitle(t.Context(), direct.ID.Hex(), nil, data.SetTitle{Title: "title"})
+	assert.ErrorIs(t, err, store.ErrNotGroup)
+}
+
+func testUpdate(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	owner, member := &data.Actor{ParticipantID: a}, &data.Actor{ParticipantID: b}
+	title, metadata, joined := "renamed", map[string]any{"topic": "sales"}, model.HistoryVisibilityJoined
+
+	direct := e.direct(t, a, b)
+	_, err := e.Conversations.Update(t.Context(), direct.ID.Hex(), owner, data.UpdateConversation{Title: &title, Metadata: &metadata})
+	assert.ErrorIs(t, err, store.ErrNotGroup)
+
+	group := e.group(t, model.HistoryVisibilityShared, a, b)
+	_, err = e.Conversations.Update(t.Context(), group.ID.Hex(), member, data.UpdateConversation{Metadata: &metadata, HistoryVisibility: &joined})
+	assert.ErrorIs(t, err, store.ErrPermissionDenied)
+
+	for _, conversation := range []*model.Conversation{direct, group} {
+		found := e.find(t, conversation)
+		assert.Equal(t, conversation.Title, found.Title)
+		assert.Equal(t, conversation.Metadata, found.Metadata)
+	}
+	assert.Equal(t, model.HistoryVisibilityShared, e.find(t, group).HistoryVisibility)
+
+	conversation, err := e.Conversations.Update(t.Context(), group.ID.Hex(), owner, data.UpdateConversation{Title: &title, Metadata: &metadata, HistoryVisibility: &joined})
+	require.NoError(t, err)
+	assert.Equal(t, title, conversation.Title)
+	assert.Equal(t, "sales", conversation.Metadata["topic"])
+	assert.Equal(t, joined, conversation.HistoryVisibility)
+
+	conversation = e.find(t, group)
+	assert.Equal(t, title, conversation.Title)
+	assert.Equal(t, joined, conversation.HistoryVisibility)
+}
+
+func testDirectParticipants(t *testing.T, e env) {
+	a, b, c := e.id("a"), e.id("b"), e.id("c")
+	direct := e.direct(t, a, b)
+
+	// The direct conversation the participants would make must not be returned instead.
+	_, err := e.Conversations.Create(t.Context(), data.CreateConversation{
+		Participants: []data.AddParticipant{{ParticipantID: a}, {ParticipantID: b}, {ParticipantID: c}},
+	})
+	require.NoError(t, err)
+
+	conversation, err := e.Conversations.AddParticipant(t.Context(), direct.ID.Hex(), nil, data.AddParticipant{ParticipantID: c})
+	assert.ErrorIs(t, err, store.ErrNotGroup)
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.Nil(t, conversation)
+	assert.Len(t, e.find(t, direct).Participants, 2)
+
+	_, err = e.Conversations.DeleteParticipant(t.Context(), direct.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: b})
+	require.NoError(t, err)
+
+	conversation, err = e.Conversations.AddParticipant(t.Context(), direct.ID.Hex(), nil, data.AddParticipant{ParticipantID: b})
+	require.NoError(t, err)
+	assert.Equal(t, direct.ID, conversation.ID)
+	assert.Len(t, conversation.Participants, 2)
+	assert.NotNil(t, conversation.ActiveParticipant(b, nil))
+}
+
+func testParticipantLimit(t *testing.T, e env) {
+	limit := model.DefaultLimits().MaxParticipants
+	members := make([]string, 0, limit-1)
+	for i := range limit - 1 {
+		members = append(members, e.id(string(rune('b'+i))))
+	}
+	group := e.group(t, model.HistoryVisibilityShared, e.id("a"), members...)
+	require.Len(t, group.Participants, limit)
+
+	_, err := e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), nil, data.AddParticipant{ParticipantID: e.id("z")})
+	assert.ErrorIs(t, err, store.ErrTooManyParticipants)
+
+	participants := []data.AddParticipant{}
+	for i := range limit + 1 {
+		participants = append(participants, data.AddParticipant{ParticipantID: e.id(string(rune('b' + i)))})
+	}
+	_, err = e.Conversations.Create(t.Context(), data.CreateConversation{
+		Kind:         model.ConversationKindGroup,
+		Participants: participants,
+	})
+	assert.ErrorIs(t, err, store.ErrValidation)
+}
+
+func testLoadConversations(t *testing.T, e env) {
+	a := e.id("a")
+	first := e.direct(t, a, e.id("b"))
+	second := e.direct(t, a, e.id("c"))
+	third := e.direct(t, a, e.id("d"))
+
+	page, err := e.Conversations.LoadConversations(t.Context(), data.LoadConversations{ParticipantID: a, PerPage: 2, Count: true})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{third.ID, second.ID}, conversationIDs(page.Conversations))
+	assert.True(t, page.HasMore)
+	require.NotNil(t, page.Total)
+	assert.Equal(t, uint(3), *page.Total)
+
+	page, err = e.Conversations.LoadConversations(t.Context(), data.LoadConversations{ParticipantID: a, PerPage: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{first.ID}, conversationIDs(page.Conversations))
+	assert.False(t, page.HasMore)
+	assert.Nil(t, page.Total)
+
+	_, err = e.Conversations.LoadConversations(t.Context(), data.LoadConversations{ParticipantID: a, PerPage: 2, Cursor: "invalid"})
+	assert.ErrorIs(t, err, store.ErrInvalidCursor)
+}
+
+func testLoadIdentity(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	metadata := map[string]any{"org": e.prefix}
+	group, err := e.Conversations.Create(t.Context(), data.CreateConversation{
+		Kind:  model.ConversationKindGroup,
+		Title: "group",
+		Participants: []data.AddParticipant{
+			{ParticipantID: a, Role: model.RoleOwner},
+			{ParticipantID: a, Metadata: metadata},
+			{ParticipantID: b},
+		},
+	})
+	require.NoError(t, err)
+	direct := e.direct(t, a, b)
+
+	load := func(d data.LoadConversations) []bson.ObjectID {
+		t.Helper()
+		d.PerPage = 10
+		page, err := e.Conversations.LoadConversations(t.Context(), d)
+		require.NoError(t, err)
+		return conversationIDs(page.Conversations)
+	}
+
+	_, err = e.Conversations.SetArchived(t.Context(), group.ID.Hex(), data.SetArchived{
+		Participant: data.ReadParticipant{ParticipantID: a, Metadata: metadata},
+		Archived:    true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []bson.ObjectID{direct.ID, group.ID}, load(data.LoadConversations{ParticipantID: a}))
+	assert.Empty(t, load(data.LoadConversations{ParticipantID: a, Metadata: metadata}))
+	assert.Equal(t, []bson.ObjectID{group.ID}, load(data.LoadConversations{ParticipantID: a, Metadata: metadata, IncludeArchived: true}))
+
+	// Removed participants no longer see the conversation.
+	_, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: a})
+	require.NoError(t, err)
+
+	assert.Equal(t, []bson.ObjectID{direct.ID}, load(data.LoadConversations{ParticipantID: a, IncludeArchived: true}))
+}
+
+func testPinnedAndArchived(t *testing.T, e env) {
+	a, d := e.id("a"), e.id("d")
+	first := e.direct(t, a, e.id("b"))
+	second := e.direct(t, a, e.id("c"))
+	third := e.direct(t, a, d)
+	me := data.ReadParticipant{ParticipantID: a}
+
+	conversation, err := e.Conversations.SetPinned(t.Context(), first.ID.Hex(), data.SetPinned{Participant: me, Pinned: true})
+	require.NoError(t, err)
+	assert.NotNil(t, conversation.ActiveParticipant(a, nil).PinnedAt)
+
+	conversations, total, err := e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), total)
+	assert.Equal(t, []bson.ObjectID{first.ID, third.ID, second.ID}, conversationIDs(conversations))
+
+	_, err = e.Conversations.SetArchived(t.Context(), third.ID.Hex(), data.SetArchived{Participant: me, Archived: true})
+	require.NoError(t, err)
+
+	conversations, total, err = e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), total)
+	assert.Equal(t, []bson.ObjectID{first.ID, second.ID}, conversationIDs(conversations))
+
+	_, total, err = e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Page: 1, PerPage: 10, IncludeArchived: true})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), total)
+
+	// A new message brings the conversation back.
+	e.send(t, third, d, "hello")
+	conversations, total, err = e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Equal(t, uint(3), total)
+	assert.Equal(t, []bson.ObjectID{first.ID, third.ID, second.ID}, conversationIDs(conversations))
+}
+
+func testPaginateIdentity(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	metadata := map[string]any{"org": e.prefix}
+	group, err := e.Conversations.Create(t.Context(), data.CreateConversation{
+		Kind:  model.ConversationKindGroup,
+		Title: "group",
+		Participants: []data.AddParticipant{
+			{ParticipantID: a, Role: model.RoleOwner},
+			{ParticipantID: a, Metadata: metadata},
+			{ParticipantID: b},
+		},
+	})
+	require.NoError(t, err)
+	direct := e.direct(t, a, b)
+
+	// Pinning and archiving as a with metadata leaves a without metadata alone.
+	me := data.ReadParticipant{ParticipantID: a, Metadata: metadata}
+	_, err = e.Conversations.SetPinned(t.Context(), group.ID.Hex(), data.SetPinned{Participant: me, Pinned: true})
+	require.NoError(t, err)
+	_, err = e.Conversations.SetArchived(t.Context(), group.ID.Hex(), data.SetArchived{Participant: me, Archived: true})
+	require.NoError(t, err)
+
+	conversations, total, err := e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), total)
+	assert.Equal(t, []bson.ObjectID{direct.ID, group.ID}, conversationIDs(conversations))
+
+	conversations, total, err = e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Metadata: metadata, Page: 1, PerPage: 10})
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), total)
+	assert.Empty(t, conversations)
+
+	conversations, total, err = e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Metadata: metadata, Page: 1, PerPage: 10, IncludeArchived: true})
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), total)
+	assert.Equal(t, []bson.ObjectID{group.ID}, conversationIDs(conversations))
+
+	// Removed participants no longer see the conversation.
+	_, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: a})
+	require.NoError(t, err)
+
+	conversations, total, err = e.Conversations.Paginate(t.Context(), data.PaginateConversations{ParticipantID: a, Page: 1, PerPage: 10, IncludeArchived: true})
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), total)
+	assert.Equal(t, []bson.ObjectID{direct.ID}, conversationIDs(conversations))
+}
+
+func testFindByMetadata(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	metadata := map[string]any{"org": e.prefix, "team": "sales"}
+	conversation, err := e.Conversations.Create(t.Context(), data.CreateConversation{
+		Participants: []data.AddParticipant{
+			{ParticipantID: a, Metadata: metadata},
+			{ParticipantID: b},
+		},
+	})
+	require.NoError(t, err)
+
+	conversations, total, err := e.Conversations.FindByMetadata(t.Context(), data.FindByMetadata{
+		Metadata:  map[string]any{"org": e.prefix},
+		MatchMode: data.MetadataMatchModeKeyValue,
+		Page:      1,
+		PerPage:   10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), total)
+	assert.Equal(t, []bson.ObjectID{conversation.ID}, conversationIDs(conversations))
+
+	_, total, err = e.Conversations.FindByMetadata(t.Context(), data.FindByMetadata{
+		Metadata:  map[string]any{"org": e.prefix},
+		MatchMode: data.MetadataMatchModeExact,
+		Page:      1,
+		PerPage:   10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), total)
+
+	page, err := e.Conversations.LoadByMetadata(t.Context(), data.LoadByMetadata{
+		Metadata:  metadata,
+		MatchMode: data.MetadataMatchModeExact,
+		PerPage:   10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{conversation.ID}, conversationIDs(page.Conversations))
+
+	_, err = e.Conversations.DeleteParticipant(t.Context(), conversation.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: a, Metadata: metadata})
+	require.NoError(t, err)
+
+	_, total, err = e.Conversations.FindByMetadata(t.Context(), data.FindByMetadata{
+		Metadata:  map[string]any{"org": e.prefix},
+		MatchMode: data.MetadataMatchModeKeyValue,
+		Page:      1,
+		PerPage:   10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), total)
+
+	_, total, err = e.Conversations.FindByMetadata(t.Context(), data.FindByMetadata{
+		Metadata:       map[string]any{"org": e.prefix},
+		MatchMode:      data.MetadataMatchModeKeyValue,
+		IncludeDeleted: true,
+		Page:           1,
+		PerPage:        10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), total)
+}
@@ -0,0 +1,500 @@
+package storetest
+
+import (
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var messageTests = []test{
+	{"creates messages and keeps the last message", testCreateMessages},
+	{"only lets participants post", testPostAsParticipant},
+	{"edits messages and keeps revisions", testEditMessage},
+	{"only lets participants edit their messages", testEditAsParticipant},
+	{"deletes messages for everyone and for one participant", testDeleteMessage},
+	{"toggles reactions", testToggleReaction},
+	{"files replies under the thread root", testThreads},
+	{"quotes the replied message and keeps the quote in sync", testQuotes},
+	{"pins messages", testPinMessages},
+	{"windows the timeline around a message", testMessageWindow},
+	{"searches the participant's messages", testSearch},
+	{"ranks and pages the search results within the history", testSearchRanking},
+}
+
+func testCreateMessages(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+
+	first := e.send(t, conversation, a, "first")
+	second := e.send(t, conversation, b, "second")
+	assert.Equal(t, conversation.ID, second.ConversationID)
+	assert.Equal(t, "second", second.Content)
+
+	conversation = e.find(t, conversation)
+	require.NotNil(t, conversation.LastMessage)
+	assert.Equal(t, second.ID, conversation.LastMessage.ID)
+	assert.True(t, conversation.UpdatedAt.Equal(second.CreatedAt))
+
+	assert.Equal(t, []bson.ObjectID{second.ID, first.ID}, messageIDs(e.load(t, conversation, "")))
+
+	last := second.ID.Hex()
+	messages, err := e.Messages.LoadMessages(t.Context(), data.LoadMessages{
+		ConversationID: conversation.ID.Hex(),
+		LastMessageID:  &last,
+		PerPage:        10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{first.ID}, messageIDs(messages))
+
+	messages, total, err := e.Messages.Paginate(t.Context(), data.PaginateMessages{
+		ConversationID: conversation.ID.Hex(),
+		Page:           2,
+		PerPage:        1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), total)
+	assert.Equal(t, []bson.ObjectID{first.ID}, messageIDs(messages))
+}
+
+func testPostAsParticipant(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	group := e.group(t, model.HistoryVisibilityShared, a, b)
+
+	_, err := e.Messages.Create(t.Context(), group.ID.Hex(), data.CreateMessage{
+		Kind:    "general",
+		Sender:  data.MessageSender{ParticipantID: e.id("x")},
+		Content: "hello",
+	})
+	assert.ErrorIs(t, err, store.ErrNotParticipant)
+
+	_, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: b})
+	require.NoError(t, err)
+
+	_, err = e.Messages.Create(t.Context(), group.ID.Hex(), data.CreateMessage{
+		Kind:    "general",
+		Sender:  data.MessageSender{ParticipantID: b},
+		Content: "hello",
+	})
+	assert.ErrorIs(t, err, store.ErrParticipantDeleted)
+
+	system, err := e.Messages.Create(t.Context(), group.ID.Hex(), data.CreateMessage{
+		Kind:    "system",
+		Sender:  data.MessageSender{ParticipantID: "system"},
+		Content: "b left",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "system", system.Kind)
+
+	_, err = e.Messages.Create(t.Context(), bson.NewObjectID().Hex(), data.CreateMessage{
+		Kind:    "general",
+		Sender:  data.MessageSender{ParticipantID: a},
+		Content: "hello",
+	})
+	assert.ErrorIs(t, err, store.ErrConversationNotFound)
+
+	_, err = e.Messages.Create(t.Context(), group.ID.Hex(), data.CreateMessage{
+		Kind:   "general",
+		Sender: data.MessageSender{ParticipantID: a},
+	})
+	var verr *store.ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, "Content", verr.Fields[0].Field)
+}
+
+func testEditMessage(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	message := e.send(t, conversation, a, "draft")
+
+	_, err := e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: message.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: b},
+		Content:   "hijacked",
+	})
+	assert.ErrorIs(t, err, store.ErrNotSender)
+	assert.ErrorIs(t, err, store.ErrPermissionDenied)
+
+	edited, err := e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: message.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: a},
+		Content:   "final",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "final", edited.Content)
+	assert.NotNil(t, edited.EditedAt)
+	require.Len(t, edited.Revisions, 1)
+	assert.Equal(t, "draft", edited.Revisions[0].Content)
+	assert.Equal(t, a, edited.Revisions[0].EditedBy.ParticipantID)
+
+	conversation = e.find(t, conversation)
+	require.NotNil(t, conversation.LastMessage)
+	assert.Equal(t, "final", conversation.LastMessage.Content)
+}
+
+func testEditAsParticipant(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.group(t, model.HistoryVisibilityShared, a, b)
+	message := e.send(t, conversation, b, "draft")
+
+	_, err := e.Conversations.DeleteParticipant(t.Context(), conversation.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: b})
+	require.NoError(t, err)
+
+	_, err = e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: message.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: b},
+		Content:   "final",
+	})
+	assert.ErrorIs(t, err, store.ErrParticipantDeleted)
+
+	_, err = e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: message.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: b, Metadata: map[string]any{"org": e.prefix}},
+		Content:   "final",
+	})
+	assert.ErrorIs(t, err, store.ErrNotParticipant)
+
+	messages := e.load(t, conversation, "")
+	require.Len(t, messages, 1)
+	assert.Equal(t, "draft", messages[0].Content)
+	assert.Empty(t, messages[0].Revisions)
+}
+
+func testDeleteMessage(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	first := e.send(t, conversation, a, "first")
+	second := e.send(t, conversation, a, "second")
+
+	_, err := e.Messages.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+		MessageID: first.ID.Hex(),
+		Sender:    data.MessageSender{ParticipantID: b},
+	})
+	assert.ErrorIs(t, err, store.ErrNotSender)
+
+	deleted, err := e.Messages.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+		MessageID: first.ID.Hex(),
+		Sender:    data.MessageSender{ParticipantID: a},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, deleted.DeletedAt)
+	assert.Empty(t, deleted.Content)
+
+	again, err := e.Messages.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+		MessageID: first.ID.Hex(),
+		Sender:    data.MessageSender{ParticipantID: a},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, again.DeletedAt)
+	assert.True(t, deleted.DeletedAt.Equal(*again.DeletedAt))
+
+	_, err = e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: first.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: a},
+		Content:   "revived",
+	})
+	assert.ErrorIs(t, err, store.ErrMessageDeleted)
+	assert.ErrorIs(t, err, store.ErrConflict)
+
+	_, err = e.Messages.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+		MessageID: bson.NewObjectID().Hex(),
+		Sender:    data.MessageSender{ParticipantID: a},
+	})
+	assert.ErrorIs(t, err, store.ErrMessageNotFound)
+
+	err = e.Messages.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+		MessageID:   second.ID.Hex(),
+		Participant: data.ReadParticipant{ParticipantID: b},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []bson.ObjectID{first.ID}, messageIDs(e.load(t, conversation, b)))
+	assert.Equal(t, []bson.ObjectID{second.ID, first.ID}, messageIDs(e.load(t, conversation, a)))
+	assert.Equal(t, []bson.ObjectID{second.ID, first.ID}, messageIDs(e.load(t, conversation, "")))
+}
+
+func testToggleReaction(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	message := e.send(t, conversation, a, "hello")
+
+	toggle := func(participantID string) (*model.Message, error) {
+		return e.Messages.ToggleReaction(t.Context(), data.ToggleReaction{
+			MessageID:   message.ID.Hex(),
+			Emoji:       "👍",
+			Participant: data.ReactionParticipant{ParticipantID: participantID},
+		})
+	}
+
+	reacted, err := toggle(a)
+	require.NoError(t, err)
+	require.Len(t, reacted.Reactions, 1)
+	assert.Equal(t, "👍", reacted.Reactions[0].Emoji)
+	assert.Len(t, reacted.Reactions[0].Participants, 1)
+
+	reacted, err = toggle(b)
+	require.NoError(t, err)
+	require.Len(t, reacted.Reactions, 1)
+	assert.Len(t, reacted.Reactions[0].Participants, 2)
+
+	reacted, err = toggle(a)
+	require.NoError(t, err)
+	require.Len(t, reacted.Reactions, 1)
+	require.Len(t, reacted.Reactions[0].Participants, 1)
+	assert.Equal(t, b, reacted.Reactions[0].Participants[0].ParticipantID)
+
+	reacted, err = toggle(b)
+	require.NoError(t, err)
+	assert.Empty(t, reacted.Reactions)
+
+	_, err = toggle(e.id("x"))
+	assert.ErrorIs(t, err, store.ErrNotParticipant)
+}
+
+func testThreads(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	root := e.send(t, conversation, a, "root")
+
+	reply := func(parentID bson.ObjectID, content string) *model.Message {
+		parent := parentID.Hex()
+		message, err := e.Messages.Create(t.Context(), conversation.ID.Hex(), data.CreateMessage{
+			Kind:     "general",
+			Sender:   data.MessageSender{ParticipantID: b},
+			Content:  content,
+			ParentID: &parent,
+		})
+		require.NoError(t, err)
+		return message
+	}
+
+	first := reply(root.ID, "first")
+	second := reply(first.ID, "second")
+	require.NotNil(t, second.ParentID)
+	assert.Equal(t, root.ID, *second.ParentID)
+
+	messages := e.load(t, conversation, "")
+	require.Equal(t, []bson.ObjectID{root.ID}, messageIDs(messages))
+	assert.Equal(t, uint(2), messages[0].ReplyCount)
+	assert.NotNil(t, messages[0].LastReplyAt)
+
+	replies, err := e.Messages.LoadThread(t.Context(), data.LoadThread{
+		ConversationID: conversation.ID.Hex(),
+		ParentID:       root.ID.Hex(),
+		PerPage:        10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{second.ID, first.ID}, messageIDs(replies))
+
+	messages, err = e.Messages.LoadMessages(t.Context(), data.LoadMessages{
+		ConversationID:       conversation.ID.Hex(),
+		IncludeThreadReplies: true,
+		PerPage:              10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{second.ID, first.ID, root.ID}, messageIDs(messages))
+}
+
+func testQuotes(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	quoted := e.send(t, conversation, a, "original")
+
+	replyTo := quoted.ID.Hex()
+	reply, err := e.Messages.Create(t.Context(), conversation.ID.Hex(), data.CreateMessage{
+		Kind:      "general",
+		Sender:    data.MessageSender{ParticipantID: b},
+		Content:   "reply",
+		ReplyToID: &replyTo,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, reply.ReplyTo)
+	assert.Equal(t, quoted.ID, reply.ReplyTo.MessageID)
+	assert.Equal(t, "original", reply.ReplyTo.Excerpt)
+
+	_, err = e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: quoted.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: a},
+		Content:   "edited",
+	})
+	require.NoError(t, err)
+
+	messages := e.load(t, conversation, "")
+	require.Equal(t, []bson.ObjectID{reply.ID, quoted.ID}, messageIDs(messages))
+	require.NotNil(t, messages[0].ReplyTo)
+	assert.Equal(t, "edited", messages[0].ReplyTo.Excerpt)
+
+	conversation = e.find(t, conversation)
+	require.NotNil(t, conversation.LastMessage)
+	require.NotNil(t, conversation.LastMessage.ReplyTo)
+	assert.Equal(t, "edited", conversation.LastMessage.ReplyTo.Excerpt)
+
+	_, err = e.Messages.DeleteForEveryone(t.Context(), data.DeleteMessageForEveryone{
+		MessageID: quoted.ID.Hex(),
+		Sender:    data.MessageSender{ParticipantID: a},
+	})
+	require.NoError(t, err)
+
+	messages = e.load(t, conversation, "")
+	require.NotNil(t, messages[0].ReplyTo)
+	assert.NotNil(t, messages[0].ReplyTo.DeletedAt)
+	assert.Empty(t, messages[0].ReplyTo.Excerpt)
+}
+
+func testPinMessages(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	group := e.group(t, model.HistoryVisibilityShared, a, b)
+	message := e.send(t, group, b, "important")
+	d := data.PinMessage{MessageID: message.ID.Hex()}
+
+	_, err := e.Messages.Pin(t.Context(), &data.Actor{ParticipantID: b}, d)
+	assert.ErrorIs(t, err, store.ErrPermissionDenied)
+
+	pinned, err := e.Messages.Pin(t.Context(), &data.Actor{ParticipantID: a}, d)
+	require.NoError(t, err)
+	assert.NotNil(t, pinned.PinnedAt)
+	require.NotNil(t, pinned.PinnedBy)
+	assert.Equal(t, a, pinned.PinnedBy.ParticipantID)
+
+	messages, err := e.Messages.LoadPinned(t.Context(), data.LoadPinnedMessages{ConversationID: group.ID.Hex()})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{message.ID}, messageIDs(messages))
+
+	unpinned, err := e.Messages.Unpin(t.Context(), &data.Actor{ParticipantID: a}, d)
+	require.NoError(t, err)
+	assert.Nil(t, unpinned.PinnedAt)
+
+	messages, err = e.Messages.LoadPinned(t.Context(), data.LoadPinnedMessages{ConversationID: group.ID.Hex()})
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func testMessageWindow(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	messages := make([]*model.Message, 0, 5)
+	for range 5 {
+		messages = append(messages, e.send(t, conversation, a, "hello"))
+	}
+
+	window, err := e.Messages.LoadMessagesAround(t.Context(), data.LoadMessagesAround{
+		ConversationID: conversation.ID.Hex(),
+		MessageID:      messages[2].ID.Hex(),
+		Before:         1,
+		After:          1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{messages[3].ID, messages[2].ID, messages[1].ID}, messageIDs(window.Messages))
+	assert.True(t, window.HasOlder)
+	assert.True(t, window.HasNewer)
+
+	window, err = e.Messages.LoadNewerMessages(t.Context(), data.LoadNewerMessages{
+		ConversationID: conversation.ID.Hex(),
+		FirstMessageID: messages[2].ID.Hex(),
+		PerPage:        1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{messages[3].ID}, messageIDs(window.Messages))
+	assert.True(t, window.HasOlder)
+	assert.True(t, window.HasNewer)
+
+	window, err = e.Messages.LoadNewerMessages(t.Context(), data.LoadNewerMessages{
+		ConversationID: conversation.ID.Hex(),
+		FirstMessageID: messages[2].ID.Hex(),
+		PerPage:        10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bson.ObjectID{messages[4].ID, messages[3].ID}, messageIDs(window.Messages))
+	assert.False(t, window.HasNewer)
+}
+
+func testSearch(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	match := e.send(t, conversation, a, "the zanzibar invoice")
+	e.send(t, conversation, b, "unrelated")
+	e.send(t, e.direct(t, e.id("c"), e.id("d")), e.id("c"), "zanzibar")
+
+	results, total, err := e.Messages.Search(t.Context(), data.SearchMessages{
+		Participant: data.ReadParticipant{ParticipantID: b},
+		Query:       "zanzibar",
+		Page:        1,
+		PerPage:     10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), total)
+	require.Len(t, results, 1)
+	assert.Equal(t, match.ID, results[0].Message.ID)
+	assert.Equal(t, conversation.ID, results[0].Conversation.ID)
+	assert.Positive(t, results[0].Score)
+
+	_, total, err = e.Messages.Search(t.Context(), data.SearchMessages{
+		Participant: data.ReadParticipant{ParticipantID: b},
+		Query:       "zanzibar -invoice",
+		Page:        1,
+		PerPage:     10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), total)
+}
+
+func testSearchRanking(t *testing.T, e env) {
+	a, b, c := e.id("a"), e.id("b"), e.id("c")
+	group := e.group(t, model.HistoryVisibilityJoined, a, b)
+	e.send(t, group, a, "quokka before")
+
+	_, err := e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), &data.Actor{ParticipantID: a}, data.AddParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	late := e.send(t, group, a, "quokka after the others joined")
+	best := e.send(t, group, b, "quokka quokka quokka")
+
+	search := func(participantID, query string, page uint) ([]bson.ObjectID, uint) {
+		t.Helper()
+		results, total, err := e.Messages.Search(t.Context(), data.SearchMessages{
+			Participant: data.ReadParticipant{ParticipantID: participantID},
+			Query:       query,
+			Page:        page,
+			PerPage:     1,
+		})
+		require.NoError(t, err)
+		ids := make([]bson.ObjectID, 0, len(results))
+		for _, result := range results {
+			ids = append(ids, result.Message.ID)
+		}
+		return ids, total
+	}
+
+	ids, total := search(b, "quokka", 1)
+	assert.Equal(t, uint(3), total)
+	assert.Equal(t, []bson.ObjectID{best.ID}, ids)
+	next, _ := search(b, "quokka", 2)
+	require.Len(t, next, 1)
+	assert.NotEqual(t, best.ID, next[0])
+
+	// c only sees the messages sent since joining.
+	_, total = search(c, "quokka", 1)
+	assert.Equal(t, uint(2), total)
+
+	_, err = e.Messages.Edit(t.Context(), data.EditMessage{
+		MessageID: late.ID.Hex(),
+		Editor:    data.MessageSender{ParticipantID: a},
+		Content:   "wombat",
+	})
+	require.NoError(t, err)
+	_, total = search(c, "quokka", 1)
+	assert.Equal(t, uint(1), total)
+	ids, _ = search(c, "wombat", 1)
+	assert.Equal(t, []bson.ObjectID{late.ID}, ids)
+
+	err = e.Messages.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+		MessageID:   best.ID.Hex(),
+		Participant: data.ReadParticipant{ParticipantID: c},
+	})
+	require.NoError(t, err)
+	_, total = search(c, "quokka", 1)
+	assert.Equal(t, uint(0), total)
+}
@@ -0,0 +1,279 @@
+package storetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var readTests = []test{
+	{"advances the read cursor monotonically", testMarkRead},
+	{"keeps concurrent reads and reactions", testConcurrentWrites},
+	{"counts unread messages", testUnreadCount},
+	{"builds the inbox", testInbox},
+	{"limits joined history to membership periods", testJoinedHistory},
+	{"tracks typing and presence", testPresence},
+}
+
+func testMarkRead(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	group := e.group(t, model.HistoryVisibilityShared, a, b)
+	first := e.send(t, group, a, "first")
+	second := e.send(t, group, a, "second")
+	third := e.send(t, group, a, "third")
+
+	markRead := func(participantID string, message *model.Message) (*model.Conversation, error) {
+		return e.Messages.MarkRead(t.Context(), data.MarkRead{
+			ConversationID: group.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: participantID},
+			MessageID:      message.ID.Hex(),
+		})
+	}
+
+	conversation, err := markRead(b, second)
+	require.NoError(t, err)
+	reader := conversation.ActiveParticipant(b, nil)
+	require.NotNil(t, reader.LastReadMessageID)
+	assert.Equal(t, second.ID, *reader.LastReadMessageID)
+	assert.NotNil(t, reader.LastReadAt)
+
+	conversation, err = markRead(b, first)
+	require.NoError(t, err)
+	assert.Equal(t, second.ID, *conversation.ActiveParticipant(b, nil).LastReadMessageID)
+
+	readersOf := func(message *model.Message) []string {
+		readers, err := e.Messages.ReadersOf(t.Context(), data.ReadersOf{
+			ConversationID: group.ID.Hex(),
+			MessageID:      message.ID.Hex(),
+		})
+		require.NoError(t, err)
+
+		ids := []string{}
+		for _, reader := range readers {
+			ids = append(ids, reader.ParticipantID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{b}, readersOf(first))
+	assert.Empty(t, readersOf(third))
+
+	other := e.send(t, e.direct(t, a, b), a, "elsewhere")
+	_, err = markRead(b, other)
+	assert.ErrorIs(t, err, store.ErrMessageNotFound)
+
+	_, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: b})
+	require.NoError(t, err)
+
+	_, err = markRead(b, third)
+	assert.ErrorIs(t, err, store.ErrNotParticipant)
+	assert.Empty(t, readersOf(first))
+}
+
+func testConcurrentWrites(t *testing.T, e env) {
+	owner := e.id("owner")
+	members := []string{}
+	for i := range 8 {
+		members = append(members, e.id(fmt.Sprintf("member-%d", i)))
+	}
+	group := e.group(t, model.HistoryVisibilityShared, owner, members...)
+
+	messages := []*model.Message{}
+	for i := range 5 {
+		messages = append(messages, e.send(t, group, owner, fmt.Sprintf("m%d", i)))
+	}
+	last := messages[len(messages)-1]
+
+	var wg sync.WaitGroup
+	for _, member := range members {
+		for _, message := range messages {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := e.Messages.MarkRead(t.Context(), data.MarkRead{
+					ConversationID: group.ID.Hex(),
+					Participant:    data.ReadParticipant{ParticipantID: member},
+					MessageID:      message.ID.Hex(),
+				})
+				assert.NoError(t, err)
+			}()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := e.Messages.ToggleReaction(t.Context(), data.ToggleReaction{
+				MessageID:   last.ID.Hex(),
+				Emoji:       "👍",
+				Participant: data.ReactionParticipant{ParticipantID: member},
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	conversation := e.find(t, group)
+	for _, member := range members {
+		reader := conversation.ActiveParticipant(member, nil)
+		require.NotNil(t, reader)
+		require.NotNil(t, reader.LastReadMessageID, member)
+		assert.Equal(t, last.ID, *reader.LastReadMessageID, member)
+	}
+
+	reacted := e.load(t, group, owner)
+	require.NotEmpty(t, reacted)
+	require.Equal(t, last.ID, reacted[0].ID)
+	require.Len(t, reacted[0].Reactions, 1)
+	assert.Len(t, reacted[0].Reactions[0].Participants, len(members))
+}
+
+func testUnreadCount(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	first := e.send(t, conversation, a, "first")
+	e.send(t, conversation, a, "second")
+	e.send(t, conversation, b, "third")
+
+	unread := func(participantID string) (uint, error) {
+		return e.Messages.UnreadCount(t.Context(), data.UnreadCount{
+			ConversationID: conversation.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: participantID},
+		})
+	}
+
+	count, err := unread(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint(2), count)
+
+	err = e.Messages.DeleteForMe(t.Context(), data.DeleteMessageForMe{
+		MessageID:   first.ID.Hex(),
+		Participant: data.ReadParticipant{ParticipantID: b},
+	})
+	require.NoError(t, err)
+
+	count, err = unread(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), count)
+
+	_, err = e.Messages.MarkAllRead(t.Context(), data.MarkAllRead{
+		ConversationID: conversation.ID.Hex(),
+		Participant:    data.ReadParticipant{ParticipantID: b},
+	})
+	require.NoError(t, err)
+
+	count, err = unread(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), count)
+
+	_, err = unread(e.id("x"))
+	assert.ErrorIs(t, err, store.ErrNotParticipant)
+}
+
+func testInbox(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	me := data.ReadParticipant{ParticipantID: b}
+
+	first := e.direct(t, a, b)
+	e.send(t, first, a, "first")
+	e.send(t, first, a, "second")
+	second := e.group(t, model.HistoryVisibilityShared, a, b)
+	e.send(t, second, a, "third")
+
+	_, err := e.Conversations.SetMuted(t.Context(), second.ID.Hex(), data.SetMuted{Participant: me, Muted: true})
+	require.NoError(t, err)
+
+	inbox, err := e.Conversations.Inbox(t.Context(), data.LoadInbox{Participant: me, PerPage: 1})
+	require.NoError(t, err)
+	require.Len(t, inbox.Entries, 1)
+	assert.Equal(t, second.ID, inbox.Entries[0].Conversation.ID)
+	assert.Equal(t, uint(1), inbox.Entries[0].UnreadCount)
+	assert.Equal(t, b, inbox.Entries[0].Participant.ParticipantID)
+	assert.True(t, inbox.HasMore)
+	assert.Equal(t, uint(2), inbox.UnreadMessages)
+	assert.Equal(t, uint(1), inbox.UnreadConversations)
+
+	inbox, err = e.Conversations.Inbox(t.Context(), data.LoadInbox{Participant: me, PerPage: 1, Cursor: inbox.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, inbox.Entries, 1)
+	assert.Equal(t, first.ID, inbox.Entries[0].Conversation.ID)
+	assert.Equal(t, uint(2), inbox.Entries[0].UnreadCount)
+	assert.False(t, inbox.HasMore)
+
+	_, err = e.Messages.MarkAllRead(t.Context(), data.MarkAllRead{ConversationID: first.ID.Hex(), Participant: me})
+	require.NoError(t, err)
+
+	inbox, err = e.Conversations.Inbox(t.Context(), data.LoadInbox{Participant: me, PerPage: 10, UnreadOnly: true})
+	require.NoError(t, err)
+	require.Len(t, inbox.Entries, 1)
+	assert.Equal(t, second.ID, inbox.Entries[0].Conversation.ID)
+	assert.Equal(t, uint(0), inbox.UnreadMessages)
+	assert.Equal(t, uint(0), inbox.UnreadConversations)
+}
+
+func testJoinedHistory(t *testing.T, e env) {
+	a, b, c := e.id("a"), e.id("b"), e.id("c")
+	group := e.group(t, model.HistoryVisibilityJoined, a, b)
+	before := e.send(t, group, a, "before")
+
+	_, err := e.Conversations.AddParticipant(t.Context(), group.ID.Hex(), nil, data.AddParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	during := e.send(t, group, a, "during")
+
+	assert.Equal(t, []bson.ObjectID{during.ID}, messageIDs(e.load(t, group, c)))
+	assert.Equal(t, []bson.ObjectID{during.ID, before.ID}, messageIDs(e.load(t, group, b)))
+
+	count, err := e.Messages.UnreadCount(t.Context(), data.UnreadCount{
+		ConversationID: group.ID.Hex(),
+		Participant:    data.ReadParticipant{ParticipantID: c},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), count)
+
+	_, err = e.Conversations.DeleteParticipant(t.Context(), group.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: c})
+	require.NoError(t, err)
+	e.send(t, group, a, "after")
+
+	assert.Equal(t, []bson.ObjectID{during.ID}, messageIDs(e.load(t, group, c)))
+}
+
+func testPresence(t *testing.T, e env) {
+	a, b := e.id("a"), e.id("b")
+	conversation := e.direct(t, a, b)
+	typing := data.SetTyping{ConversationID: conversation.ID.Hex(), Participant: data.ReadParticipant{ParticipantID: a}}
+
+	require.NoError(t, e.Presence.SetTyping(t.Context(), typing))
+
+	typists, err := e.Presence.Typing(t.Context(), data.LoadTyping{ConversationID: conversation.ID.Hex()})
+	require.NoError(t, err)
+	require.Len(t, typists, 1)
+	assert.Equal(t, a, typists[0].ParticipantID)
+
+	require.NoError(t, e.Presence.StopTyping(t.Context(), typing))
+
+	typists, err = e.Presence.Typing(t.Context(), data.LoadTyping{ConversationID: conversation.ID.Hex()})
+	require.NoError(t, err)
+	assert.Empty(t, typists)
+
+	err = e.Presence.SetTyping(t.Context(), data.SetTyping{
+		ConversationID: conversation.ID.Hex(),
+		Participant:    data.ReadParticipant{ParticipantID: e.id("x")},
+	})
+	assert.ErrorIs(t, err, store.ErrNotParticipant)
+
+	require.NoError(t, e.Presence.Heartbeat(t.Context(), data.Heartbeat{Participant: data.ReadParticipant{ParticipantID: a}}))
+
+	presence, err := e.Presence.LastSeen(t.Context(), data.LoadPresence{Participants: []data.ReadParticipant{
+		{ParticipantID: a},
+		{ParticipantID: e.id("x")},
+	}})
+	require.NoError(t, err)
+	require.Len(t, presence, 1)
+	assert.Equal(t, a, presence[0].ParticipantID)
+	assert.True(t, presence[0].Online)
+}
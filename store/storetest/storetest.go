@@ -0,0 +1,172 @@
+// Package storetest checks that a storage backend implements the interfaces of the store
+// package with the same semantics as the other backends.
+// Backends run the suite from their tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T, now func() time.Time) storetest.Stores {
+//			...
+//		})
+//	}
+package storetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/store"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Stores are the stores of the backend under test. They must share their storage.
+type Stores struct {
+	Conversations store.ConversationStore
+	Messages      store.MessageStore
+	Presence      store.PresenceStore
+}
+
+// Factory returns the stores under test. The stores must take their timestamps from now and
+// use the default permissions and limits, without a tenant.
+// The stores may be shared between tests, each test uses participants of its own.
+type Factory func(t *testing.T, now func() time.Time) Stores
+
+// Run runs the conformance suite against the stores returned by the factory.
+func Run(t *testing.T, newStores Factory) {
+	for _, test := range conversationTests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newEnv(t, newStores))
+		})
+	}
+	for _, test := range messageTests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newEnv(t, newStores))
+		})
+	}
+	for _, test := range readTests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newEnv(t, newStores))
+		})
+	}
+}
+
+type test struct {
+	name string
+	run  func(t *testing.T, e env)
+}
+
+// env is the environment of a test, the stores and helpers to create conversations and messages.
+type env struct {
+	Stores
+	prefix string
+}
+
+func newEnv(t *testing.T, newStores Factory) env {
+	return env{
+		Stores: newStores(t, clock()),
+		prefix: bson.NewObjectID().Hex(),
+	}
+}
+
+// clock returns a clock that advances by a second on every call, so that every write gets
+// a distinct timestamp on every backend.
+func clock() func() time.Time {
+	var mu sync.Mutex
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+// id returns a participant id unique to the test.
+func (e env) id(name string) string {
+	return e.prefix + "-" + name
+}
+
+// direct creates the direct conversation between the participants.
+func (e env) direct(t *testing.T, a, b string) *model.Conversation {
+	t.Helper()
+	conversation, err := e.Conversations.Create(t.Context(), data.CreateConversation{
+		Participants: []data.AddParticipant{
+			{ParticipantID: a},
+			{ParticipantID: b},
+		},
+	})
+	require.NoError(t, err)
+	return conversation
+}
+
+// group creates a group owned by the owner with the members.
+func (e env) group(t *testing.T, visibility model.HistoryVisibility, owner string, members ...string) *model.Conversation {
+	t.Helper()
+	participants := []data.AddParticipant{{ParticipantID: owner, Role: model.RoleOwner}}
+	for _, member := range members {
+		participants = append(participants, data.AddParticipant{ParticipantID: member})
+	}
+
+	conversation, err := e.Conversations.Create(t.Context(), data.CreateConversation{
+		Kind:              model.ConversationKindGroup,
+		Title:             "group",
+		Participants:      participants,
+		HistoryVisibility: visibility,
+	})
+	require.NoError(t, err)
+	return conversation
+}
+
+// send sends the message to the conversation.
+func (e env) send(t *testing.T, conversation *model.Conversation, sender string, content string) *model.Message {
+	t.Helper()
+	message, err := e.Messages.Create(t.Context(), conversation.ID.Hex(), data.CreateMessage{
+		Kind:    "general",
+		Sender:  data.MessageSender{ParticipantID: sender},
+		Content: content,
+	})
+	require.NoError(t, err)
+	return message
+}
+
+// find fetches the conversation.
+func (e env) find(t *testing.T, conversation *model.Conversation) *model.Conversation {
+	t.Helper()
+	found, err := e.Conversations.Find(t.Context(), conversation.ID.Hex())
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	return found
+}
+
+// load loads the latest messages of the conversation as seen by the viewer, if any.
+func (e env) load(t *testing.T, conversation *model.Conversation, viewer string) []model.Message {
+	t.Helper()
+	d := data.LoadMessages{ConversationID: conversation.ID.Hex(), PerPage: 100}
+	if viewer != "" {
+		d.Viewer = &data.ReadParticipant{ParticipantID: viewer}
+	}
+
+	messages, err := e.Messages.LoadMessages(t.Context(), d)
+	require.NoError(t, err)
+	return messages
+}
+
+// conversationIDs returns the ids of the conversations.
+func conversationIDs(conversations []model.Conversation) []bson.ObjectID {
+	ids := make([]bson.ObjectID, 0, len(conversations))
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.ID)
+	}
+	return ids
+}
+
+// messageIDs returns the ids of the messages.
+func messageIDs(messages []model.Message) []bson.ObjectID {
+	ids := make([]bson.ObjectID, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
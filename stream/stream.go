@@ -0,0 +1,345 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/internal/cache"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/store"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Subscription is a resumable stream of conversation and message events backed by a
+// MongoDB change stream. Change streams require a replica set or a sharded cluster.
+type Subscription struct {
+	changeStream  *mongo.ChangeStream
+	conversations *repository.Conversation
+	collections   model.Collections
+	filter        data.Subscribe
+
+	// memberships caches the conversations of message events when filtering by participant.
+	// It is refreshed by the conversation changes seen on the same stream and holds up to
+	// membershipCacheSize conversations.
+	memberships *cache.LRU[string, *model.Conversation]
+
+	// pending holds the events of the current change that were not read yet. delivered is
+	// the resume token of the change before it, whose events were all read.
+	pending   []model.Event
+	delivered bson.Raw
+	event     model.Event
+	err       error
+}
+
+// membershipCacheSize is the number of conversations a subscription keeps to check whether
+// the participant may see their events.
+const membershipCacheSize = 1024
+
+type change struct {
+	OperationType string `bson:"operationType"`
+	Namespace     struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	ClusterTime       bson.Timestamp `bson:"clusterTime"`
+	FullDocument      bson.Raw       `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Subscribe opens a change stream over the conversations and messages collections, as named
+// by the conversation repository, limited to the tenant of the conversation repository.
+// Events can be narrowed down to a set of conversations, to the conversations the participant
+// is a non-deleted member of and to a set of event kinds.
+// Passing the resume token of the last processed event continues the stream right after it.
+func Subscribe(ctx context.Context, db *mongo.Database, conversations *repository.Conversation, d data.Subscribe) (*Subscription, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate subscribe data: %w", err)
+	}
+
+	collections := conversations.Collections()
+	match := bson.M{
+		"ns.coll":                bson.M{"$in": []string{collections.Conversations, collections.Messages}},
+		"operationType":          bson.M{"$in": []string{"insert", "update"}},
+		"fullDocument.tenant_id": conversations.Tenant(),
+	}
+
+	if len(d.ConversationIDs) > 0 {
+		conversationObIDs := make([]bson.ObjectID, 0, len(d.ConversationIDs))
+		for _, id := range d.ConversationIDs {
+			obID, err := bson.ObjectIDFromHex(id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse conversation id: %w", err)
+			}
+			conversationObIDs = append(conversationObIDs, obID)
+		}
+
+		match["$or"] = []bson.M{
+			{"ns.coll": collections.Messages, "fullDocument.conversation_id": bson.M{"$in": d.ConversationIDs}},
+			{"ns.coll": collections.Conversations, "documentKey._id": bson.M{"$in": conversationObIDs}},
+		}
+	}
+
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(d.ResumeToken) > 0 {
+		opts.SetResumeAfter(d.ResumeToken)
+	}
+
+	changeStream, err := db.Watch(ctx, mongo.Pipeline{{{Key: "$match", Value: match}}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open change stream: %w", err)
+	}
+
+	return &Subscription{
+		changeStream:  changeStream,
+		conversations: conversations,
+		collections:   collections,
+		filter:        d,
+		delivered:     d.ResumeToken,
+		memberships:   cache.NewLRU[string, *model.Conversation](membershipCacheSize),
+	}, nil
+}
+
+// Next blocks until the next event is available and reports whether there is one.
+// It returns false once the context is done or the stream fails, see Err.
+func (s *Subscription) Next(ctx context.Context) bool {
+	if s.err != nil {
+		return false
+	}
+
+	for len(s.pending) == 0 {
+		if token := s.changeStream.ResumeToken(); len(token) > 0 {
+			s.delivered = slices.Clone(token)
+		}
+		if !s.changeStream.Next(ctx) {
+			s.err = s.changeStream.Err()
+			return false
+		}
+
+		events, err := s.decode(ctx)
+		if err != nil {
+			s.err = err
+			return false
+		}
+		s.pending = events
+	}
+
+	s.event = s.pending[0]
+	s.pending = s.pending[1:]
+	return true
+}
+
+// Event returns the event read by the last successful call to Next.
+func (s *Subscription) Event() model.Event {
+	return s.event
+}
+
+// Err returns the error that stopped the subscription, if any.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// ResumeToken returns the token of the latest change whose events were all read, including
+// changes that did not produce an event. Persisting it lets a restarted consumer continue
+// from here.
+func (s *Subscription) ResumeToken() bson.Raw {
+	if len(s.pending) > 0 {
+		return s.delivered
+	}
+	return s.changeStream.ResumeToken()
+}
+
+// Close closes the underlying change stream.
+func (s *Subscription) Close(ctx context.Context) error {
+	return s.changeStream.Close(ctx)
+}
+
+// decode turns the current change into the events matching the subscription filter.
+func (s *Subscription) decode(ctx context.Context) ([]model.Event, error) {
+	var c change
+	if err := s.changeStream.Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to decode change: %w", err)
+	}
+
+	// The document was removed before its update could be looked up.
+	if len(c.FullDocument) == 0 {
+		return nil, nil
+	}
+
+	var events []model.Event
+	var conversation *model.Conversation
+
+	switch c.Namespace.Coll {
+	case s.collections.Messages:
+		var message model.Message
+		if err := bson.Unmarshal(c.FullDocument, &message); err != nil {
+			return nil, fmt.Errorf("failed to decode message: %w", err)
+		}
+
+		var kind model.EventKind
+		switch {
+		case c.OperationType == "insert":
+			kind = model.EventKindMessageCreated
+		case updatedField(c, "reactions") && !updatedField(c, "deleted_at"):
+			kind = model.EventKindReactionToggled
+		default:
+			return nil, nil
+		}
+
+		events = append(events, model.Event{
+			Kind:           kind,
+			ConversationID: message.ConversationID.Hex(),
+			Message:        &message,
+		})
+
+	case s.collections.Conversations:
+		conversation = &model.Conversation{}
+		if err := bson.Unmarshal(c.FullDocument, conversation); err != nil {
+			return nil, fmt.Errorf("failed to decode conversation: %w", err)
+		}
+		s.memberships.Add(conversation.ID.Hex(), conversation)
+
+		if c.OperationType != "update" {
+			return nil, nil
+		}
+
+		events = participantEvents(c, conversation)
+	}
+
+	token := slices.Clone(s.changeStream.ResumeToken())
+	occurredAt := time.Unix(int64(c.ClusterTime.T), 0)
+
+	filtered := make([]model.Event, 0, len(events))
+	for _, event := range events {
+		if len(s.filter.Kinds) > 0 && !slices.Contains(s.filter.Kinds, event.Kind) {
+			continue
+		}
+
+		if s.filter.Participant != nil {
+			visible, err := s.visibleToParticipant(ctx, event, conversation)
+			if err != nil {
+				return nil, err
+			}
+			if !visible {
+				continue
+			}
+		}
+
+		event.OccurredAt = occurredAt
+		filtered = append(filtered, event)
+	}
+
+	// A change can produce several events, e.g. participants added at once, and resuming after
+	// its token skips all of them. Only the last one carries it, so that resuming from the token
+	// of an event never skips the events after it.
+	if len(filtered) > 0 {
+		filtered[len(filtered)-1].ResumeToken = token
+	}
+
+	return filtered, nil
+}
+
+// visibleToParticipant reports whether the subscribed participant is a non-deleted member of
+// the event's conversation. Participants always see the events about themselves, including
+// their own removal.
+func (s *Subscription) visibleToParticipant(ctx context.Context, event model.Event, conversation *model.Conversation) (bool, error) {
+	p := s.filter.Participant
+
+	if event.Participant != nil && event.Participant.ParticipantID == p.ParticipantID && model.MetadataEqual(event.Participant.Metadata, p.Metadata) {
+		return true, nil
+	}
+
+	if conversation == nil {
+		conversation, _ = s.memberships.Get(event.ConversationID)
+	}
+	if conversation == nil {
+		found, err := s.conversations.Find(ctx, event.ConversationID)
+		if errors.Is(err, store.ErrConversationNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch the conversation: %w", err)
+		}
+		conversation = found
+		s.memberships.Add(event.ConversationID, found)
+	}
+
+	return conversation.ActiveParticipant(p.ParticipantID, p.Metadata) != nil, nil
+}
+
+// participantEvents derives participant events from the fields updated on a conversation.
+// Pushing a participant updates participants.<n>, soft deleting it updates
+// participants.<n>.deleted_at and marking messages read updates participants.<n>.last_read_*.
+func participantEvents(c change, conversation *model.Conversation) []model.Event {
+	elements, err := c.UpdateDescription.UpdatedFields.Elements()
+	if err != nil {
+		return nil
+	}
+
+	kinds := make(map[int]model.EventKind)
+	for _, element := range elements {
+		path := strings.Split(element.Key(), ".")
+		if len(path) < 2 || path[0] != "participants" {
+			continue
+		}
+
+		index, err := strconv.Atoi(path[1])
+		if err != nil || index >= len(conversation.Participants) {
+			continue
+		}
+
+		switch {
+		case len(path) == 2:
+			kinds[index] = model.EventKindParticipantAdded
+		case path[2] == "deleted_at" && element.Value().Type == bson.TypeNull:
+			kinds[index] = model.EventKindParticipantAdded
+		case path[2] == "deleted_at":
+			kinds[index] = model.EventKindParticipantRemoved
+		case path[2] == "last_read_message_id":
+			if _, ok := kinds[index]; !ok {
+				kinds[index] = model.EventKindReadAdvanced
+			}
+		}
+	}
+
+	indexes := make([]int, 0, len(kinds))
+	for index := range kinds {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+
+	events := make([]model.Event, 0, len(indexes))
+	for _, index := range indexes {
+		participant := conversation.Participants[index]
+		events = append(events, model.Event{
+			Kind:           kinds[index],
+			ConversationID: conversation.ID.Hex(),
+			Participant:    &participant,
+		})
+	}
+
+	return events
+}
+
+func updatedField(c change, field string) bool {
+	elements, err := c.UpdateDescription.UpdatedFields.Elements()
+	if err != nil {
+		return false
+	}
+	for _, element := range elements {
+		key := element.Key()
+		if key == field || strings.HasPrefix(key, field+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package stream_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/davesavic/chatsavvy/data"
+	"github.com/davesavic/chatsavvy/model"
+	"github.com/davesavic/chatsavvy/repository"
+	"github.com/davesavic/chatsavvy/stream"
+	"github.com/davesavic/chatsavvy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// mustConnectReplicaSet connects to MongoDB and skips the test unless it runs as a replica set,
+// which change streams require.
+func mustConnectReplicaSet(t *testing.T) *mongo.Client {
+	t.Helper()
+
+	client := testutil.MustConnectMongoDB(t, os.Getenv("MONGODB_URI"))
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+	err := client.Database("admin").RunCommand(t.Context(), bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	require.NoError(t, err)
+	if hello.SetName == "" {
+		t.Skip("change streams require a replica set")
+	}
+
+	return client
+}
+
+func nextEvent(t *testing.T, sub *stream.Subscription) model.Event {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+
+	require.True(t, sub.Next(ctx), "expected an event: %v", sub.Err())
+	return sub.Event()
+}
+
+func TestSubscribe(t *testing.T) {
+	client := mustConnectReplicaSet(t)
+	db := client.Database("chatsavvy")
+
+	cr := repository.NewConversation(db)
+	mr := repository.NewMessage(db, cr)
+
+	t.Run("emits typed events for a conversation", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Kind: model.ConversationKindGroup,
+			Participants: []data.AddParticipant{
+				{ParticipantID: "st-kinds-a"},
+				{ParticipantID: "st-kinds-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		sub, err := stream.Subscribe(t.Context(), db, cr, data.Subscribe{ConversationIDs: []string{conv.ID.Hex()}})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sub.Close(context.Background()) })
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "st-kinds-a"},
+			Content: "hello",
+		})
+		require.NoError(t, err)
+
+		event := nextEvent(t, sub)
+		assert.Equal(t, model.EventKindMessageCreated, event.Kind)
+		assert.Equal(t, conv.ID.Hex(), event.ConversationID)
+		require.NotNil(t, event.Message)
+		assert.Equal(t, msg.ID.Hex(), event.Message.ID.Hex())
+		assert.NotEmpty(t, event.ResumeToken)
+
+		_, err = mr.ToggleReaction(t.Context(), data.ToggleReaction{
+			MessageID:   msg.ID.Hex(),
+			Emoji:       ":+1:",
+			Participant: data.ReactionParticipant{ParticipantID: "st-kinds-b"},
+		})
+		require.NoError(t, err)
+
+		event = nextEvent(t, sub)
+		assert.Equal(t, model.EventKindReactionToggled, event.Kind)
+		require.NotNil(t, event.Message)
+		assert.Len(t, event.Message.Reactions, 1)
+
+		_, err = mr.MarkRead(t.Context(), data.MarkRead{
+			ConversationID: conv.ID.Hex(),
+			Participant:    data.ReadParticipant{ParticipantID: "st-kinds-b"},
+			MessageID:      msg.ID.Hex(),
+		})
+		require.NoError(t, err)
+
+		event = nextEvent(t, sub)
+		assert.Equal(t, model.EventKindReadAdvanced, event.Kind)
+		require.NotNil(t, event.Participant)
+		assert.Equal(t, "st-kinds-b", event.Participant.ParticipantID)
+
+		_, err = cr.AddParticipant(t.Context(), conv.ID.Hex(), nil, data.AddParticipant{ParticipantID: "st-kinds-c"})
+		require.NoError(t, err)
+
+		event = nextEvent(t, sub)
+		assert.Equal(t, model.EventKindParticipantAdded, event.Kind)
+		require.NotNil(t, event.Participant)
+		assert.Equal(t, "st-kinds-c", event.Participant.ParticipantID)
+
+		_, err = cr.DeleteParticipant(t.Context(), conv.ID.Hex(), nil, data.DeleteParticipant{ParticipantID: "st-kinds-c"})
+		require.NoError(t, err)
+
+		event = nextEvent(t, sub)
+		assert.Equal(t, model.EventKindParticipantRemoved, event.Kind)
+		require.NotNil(t, event.Participant)
+		assert.Equal(t, "st-kinds-c", event.Participant.ParticipantID)
+	})
+
+	t.Run("filters by participant and kind", func(t *testing.T) {
+		mine, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "st-filter-a"},
+				{ParticipantID: "st-filter-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		other, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "st-filter-c"},
+				{ParticipantID: "st-filter-d"},
+			},
+		})
+		require.NoError(t, err)
+
+		sub, err := stream.Subscribe(t.Context(), db, cr, data.Subscribe{
+			Participant: &data.ReadParticipant{ParticipantID: "st-filter-a"},
+			Kinds:       []model.EventKind{model.EventKindMessageCreated},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sub.Close(context.Background()) })
+
+		_, err = mr.Create(t.Context(), other.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "st-filter-c"},
+			Content: "not for a",
+		})
+		require.NoError(t, err)
+
+		msg, err := mr.Create(t.Context(), mine.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "st-filter-b"},
+			Content: "for a",
+		})
+		require.NoError(t, err)
+
+		event := nextEvent(t, sub)
+		assert.Equal(t, model.EventKindMessageCreated, event.Kind)
+		assert.Equal(t, msg.ID.Hex(), event.Message.ID.Hex())
+	})
+
+	t.Run("skips the events of missing conversations", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "st-missing-a"},
+				{ParticipantID: "st-missing-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		sub, err := stream.Subscribe(t.Context(), db, cr, data.Subscribe{
+			Participant: &data.ReadParticipant{ParticipantID: "st-missing-a"},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sub.Close(context.Background()) })
+
+		// A message whose conversation is gone, e.g. deleted or of another tenant.
+		_, err = db.Collection(cr.Collections().Messages).InsertOne(t.Context(), model.Message{
+			ID:             bson.NewObjectID(),
+			ConversationID: bson.NewObjectID(),
+			Sender:         model.MessageSender{ParticipantID: "st-missing-b"},
+			Kind:           "general",
+			Content:        "orphan",
+			CreatedAt:      time.Now(),
+		})
+		require.NoError(t, err)
+
+		msg, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "st-missing-b"},
+			Content: "for a",
+		})
+		require.NoError(t, err)
+
+		event := nextEvent(t, sub)
+		assert.Equal(t, model.EventKindMessageCreated, event.Kind)
+		assert.Equal(t, msg.ID.Hex(), event.Message.ID.Hex())
+	})
+
+	t.Run("resumes after a token", func(t *testing.T) {
+		conv, err := cr.Create(t.Context(), data.CreateConversation{
+			Participants: []data.AddParticipant{
+				{ParticipantID: "st-resume-a"},
+				{ParticipantID: "st-resume-b"},
+			},
+		})
+		require.NoError(t, err)
+
+		filter := data.Subscribe{ConversationIDs: []string{conv.ID.Hex()}}
+		sub, err := stream.Subscribe(t.Context(), db, cr, filter)
+		require.NoError(t, err)
+
+		first, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "st-resume-a"},
+			Content: "first",
+		})
+		require.NoError(t, err)
+
+		event := nextEvent(t, sub)
+		assert.Equal(t, first.ID.Hex(), event.Message.ID.Hex())
+		require.NoError(t, sub.Close(t.Context()))
+
+		second, err := mr.Create(t.Context(), conv.ID.Hex(), data.CreateMessage{
+			Kind:    "general",
+			Sender:  data.MessageSender{ParticipantID: "st-resume-b"},
+			Content: "second",
+		})
+		require.NoError(t, err)
+
+		filter.ResumeToken = event.ResumeToken
+		resumed, err := stream.Subscribe(t.Context(), db, cr, filter)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resumed.Close(context.Background()) })
+
+		event = nextEvent(t, resumed)
+		assert.Equal(t, model.EventKindMessageCreated, event.Kind)
+		assert.Equal(t, second.ID.Hex(), event.Message.ID.Hex())
+	})
+}
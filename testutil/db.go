@@ -16,8 +16,11 @@ func MustConnectMongoDB(t *testing.T, dbURI string) *mongo.Client {
 		t.Fatal(err)
 	}
 
-	migrations.Run(client, "down")
-	migrations.Run(client, "up")
+	for _, direction := range []string{"down", "up"} {
+		if err := migrations.Run(client, direction); err != nil {
+			t.Fatal(err)
+		}
+	}
 
 	return client
 }